@@ -0,0 +1,66 @@
+package simplepeer
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+)
+
+// apiOptions are the parts of PeerOptions the peer's webrtc.API is made of.
+type apiOptions struct {
+	api                 *webrtc.API
+	mediaEngine         *webrtc.MediaEngine
+	settingEngine       *webrtc.SettingEngine
+	interceptorRegistry *interceptor.Registry
+	defaultInterceptors bool
+}
+
+// build returns the API to create connections with. Unless a complete API
+// was given, it records stream statistics and passes the getter of every
+// new connection to onStreamStats.
+func (options apiOptions) build(onStreamStats stats.NewPeerConnectionCallback) (*webrtc.API, error) {
+	if options.api != nil {
+		return options.api, nil
+	}
+	mediaEngine := options.mediaEngine
+	if mediaEngine == nil {
+		mediaEngine = &webrtc.MediaEngine{}
+		if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+			return nil, err
+		}
+	}
+	// the user's registry is wrapped rather than added to, so it can be
+	// shared between peers
+	interceptorRegistry := &interceptor.Registry{}
+	if options.interceptorRegistry != nil {
+		interceptorRegistry.Add(registryFactory{options.interceptorRegistry})
+	}
+	if options.interceptorRegistry == nil || options.defaultInterceptors {
+		if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+			return nil, err
+		}
+	}
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	statsInterceptor.OnNewPeerConnection(onStreamStats)
+	interceptorRegistry.Add(statsInterceptor)
+	apiOptions := []func(*webrtc.API){
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	}
+	if options.settingEngine != nil {
+		apiOptions = append(apiOptions, webrtc.WithSettingEngine(*options.settingEngine))
+	}
+	return webrtc.NewAPI(apiOptions...), nil
+}
+
+// registryFactory builds the interceptors of a registry as one factory.
+type registryFactory struct {
+	registry *interceptor.Registry
+}
+
+func (factory registryFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	return factory.registry.Build(id)
+}
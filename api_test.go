@@ -0,0 +1,167 @@
+package simplepeer
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/rtcp"
+	"github.com/pion/transport/v3/vnet"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestMediaEngineRestrictsCodecs(t *testing.T) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	peer1, peer2 := connectTestPeers(t, PeerOptions{MediaEngine: mediaEngine}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if _, err := peer1.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err == nil {
+		t.Fatal("expected a video transceiver to fail without video codecs")
+	}
+	transceiver, err := peer1.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		description := peer2.Connection().CurrentLocalDescription()
+		return description != nil && strings.Contains(description.SDP, "m=audio")
+	})
+	for _, description := range []*webrtc.SessionDescription{
+		peer1.Connection().CurrentLocalDescription(),
+		peer2.Connection().CurrentLocalDescription(),
+	} {
+		for _, line := range strings.Split(description.SDP, "\r\n") {
+			if strings.HasPrefix(line, "a=rtpmap:") && !strings.Contains(line, "opus/48000") {
+				t.Fatalf("expected only opus to be negotiated, got %q", line)
+			}
+		}
+	}
+	for _, codec := range transceiver.Sender().GetParameters().Codecs {
+		if codec.MimeType != webrtc.MimeTypeOpus {
+			t.Fatalf("expected only opus to be negotiated, got %s", codec.MimeType)
+		}
+	}
+}
+
+// connectVNetPeers connects two peers over a virtual network whose router
+// passes every chunk through filter.
+func connectVNetPeers(t *testing.T, filter vnet.ChunkFilter, peer1Options, peer2Options PeerOptions) (*Peer, *Peer) {
+	router, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.AddChunkFilter(filter)
+	for i, options := range []*PeerOptions{&peer1Options, &peer2Options} {
+		network, err := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+4)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := router.AddNet(network); err != nil {
+			t.Fatal(err)
+		}
+		settingEngine := &webrtc.SettingEngine{}
+		settingEngine.SetNet(network)
+		options.SettingEngine = settingEngine
+	}
+	if err := router.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		router.Stop()
+	})
+	return connectTestPeers(t, peer1Options, peer2Options)
+}
+
+func TestDefaultInterceptorsSendNacks(t *testing.T) {
+	for name, options := range map[string]func() PeerOptions{
+		"default": func() PeerOptions {
+			return PeerOptions{}
+		},
+		"registry": func() PeerOptions {
+			return PeerOptions{InterceptorRegistry: &interceptor.Registry{}, EnableDefaultInterceptors: true}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// drops every fourth RTP packet, leaving the handshakes and RTCP
+			var rtpPackets atomic.Int64
+			dropRTP := func(chunk vnet.Chunk) bool {
+				data := chunk.UserData()
+				if len(data) < 2 || data[0]>>6 != 2 || (data[1] >= 192 && data[1] <= 223) {
+					return true
+				}
+				return rtpPackets.Add(1)%4 != 0
+			}
+			receiverOptions := options()
+			receiverOptions.OnTrack = func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+				// interceptors only see the packets that are read
+				for {
+					if _, _, err := track.ReadRTP(); err != nil {
+						return
+					}
+				}
+			}
+			peer1, peer2 := connectVNetPeers(t, dropRTP, options(), receiverOptions)
+			defer peer1.Close()
+			defer peer2.Close()
+
+			track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "lossy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sender, err := peer1.AddTrack(track)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nacks := make(chan struct{}, 1)
+			go func() {
+				for {
+					packets, _, err := sender.ReadRTCP()
+					if err != nil {
+						return
+					}
+					for _, packet := range packets {
+						if _, ok := packet.(*rtcp.TransportLayerNack); ok {
+							select {
+							case nacks <- struct{}{}:
+							default:
+							}
+						}
+					}
+				}
+			}()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(20 * time.Millisecond):
+						track.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond})
+					}
+				}
+			}()
+			select {
+			case <-nacks:
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected the receiver to send NACKs for lost packets")
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultBufferedAmountHighThreshold uint64 = 1024 * 1024
+	defaultBufferedAmountLowThreshold  uint64 = 256 * 1024
+	// flushPollInterval is how often Flush checks the buffered amount once
+	// it is below the low threshold, where pion sends no further events.
+	flushPollInterval = 5 * time.Millisecond
+)
+
+// SendBufferPolicy controls what a write does when it does not fit
+// MaxBufferedBytes.
+type SendBufferPolicy int
+
+const (
+	// SendBufferPolicyError fails the write with ErrSendBufferFull, like
+	// simple-peer's write returning false. Wait for OnDrain to write again.
+	SendBufferPolicyError SendBufferPolicy = iota
+	// SendBufferPolicyBlock waits until the write fits, its context is done
+	// or the peer closes.
+	SendBufferPolicyBlock
+)
+
+// sendBuffer counts the bytes of writes in progress, which the channel's
+// buffered amount does not include yet.
+type sendBuffer struct {
+	mutex   sync.Mutex
+	pending uint64
+	changed chan struct{}
+	// failed is the size of the largest write that did not fit since
+	// OnDrain last fired, and watching whether watchDrain waits for it to
+	// fit
+	failed   uint64
+	watching bool
+}
+
+// release returns the bytes of a finished write and wakes blocked writes.
+func (buffer *sendBuffer) release(size uint64) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.pending -= size
+	if buffer.changed != nil {
+		close(buffer.changed)
+		buffer.changed = nil
+	}
+}
+
+// reserveSendBuffer counts a write of size bytes against MaxBufferedBytes
+// for as long as it is in progress. The returned function ends the write.
+func (peer *Peer) reserveSendBuffer(ctx context.Context, channel *webrtc.DataChannel, size int) (func(), error) {
+	if peer.maxBufferedBytes == 0 {
+		return func() {}, nil
+	}
+	var ticker *time.Ticker
+	for {
+		buffer := &peer.sendBuffer
+		buffer.mutex.Lock()
+		buffered := channel.BufferedAmount() + buffer.pending
+		if buffered == 0 || buffered+uint64(size) <= peer.maxBufferedBytes || channel.ReadyState() != webrtc.DataChannelStateOpen {
+			buffer.pending += uint64(size)
+			buffer.mutex.Unlock()
+			if ticker != nil {
+				ticker.Stop()
+			}
+			return func() {
+				buffer.release(uint64(size))
+			}, nil
+		}
+		if peer.sendBufferPolicy == SendBufferPolicyError {
+			if uint64(size) > buffer.failed {
+				buffer.failed = uint64(size)
+			}
+			if !buffer.watching {
+				buffer.watching = true
+				go peer.watchDrain(channel)
+			}
+			buffer.mutex.Unlock()
+			return nil, fmt.Errorf("%w: %d bytes buffered, %d more exceed %d", ErrSendBufferFull, buffered, size, peer.maxBufferedBytes)
+		}
+		if buffer.changed == nil {
+			buffer.changed = make(chan struct{})
+		}
+		changed := buffer.changed
+		buffer.mutex.Unlock()
+		if ticker == nil {
+			// pion only reports crossing the low threshold, so a queue
+			// draining between it and the cap is polled
+			ticker = time.NewTicker(flushPollInterval)
+		}
+		peer.mutex.RLock()
+		bufferedAmountLow := peer.bufferedAmountLow
+		channelsChanged := peer.channelsChanged
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return nil, ctx.Err()
+		case <-peerContext.Done():
+			ticker.Stop()
+			return nil, peerClosedErr(peerContext)
+		case <-bufferedAmountLow:
+		case <-channelsChanged:
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchDrain fires OnDrain once the largest write that failed with
+// ErrSendBufferFull would fit. The queue is polled since pion only reports
+// crossing the low threshold, which the queue may already be below.
+func (peer *Peer) watchDrain(channel *webrtc.DataChannel) {
+	buffer := &peer.sendBuffer
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	peerContext := peer.Context()
+	for {
+		select {
+		case <-peerContext.Done():
+		case <-ticker.C:
+		}
+		buffer.mutex.Lock()
+		if peerContext.Err() != nil || channel.ReadyState() != webrtc.DataChannelStateOpen {
+			buffer.failed = 0
+			buffer.watching = false
+			buffer.mutex.Unlock()
+			return
+		}
+		buffered := channel.BufferedAmount() + buffer.pending
+		if buffered == 0 || buffered+buffer.failed <= peer.maxBufferedBytes {
+			buffer.failed = 0
+			buffer.watching = false
+			buffer.mutex.Unlock()
+			for _, fn := range peer.onDrain.Slice() {
+				go fn()
+			}
+			return
+		}
+		buffer.mutex.Unlock()
+	}
+}
+
+// OnDrain adds a callback for when a write that failed with
+// ErrSendBufferFull would fit MaxBufferedBytes, like simple-peer's drain
+// event. It fires once per run of failed writes.
+func (peer *Peer) OnDrain(fn OnDrain) (off func()) {
+	return peer.onDrain.Append(fn)
+}
+
+// Deprecated: call the func returned by OnDrain instead.
+func (peer *Peer) OffDrain(fn OnDrain) {
+	peer.onDrain.Delete(func(index int, onDrain OnDrain) bool {
+		return sameFunc(onDrain, fn)
+	})
+}
+
+// setBufferedAmountLow makes the channel wake writers blocked on its
+// buffered amount once it drains below the low threshold.
+func (peer *Peer) setBufferedAmountLow(channel *webrtc.DataChannel) {
+	channel.SetBufferedAmountLowThreshold(peer.bufferedAmountLowThreshold)
+	channel.OnBufferedAmountLow(peer.onBufferedAmountLow)
+}
+
+func (peer *Peer) onBufferedAmountLow() {
+	peer.mutex.Lock()
+	close(peer.bufferedAmountLow)
+	peer.bufferedAmountLow = make(chan struct{})
+	peer.mutex.Unlock()
+}
+
+// waitForBufferedAmount blocks while more than the high threshold is queued
+// on the channel. It returns early once the channel leaves the open state so
+// the following send reports the error.
+func (peer *Peer) waitForBufferedAmount(ctx context.Context, channel *webrtc.DataChannel) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		peer.mutex.RLock()
+		bufferedAmountLow := peer.bufferedAmountLow
+		channelsChanged := peer.channelsChanged
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		if channel.BufferedAmount() <= peer.bufferedAmountHighThreshold || channel.ReadyState() != webrtc.DataChannelStateOpen {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-peerContext.Done():
+			return peerClosedErr(peerContext)
+		case <-bufferedAmountLow:
+		case <-channelsChanged:
+		}
+	}
+}
+
+// Flush blocks until everything written to the data channel has been handed
+// to the network, the context is done or the peer closes. It returns
+// ErrChannelClosed if the channel closes first.
+func (peer *Peer) Flush(ctx context.Context) error {
+	channel := peer.Channel()
+	if channel == nil {
+		return peer.notInitializedErr()
+	}
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for {
+		peer.mutex.RLock()
+		bufferedAmountLow := peer.bufferedAmountLow
+		channelsChanged := peer.channelsChanged
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		if state := channel.ReadyState(); state == webrtc.DataChannelStateClosing || state == webrtc.DataChannelStateClosed {
+			return ErrChannelClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if channel.BufferedAmount() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-peerContext.Done():
+			return peerClosedErr(peerContext)
+		case <-bufferedAmountLow:
+		case <-channelsChanged:
+		case <-ticker.C:
+		}
+	}
+}
+
+// CloseGracefully flushes the data channel like Flush and then closes the
+// peer, even if flushing failed.
+func (peer *Peer) CloseGracefully(ctx context.Context) error {
+	err := peer.Flush(ctx)
+	return errors.Join(err, peer.Close())
+}
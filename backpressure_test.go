@@ -0,0 +1,73 @@
+package simplepeer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxBufferedBytes(t *testing.T) {
+	drained := make(chan bool, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		MaxBufferedBytes: 256 * 1024,
+		OnDrain: func() {
+			drained <- true
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+	if peer1.bufferedAmountLowThreshold != 128*1024 {
+		t.Fatalf("expected the low threshold to be half the cap, got %d", peer1.bufferedAmountLowThreshold)
+	}
+
+	if err := peer1.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the queue is empty, so a write over the cap is accepted
+	if _, err := peer1.Write(bytes.Repeat([]byte{1}, 2*1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if buffered := peer1.Channel().BufferedAmount(); buffered > 256*1024 {
+		t.Fatalf("expected at most the cap to be buffered, got %d", buffered)
+	}
+	if _, err := peer1.Write(bytes.Repeat([]byte{2}, 200*1024)); !errors.Is(err, ErrSendBufferFull) {
+		t.Fatalf("expected ErrSendBufferFull, got %v", err)
+	}
+	select {
+	case <-drained:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected OnDrain to be called")
+	}
+	if _, err := peer1.Write([]byte("more")); err != nil {
+		t.Fatalf("expected the write to fit after draining, got %v", err)
+	}
+}
+
+func TestMaxBufferedBytesBlock(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		MaxBufferedBytes: 256 * 1024,
+		SendBufferPolicy: SendBufferPolicyBlock,
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.Write(bytes.Repeat([]byte{1}, 2*1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	channel := peer1.Channel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := peer1.WriteContext(ctx, bytes.Repeat([]byte{2}, 200*1024)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the write to block until the deadline, got %v", err)
+	}
+	if _, err := peer1.Write(bytes.Repeat([]byte{2}, 200*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if buffered := channel.BufferedAmount(); buffered > 256*1024 {
+		t.Fatalf("expected at most the cap to be buffered, got %d", buffered)
+	}
+}
@@ -0,0 +1,102 @@
+package simplepeer
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ChannelReliability selects how the main data channel delivers messages.
+type ChannelReliability int
+
+const (
+	// ChannelReliable delivers every message in order.
+	ChannelReliable ChannelReliability = iota
+	// ChannelUnordered delivers every message but in any order.
+	ChannelUnordered
+	// ChannelUnreliable delivers messages in any order and never
+	// retransmits lost ones.
+	ChannelUnreliable
+)
+
+// UnorderedChannelConfig returns a config for a reliable channel that may
+// deliver messages out of order.
+func UnorderedChannelConfig() *webrtc.DataChannelInit {
+	ordered := false
+	return &webrtc.DataChannelInit{Ordered: &ordered}
+}
+
+// UnreliableChannelConfig returns a config for an unordered channel that
+// retransmits lost messages at most maxRetransmits times.
+func UnreliableChannelConfig(maxRetransmits uint16) *webrtc.DataChannelInit {
+	config := UnorderedChannelConfig()
+	config.MaxRetransmits = &maxRetransmits
+	return config
+}
+
+// LifetimeChannelConfig returns a config for an unordered channel that stops
+// retransmitting a message maxPacketLifeTime milliseconds after sending it.
+func LifetimeChannelConfig(maxPacketLifeTime uint16) *webrtc.DataChannelInit {
+	config := UnorderedChannelConfig()
+	config.MaxPacketLifeTime = &maxPacketLifeTime
+	return config
+}
+
+// ChannelConfig returns a copy of the main data channel's configuration
+// with ChannelReliability applied, or nil for pion's defaults.
+func (peer *Peer) ChannelConfig() *webrtc.DataChannelInit {
+	return copyChannelConfig(peer.channelConfig)
+}
+
+func copyChannelConfig(config *webrtc.DataChannelInit) *webrtc.DataChannelInit {
+	if config == nil {
+		return nil
+	}
+	copied := *config
+	copied.Ordered = copyPointer(config.Ordered)
+	copied.MaxPacketLifeTime = copyPointer(config.MaxPacketLifeTime)
+	copied.MaxRetransmits = copyPointer(config.MaxRetransmits)
+	copied.Protocol = copyPointer(config.Protocol)
+	copied.Negotiated = copyPointer(config.Negotiated)
+	copied.ID = copyPointer(config.ID)
+	return &copied
+}
+
+func copyPointer[T any](value *T) *T {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+// channelConfigFor applies reliability on top of a copy of config.
+func channelConfigFor(config *webrtc.DataChannelInit, reliability ChannelReliability) (*webrtc.DataChannelInit, error) {
+	var result *webrtc.DataChannelInit
+	if config != nil {
+		copied := *config
+		result = &copied
+	}
+	switch reliability {
+	case ChannelReliable:
+	case ChannelUnordered, ChannelUnreliable:
+		if result == nil {
+			result = &webrtc.DataChannelInit{}
+		}
+		if result.Ordered != nil && *result.Ordered {
+			return nil, fmt.Errorf("%w: ordered conflicts with an unordered ChannelReliability", ErrInvalidChannelConfig)
+		}
+		ordered := false
+		result.Ordered = &ordered
+		if reliability == ChannelUnreliable && result.MaxPacketLifeTime == nil && result.MaxRetransmits == nil {
+			maxRetransmits := uint16(0)
+			result.MaxRetransmits = &maxRetransmits
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown ChannelReliability %d", ErrInvalidChannelConfig, reliability)
+	}
+	if result != nil && result.MaxRetransmits != nil && result.MaxPacketLifeTime != nil {
+		return nil, fmt.Errorf("%w: maxRetransmits and maxPacketLifeTime are mutually exclusive", ErrInvalidChannelConfig)
+	}
+	return result, nil
+}
@@ -0,0 +1,87 @@
+package simplepeer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestChannelReliability(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		options        PeerOptions
+		ordered        bool
+		maxRetransmits *uint16
+		maxLifeTime    *uint16
+	}{
+		{name: "reliable", ordered: true},
+		{name: "unordered", options: PeerOptions{ChannelReliability: ChannelUnordered}},
+		{name: "unreliable", options: PeerOptions{ChannelReliability: ChannelUnreliable}, maxRetransmits: UnreliableChannelConfig(0).MaxRetransmits},
+		{name: "retransmits", options: PeerOptions{ChannelConfig: UnreliableChannelConfig(3)}, maxRetransmits: UnreliableChannelConfig(3).MaxRetransmits},
+		{name: "lifetime", options: PeerOptions{ChannelConfig: LifetimeChannelConfig(500), ChannelReliability: ChannelUnreliable}, maxLifeTime: LifetimeChannelConfig(500).MaxPacketLifeTime},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			peer1, peer2 := connectTestPeers(t, test.options, PeerOptions{})
+			defer peer1.Close()
+			defer peer2.Close()
+			for _, channel := range []*webrtc.DataChannel{peer1.Channel(), peer2.Channel()} {
+				if channel.Ordered() != test.ordered {
+					t.Fatalf("expected ordered=%t, got %t", test.ordered, channel.Ordered())
+				}
+				if !equalUint16Pointers(channel.MaxRetransmits(), test.maxRetransmits) {
+					t.Fatalf("expected maxRetransmits %v, got %v", test.maxRetransmits, channel.MaxRetransmits())
+				}
+				if !equalUint16Pointers(channel.MaxPacketLifeTime(), test.maxLifeTime) {
+					t.Fatalf("expected maxPacketLifeTime %v, got %v", test.maxLifeTime, channel.MaxPacketLifeTime())
+				}
+			}
+		})
+	}
+}
+
+func equalUint16Pointers(a, b *uint16) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestInvalidChannelConfig(t *testing.T) {
+	both := UnreliableChannelConfig(3)
+	both.MaxPacketLifeTime = LifetimeChannelConfig(500).MaxPacketLifeTime
+	ordered := true
+	for name, options := range map[string]PeerOptions{
+		"exclusive":   {ChannelConfig: both},
+		"ordered":     {ChannelConfig: &webrtc.DataChannelInit{Ordered: &ordered}, ChannelReliability: ChannelUnordered},
+		"reliability": {ChannelReliability: ChannelReliability(42)},
+	} {
+		peer := NewPeer(options)
+		if err := peer.Init(); !errors.Is(err, ErrInvalidChannelConfig) {
+			t.Fatalf("%s: expected ErrInvalidChannelConfig, got %v", name, err)
+		}
+		if peer.Connection() != nil {
+			t.Fatalf("%s: expected no connection to be created", name)
+		}
+	}
+}
+
+func TestChannelConfigCopy(t *testing.T) {
+	peer := NewPeer(PeerOptions{ChannelConfig: UnreliableChannelConfig(3)})
+	defer peer.Close()
+	if len(peer.ChannelName()) != 36 {
+		t.Fatalf("expected a generated UUID as the channel name, got %q", peer.ChannelName())
+	}
+	config := peer.ChannelConfig()
+	if config == nil || config.Ordered == nil || *config.Ordered || !equalUint16Pointers(config.MaxRetransmits, UnreliableChannelConfig(3).MaxRetransmits) {
+		t.Fatalf("expected the unreliable configuration, got %+v", config)
+	}
+	*config.MaxRetransmits = 7
+	*config.Ordered = true
+	if config := peer.ChannelConfig(); *config.MaxRetransmits != 3 || *config.Ordered {
+		t.Fatal("expected changes to the returned configuration not to reach the peer")
+	}
+	if config := NewPeer(PeerOptions{}).ChannelConfig(); config != nil {
+		t.Fatalf("expected no configuration by default, got %+v", config)
+	}
+}
@@ -0,0 +1,270 @@
+package simplepeer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type OnAllChannelsReady func()
+
+// OnAllChannelsReady adds a callback for when every channel in
+// RequiredChannels is open. Registered while they are open it fires at once
+// unless SkipReplay is set.
+func (peer *Peer) OnAllChannelsReady(fn OnAllChannelsReady, options ...CallbackOptions) (off func()) {
+	peer.callbackMutex.Lock()
+	off = peer.onAllChannelsReady.Append(fn)
+	replay := peer.allChannelsReady && !skipReplay(options)
+	peer.callbackMutex.Unlock()
+	if replay {
+		go fn()
+	}
+	return off
+}
+
+// Deprecated: call the func returned by OnAllChannelsReady instead.
+func (peer *Peer) OffAllChannelsReady(fn OnAllChannelsReady) {
+	peer.onAllChannelsReady.Delete(func(index int, onAllChannelsReady OnAllChannelsReady) bool {
+		return sameFunc(onAllChannelsReady, fn)
+	})
+}
+
+// DataMessage is a data channel message along with the channel it arrived
+// on.
+type DataMessage struct {
+	webrtc.DataChannelMessage
+	Label   string
+	Channel *webrtc.DataChannel
+}
+
+type OnDataMessage func(message DataMessage)
+
+type labeledOnData struct {
+	label string
+	fn    OnData
+}
+
+// OnDataFrom adds a callback for messages arriving on the channel with the
+// given label. OnData receives messages from every channel.
+func (peer *Peer) OnDataFrom(label string, fn OnData) (off func()) {
+	return peer.onDataFrom.Append(labeledOnData{label: label, fn: fn})
+}
+
+// Deprecated: call the func returned by OnDataFrom instead.
+func (peer *Peer) OffDataFrom(label string, fn OnData) {
+	peer.onDataFrom.Delete(func(index int, onDataFrom labeledOnData) bool {
+		return onDataFrom.label == label && sameFunc(onDataFrom.fn, fn)
+	})
+}
+
+// OnDataMessage adds a callback for messages from every channel that also
+// receives the channel they arrived on.
+func (peer *Peer) OnDataMessage(fn OnDataMessage) (off func()) {
+	return peer.onDataMessage.Append(fn)
+}
+
+// Deprecated: call the func returned by OnDataMessage instead.
+func (peer *Peer) OffDataMessage(fn OnDataMessage) {
+	peer.onDataMessage.Delete(func(index int, onDataMessage OnDataMessage) bool {
+		return sameFunc(onDataMessage, fn)
+	})
+}
+
+// NegotiatedChannel is a data channel both peers create with the same id
+// instead of announcing it in-band. A channel labeled ChannelName replaces
+// the main channel, so both peers must set the same ChannelName.
+type NegotiatedChannel struct {
+	Label string
+	ID    uint16
+	Init  *webrtc.DataChannelInit
+}
+
+func validateNegotiatedChannels(channels []NegotiatedChannel) error {
+	labels := make(map[string]bool, len(channels))
+	ids := make(map[uint16]bool, len(channels))
+	for _, channel := range channels {
+		if labels[channel.Label] {
+			return fmt.Errorf("%w: negotiated channel label %q is used twice", ErrInvalidChannelConfig, channel.Label)
+		}
+		if ids[channel.ID] {
+			return fmt.Errorf("%w: negotiated channel id %d is used twice", ErrInvalidChannelConfig, channel.ID)
+		}
+		labels[channel.Label] = true
+		ids[channel.ID] = true
+	}
+	return nil
+}
+
+func (peer *Peer) createNegotiatedChannels() error {
+	for _, negotiatedChannel := range peer.negotiatedChannels {
+		config := webrtc.DataChannelInit{}
+		if negotiatedChannel.Init != nil {
+			config = *negotiatedChannel.Init
+		}
+		negotiated := true
+		id := negotiatedChannel.ID
+		config.Negotiated = &negotiated
+		config.ID = &id
+		if err := peer.createChannel(negotiatedChannel.Label, &config, negotiatedChannel.Label == peer.channelName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnChannelClose adds a callback for when the main data channel closes while
+// the connection stays up, e.g. because the remote peer closed just the
+// channel. OnClose still only fires when the peer closes.
+func (peer *Peer) OnChannelClose(fn OnClose) (off func()) {
+	return peer.onChannelClose.Append(fn)
+}
+
+// Deprecated: call the func returned by OnChannelClose instead.
+func (peer *Peer) OffChannelClose(fn OnClose) {
+	peer.onChannelClose.Delete(func(index int, onChannelClose OnClose) bool {
+		return sameFunc(onChannelClose, fn)
+	})
+}
+
+func (peer *Peer) onDataChannelClose() {
+	peer.debugf("data channel closed")
+	for _, fn := range peer.onChannelClose.Slice() {
+		go fn()
+	}
+}
+
+// WaitForChannels blocks until every named data channel is open, the context
+// is done or the peer closes. Channels declared in RequiredChannels that the
+// initiator has not created yet are created.
+func (peer *Peer) WaitForChannels(ctx context.Context, labels ...string) error {
+	if peer.initiator.Load() {
+		for _, label := range labels {
+			if peer.isRequiredChannel(label) && peer.getChannel(label) == nil {
+				if err := peer.createChannel(label, nil, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for {
+		peer.mutex.RLock()
+		channelsChanged := peer.channelsChanged
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		if peerContext.Err() != nil {
+			return peerClosedErr(peerContext)
+		}
+		if peer.channelsOpen(labels) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-peerContext.Done():
+			return peerClosedErr(peerContext)
+		case <-channelsChanged:
+		}
+	}
+}
+
+func (peer *Peer) isRequiredChannel(label string) bool {
+	for _, requiredChannel := range peer.requiredChannels {
+		if requiredChannel == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (peer *Peer) getChannel(label string) *webrtc.DataChannel {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.channels[label]
+}
+
+func (peer *Peer) channelsOpen(labels []string) bool {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	for _, label := range labels {
+		channel := peer.channels[label]
+		if channel == nil || channel.ReadyState() != webrtc.DataChannelStateOpen {
+			return false
+		}
+	}
+	return true
+}
+
+func (peer *Peer) createChannel(label string, config *webrtc.DataChannelInit, primary bool) error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	channel, err := connection.CreateDataChannel(label, config)
+	if err != nil {
+		return err
+	}
+	peer.attachChannel(channel, primary)
+	return nil
+}
+
+func (peer *Peer) attachChannel(channel *webrtc.DataChannel, primary bool) {
+	peer.debugf("attaching channel label=%s", channel.Label())
+	peer.mutex.Lock()
+	if primary {
+		peer.channel = channel
+		peer.frameReader.reset()
+	}
+	if peer.channels == nil {
+		peer.channels = make(map[string]*webrtc.DataChannel)
+	}
+	peer.channels[channel.Label()] = channel
+	peer.mutex.Unlock()
+	channel.OnError(peer.onDataChannelError)
+	peer.setBufferedAmountLow(channel)
+	if primary {
+		channel.OnOpen(func() {
+			peer.onDataChannelOpen()
+			peer.onChannelStateChange()
+		})
+	} else {
+		channel.OnOpen(peer.onChannelStateChange)
+	}
+	channel.OnClose(func() {
+		peer.onChannelStateChange()
+		if primary && peer.Channel() == channel {
+			peer.onDataChannelClose()
+		}
+	})
+	channel.OnMessage(func(message webrtc.DataChannelMessage) {
+		peer.onDataChannelMessage(channel, message)
+	})
+	peer.onChannelStateChange()
+}
+
+func (peer *Peer) onChannelStateChange() {
+	peer.mutex.Lock()
+	close(peer.channelsChanged)
+	peer.channelsChanged = make(chan struct{})
+	peer.mutex.Unlock()
+	if len(peer.requiredChannels) == 0 {
+		return
+	}
+	peer.callbackMutex.Lock()
+	var onAllChannelsReady []OnAllChannelsReady
+	if peer.channelsOpen(peer.requiredChannels) {
+		if !peer.allChannelsReady {
+			peer.allChannelsReady = true
+			onAllChannelsReady = peer.onAllChannelsReady.Slice()
+		}
+	} else {
+		peer.allChannelsReady = false
+	}
+	peer.callbackMutex.Unlock()
+	if len(onAllChannelsReady) > 0 {
+		peer.debugf("all channels ready")
+	}
+	for _, fn := range onAllChannelsReady {
+		go fn()
+	}
+}
@@ -0,0 +1,131 @@
+package simplepeer
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// PeerDebugState is a snapshot of a peer for debugging. It only holds plain
+// values, so it can be printed or marshaled to JSON. States are empty while
+// there is no connection or channel.
+type PeerDebugState struct {
+	Id           string `json:"id"`
+	RemoteId     string `json:"remoteId,omitempty"`
+	Initiator    bool   `json:"initiator"`
+	Closed       bool   `json:"closed"`
+	Negotiating  bool   `json:"negotiating"`
+	ChannelName  string `json:"channelName"`
+	ChannelState string `json:"channelState,omitempty"`
+	Channels     int    `json:"channels"`
+	// ChannelConfig is a copy of Peer.ChannelConfig.
+	ChannelConfig *webrtc.DataChannelInit `json:"channelConfig,omitempty"`
+	// ICEServers are the URLs of the servers of Peer.Config, without their
+	// credentials.
+	ICEServers              []string `json:"iceServers"`
+	MaxChannelMessageSize   int      `json:"maxChannelMessageSize"`
+	MaxMessageSize          int      `json:"maxMessageSize"`
+	ConnectionState         string   `json:"connectionState,omitempty"`
+	ICEConnectionState      string   `json:"iceConnectionState,omitempty"`
+	ICEGatheringState       string   `json:"iceGatheringState,omitempty"`
+	SignalingState          string   `json:"signalingState,omitempty"`
+	LocalDescriptionType    string   `json:"localDescriptionType,omitempty"`
+	RemoteDescriptionType   string   `json:"remoteDescriptionType,omitempty"`
+	PendingLocalCandidates  int      `json:"pendingLocalCandidates"`
+	PendingRemoteCandidates int      `json:"pendingRemoteCandidates"`
+	LocalTracks             int      `json:"localTracks"`
+	RemoteTracks            int      `json:"remoteTracks"`
+	// Callbacks counts the registered callbacks by the name of their On
+	// method without the prefix, e.g. "Data" for OnData.
+	Callbacks map[string]int `json:"callbacks"`
+}
+
+// DebugState returns a snapshot of the peer. It is safe to call at any
+// point, including concurrently with and after Close.
+func (peer *Peer) DebugState() PeerDebugState {
+	state := PeerDebugState{
+		Id:                      peer.id,
+		RemoteId:                peer.RemoteId(),
+		Initiator:               peer.Initiator(),
+		Closed:                  peer.Context().Err() != nil,
+		Negotiating:             peer.Negotiating(),
+		ChannelName:             peer.channelName,
+		ChannelConfig:           peer.ChannelConfig(),
+		ICEServers:              []string{},
+		MaxChannelMessageSize:   peer.maxChannelMessageSize,
+		MaxMessageSize:          peer.MaxMessageSize(),
+		PendingLocalCandidates:  peer.pendingLocalCandidates.Len(),
+		PendingRemoteCandidates: peer.pendingRemoteCandidates.Len(),
+		Callbacks: map[string]int{
+			"Signal":                   peer.onSignal.Len() + peer.onSignalBytes.Len(),
+			"Connect":                  peer.onConnect.Len(),
+			"Data":                     peer.onData.Len() + peer.onDataFrom.Len() + peer.onDataMessage.Len(),
+			"Message":                  peer.onMessage.Len(),
+			"JSON":                     peer.onJSON.Len(),
+			"Error":                    peer.onError.Len(),
+			"Close":                    peer.onClose.Len(),
+			"ChannelClose":             peer.onChannelClose.Len(),
+			"Transceiver":              peer.onTransceiver.Len(),
+			"Track":                    peer.onTrack.Len() + peer.onTrackForMid.Len(),
+			"TrackEnded":               peer.onTrackEnded.Len(),
+			"Sample":                   peer.onSample.Len(),
+			"ConnectionStateChange":    peer.onConnectionState.Len(),
+			"ICEConnectionStateChange": peer.onICEConnectionState.Len(),
+			"ICEGatheringStateChange":  peer.onICEGatheringState.Len(),
+			"SignalingStateChange":     peer.onSignalingState.Len(),
+			"NegotiationComplete":      peer.onNegotiationComplete.Len(),
+			"Reconnecting":             peer.onReconnecting.Len(),
+			"Reconnected":              peer.onReconnected.Len(),
+			"Disconnect":               peer.onDisconnect.Len(),
+			"Resume":                   peer.onResume.Len(),
+			"Failure":                  peer.onFailure.Len(),
+			"Drain":                    peer.onDrain.Len(),
+		},
+	}
+	for _, server := range peer.Config().ICEServers {
+		state.ICEServers = append(state.ICEServers, server.URLs...)
+	}
+	if info, ok := peer.ChannelInfo(); ok {
+		state.ChannelState = info.ReadyState.String()
+	}
+	peer.mutex.RLock()
+	state.Channels = len(peer.channels)
+	connection := peer.connection
+	peer.mutex.RUnlock()
+	if connection != nil {
+		state.ConnectionState = connection.ConnectionState().String()
+		state.ICEConnectionState = connection.ICEConnectionState().String()
+		state.ICEGatheringState = connection.ICEGatheringState().String()
+		state.SignalingState = connection.SignalingState().String()
+		if description := connection.LocalDescription(); description != nil {
+			state.LocalDescriptionType = description.Type.String()
+		}
+		if description := connection.RemoteDescription(); description != nil {
+			state.RemoteDescriptionType = description.Type.String()
+		}
+		for _, sender := range connection.GetSenders() {
+			if sender.Track() != nil {
+				state.LocalTracks++
+			}
+		}
+	}
+	peer.callbackMutex.Lock()
+	state.RemoteTracks = len(peer.remoteTracks)
+	peer.callbackMutex.Unlock()
+	return state
+}
+
+// String describes the peer in one line, e.g. for %v.
+func (peer *Peer) String() string {
+	state := peer.DebugState()
+	return fmt.Sprintf("Peer{id=%s initiator=%t closed=%t channel=%s connection=%s pendingLocalCandidates=%d pendingRemoteCandidates=%d}",
+		state.Id, state.Initiator, state.Closed, orNone(state.ChannelState), orNone(state.ConnectionState),
+		state.PendingLocalCandidates, state.PendingRemoteCandidates)
+}
+
+func orNone(state string) string {
+	if state == "" {
+		return "none"
+	}
+	return state
+}
@@ -0,0 +1,56 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDebugState(t *testing.T) {
+	idle := NewPeer(PeerOptions{Id: "idle"})
+	if got := fmt.Sprintf("%v", idle); got != "Peer{id=idle initiator=false closed=false channel=none connection=none pendingLocalCandidates=0 pendingRemoteCandidates=0}" {
+		t.Fatalf("unexpected description of a new peer: %s", got)
+	}
+	idle.Close()
+	if !idle.DebugState().Closed {
+		t.Fatal("expected a closed peer to be reported closed")
+	}
+
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer2.Close()
+	state := peer1.DebugState()
+	if state.Id != "peer1" || !state.Initiator || state.Closed {
+		t.Fatalf("unexpected identity: %+v", state)
+	}
+	if state.ChannelState != "open" || state.ConnectionState != "connected" || state.SignalingState != "stable" {
+		t.Fatalf("unexpected states: %+v", state)
+	}
+	if state.LocalDescriptionType != "offer" || state.RemoteDescriptionType != "answer" {
+		t.Fatalf("unexpected description types: %+v", state)
+	}
+	if state.ChannelName != peer1.ChannelName() || state.MaxMessageSize != peer1.MaxMessageSize() || len(state.ICEServers) != 0 {
+		t.Fatalf("unexpected configuration: %+v", state)
+	}
+	if state.Callbacks["Signal"] != 1 || state.Callbacks["Connect"] != 1 || state.Callbacks["Data"] != 0 {
+		t.Fatalf("unexpected callback counts: %v", state.Callbacks)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"connectionState":"connected"`) {
+		t.Fatalf("expected the states in the JSON, got %s", data)
+	}
+	if !strings.Contains(peer1.String(), "channel=open connection=connected") {
+		t.Fatalf("expected the states in the description, got %s", peer1)
+	}
+
+	peer1.Close()
+	if state := peer1.DebugState(); !state.Closed || state.ConnectionState != "" {
+		t.Fatalf("expected a closed peer without a connection, got %+v", state)
+	}
+	if !strings.Contains(peer1.String(), "closed=true") {
+		t.Fatalf("expected the description to say the peer closed, got %s", peer1)
+	}
+}
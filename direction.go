@@ -0,0 +1,228 @@
+package simplepeer
+
+import (
+	"fmt"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// OnTransceiverDirectionChange is called when a renegotiation changes the
+// direction the remote description gives a transceiver, e.g. to recvonly
+// once the remote peer paused sending on it.
+type OnTransceiverDirectionChange func(transceiver *webrtc.RTPTransceiver, direction webrtc.RTPTransceiverDirection)
+
+// pausedTrack is the track a transceiver sent before sending was paused.
+type pausedTrack struct {
+	sender *webrtc.RTPSender
+	track  webrtc.TrackLocal
+}
+
+func (peer *Peer) OnTransceiverDirectionChange(fn OnTransceiverDirectionChange) (off func()) {
+	return peer.onTransceiverDirectionChange.Append(fn)
+}
+
+// Deprecated: call the func returned by OnTransceiverDirectionChange instead.
+func (peer *Peer) OffTransceiverDirectionChange(fn OnTransceiverDirectionChange) {
+	peer.onTransceiverDirectionChange.Delete(func(index int, onTransceiverDirectionChange OnTransceiverDirectionChange) bool {
+		return sameFunc(onTransceiverDirectionChange, fn)
+	})
+}
+
+// SetTransceiverDirection changes whether the transceiver sends and
+// renegotiates. Pausing stops the sender, so no RTP is sent, and keeps its
+// track to send again once sending resumes on a new sender. pion cannot stop
+// receiving on a transceiver, so changes to receiving return
+// ErrDirectionUnsupported.
+func (peer *Peer) SetTransceiverDirection(transceiver *webrtc.RTPTransceiver, direction webrtc.RTPTransceiverDirection) error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	current := transceiver.Direction()
+	if current == direction {
+		return nil
+	}
+	if direction == webrtc.RTPTransceiverDirectionUnknown || receives(current) != receives(direction) {
+		return fmt.Errorf("%w: %s to %s", ErrDirectionUnsupported, current, direction)
+	}
+	if !sends(direction) {
+		// pion fires OnNegotiationNeeded for the removed track
+		return peer.pauseSending(connection, transceiver)
+	}
+	if err := peer.resumeSending(connection, transceiver); err != nil {
+		return err
+	}
+	// a sender set on an existing transceiver is invisible to pion's
+	// negotiation needed check until the next negotiation
+	return peer.needsNegotiation()
+}
+
+// PauseTrack stops sending the sender's track, changing its transceiver to
+// recvonly or inactive. See SetTransceiverDirection.
+func (peer *Peer) PauseTrack(sender *webrtc.RTPSender) error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	for _, transceiver := range connection.GetTransceivers() {
+		if sender != nil && transceiver.Sender() == sender {
+			return peer.SetTransceiverDirection(transceiver, withSending(transceiver.Direction(), false))
+		}
+	}
+	return ErrNoSender
+}
+
+// ResumeTrack sends the track PauseTrack paused on sender again and returns
+// the sender that now sends it.
+func (peer *Peer) ResumeTrack(sender *webrtc.RTPSender) (*webrtc.RTPSender, error) {
+	connection := peer.Connection()
+	if connection == nil {
+		return nil, peer.notInitializedErr()
+	}
+	var transceiver *webrtc.RTPTransceiver
+	peer.mutex.RLock()
+	for pausedTransceiver, paused := range peer.pausedTracks {
+		if paused.sender == sender {
+			transceiver = pausedTransceiver
+			break
+		}
+	}
+	peer.mutex.RUnlock()
+	if transceiver == nil {
+		return nil, ErrNoTrack
+	}
+	if err := peer.SetTransceiverDirection(transceiver, withSending(transceiver.Direction(), true)); err != nil {
+		return nil, err
+	}
+	return transceiver.Sender(), nil
+}
+
+func (peer *Peer) pauseSending(connection *webrtc.PeerConnection, transceiver *webrtc.RTPTransceiver) error {
+	sender := transceiver.Sender()
+	if sender == nil || sender.Track() == nil {
+		return ErrNoSender
+	}
+	track := sender.Track()
+	if err := connection.RemoveTrack(sender); err != nil {
+		return err
+	}
+	peer.mutex.Lock()
+	if peer.pausedTracks == nil {
+		peer.pausedTracks = make(map[*webrtc.RTPTransceiver]pausedTrack)
+	}
+	peer.pausedTracks[transceiver] = pausedTrack{sender: sender, track: track}
+	peer.mutex.Unlock()
+	return nil
+}
+
+func (peer *Peer) resumeSending(connection *webrtc.PeerConnection, transceiver *webrtc.RTPTransceiver) error {
+	peer.mutex.Lock()
+	paused, ok := peer.pausedTracks[transceiver]
+	delete(peer.pausedTracks, transceiver)
+	peer.mutex.Unlock()
+	if !ok {
+		return ErrNoTrack
+	}
+	sctp := connection.SCTP()
+	if peer.api == nil || sctp == nil {
+		return peer.notInitializedErr()
+	}
+	sender, err := peer.api.NewRTPSender(paused.track, sctp.Transport())
+	if err != nil {
+		return err
+	}
+	if err := transceiver.SetSender(sender, paused.track); err != nil {
+		_ = sender.Stop()
+		return err
+	}
+	return nil
+}
+
+func sends(direction webrtc.RTPTransceiverDirection) bool {
+	return direction == webrtc.RTPTransceiverDirectionSendrecv || direction == webrtc.RTPTransceiverDirectionSendonly
+}
+
+func receives(direction webrtc.RTPTransceiverDirection) bool {
+	return direction == webrtc.RTPTransceiverDirectionSendrecv || direction == webrtc.RTPTransceiverDirectionRecvonly
+}
+
+func withSending(direction webrtc.RTPTransceiverDirection, sending bool) webrtc.RTPTransceiverDirection {
+	switch {
+	case sending && receives(direction):
+		return webrtc.RTPTransceiverDirectionSendrecv
+	case sending:
+		return webrtc.RTPTransceiverDirectionSendonly
+	case receives(direction):
+		return webrtc.RTPTransceiverDirectionRecvonly
+	default:
+		return webrtc.RTPTransceiverDirectionInactive
+	}
+}
+
+// updateRemoteDirections records the directions of the applied remote
+// description by mid, ends the remote tracks of m-sections the remote peer
+// no longer sends on and calls OnTransceiverDirectionChange for the
+// transceivers whose direction changed.
+func (peer *Peer) updateRemoteDirections(connection *webrtc.PeerConnection) {
+	description := connection.RemoteDescription()
+	if description == nil {
+		return
+	}
+	// pion shares the description, so it is parsed without caching the
+	// result on it
+	var parsed sdp.SessionDescription
+	if err := parsed.UnmarshalString(description.SDP); err != nil {
+		return
+	}
+	changed := make(map[string]webrtc.RTPTransceiverDirection)
+	ended := make(map[string]bool)
+	peer.mutex.Lock()
+	if peer.remoteDirections == nil {
+		peer.remoteDirections = make(map[string]webrtc.RTPTransceiverDirection)
+	}
+	for _, media := range parsed.MediaDescriptions {
+		mid, ok := media.Attribute(sdp.AttrKeyMID)
+		if !ok || media.MediaName.Media == "application" {
+			continue
+		}
+		direction := mediaDirection(media)
+		if media.MediaName.Port.Value == 0 {
+			// a rejected m-section carries no media
+			direction = webrtc.RTPTransceiverDirectionInactive
+		}
+		if !sends(direction) {
+			ended[mid] = true
+		}
+		if previous, ok := peer.remoteDirections[mid]; ok && previous != direction {
+			changed[mid] = direction
+		}
+		peer.remoteDirections[mid] = direction
+	}
+	peer.mutex.Unlock()
+	peer.endTracks(func(remoteTrack remoteTrack) bool {
+		return ended[remoteTrack.mid]
+	})
+	if len(changed) == 0 {
+		return
+	}
+	for _, transceiver := range connection.GetTransceivers() {
+		direction, ok := changed[transceiver.Mid()]
+		if !ok {
+			continue
+		}
+		for _, fn := range peer.onTransceiverDirectionChange.Slice() {
+			go fn(transceiver, direction)
+		}
+	}
+}
+
+func mediaDirection(media *sdp.MediaDescription) webrtc.RTPTransceiverDirection {
+	for _, attribute := range media.Attributes {
+		switch attribute.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return webrtc.NewRTPTransceiverDirection(attribute.Key)
+		}
+	}
+	return webrtc.RTPTransceiverDirectionSendrecv
+}
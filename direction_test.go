@@ -0,0 +1,106 @@
+package simplepeer
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/aicacia/go-simplepeer/testsrc"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestPauseResumeTrack(t *testing.T) {
+	var samples atomic.Int32
+	directions := make(chan webrtc.RTPTransceiverDirection, 4)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnSample: func(track *webrtc.TrackRemote, sample media.Sample) {
+			samples.Add(1)
+		},
+		OnTransceiverDirectionChange: func(transceiver *webrtc.RTPTransceiver, direction webrtc.RTPTransceiverDirection) {
+			directions <- direction
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	track, err := testsrc.NewVP8(testsrc.Options{FrameRate: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := peer1.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track.Start()
+	defer track.Stop()
+	testutil.WaitFor(t, func() bool {
+		return samples.Load() > 5
+	})
+
+	if err := peer1.PauseTrack(sender); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case direction := <-directions:
+		if direction != webrtc.RTPTransceiverDirectionRecvonly {
+			t.Fatalf("expected the remote direction recvonly, got %s", direction)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the direction change")
+	}
+	time.Sleep(200 * time.Millisecond)
+	paused := samples.Load()
+	time.Sleep(300 * time.Millisecond)
+	if samples.Load() != paused {
+		t.Fatal("expected no samples while paused")
+	}
+
+	resumed, err := peer1.ResumeTrack(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed == nil || resumed == sender || resumed.Track() != track {
+		t.Fatal("expected a new sender for the track")
+	}
+	select {
+	case direction := <-directions:
+		if direction != webrtc.RTPTransceiverDirectionSendrecv {
+			t.Fatalf("expected the remote direction sendrecv, got %s", direction)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the direction change")
+	}
+	testutil.WaitFor(t, func() bool {
+		return samples.Load() > paused+5
+	})
+}
+
+func TestSetTransceiverDirectionUnsupported(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	track, err := testsrc.NewOpus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := peer1.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transceiver *webrtc.RTPTransceiver
+	for _, candidate := range peer1.Connection().GetTransceivers() {
+		if candidate.Sender() == sender {
+			transceiver = candidate
+		}
+	}
+	if err := peer1.SetTransceiverDirection(transceiver, webrtc.RTPTransceiverDirectionSendonly); !errors.Is(err, ErrDirectionUnsupported) {
+		t.Fatalf("expected ErrDirectionUnsupported, got %v", err)
+	}
+	if _, err := peer1.ResumeTrack(sender); !errors.Is(err, ErrNoTrack) {
+		t.Fatalf("expected ErrNoTrack for a track that is not paused, got %v", err)
+	}
+}
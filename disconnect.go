@@ -0,0 +1,119 @@
+package simplepeer
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultDisconnectedTimeout = 5 * time.Second
+
+// OnDisconnect is called when the connection becomes disconnected, which is
+// often transient, and OnResume when it recovers within
+// PeerOptions.DisconnectedTimeout.
+type OnDisconnect func()
+type OnResume func()
+
+// disconnectGrace waits for a disconnected connection to recover before it
+// is given up.
+type disconnectGrace struct {
+	mutex   sync.Mutex
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// start calls fn once the timeout passes, unless stop is called first. It
+// returns false if a timer is already running.
+func (grace *disconnectGrace) start(fn func()) bool {
+	grace.mutex.Lock()
+	defer grace.mutex.Unlock()
+	if grace.timer != nil {
+		return false
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(grace.timeout, func() {
+		grace.mutex.Lock()
+		expired := grace.timer == timer
+		if expired {
+			grace.timer = nil
+		}
+		grace.mutex.Unlock()
+		if expired {
+			fn()
+		}
+	})
+	grace.timer = timer
+	return true
+}
+
+// stop cancels the timer and returns true if one was running.
+func (grace *disconnectGrace) stop() bool {
+	grace.mutex.Lock()
+	defer grace.mutex.Unlock()
+	if grace.timer == nil {
+		return false
+	}
+	grace.timer.Stop()
+	grace.timer = nil
+	return true
+}
+
+func (peer *Peer) OnDisconnect(fn OnDisconnect) (off func()) {
+	return peer.onDisconnect.Append(fn)
+}
+
+// Deprecated: call the func returned by OnDisconnect instead.
+func (peer *Peer) OffDisconnect(fn OnDisconnect) {
+	peer.onDisconnect.Delete(func(index int, onDisconnect OnDisconnect) bool {
+		return sameFunc(onDisconnect, fn)
+	})
+}
+
+func (peer *Peer) OnResume(fn OnResume) (off func()) {
+	return peer.onResume.Append(fn)
+}
+
+// Deprecated: call the func returned by OnResume instead.
+func (peer *Peer) OffResume(fn OnResume) {
+	peer.onResume.Delete(func(index int, onResume OnResume) bool {
+		return sameFunc(onResume, fn)
+	})
+}
+
+// onDisconnected gives the connection DisconnectedTimeout to recover before
+// it is lost.
+func (peer *Peer) onDisconnected() {
+	if peer.Context().Err() != nil {
+		return
+	}
+	if peer.disconnects.timeout <= 0 {
+		peer.onConnectionLost(ErrConnectionDisconnected)
+		return
+	}
+	connection := peer.Connection()
+	started := peer.disconnects.start(func() {
+		// leaving the disconnected state stops the timer, but a replaced
+		// connection must not be given up for its successor
+		if connection == nil || !peer.isCurrentConnection(connection) {
+			return
+		}
+		peer.debugf("connection did not recover within %s", peer.disconnects.timeout)
+		peer.onConnectionLost(ErrConnectionDisconnected)
+	})
+	if !started {
+		return
+	}
+	for _, fn := range peer.onDisconnect.Slice() {
+		go fn()
+	}
+}
+
+// onResumed reports a disconnected connection that recovered.
+func (peer *Peer) onResumed() {
+	if !peer.disconnects.stop() {
+		return
+	}
+	peer.debugf("connection resumed")
+	for _, fn := range peer.onResume.Slice() {
+		go fn()
+	}
+}
@@ -0,0 +1,65 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestDisconnectedResume(t *testing.T) {
+	disconnected := make(chan struct{}, 1)
+	resumed := make(chan struct{}, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		DisconnectedTimeout: 200 * time.Millisecond,
+		OnDisconnect: func() {
+			disconnected <- struct{}{}
+		},
+		OnResume: func() {
+			resumed <- struct{}{}
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateDisconnected)
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnDisconnect")
+	}
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateConnected)
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnResume")
+	}
+	time.Sleep(400 * time.Millisecond)
+	if err := peer1.Context().Err(); err != nil {
+		t.Fatalf("expected the resumed peer to stay open, got %v", err)
+	}
+}
+
+func TestDisconnectedTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		DisconnectedTimeout: timeout,
+		OnError:             func(err error) {},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	start := time.Now()
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateDisconnected)
+	testutil.WaitFor(t, func() bool {
+		return peer1.Context().Err() != nil
+	})
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("expected the peer to wait %s for the connection to recover, closed after %s", timeout, elapsed)
+	}
+	if cause := context.Cause(peer1.Context()); !errors.Is(cause, ErrConnectionDisconnected) {
+		t.Fatalf("expected ErrConnectionDisconnected, got %v", cause)
+	}
+}
@@ -0,0 +1,67 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	withV2Defaults(t)
+	noSignal := func(message map[string]interface{}) error { return nil }
+	connected1, connected2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer connected2.Close()
+	connected1.Close()
+
+	tests := []struct {
+		name     string
+		call     func(peer *Peer) error
+		expected error
+	}{
+		{"write before init", func(peer *Peer) error {
+			_, err := peer.Write([]byte("early"))
+			return err
+		}, ErrConnectionNotInitialized},
+		{"signal without type", func(peer *Peer) error {
+			return peer.Signal(map[string]interface{}{"type": 1})
+		}, ErrInvalidSignalMessageType},
+		{"offer without sdp", func(peer *Peer) error {
+			return peer.Signal(map[string]interface{}{"type": "offer"})
+		}, ErrInvalidSignalMessage},
+		{"renegotiate to the answerer", func(peer *Peer) error {
+			return peer.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true})
+		}, ErrInvalidSignalState},
+		{"write while connecting", func(peer *Peer) error {
+			if err := peer.Init(); err != nil {
+				return err
+			}
+			_, err := peer.Write([]byte("early"))
+			return err
+		}, ErrChannelNotOpen},
+		{"write after close", func(peer *Peer) error {
+			_, err := connected1.Write([]byte("late"))
+			return err
+		}, ErrPeerClosed},
+		{"flush after close", func(peer *Peer) error {
+			return connected1.Flush(context.Background())
+		}, ErrPeerClosed},
+		{"wait for channels after close", func(peer *Peer) error {
+			return connected1.WaitForChannels(context.Background())
+		}, ErrPeerClosed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := NewPeer(PeerOptions{OnSignal: noSignal})
+			defer peer.Close()
+			if err := test.call(peer); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestUnexportedErrorAliases(t *testing.T) {
+	if !errors.Is(errConnectionNotInitialized, ErrConnectionNotInitialized) || !errors.Is(ErrNotConnected, ErrChannelNotOpen) {
+		t.Fatal("expected the old names to match the exported errors")
+	}
+}
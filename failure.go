@@ -0,0 +1,161 @@
+package simplepeer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type FailureStage string
+
+const (
+	// FailureStageSignaling means no remote description was ever applied.
+	FailureStageSignaling FailureStage = "signaling"
+	// FailureStageGathering means no local candidate was gathered.
+	FailureStageGathering FailureStage = "gathering"
+	// FailureStageConnectivity means ICE never found a working pair.
+	FailureStageConnectivity FailureStage = "connectivity"
+	// FailureStageDTLS means ICE connected but the DTLS handshake did not.
+	FailureStageDTLS FailureStage = "dtls"
+	// FailureStageChannelOpen means the connection came up but the data
+	// channel never opened.
+	FailureStageChannelOpen FailureStage = "channel-open"
+)
+
+// NegotiationFailure is the verdict for a connection attempt that closed
+// without ever connecting. Sessions that connected and later dropped are not
+// failures.
+type NegotiationFailure struct {
+	Stage FailureStage
+	// Err is the last error reported through OnError, or the reason the
+	// connection closed if there was none.
+	Err              error
+	LocalCandidates  map[webrtc.ICECandidateType]int
+	RemoteCandidates map[webrtc.ICECandidateType]int
+	// RelayTried reports whether a relay candidate was gathered or received.
+	RelayTried bool
+	Elapsed    time.Duration
+}
+
+type OnFailure func(failure NegotiationFailure)
+
+func (peer *Peer) OnFailure(fn OnFailure) (off func()) {
+	return peer.onFailure.Append(fn)
+}
+
+// Deprecated: call the func returned by OnFailure instead.
+func (peer *Peer) OffFailure(fn OnFailure) {
+	peer.onFailure.Delete(func(index int, onFailure OnFailure) bool {
+		return sameFunc(onFailure, fn)
+	})
+}
+
+// Failure returns the verdict of the last connection attempt if it failed.
+func (peer *Peer) Failure() (NegotiationFailure, bool) {
+	peer.attempt.mutex.Lock()
+	defer peer.attempt.mutex.Unlock()
+	if peer.attempt.failure == nil {
+		return NegotiationFailure{}, false
+	}
+	return *peer.attempt.failure, true
+}
+
+// connectionAttempt tracks how far the current connection got, to attribute a
+// failure to a stage.
+type connectionAttempt struct {
+	mutex            sync.Mutex
+	start            time.Time
+	localCandidates  map[webrtc.ICECandidateType]int
+	remoteCandidates map[webrtc.ICECandidateType]int
+	iceConnected     bool
+	dtlsConnected    bool
+	connected        bool
+	lastError        error
+	failure          *NegotiationFailure
+}
+
+func (attempt *connectionAttempt) reset() {
+	attempt.mutex.Lock()
+	defer attempt.mutex.Unlock()
+	attempt.start = time.Now()
+	attempt.localCandidates = make(map[webrtc.ICECandidateType]int)
+	attempt.remoteCandidates = make(map[webrtc.ICECandidateType]int)
+	attempt.iceConnected = false
+	attempt.dtlsConnected = false
+	attempt.connected = false
+	attempt.lastError = nil
+	attempt.failure = nil
+}
+
+func (attempt *connectionAttempt) update(fn func(attempt *connectionAttempt)) {
+	attempt.mutex.Lock()
+	defer attempt.mutex.Unlock()
+	fn(attempt)
+}
+
+func (attempt *connectionAttempt) addRemoteCandidate(candidate webrtc.ICECandidateInit) {
+	candidateType, ok := parseCandidateType(candidate.Candidate)
+	if !ok {
+		return
+	}
+	attempt.update(func(attempt *connectionAttempt) {
+		if attempt.remoteCandidates != nil {
+			attempt.remoteCandidates[candidateType]++
+		}
+	})
+}
+
+// fail records the failure of the attempt unless it ever connected.
+func (attempt *connectionAttempt) fail(connection *webrtc.PeerConnection, cause error) *NegotiationFailure {
+	attempt.mutex.Lock()
+	defer attempt.mutex.Unlock()
+	if attempt.connected || attempt.failure != nil || attempt.start.IsZero() {
+		return nil
+	}
+	failure := NegotiationFailure{
+		Err:              attempt.lastError,
+		LocalCandidates:  make(map[webrtc.ICECandidateType]int, len(attempt.localCandidates)),
+		RemoteCandidates: make(map[webrtc.ICECandidateType]int, len(attempt.remoteCandidates)),
+		Elapsed:          time.Since(attempt.start),
+	}
+	if failure.Err == nil {
+		failure.Err = cause
+	}
+	localCount := 0
+	for candidateType, count := range attempt.localCandidates {
+		failure.LocalCandidates[candidateType] = count
+		localCount += count
+	}
+	for candidateType, count := range attempt.remoteCandidates {
+		failure.RemoteCandidates[candidateType] = count
+	}
+	failure.RelayTried = failure.LocalCandidates[webrtc.ICECandidateTypeRelay] > 0 || failure.RemoteCandidates[webrtc.ICECandidateTypeRelay] > 0
+	switch {
+	case connection == nil || connection.RemoteDescription() == nil:
+		failure.Stage = FailureStageSignaling
+	case localCount == 0:
+		failure.Stage = FailureStageGathering
+	case !attempt.iceConnected:
+		failure.Stage = FailureStageConnectivity
+	case !attempt.dtlsConnected:
+		failure.Stage = FailureStageDTLS
+	default:
+		failure.Stage = FailureStageChannelOpen
+	}
+	attempt.failure = &failure
+	return &failure
+}
+
+// parseCandidateType reads the "typ" of a candidate attribute value.
+func parseCandidateType(candidate string) (webrtc.ICECandidateType, bool) {
+	fields := strings.Fields(candidate)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "typ" {
+			candidateType, err := webrtc.NewICECandidateType(fields[i+1])
+			return candidateType, err == nil
+		}
+	}
+	return webrtc.ICECandidateTypeUnknown, false
+}
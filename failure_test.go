@@ -0,0 +1,189 @@
+package simplepeer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+func waitForFailure(t *testing.T, failures chan NegotiationFailure) NegotiationFailure {
+	select {
+	case failure := <-failures:
+		return failure
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the negotiation failure")
+	}
+	return NegotiationFailure{}
+}
+
+func TestNegotiationFailureSignaling(t *testing.T) {
+	failures := make(chan NegotiationFailure, 1)
+	peer := NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			return nil
+		},
+		OnFailure: func(failure NegotiationFailure) {
+			failures <- failure
+		},
+	})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		return peer.LocalDescription() != nil
+	})
+	peer.Connection().Close()
+
+	failure := waitForFailure(t, failures)
+	if failure.Stage != FailureStageSignaling {
+		t.Fatalf("expected stage signaling, got %s", failure.Stage)
+	}
+	if !errors.Is(failure.Err, ErrConnectionClosed) {
+		t.Fatalf("expected connection closed, got %v", failure.Err)
+	}
+	if stored, ok := peer.Failure(); !ok || stored.Stage != failure.Stage {
+		t.Fatalf("expected Failure to return the delivered failure, got %+v", stored)
+	}
+}
+
+func TestNegotiationFailureGathering(t *testing.T) {
+	failures := make(chan NegotiationFailure, 1)
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		// no ICE servers, so relay-only gathering finds nothing
+		Config: &webrtc.Configuration{ICETransportPolicy: webrtc.ICETransportPolicyRelay},
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnFailure: func(failure NegotiationFailure) {
+			failures <- failure
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		return peer1.RemoteDescription() != nil
+	})
+	peer1.Connection().Close()
+
+	failure := waitForFailure(t, failures)
+	if failure.Stage != FailureStageGathering {
+		t.Fatalf("expected stage gathering, got %s", failure.Stage)
+	}
+	if failure.RelayTried {
+		t.Fatal("expected no relay candidates")
+	}
+}
+
+func TestNegotiationFailureConnectivity(t *testing.T) {
+	failures := make(chan NegotiationFailure, 1)
+	gathered := make(chan bool, 1)
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageCandidate {
+				select {
+				case gathered <- true:
+				default:
+				}
+				return nil
+			}
+			return peer2.Signal(message)
+		},
+		OnFailure: func(failure NegotiationFailure) {
+			failures <- failure
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageCandidate {
+				return nil
+			}
+			return peer1.Signal(message)
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gathered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a local candidate")
+	}
+	testutil.WaitFor(t, func() bool {
+		return peer1.RemoteDescription() != nil
+	})
+	peer1.Connection().Close()
+
+	failure := waitForFailure(t, failures)
+	if failure.Stage != FailureStageConnectivity {
+		t.Fatalf("expected stage connectivity, got %s", failure.Stage)
+	}
+	if failure.LocalCandidates[webrtc.ICECandidateTypeHost] == 0 {
+		t.Fatalf("expected host candidates to be counted, got %v", failure.LocalCandidates)
+	}
+	if failure.Elapsed <= 0 {
+		t.Fatal("expected elapsed time to be recorded")
+	}
+}
+
+func TestNoNegotiationFailureAfterConnect(t *testing.T) {
+	failures := make(chan NegotiationFailure, 1)
+	closed := make(chan bool, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnFailure: func(failure NegotiationFailure) {
+			failures <- failure
+		},
+		OnClose: func() {
+			closed <- true
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	peer1.Connection().Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for close")
+	}
+	select {
+	case failure := <-failures:
+		t.Fatalf("expected no failure for a dropped session, got %+v", failure)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok := peer1.Failure(); ok {
+		t.Fatal("expected no failure for a dropped session")
+	}
+}
+
+func TestParseCandidateType(t *testing.T) {
+	for candidate, expected := range map[string]webrtc.ICECandidateType{
+		"candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host":                                    webrtc.ICECandidateTypeHost,
+		"candidate:2 1 udp 1694498815 1.2.3.4 50000 typ srflx raddr 192.168.1.2 rport 50000":         webrtc.ICECandidateTypeSrflx,
+		"candidate:3 1 udp 16777215 5.6.7.8 3478 typ relay raddr 1.2.3.4 rport 50000":                webrtc.ICECandidateTypeRelay,
+		"candidate:4 1 udp 1845501695 9.9.9.9 50000 typ prflx raddr 192.168.1.2 rport 50000 ufrag x": webrtc.ICECandidateTypePrflx,
+	} {
+		candidateType, ok := parseCandidateType(candidate)
+		if !ok || candidateType != expected {
+			t.Fatalf("%s: expected %s, got %s", candidate, expected, candidateType)
+		}
+	}
+	if _, ok := parseCandidateType(""); ok {
+		t.Fatal("expected the end of candidates marker to have no type")
+	}
+}
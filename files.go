@@ -0,0 +1,359 @@
+package simplepeer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// File transfer
+//
+// SendFile and OnFileOffer exchange files over framed messages. Every file
+// message is one binary frame starting with fileMagic, a kind byte and the
+// 36 byte transfer id, followed by the kind's payload: the JSON FileMeta
+// for an offer, file data, the SHA-256 of the file for the end message and
+// a status byte and reason for the done message. The sender offers the
+// file, waits for it to be accepted, sends the data and the checksum, and
+// waits for the receiver to confirm the size and checksum matched. File
+// frames are not passed to OnMessage, ReadMessage, MessageReader or OnJSON.
+var fileMagic = []byte{0, 'S', 'P', 'F'}
+
+const (
+	fileOffer byte = iota + 1
+	fileAccept
+	fileReject
+	fileData
+	fileEnd
+	fileCancel
+	fileDone
+
+	fileIdSize     = 36
+	fileHeaderSize = 4 + 1 + fileIdSize
+	fileChunkSize  = 256 * 1024
+)
+
+const (
+	fileDoneOk byte = iota
+	fileDoneChecksum
+	fileDoneFailed
+)
+
+// FileMeta describes a file offered by the remote peer.
+type FileMeta struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// OnFileOffer is called for every file the remote peer offers. Calling
+// accept before returning accepts the file and writes it to w, otherwise
+// the offer is rejected. If w is an io.Closer it is closed once the whole
+// file arrived, and if it has a CloseWithError(error) error method like
+// io.PipeWriter a failed transfer closes it with the error.
+type OnFileOffer func(meta FileMeta, accept func(w io.Writer))
+
+type fileReply struct {
+	kind    byte
+	payload []byte
+}
+
+type fileReceive struct {
+	meta     FileMeta
+	writer   io.Writer
+	hash     hash.Hash
+	received int64
+}
+
+type fileTransfers struct {
+	mutex     sync.Mutex
+	sending   map[string]chan fileReply
+	receiving map[string]*fileReceive
+}
+
+func (files *fileTransfers) startSend(id string) chan fileReply {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	if files.sending == nil {
+		files.sending = make(map[string]chan fileReply)
+	}
+	replies := make(chan fileReply, 4)
+	files.sending[id] = replies
+	return replies
+}
+
+func (files *fileTransfers) endSend(id string) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	delete(files.sending, id)
+}
+
+func (files *fileTransfers) reply(id string, reply fileReply) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	if replies, ok := files.sending[id]; ok {
+		select {
+		case replies <- reply:
+		default:
+		}
+	}
+}
+
+func (files *fileTransfers) startReceive(id string, receive *fileReceive) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	if files.receiving == nil {
+		files.receiving = make(map[string]*fileReceive)
+	}
+	files.receiving[id] = receive
+}
+
+func (files *fileTransfers) receive(id string) *fileReceive {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	return files.receiving[id]
+}
+
+func (files *fileTransfers) endReceive(id string) *fileReceive {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	receive := files.receiving[id]
+	delete(files.receiving, id)
+	return receive
+}
+
+// OnFileOffer adds a callback for files offered by the remote peer. It
+// requires PeerOptions.FrameMessages.
+func (peer *Peer) OnFileOffer(fn OnFileOffer) (off func()) {
+	return peer.onFileOffer.Append(fn)
+}
+
+// Deprecated: call the func returned by OnFileOffer instead.
+func (peer *Peer) OffFileOffer(fn OnFileOffer) {
+	peer.onFileOffer.Delete(func(index int, onFileOffer OnFileOffer) bool {
+		return sameFunc(onFileOffer, fn)
+	})
+}
+
+// SendFile offers size bytes read from r to the remote peer as name and
+// sends them once the offer is accepted, calling progress, if set, after
+// every chunk. It returns once the remote peer confirmed the file arrived
+// whole, ErrFileRejected if it was rejected and ErrFileChecksum if the data
+// did not match size or the checksum. Cancelling ctx cancels the transfer
+// on both sides. It requires PeerOptions.FrameMessages.
+func (peer *Peer) SendFile(ctx context.Context, name string, r io.Reader, size int64, progress func(sent int64)) error {
+	if !peer.frameMessages {
+		return errFramingDisabled
+	}
+	id := uuid.New().String()
+	replies := peer.files.startSend(id)
+	defer peer.files.endSend(id)
+	meta, err := json.Marshal(FileMeta{Name: name, Size: size})
+	if err != nil {
+		return err
+	}
+	if err := peer.sendFileFrame(ctx, fileOffer, id, meta); err != nil {
+		return err
+	}
+	reply, err := peer.waitForFileReply(ctx, id, replies)
+	if err != nil {
+		return err
+	}
+	if reply.kind != fileAccept {
+		return fileReplyError(reply)
+	}
+	checksum := sha256.New()
+	buffer := make([]byte, fileChunkSize)
+	var sent int64
+	for {
+		n, readErr := r.Read(buffer)
+		if n > 0 {
+			select {
+			case reply := <-replies:
+				return fileReplyError(reply)
+			default:
+			}
+			checksum.Write(buffer[:n])
+			if err := peer.sendFileFrame(ctx, fileData, id, buffer[:n]); err != nil {
+				peer.cancelFile(id)
+				return err
+			}
+			sent += int64(n)
+			if progress != nil {
+				progress(sent)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			peer.cancelFile(id)
+			return readErr
+		}
+	}
+	if err := peer.sendFileFrame(ctx, fileEnd, id, checksum.Sum(nil)); err != nil {
+		peer.cancelFile(id)
+		return err
+	}
+	reply, err = peer.waitForFileReply(ctx, id, replies)
+	if err != nil {
+		return err
+	}
+	return fileReplyError(reply)
+}
+
+func (peer *Peer) waitForFileReply(ctx context.Context, id string, replies chan fileReply) (fileReply, error) {
+	peer.mutex.RLock()
+	peerContext := peer.context
+	peer.mutex.RUnlock()
+	select {
+	case <-ctx.Done():
+		peer.cancelFile(id)
+		return fileReply{}, ctx.Err()
+	case <-peerContext.Done():
+		return fileReply{}, peerClosedErr(peerContext)
+	case reply := <-replies:
+		return reply, nil
+	}
+}
+
+// fileReplyError returns the error a reply from the receiver reports, if
+// any.
+func fileReplyError(reply fileReply) error {
+	switch reply.kind {
+	case fileReject:
+		return ErrFileRejected
+	case fileCancel:
+		return ErrFileCancelled
+	case fileDone:
+		if len(reply.payload) == 0 || reply.payload[0] == fileDoneOk {
+			return nil
+		}
+		return fileDoneError(reply.payload[0], string(reply.payload[1:]))
+	}
+	return fmt.Errorf("%w: unexpected reply %d", ErrFileTransfer, reply.kind)
+}
+
+func (peer *Peer) sendFileFrame(ctx context.Context, kind byte, id string, payload []byte) error {
+	frame := make([]byte, 0, fileHeaderSize+len(payload))
+	frame = append(frame, fileMagic...)
+	frame = append(frame, kind)
+	frame = append(frame, id...)
+	frame = append(frame, payload...)
+	_, err := peer.sendContext(ctx, frame, false)
+	return err
+}
+
+// replyFile sends a reply from the receive loop without blocking it.
+func (peer *Peer) replyFile(kind byte, id string, payload []byte) {
+	go func() {
+		if err := peer.sendFileFrame(context.Background(), kind, id, payload); err != nil {
+			peer.debugf("failed to send file reply: %s", err)
+		}
+	}()
+}
+
+func (peer *Peer) cancelFile(id string) {
+	peer.replyFile(fileCancel, id, nil)
+}
+
+// onFileMessage handles a reassembled frame if it is a file message.
+func (peer *Peer) onFileMessage(message []byte) bool {
+	if len(message) < fileHeaderSize || !bytes.HasPrefix(message, fileMagic) {
+		return false
+	}
+	kind := message[len(fileMagic)]
+	id := string(message[len(fileMagic)+1 : fileHeaderSize])
+	payload := message[fileHeaderSize:]
+	switch kind {
+	case fileOffer:
+		var meta FileMeta
+		if err := json.Unmarshal(payload, &meta); err != nil {
+			peer.error(fmt.Errorf("%w: %s", ErrMalformedFrame, err))
+			peer.replyFile(fileReject, id, nil)
+			return true
+		}
+		peer.onFileOfferMessage(id, meta)
+	case fileData:
+		receive := peer.files.receive(id)
+		if receive == nil {
+			return true
+		}
+		receive.hash.Write(payload)
+		receive.received += int64(len(payload))
+		if _, err := receive.writer.Write(payload); err != nil {
+			peer.files.endReceive(id)
+			peer.failFile(id, receive, fileDoneFailed, err.Error())
+		}
+	case fileEnd:
+		receive := peer.files.endReceive(id)
+		if receive == nil {
+			return true
+		}
+		if receive.received != receive.meta.Size {
+			peer.failFile(id, receive, fileDoneChecksum, fmt.Sprintf("received %d of %d bytes", receive.received, receive.meta.Size))
+		} else if !bytes.Equal(receive.hash.Sum(nil), payload) {
+			peer.failFile(id, receive, fileDoneChecksum, "sha-256 mismatch")
+		} else {
+			if closer, ok := receive.writer.(io.Closer); ok {
+				closer.Close()
+			}
+			peer.replyFile(fileDone, id, []byte{fileDoneOk})
+		}
+	case fileCancel:
+		if receive := peer.files.endReceive(id); receive != nil {
+			closeFileWriter(receive.writer, ErrFileCancelled)
+		}
+		peer.files.reply(id, fileReply{kind: kind})
+	case fileAccept, fileReject, fileDone:
+		peer.files.reply(id, fileReply{kind: kind, payload: payload})
+	}
+	return true
+}
+
+func (peer *Peer) onFileOfferMessage(id string, meta FileMeta) {
+	go func() {
+		var once sync.Once
+		accepted := false
+		accept := func(w io.Writer) {
+			once.Do(func() {
+				accepted = true
+				peer.files.startReceive(id, &fileReceive{meta: meta, writer: w, hash: sha256.New()})
+				peer.replyFile(fileAccept, id, nil)
+			})
+		}
+		for _, fn := range peer.onFileOffer.Slice() {
+			fn(meta, accept)
+		}
+		once.Do(func() {})
+		if !accepted {
+			peer.replyFile(fileReject, id, nil)
+		}
+	}()
+}
+
+func fileDoneError(status byte, reason string) error {
+	if status == fileDoneChecksum {
+		return fmt.Errorf("%w: %s", ErrFileChecksum, reason)
+	}
+	return fmt.Errorf("%w: %s", ErrFileTransfer, reason)
+}
+
+// failFile reports a failed transfer to the sender, the writer and OnError.
+func (peer *Peer) failFile(id string, receive *fileReceive, status byte, reason string) {
+	err := fileDoneError(status, reason)
+	closeFileWriter(receive.writer, err)
+	peer.replyFile(fileDone, id, append([]byte{status}, reason...))
+	peer.error(err)
+}
+
+func closeFileWriter(w io.Writer, err error) {
+	if closer, ok := w.(interface{ CloseWithError(error) error }); ok {
+		closer.CloseWithError(err)
+	}
+}
@@ -0,0 +1,125 @@
+package simplepeer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type fileResult struct {
+	meta FileMeta
+	data []byte
+	err  error
+}
+
+// acceptFiles accepts every offered file into a pipe and reports what was
+// read from it.
+func acceptFiles(peer *Peer) chan fileResult {
+	results := make(chan fileResult, 1)
+	peer.OnFileOffer(func(meta FileMeta, accept func(w io.Writer)) {
+		reader, writer := io.Pipe()
+		accept(writer)
+		go func() {
+			data, err := io.ReadAll(reader)
+			results <- fileResult{meta: meta, data: data, err: err}
+		}()
+	})
+	return results
+}
+
+func TestSendFile(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{FrameMessages: true}, PeerOptions{FrameMessages: true})
+	defer peer1.Close()
+	defer peer2.Close()
+	results := acceptFiles(peer2)
+
+	file := make([]byte, 3*fileChunkSize+123)
+	rand.Read(file)
+	var progress []int64
+	err := peer1.SendFile(context.Background(), "data.bin", bytes.NewReader(file), int64(len(file)), func(sent int64) {
+		progress = append(progress, sent)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := <-results
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.meta.Name != "data.bin" || result.meta.Size != int64(len(file)) || !bytes.Equal(result.data, file) {
+		t.Fatalf("expected data.bin with %d bytes, got %s with %d bytes", len(file), result.meta.Name, len(result.data))
+	}
+	if len(progress) != 4 || progress[len(progress)-1] != int64(len(file)) {
+		t.Fatalf("expected progress for every chunk, got %v", progress)
+	}
+}
+
+func TestSendFileRejected(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{FrameMessages: true}, PeerOptions{FrameMessages: true})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer2.OnFileOffer(func(meta FileMeta, accept func(w io.Writer)) {})
+
+	err := peer1.SendFile(context.Background(), "data.bin", bytes.NewReader([]byte("data")), 4, nil)
+	if !errors.Is(err, ErrFileRejected) {
+		t.Fatalf("expected ErrFileRejected, got %v", err)
+	}
+}
+
+func TestSendFileSizeMismatch(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{FrameMessages: true}, PeerOptions{FrameMessages: true})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer2.OnError(func(err error) {})
+	results := acceptFiles(peer2)
+
+	err := peer1.SendFile(context.Background(), "data.bin", bytes.NewReader([]byte("data")), 5, nil)
+	if !errors.Is(err, ErrFileChecksum) {
+		t.Fatalf("expected ErrFileChecksum, got %v", err)
+	}
+	if result := <-results; !errors.Is(result.err, ErrFileChecksum) {
+		t.Fatalf("expected the receiver to fail with ErrFileChecksum, got %v", result.err)
+	}
+}
+
+func TestSendFileCancelled(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{FrameMessages: true}, PeerOptions{FrameMessages: true})
+	defer peer1.Close()
+	defer peer2.Close()
+	results := acceptFiles(peer2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := make(chan int64, 1)
+	go func() {
+		<-progress
+		cancel()
+	}()
+	err := peer1.SendFile(ctx, "data.bin", io.MultiReader(bytes.NewReader([]byte("partial")), waitingReader{ctx}), 100, func(sent int64) {
+		progress <- sent
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	select {
+	case result := <-results:
+		if !errors.Is(result.err, ErrFileCancelled) {
+			t.Fatalf("expected the receiver to see ErrFileCancelled, got %v", result.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the receiver to see the cancellation")
+	}
+}
+
+// waitingReader blocks until its context is done.
+type waitingReader struct {
+	ctx context.Context
+}
+
+func (reader waitingReader) Read(bytes []byte) (int, error) {
+	<-reader.ctx.Done()
+	return 0, reader.ctx.Err()
+}
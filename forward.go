@@ -0,0 +1,384 @@
+package simplepeer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	forwardBurstWindow = 250 * time.Millisecond
+	// forwardKeyFrameInterval rate-limits the keyframes a target waiting for
+	// one requests from the source.
+	forwardKeyFrameInterval = time.Second
+)
+
+// ForwardPolicy controls how much forwarded media a single destination
+// receives.
+type ForwardPolicy struct {
+	// TargetBitrate caps forwarded video in bits per second. When over budget
+	// whole frames are dropped and forwarding resumes on the next keyframe.
+	// Audio is never dropped. Zero forwards everything.
+	TargetBitrate uint64
+}
+
+type ForwardStats struct {
+	PacketsForwarded uint64
+	PacketsDropped   uint64
+	BytesForwarded   uint64
+	BytesDropped     uint64
+}
+
+// ForwardTarget shapes forwarded RTP for one destination. The policy can be
+// changed at any time, e.g. as quality reports arrive. Sequence numbers are
+// rewritten per destination so dropped frames leave no gaps that the
+// receiver would report as loss.
+type ForwardTarget struct {
+	mutex               sync.Mutex
+	kind                webrtc.RTPCodecType
+	policy              ForwardPolicy
+	tokens              float64
+	lastRefill          time.Time
+	hasFrame            bool
+	frameTimestamp      uint32
+	forwardingFrame     bool
+	waitingForKeyframe  bool
+	lastKeyFrameRequest time.Time
+	droppedPackets      uint16
+	stats               ForwardStats
+}
+
+func newForwardTarget(kind webrtc.RTPCodecType, policy ForwardPolicy) *ForwardTarget {
+	target := &ForwardTarget{kind: kind}
+	target.SetPolicy(policy)
+	return target
+}
+
+func (target *ForwardTarget) Kind() webrtc.RTPCodecType {
+	return target.kind
+}
+
+func (target *ForwardTarget) Policy() ForwardPolicy {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	return target.policy
+}
+
+func (target *ForwardTarget) SetPolicy(policy ForwardPolicy) {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	target.policy = policy
+	target.tokens = target.capacity()
+	target.lastRefill = time.Time{}
+}
+
+func (target *ForwardTarget) Stats() ForwardStats {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	return target.stats
+}
+
+// trackForward pumps the RTP of a remote track of source to a local track
+// on every destination.
+type trackForward struct {
+	source       *Peer
+	remote       *webrtc.TrackRemote
+	destinations []forwardDestination
+	stopped      chan struct{}
+	stopOnce     sync.Once
+}
+
+type forwardDestination struct {
+	peer   *Peer
+	track  *webrtc.TrackLocalStaticRTP
+	sender *webrtc.RTPSender
+	target *ForwardTarget
+}
+
+// ForwardTrack adds a track with the codec of remote to every target, which
+// negotiates it, and forwards the RTP of remote to them until stop is called
+// or remote ends. The peer reads remote from then on. Picture loss
+// indications from the targets are passed on to the peer so its sender sends
+// a keyframe. How much each target receives is set on its ForwardTarget.
+func (peer *Peer) ForwardTrack(remote *webrtc.TrackRemote, targets ...*Peer) (stop func(), err error) {
+	if remote == nil {
+		return nil, ErrNoTrack
+	}
+	forward := &trackForward{
+		source:  peer,
+		remote:  remote,
+		stopped: make(chan struct{}),
+	}
+	codec := remote.Codec()
+	for _, target := range targets {
+		track, err := webrtc.NewTrackLocalStaticRTP(codec.RTPCodecCapability, remote.ID(), remote.StreamID())
+		if err != nil {
+			forward.stop()
+			return nil, err
+		}
+		sender, err := target.AddTrack(track)
+		if sender != nil {
+			forward.destinations = append(forward.destinations, forwardDestination{
+				peer:   target,
+				track:  track,
+				sender: sender,
+				target: newForwardTarget(remote.Kind(), ForwardPolicy{}),
+			})
+		}
+		if err != nil {
+			forward.stop()
+			return nil, err
+		}
+	}
+	peer.mutex.Lock()
+	if peer.forwards[remote] != nil {
+		peer.mutex.Unlock()
+		forward.stop()
+		return nil, ErrTrackForwarded
+	}
+	if peer.forwards == nil {
+		peer.forwards = make(map[*webrtc.TrackRemote]*trackForward)
+	}
+	peer.forwards[remote] = forward
+	peer.mutex.Unlock()
+	for _, destination := range forward.destinations {
+		go forward.readRTCP(destination.sender)
+	}
+	go forward.pump()
+	return forward.stop, nil
+}
+
+// ForwardTarget returns how remote is forwarded to target by ForwardTrack.
+func (peer *Peer) ForwardTarget(remote *webrtc.TrackRemote, target *Peer) (*ForwardTarget, bool) {
+	peer.mutex.RLock()
+	forward := peer.forwards[remote]
+	peer.mutex.RUnlock()
+	if forward == nil {
+		return nil, false
+	}
+	for _, destination := range forward.destinations {
+		if destination.peer == target {
+			return destination.target, true
+		}
+	}
+	return nil, false
+}
+
+func (forward *trackForward) pump() {
+	defer forward.stop()
+	mimeType := forward.remote.Codec().MimeType
+	for {
+		// a stopped forward ends with the next packet, as reads cannot be
+		// interrupted without spoiling the track for later readers
+		packet, _, err := forward.remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		select {
+		case <-forward.stopped:
+			return
+		default:
+		}
+		keyframe := isKeyframe(mimeType, packet)
+		now := time.Now()
+		requestKeyFrame := false
+		for _, destination := range forward.destinations {
+			shaped, request := destination.target.shape(packet, keyframe, now)
+			requestKeyFrame = requestKeyFrame || request
+			if shaped != nil {
+				// writes only fail once the destination closed, which
+				// its peer reports
+				destination.track.WriteRTP(shaped)
+			}
+		}
+		if requestKeyFrame {
+			go forward.requestKeyFrame()
+		}
+	}
+}
+
+// readRTCP passes picture loss indications of a destination on to the
+// source until the sender stops.
+func (forward *trackForward) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				forward.requestKeyFrame()
+			}
+		}
+	}
+}
+
+func (forward *trackForward) requestKeyFrame() {
+	if err := forward.source.RequestKeyFrame(forward.remote); err != nil {
+		forward.source.debugf("failed to forward picture loss indication: %s", err)
+	}
+}
+
+func (forward *trackForward) stop() {
+	forward.stopOnce.Do(func() {
+		close(forward.stopped)
+		forward.source.mutex.Lock()
+		if forward.source.forwards[forward.remote] == forward {
+			delete(forward.source.forwards, forward.remote)
+		}
+		forward.source.mutex.Unlock()
+		for _, destination := range forward.destinations {
+			connection := destination.peer.Connection()
+			if connection == nil {
+				continue
+			}
+			connection.RemoveTrack(destination.sender)
+		}
+	})
+}
+
+func (target *ForwardTarget) capacity() float64 {
+	return float64(target.policy.TargetBitrate) / 8 * forwardBurstWindow.Seconds()
+}
+
+// shape returns packet as it is forwarded to the target, or nil when it is
+// dropped, and whether the source should be asked for a keyframe as the
+// target waits for one.
+func (target *ForwardTarget) shape(packet *rtp.Packet, keyframe bool, now time.Time) (shaped *rtp.Packet, requestKeyFrame bool) {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	size := packet.MarshalSize()
+	forward := target.kind == webrtc.RTPCodecTypeAudio || target.policy.TargetBitrate == 0
+	if !forward {
+		target.refill(now)
+		if !target.hasFrame || packet.Timestamp != target.frameTimestamp {
+			target.hasFrame = true
+			target.frameTimestamp = packet.Timestamp
+			if keyframe {
+				target.waitingForKeyframe = false
+				target.forwardingFrame = true
+			} else {
+				target.forwardingFrame = !target.waitingForKeyframe && target.tokens > 0
+				if !target.forwardingFrame {
+					target.waitingForKeyframe = true
+				}
+			}
+		}
+		forward = target.forwardingFrame
+		if forward {
+			target.tokens -= float64(size)
+			if capacity := target.capacity(); target.tokens < -capacity {
+				target.tokens = -capacity
+			}
+		}
+		if target.waitingForKeyframe && (target.lastKeyFrameRequest.IsZero() || now.Sub(target.lastKeyFrameRequest) >= forwardKeyFrameInterval) {
+			target.lastKeyFrameRequest = now
+			requestKeyFrame = true
+		}
+	}
+	if !forward {
+		target.droppedPackets++
+		target.stats.PacketsDropped++
+		target.stats.BytesDropped += uint64(size)
+		return nil, requestKeyFrame
+	}
+	target.stats.PacketsForwarded++
+	target.stats.BytesForwarded += uint64(size)
+	if target.droppedPackets == 0 {
+		return packet, requestKeyFrame
+	}
+	// the packet is shared by every destination, so only a copy is rewritten
+	rewritten := *packet
+	rewritten.SequenceNumber -= target.droppedPackets
+	return &rewritten, requestKeyFrame
+}
+
+func (target *ForwardTarget) refill(now time.Time) {
+	if !target.lastRefill.IsZero() {
+		target.tokens += now.Sub(target.lastRefill).Seconds() * float64(target.policy.TargetBitrate) / 8
+		if capacity := target.capacity(); target.tokens > capacity {
+			target.tokens = capacity
+		}
+	}
+	target.lastRefill = now
+}
+
+func isKeyframe(mimeType string, packet *rtp.Packet) bool {
+	payload := packet.Payload
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return isVP8Keyframe(payload)
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return len(payload) > 0 && payload[0]&0x40 == 0 && payload[0]&0x08 != 0
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264Keyframe(payload)
+	default:
+		return false
+	}
+}
+
+func isVP8Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	// start of partition with partition index 0
+	if payload[0]&0x10 == 0 || payload[0]&0x0F != 0 {
+		return false
+	}
+	index := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) <= index {
+			return false
+		}
+		extension := payload[index]
+		index++
+		if extension&0x80 != 0 {
+			if len(payload) <= index {
+				return false
+			}
+			if payload[index]&0x80 != 0 {
+				index += 2
+			} else {
+				index++
+			}
+		}
+		if extension&0x40 != 0 {
+			index++
+		}
+		if extension&0x30 != 0 {
+			index++
+		}
+	}
+	return len(payload) > index && payload[index]&0x01 == 0
+}
+
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	switch nalType := payload[0] & 0x1F; nalType {
+	case 5, 7:
+		return true
+	case 24:
+		for index := 1; index+2 < len(payload); {
+			size := int(payload[index])<<8 | int(payload[index+1])
+			index += 2
+			if index < len(payload) {
+				if innerType := payload[index] & 0x1F; innerType == 5 || innerType == 7 {
+					return true
+				}
+			}
+			index += size
+		}
+		return false
+	case 28:
+		return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1F == 5
+	default:
+		return false
+	}
+}
@@ -0,0 +1,256 @@
+package simplepeer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestForwardTargetVideoBudget(t *testing.T) {
+	const (
+		fps             = 30
+		packetsPerFrame = 3
+		payloadSize     = 1000
+		targetBitrate   = 240_000
+		seconds         = 3
+	)
+	target := newForwardTarget(webrtc.RTPCodecTypeVideo, ForwardPolicy{TargetBitrate: targetBitrate})
+
+	start := time.Now()
+	sequenceNumber := uint16(0)
+	waitingForKeyframe := false
+	var lastForwarded *uint16
+	var keyFrameRequests []time.Time
+	for frame := 0; frame < fps*seconds; frame++ {
+		now := start.Add(time.Duration(frame) * time.Second / fps)
+		keyframe := frame%fps == 0
+		forwarded := 0
+		for i := 0; i < packetsPerFrame; i++ {
+			packet := &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					SequenceNumber: sequenceNumber,
+					Timestamp:      uint32(frame * 3000),
+					Marker:         i == packetsPerFrame-1,
+				},
+				Payload: make([]byte, payloadSize),
+			}
+			sequenceNumber++
+			shaped, requestKeyFrame := target.shape(packet, keyframe, now)
+			if requestKeyFrame {
+				keyFrameRequests = append(keyFrameRequests, now)
+			}
+			if shaped != nil {
+				if lastForwarded != nil && shaped.SequenceNumber != *lastForwarded+1 {
+					t.Fatalf("frame %d was forwarded with sequence number %d after %d", frame, shaped.SequenceNumber, *lastForwarded)
+				}
+				lastForwarded = &shaped.SequenceNumber
+				forwarded++
+			}
+		}
+		if forwarded != 0 && forwarded != packetsPerFrame {
+			t.Fatalf("frame %d was partially forwarded (%d of %d packets)", frame, forwarded, packetsPerFrame)
+		}
+		if keyframe {
+			if forwarded == 0 {
+				t.Fatalf("keyframe %d was dropped", frame)
+			}
+			waitingForKeyframe = false
+		} else if forwarded == 0 {
+			waitingForKeyframe = true
+		} else if waitingForKeyframe {
+			t.Fatalf("delta frame %d was forwarded before the next keyframe", frame)
+		}
+	}
+
+	if len(keyFrameRequests) == 0 {
+		t.Fatal("expected keyframes to be requested while waiting for one")
+	}
+	for i := 1; i < len(keyFrameRequests); i++ {
+		if interval := keyFrameRequests[i].Sub(keyFrameRequests[i-1]); interval < forwardKeyFrameInterval {
+			t.Fatalf("keyframes requested %s apart", interval)
+		}
+	}
+
+	stats := target.Stats()
+	budget := uint64(targetBitrate/8*seconds) + uint64(target.capacity()) + packetsPerFrame*(payloadSize+12)
+	if stats.BytesForwarded > budget {
+		t.Fatalf("forwarded %d bytes, budget was %d", stats.BytesForwarded, budget)
+	}
+	if stats.PacketsDropped == 0 {
+		t.Fatal("expected packets to be dropped")
+	}
+	if stats.PacketsForwarded+stats.PacketsDropped != fps*seconds*packetsPerFrame {
+		t.Fatalf("expected %d packets counted, got %d", fps*seconds*packetsPerFrame, stats.PacketsForwarded+stats.PacketsDropped)
+	}
+
+	target.SetPolicy(ForwardPolicy{})
+	before := target.Stats()
+	packet := &rtp.Packet{Header: rtp.Header{Version: 2, Timestamp: 1}, Payload: make([]byte, payloadSize)}
+	if shaped, _ := target.shape(packet, false, start.Add(seconds*time.Second)); shaped == nil {
+		t.Fatal("expected unlimited policy to forward")
+	}
+	if after := target.Stats(); after.PacketsForwarded != before.PacketsForwarded+1 {
+		t.Fatalf("expected forwarded count to increase, got %d", after.PacketsForwarded)
+	}
+}
+
+func TestForwardTargetNeverDropsAudio(t *testing.T) {
+	target := newForwardTarget(webrtc.RTPCodecTypeAudio, ForwardPolicy{TargetBitrate: 8})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, Timestamp: uint32(i * 960)}, Payload: make([]byte, 100)}
+		if shaped, requestKeyFrame := target.shape(packet, false, now); shaped != packet || requestKeyFrame {
+			t.Fatalf("audio packet %d was dropped", i)
+		}
+	}
+	if stats := target.Stats(); stats.PacketsDropped != 0 || stats.PacketsForwarded != 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	vp8Keyframe := &rtp.Packet{Payload: []byte{0x10, 0x00, 0x9d, 0x01, 0x2a}}
+	vp8Delta := &rtp.Packet{Payload: []byte{0x10, 0x01}}
+	if !isKeyframe(webrtc.MimeTypeVP8, vp8Keyframe) {
+		t.Fatal("expected VP8 keyframe")
+	}
+	if isKeyframe(webrtc.MimeTypeVP8, vp8Delta) {
+		t.Fatal("expected VP8 delta frame")
+	}
+	h264IDR := &rtp.Packet{Payload: []byte{0x65, 0x00}}
+	h264FUAStart := &rtp.Packet{Payload: []byte{0x7c, 0x85}}
+	h264Slice := &rtp.Packet{Payload: []byte{0x41, 0x00}}
+	if !isKeyframe(webrtc.MimeTypeH264, h264IDR) || !isKeyframe(webrtc.MimeTypeH264, h264FUAStart) {
+		t.Fatal("expected H264 keyframe")
+	}
+	if isKeyframe(webrtc.MimeTypeH264, h264Slice) {
+		t.Fatal("expected H264 delta frame")
+	}
+}
+
+func TestForwardTrack(t *testing.T) {
+	remoteTracks := make(chan *webrtc.TrackRemote, 1)
+	source, relay := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			remoteTracks <- track
+		},
+	})
+	defer source.Close()
+	defer relay.Close()
+	forwardedTracks := make(chan *webrtc.TrackRemote, 1)
+	relayTarget, sink := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			forwardedTracks <- track
+		},
+	})
+	defer relayTarget.Close()
+	defer sink.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := source.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictureLoss := make(chan struct{}, 1)
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				if _, ok := packet.(*rtcp.PictureLossIndication); ok {
+					select {
+					case pictureLoss <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	marker := []byte{0xde, 0xad, 0xbe, 0xef}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				track.WriteSample(media.Sample{Data: marker, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+
+	var remote *webrtc.TrackRemote
+	select {
+	case remote = <-remoteTracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the source track")
+	}
+	stop, err := relay.ForwardTrack(remote, relayTarget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.ForwardTrack(remote, relayTarget); !errors.Is(err, ErrTrackForwarded) {
+		t.Fatalf("expected ErrTrackForwarded, got %v", err)
+	}
+	var forwarded *webrtc.TrackRemote
+	select {
+	case forwarded = <-forwardedTracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the forwarded track")
+	}
+	if forwarded.Codec().MimeType != webrtc.MimeTypeVP8 {
+		t.Fatalf("expected the forwarded track to be %s, got %s", webrtc.MimeTypeVP8, forwarded.Codec().MimeType)
+	}
+	packet, _, err := forwarded.ReadRTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(packet.Payload, marker) {
+		t.Fatalf("expected the source's samples, got %x", packet.Payload)
+	}
+	forwardTarget, ok := relay.ForwardTarget(remote, relayTarget)
+	if !ok {
+		t.Fatal("expected a forward target")
+	}
+	if forwardTarget.Stats().PacketsForwarded == 0 {
+		t.Fatal("expected forwarded packets to be counted")
+	}
+
+	if err := sink.Connection().WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(forwarded.SSRC())},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pictureLoss:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the picture loss indication to reach the source")
+	}
+
+	stop()
+	if _, ok := relay.ForwardTarget(remote, relayTarget); ok {
+		t.Fatal("expected no forward target once stopped")
+	}
+	testutil.WaitFor(t, func() bool {
+		for _, transceiver := range relayTarget.Connection().GetTransceivers() {
+			if transceiver.Sender() != nil && transceiver.Sender().Track() != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,190 @@
+package simplepeer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Framed messages
+//
+// With PeerOptions.FrameMessages set, every Write or WriteText on the main
+// channel is sent as one frame. The first data channel message of a frame
+// starts with the payload length as a 4 byte big-endian unsigned integer,
+// followed by the start of the payload. Further messages carry the rest of
+// the payload with no header. Every message of a frame is at most
+// MaxMessageSize bytes and frames are never interleaved, so the
+// receiver only has to count bytes to find where each payload ends. Both
+// peers must enable framing, and the main channel must be ordered and
+// reliable.
+const frameHeaderSize = 4
+
+type OnMessage func(message []byte)
+
+// frameReader reassembles frames from the main channel's messages.
+type frameReader struct {
+	mutex     sync.Mutex
+	buffer    []byte
+	remaining int
+	reading   bool
+}
+
+func (reader *frameReader) reset() {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	reader.buffer = nil
+	reader.remaining = 0
+	reader.reading = false
+}
+
+// read adds a data channel message to the current frame and returns the
+// payload once the frame is complete.
+func (reader *frameReader) read(data []byte) ([]byte, bool, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if !reader.reading {
+		if len(data) < frameHeaderSize {
+			return nil, false, fmt.Errorf("%w: message of %d bytes is shorter than the frame header", ErrMalformedFrame, len(data))
+		}
+		reader.remaining = int(binary.BigEndian.Uint32(data))
+		capacity := reader.remaining
+		if capacity > maxChannelMessageSize {
+			capacity = maxChannelMessageSize
+		}
+		reader.buffer = make([]byte, 0, capacity)
+		reader.reading = true
+		data = data[frameHeaderSize:]
+	}
+	if len(data) > reader.remaining {
+		reader.reading = false
+		reader.buffer = nil
+		return nil, false, fmt.Errorf("%w: message is %d bytes longer than the frame", ErrMalformedFrame, len(data)-reader.remaining)
+	}
+	reader.buffer = append(reader.buffer, data...)
+	reader.remaining -= len(data)
+	if reader.remaining > 0 {
+		return nil, false, nil
+	}
+	message := reader.buffer
+	reader.buffer = nil
+	reader.reading = false
+	return message, true, nil
+}
+
+// messageQueue holds reassembled payloads for ReadMessage. It only starts
+// queueing once ReadMessage is first called.
+type messageQueue struct {
+	mutex    sync.Mutex
+	active   bool
+	messages [][]byte
+	changed  chan struct{}
+}
+
+func (queue *messageQueue) push(message []byte) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if !queue.active {
+		return
+	}
+	queue.messages = append(queue.messages, message)
+	close(queue.changed)
+	queue.changed = make(chan struct{})
+}
+
+// OnMessage adds a callback for whole payloads reassembled from framed
+// messages. It requires PeerOptions.FrameMessages.
+func (peer *Peer) OnMessage(fn OnMessage) (off func()) {
+	return peer.onMessage.Append(fn)
+}
+
+// Deprecated: call the func returned by OnMessage instead.
+func (peer *Peer) OffMessage(fn OnMessage) {
+	peer.onMessage.Delete(func(index int, onMessage OnMessage) bool {
+		return sameFunc(onMessage, fn)
+	})
+}
+
+// ReadMessage blocks until a whole payload reassembled from framed messages
+// is available or the peer closes. Payloads are queued from the first call
+// on, so earlier ones only reach OnMessage. It requires
+// PeerOptions.FrameMessages.
+func (peer *Peer) ReadMessage() ([]byte, error) {
+	if !peer.frameMessages {
+		return nil, errFramingDisabled
+	}
+	queue := &peer.messageQueue
+	for {
+		queue.mutex.Lock()
+		if !queue.active {
+			queue.active = true
+			queue.changed = make(chan struct{})
+		}
+		if len(queue.messages) > 0 {
+			message := queue.messages[0]
+			queue.messages = queue.messages[1:]
+			queue.mutex.Unlock()
+			return message, nil
+		}
+		changed := queue.changed
+		queue.mutex.Unlock()
+		peer.mutex.RLock()
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		select {
+		case <-peerContext.Done():
+			return nil, peerClosedErr(peerContext)
+		case <-changed:
+		}
+	}
+}
+
+// sendFramed only gives up on ctx before the first message of the frame,
+// since the remote peer cannot recover from a partial frame.
+func (peer *Peer) sendFramed(ctx context.Context, channel *webrtc.DataChannel, bytes []byte, isString bool, maxMessageSize int) (int, error) {
+	if uint64(len(bytes)) > math.MaxUint32 {
+		return 0, ErrMessageTooLarge
+	}
+	peer.sendMutex.Lock()
+	defer peer.sendMutex.Unlock()
+	sent := chunkLength(bytes, maxMessageSize-frameHeaderSize, isString)
+	first := frameStart(len(bytes), bytes[:sent])
+	err := peer.sendChunk(ctx, channel, *first, isString)
+	putChunkBuffer(first)
+	if err != nil {
+		return 0, err
+	}
+	for sent < len(bytes) {
+		count := chunkLength(bytes[sent:], maxMessageSize, isString)
+		if err := peer.sendChunk(context.Background(), channel, bytes[sent:sent+count], isString); err != nil {
+			return sent, err
+		}
+		sent += count
+	}
+	return sent, nil
+}
+
+// onFramedMessage adds a main channel message to the current frame. Every
+// message of a frame has the same IsString, so the last one's applies.
+func (peer *Peer) onFramedMessage(data webrtc.DataChannelMessage) {
+	message, ok, err := peer.frameReader.read(data.Data)
+	if err != nil {
+		peer.error(err)
+		return
+	}
+	if !ok || peer.onFileMessage(message) {
+		return
+	}
+	peer.messageQueue.push(message)
+	peer.onReaderMessage(webrtc.DataChannelMessage{IsString: data.IsString, Data: message})
+	peer.onMuxMessage(message)
+	peer.onJSONMessage(message)
+	for _, fn := range peer.onMessage.Slice() {
+		fn := fn
+		message := peer.receivedData(message)
+		peer.dispatch(func() { fn(message) })
+	}
+}
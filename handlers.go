@@ -0,0 +1,82 @@
+package simplepeer
+
+import (
+	"reflect"
+	"sync"
+)
+
+// handlers is a list of callbacks. Append returns a func removing exactly
+// the callback it added, since func values cannot be compared.
+type handlers[T any] struct {
+	mutex   sync.RWMutex
+	nextId  uint64
+	entries []handler[T]
+}
+
+type handler[T any] struct {
+	id uint64
+	fn T
+}
+
+// Append adds fn and returns a func removing it again. Calling the func
+// more than once does nothing.
+func (handlers *handlers[T]) Append(fn T) (off func()) {
+	handlers.mutex.Lock()
+	handlers.nextId++
+	id := handlers.nextId
+	handlers.entries = append(handlers.entries, handler[T]{id: id, fn: fn})
+	handlers.mutex.Unlock()
+	return func() {
+		handlers.remove(id)
+	}
+}
+
+func (handlers *handlers[T]) remove(id uint64) {
+	handlers.mutex.Lock()
+	defer handlers.mutex.Unlock()
+	for index, entry := range handlers.entries {
+		if entry.id == id {
+			handlers.entries = append(handlers.entries[:index], handlers.entries[index+1:]...)
+			return
+		}
+	}
+}
+
+// Delete removes the first callback matching fn.
+func (handlers *handlers[T]) Delete(fn func(index int, item T) bool) bool {
+	handlers.mutex.Lock()
+	defer handlers.mutex.Unlock()
+	for index, entry := range handlers.entries {
+		if fn(index, entry.fn) {
+			handlers.entries = append(handlers.entries[:index], handlers.entries[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Slice returns a copy of the callbacks, so they can be called without
+// holding the lock and may remove themselves.
+func (handlers *handlers[T]) Slice() []T {
+	handlers.mutex.RLock()
+	defer handlers.mutex.RUnlock()
+	fns := make([]T, len(handlers.entries))
+	for index, entry := range handlers.entries {
+		fns[index] = entry.fn
+	}
+	return fns
+}
+
+func (handlers *handlers[T]) Len() int {
+	handlers.mutex.RLock()
+	defer handlers.mutex.RUnlock()
+	return len(handlers.entries)
+}
+
+// sameFunc reports whether a and b are the same function. Closures created
+// from the same function literal are indistinguishable, which is why the
+// Off methods taking a func are deprecated in favor of the func returned by
+// the On methods.
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
@@ -0,0 +1,119 @@
+package simplepeer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestOffRemovesHandler(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	removed := make(chan []byte, 1)
+	kept := make(chan []byte, 1)
+	off := peer2.SubscribeData(func(message webrtc.DataChannelMessage) {
+		removed <- message.Data
+	})
+	peer2.OnData(func(message webrtc.DataChannelMessage) {
+		kept <- message.Data
+	})
+	off()
+	off()
+	if _, err := peer1.Write([]byte("after off")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-kept:
+		if string(data) != "after off" {
+			t.Fatalf("expected the message, got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the remaining handler to be called")
+	}
+	select {
+	case <-removed:
+		t.Fatal("expected the removed handler not to be called")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if count := peer2.onData.Len(); count != 1 {
+		t.Fatalf("expected one handler left, got %d", count)
+	}
+}
+
+func TestOffInsideHandler(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	calls := make(chan bool, 2)
+	var off func()
+	registered := make(chan bool)
+	off = peer2.SubscribeData(func(message webrtc.DataChannelMessage) {
+		<-registered
+		off()
+		calls <- true
+	})
+	close(registered)
+	peer1.Write([]byte("first"))
+	select {
+	case <-calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler to remove itself without blocking")
+	}
+	peer1.Write([]byte("second"))
+	select {
+	case <-calls:
+		t.Fatal("expected the handler to be removed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDeprecatedOffData(t *testing.T) {
+	peer := NewPeer(PeerOptions{})
+	defer peer.Close()
+	peer.OnData(ignoreData)
+	peer.OffData(ignoreData)
+	if count := peer.onData.Len(); count != 0 {
+		t.Fatalf("expected the handler to be removed, got %d", count)
+	}
+}
+
+func ignoreData(message webrtc.DataChannelMessage) {}
+
+func TestReaderCloseDetaches(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+	received := make(chan []byte, 1)
+	peer2.OnData(func(message webrtc.DataChannelMessage) {
+		received <- message.Data
+	})
+
+	for i := 0; i < 10; i++ {
+		reader := peer2.Reader()
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected io.EOF from a closed reader, got %v", err)
+		}
+	}
+	if count := peer2.readers.Len(); count != 0 {
+		t.Fatalf("expected the closed readers to be detached, got %d", count)
+	}
+	if _, err := peer1.Write([]byte("after close")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if string(data) != "after close" {
+			t.Fatalf("expected the message, got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected messages to keep flowing after the readers closed")
+	}
+}
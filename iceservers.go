@@ -0,0 +1,58 @@
+package simplepeer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ICEServersProvider returns ICE servers for a new connection, e.g. TURN
+// servers with short lived credentials. ctx is done when the peer's context
+// is or after OperationTimeout, if set.
+type ICEServersProvider func(ctx context.Context) ([]webrtc.ICEServer, error)
+
+// Config returns a copy of the configuration in effect: that of the current
+// connection, with the servers of the ICEServersProvider, or PeerOptions.Config
+// while there is no connection.
+func (peer *Peer) Config() webrtc.Configuration {
+	config := peer.config
+	if connection := peer.Connection(); connection != nil {
+		config = connection.GetConfiguration()
+	}
+	if config.ICEServers != nil {
+		config.ICEServers = append([]webrtc.ICEServer{}, config.ICEServers...)
+		for index, server := range config.ICEServers {
+			config.ICEServers[index].URLs = append([]string{}, server.URLs...)
+		}
+	}
+	if config.Certificates != nil {
+		config.Certificates = append([]webrtc.Certificate{}, config.Certificates...)
+	}
+	return config
+}
+
+// configuration returns the configuration of a new connection, with the
+// servers of the ICEServersProvider after those of Config. A provider error
+// is reported through OnError and returned, so no connection is created with
+// stale servers.
+func (peer *Peer) configuration() (webrtc.Configuration, error) {
+	config := peer.config
+	if peer.iceServersProvider == nil {
+		return config, nil
+	}
+	ctx := peer.parentContext
+	if peer.operationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, peer.operationTimeout)
+		defer cancel()
+	}
+	servers, err := peer.iceServersProvider(ctx)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrICEServersProvider, err)
+		peer.error(err)
+		return config, err
+	}
+	config.ICEServers = append(append([]webrtc.ICEServer{}, peer.config.ICEServers...), servers...)
+	return config, nil
+}
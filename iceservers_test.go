@@ -0,0 +1,104 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestICEServersProvider(t *testing.T) {
+	var calls atomic.Int32
+	reconnected := make(chan bool, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		Config: &webrtc.Configuration{
+			ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:127.0.0.1:3478"}}},
+		},
+		ICEServersProvider: func(ctx context.Context) ([]webrtc.ICEServer, error) {
+			call := calls.Add(1)
+			return []webrtc.ICEServer{{URLs: []string{fmt.Sprintf("stun:127.0.0.1:%d", 3478+call)}}}, nil
+		},
+		AutoReconnect: true,
+		Backoff:       10 * time.Millisecond,
+		OnReconnected: func() {
+			reconnected <- true
+		},
+	}, PeerOptions{AutoReconnect: true})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	urls := func() []string {
+		var urls []string
+		for _, server := range peer1.Config().ICEServers {
+			urls = append(urls, server.URLs...)
+		}
+		return urls
+	}
+	if got := fmt.Sprint(urls()); got != "[stun:127.0.0.1:3478 stun:127.0.0.1:3479]" {
+		t.Fatalf("expected the configured and the provided server, got %s", got)
+	}
+
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the reconnect")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected the provider to be called for the new connection, got %d calls", calls.Load())
+	}
+	if got := fmt.Sprint(urls()); got != "[stun:127.0.0.1:3478 stun:127.0.0.1:3480]" {
+		t.Fatalf("expected the fresh server, got %s", got)
+	}
+}
+
+func TestICEServersProviderError(t *testing.T) {
+	providerErr := errors.New("credentials expired")
+	errs := make(chan error, 1)
+	peer := NewPeer(PeerOptions{
+		ICEServersProvider: func(ctx context.Context) ([]webrtc.ICEServer, error) {
+			return nil, providerErr
+		},
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	defer peer.Close()
+	if err := peer.Init(); !errors.Is(err, ErrICEServersProvider) || !errors.Is(err, providerErr) {
+		t.Fatalf("expected the provider error, got %v", err)
+	}
+	if peer.Connection() != nil {
+		t.Fatal("expected no connection")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, providerErr) {
+			t.Fatalf("expected the provider error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnError to be called")
+	}
+}
+
+func TestConfigCopy(t *testing.T) {
+	peer := NewPeer(PeerOptions{
+		Config: &webrtc.Configuration{
+			ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:127.0.0.1:3478"}}},
+		},
+		MaxChannelMessageSize: 1024,
+	})
+	defer peer.Close()
+	config := peer.Config()
+	config.ICEServers[0].URLs[0] = "stun:changed"
+	config.ICEServers = append(config.ICEServers, webrtc.ICEServer{URLs: []string{"stun:added"}})
+	if servers := peer.Config().ICEServers; len(servers) != 1 || servers[0].URLs[0] != "stun:127.0.0.1:3478" {
+		t.Fatalf("expected changes to the returned configuration not to reach the peer, got %v", servers)
+	}
+	if peer.MaxChannelMessageSize() != 1024 {
+		t.Fatalf("expected the configured message size, got %d", peer.MaxChannelMessageSize())
+	}
+}
@@ -0,0 +1,34 @@
+package simplepeer
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ValidateId checks an externally supplied peer or channel id. Returning an
+// error makes Init and Signal refuse to create the connection.
+type ValidateId func(id string) error
+
+// UUIDValidator accepts only ids in the canonical 36 character UUID form,
+// which is what NewPeer generates when no id is given.
+func UUIDValidator(id string) error {
+	if len(id) != 36 {
+		return fmt.Errorf("expected a 36 character uuid, got %d characters", len(id))
+	}
+	_, err := uuid.Parse(id)
+	return err
+}
+
+func (peer *Peer) validateIds() error {
+	if peer.validateId == nil {
+		return nil
+	}
+	if err := peer.validateId(peer.id); err != nil {
+		return fmt.Errorf("%w: id %q: %w", ErrInvalidId, peer.id, err)
+	}
+	if err := peer.validateId(peer.channelName); err != nil {
+		return fmt.Errorf("%w: channel name %q: %w", ErrInvalidId, peer.channelName, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package simplepeer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUUIDValidator(t *testing.T) {
+	if err := UUIDValidator(uuid.New().String()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "peer1", "urn:uuid:" + uuid.New().String(), "{" + uuid.New().String() + "}", "zzzzzzzz-zzzz-zzzz-zzzz-zzzzzzzzzzzz"} {
+		if err := UUIDValidator(id); err == nil {
+			t.Fatalf("expected %q to be rejected", id)
+		}
+	}
+}
+
+func TestValidateId(t *testing.T) {
+	peer := NewPeer(PeerOptions{Id: "peer1", ValidateId: UUIDValidator})
+	defer peer.Close()
+	if err := peer.Init(); !errors.Is(err, ErrInvalidId) {
+		t.Fatalf("expected invalid id, got %v", err)
+	}
+	if peer.Connection() != nil {
+		t.Fatal("expected no connection for an invalid id")
+	}
+	err := peer.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true})
+	if !errors.Is(err, ErrInvalidId) {
+		t.Fatalf("expected invalid id, got %v", err)
+	}
+
+	peer = NewPeer(PeerOptions{ChannelName: "data", ValidateId: UUIDValidator})
+	defer peer.Close()
+	if err := peer.Init(); !errors.Is(err, ErrInvalidId) {
+		t.Fatalf("expected invalid channel name, got %v", err)
+	}
+
+	peer = NewPeer(PeerOptions{ValidateId: UUIDValidator})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
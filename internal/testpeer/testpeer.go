@@ -0,0 +1,34 @@
+// Package testpeer creates peers for the tests of the signaling adapters.
+package testpeer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+)
+
+// New creates a peer closing connected the first time it connects.
+func New(id string, connected chan struct{}) *simplepeer.Peer {
+	var once sync.Once
+	return simplepeer.NewPeer(simplepeer.PeerOptions{
+		Id: id,
+		OnConnect: func() {
+			once.Do(func() {
+				close(connected)
+			})
+		},
+	})
+}
+
+// WaitConnected waits for a peer created by New to connect, failing t after
+// ten seconds.
+func WaitConnected(t testing.TB, connected chan struct{}) {
+	t.Helper()
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out connecting")
+	}
+}
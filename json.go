@@ -0,0 +1,62 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type OnJSON func(message json.RawMessage)
+
+// SendJSON encodes v as JSON and sends it as a text message on the main
+// channel. Values encoding to more than MaxMessageSize bytes only
+// arrive whole if both peers set FrameMessages.
+func (peer *Peer) SendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = peer.send(data, true)
+	return err
+}
+
+// OnJSON adds a callback for JSON messages on the main channel. Messages
+// that are not valid JSON are reported through OnError as ErrMalformedJSON.
+func (peer *Peer) OnJSON(fn OnJSON) (off func()) {
+	return peer.onJSON.Append(fn)
+}
+
+// Deprecated: call the func returned by OnJSON instead.
+func (peer *Peer) OffJSON(fn OnJSON) {
+	peer.onJSON.Delete(func(index int, onJSON OnJSON) bool {
+		return sameFunc(onJSON, fn)
+	})
+}
+
+// OnJSONAs adds a callback for JSON messages on the main channel decoded
+// into T. Messages that do not decode are reported through OnError as
+// ErrMalformedJSON.
+func OnJSONAs[T any](peer *Peer, fn func(value T)) {
+	peer.OnJSON(func(message json.RawMessage) {
+		var value T
+		if err := json.Unmarshal(message, &value); err != nil {
+			peer.error(fmt.Errorf("%w: %s", ErrMalformedJSON, err))
+			return
+		}
+		fn(value)
+	})
+}
+
+func (peer *Peer) onJSONMessage(data []byte) {
+	if peer.onJSON.Len() == 0 {
+		return
+	}
+	if !json.Valid(data) {
+		peer.error(fmt.Errorf("%w: message of %d bytes", ErrMalformedJSON, len(data)))
+		return
+	}
+	for _, fn := range peer.onJSON.Slice() {
+		fn := fn
+		data := peer.receivedData(data)
+		peer.dispatch(func() { fn(json.RawMessage(data)) })
+	}
+}
@@ -0,0 +1,79 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDocument struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+	Body string   `json:"body"`
+}
+
+func TestSendJSON(t *testing.T) {
+	errs := make(chan error, 4)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		FrameMessages: true,
+	}, PeerOptions{
+		FrameMessages: true,
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	documents := make(chan testDocument, 4)
+	raw := make(chan json.RawMessage, 4)
+	OnJSONAs(peer2, func(document testDocument) {
+		documents <- document
+	})
+	peer2.OnJSON(func(message json.RawMessage) {
+		raw <- message
+	})
+
+	for _, document := range []testDocument{
+		{Name: "small", Tags: []string{"a"}},
+		{Name: "large", Tags: []string{"b", "c"}, Body: strings.Repeat("x", 3*maxChannelMessageSize)},
+	} {
+		if err := peer1.SendJSON(document); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case received := <-documents:
+			if received.Name != document.Name || len(received.Tags) != len(document.Tags) || received.Body != document.Body {
+				t.Fatalf("expected %s to round trip, got %s with %d body bytes", document.Name, received.Name, len(received.Body))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", document.Name)
+		}
+		select {
+		case message := <-raw:
+			expected, _ := json.Marshal(document)
+			if string(message) != string(expected) {
+				t.Fatalf("expected the raw message to match the encoded %s", document.Name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the raw %s", document.Name)
+		}
+	}
+
+	if _, err := peer1.WriteText("{not json"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrMalformedJSON) {
+			t.Fatalf("expected ErrMalformedJSON, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the decode error")
+	}
+	if err := peer1.SendJSON(func() {}); err == nil {
+		t.Fatal("expected an error sending a value that can not be encoded")
+	}
+}
@@ -0,0 +1,107 @@
+package simplepeer
+
+import (
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Latency budgets for small messages between two peers on loopback, from
+// entering Write to entering the receiving handler. They are generous so
+// they hold on loaded CI machines while still catching regressions that
+// queue messages behind each other.
+const (
+	latencyMessages    = 10000
+	latencyInterval    = 100 * time.Microsecond
+	latencyBudgetP50   = 5 * time.Millisecond
+	latencyBudgetP99   = 25 * time.Millisecond
+	latencyMessageSize = 32
+)
+
+type latencyResult struct {
+	p50, p99, max time.Duration
+}
+
+func measureLatency(t *testing.T, options PeerOptions) latencyResult {
+	latencies := make(chan time.Duration, latencyMessages)
+	record := func(data []byte) {
+		received := time.Now()
+		if len(data) >= 8 {
+			latencies <- received.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(data))))
+		}
+	}
+	receiverOptions := options
+	if options.FrameMessages {
+		receiverOptions.OnData = nil
+	} else {
+		receiverOptions.OnData = func(message webrtc.DataChannelMessage) {
+			record(message.Data)
+		}
+	}
+	peer1, peer2 := connectTestPeers(t, options, receiverOptions)
+	defer peer1.Close()
+	defer peer2.Close()
+	if options.FrameMessages {
+		peer2.OnMessage(record)
+	}
+
+	payload := make([]byte, latencyMessageSize)
+	start := time.Now()
+	for i := 0; i < latencyMessages; i++ {
+		if wait := time.Until(start.Add(time.Duration(i) * latencyInterval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+		if _, err := peer1.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := make([]time.Duration, 0, latencyMessages)
+	timeout := time.After(10 * time.Second)
+	for len(results) < latencyMessages {
+		select {
+		case latency := <-latencies:
+			results = append(results, latency)
+		case <-timeout:
+			t.Fatalf("timed out after receiving %d of %d messages", len(results), latencyMessages)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i] < results[j]
+	})
+	return latencyResult{
+		p50: results[len(results)/2],
+		p99: results[len(results)*99/100],
+		max: results[len(results)-1],
+	}
+}
+
+func TestMessageLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping latency measurements in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping latency measurements under the race detector")
+	}
+	for name, options := range map[string]PeerOptions{
+		"ordered":          {},
+		"unordered":        {ChannelReliability: ChannelUnordered},
+		"ordered-framed":   {FrameMessages: true},
+		"unordered-framed": {ChannelReliability: ChannelUnordered, FrameMessages: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := measureLatency(t, options)
+			t.Logf("p50=%s p99=%s max=%s", result.p50, result.p99, result.max)
+			if result.p50 > latencyBudgetP50 {
+				t.Errorf("expected p50 latency under %s, got %s", latencyBudgetP50, result.p50)
+			}
+			if result.p99 > latencyBudgetP99 {
+				t.Errorf("expected p99 latency under %s, got %s", latencyBudgetP99, result.p99)
+			}
+		})
+	}
+}
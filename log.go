@@ -0,0 +1,138 @@
+package simplepeer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Keys of the attributes of the peer's structured log records.
+const (
+	LogKeyPeer          = "peer"
+	LogKeySignalType    = "signal_type"
+	LogKeyState         = "state"
+	LogKeyCandidateType = "candidate_type"
+)
+
+// LogEvent is a record the peer logged, as passed to OnLogEvent. Attrs
+// start with the peer id.
+type LogEvent struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   []slog.Attr
+}
+
+// Attr returns the value of the attribute with key, if the event has it.
+func (event LogEvent) Attr(key string) (slog.Value, bool) {
+	for _, attr := range event.Attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return slog.Value{}, false
+}
+
+type OnLogEvent func(event LogEvent)
+
+// OnLogEvent adds a callback receiving every record the peer logs, whatever
+// the level of its Logger, e.g. to feed negotiation timelines into an
+// application's telemetry. Records of single messages and candidates need
+// TraceMessages. Callbacks are called synchronously and must not block.
+func (peer *Peer) OnLogEvent(fn OnLogEvent) (off func()) {
+	return peer.onLogEvent.Append(fn)
+}
+
+// Deprecated: call the func returned by OnLogEvent instead.
+func (peer *Peer) OffLogEvent(fn OnLogEvent) {
+	peer.onLogEvent.Delete(func(index int, onLogEvent OnLogEvent) bool {
+		return sameFunc(onLogEvent, fn)
+	})
+}
+
+// log returns PeerOptions.Logger, or else the current default logger, with
+// the peer id attached.
+func (peer *Peer) log() *slog.Logger {
+	if peer.logger != nil {
+		return peer.logger
+	}
+	return slog.Default().With(slog.String(LogKeyPeer, peer.id))
+}
+
+// enabled reports whether a record of level would be logged or passed to
+// OnLogEvent, so callers can skip formatting records that would be dropped.
+func (peer *Peer) enabled(level slog.Level) bool {
+	if peer.onLogEvent.Len() > 0 {
+		return true
+	}
+	logger := peer.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.Enabled(context.Background(), level)
+}
+
+func (peer *Peer) logAttrs(level slog.Level, message string, attrs ...slog.Attr) {
+	if !peer.enabled(level) {
+		return
+	}
+	peer.log().LogAttrs(context.Background(), level, message, attrs...)
+	if peer.onLogEvent.Len() == 0 {
+		return
+	}
+	event := LogEvent{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+		Attrs:   append([]slog.Attr{slog.String(LogKeyPeer, peer.id)}, attrs...),
+	}
+	for _, fn := range peer.onLogEvent.Slice() {
+		fn(event)
+	}
+}
+
+func (peer *Peer) debug(message string, attrs ...slog.Attr) {
+	peer.logAttrs(slog.LevelDebug, message, attrs...)
+}
+
+// trace logs per-message events, which is only done with TraceMessages.
+func (peer *Peer) trace(message string, attrs ...slog.Attr) {
+	if peer.traceMessages {
+		peer.debug(message, attrs...)
+	}
+}
+
+func (peer *Peer) debugf(format string, args ...interface{}) {
+	if peer.enabled(slog.LevelDebug) {
+		peer.debug(fmt.Sprintf(format, args...))
+	}
+}
+
+func (peer *Peer) tracef(format string, args ...interface{}) {
+	if peer.traceMessages {
+		peer.debugf(format, args...)
+	}
+}
+
+// debugSdp logs a description's SDP, which is only done with LogSDP.
+func (peer *Peer) debugSdp(side string, description webrtc.SessionDescription) {
+	if peer.logSdp {
+		peer.debug(side+" description", slog.String(LogKeySignalType, description.Type.String()), slog.String("sdp", description.SDP))
+	}
+}
+
+// candidateAttrs appends the type of a candidate, e.g. host or relay, to
+// attrs. The end of candidates has none.
+func candidateAttrs(candidate string, attrs ...slog.Attr) []slog.Attr {
+	fields := strings.Fields(candidate)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "typ" {
+			return append(attrs, slog.String(LogKeyCandidateType, fields[i+1]))
+		}
+	}
+	return attrs
+}
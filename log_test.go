@@ -0,0 +1,204 @@
+package simplepeer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+// logged reports whether a line of output contains every part.
+func logged(output string, parts ...string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		found := true
+		for _, part := range parts {
+			if !strings.Contains(line, part) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTraceMessages(t *testing.T) {
+	logs := &lockedBuffer{}
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	received := make(chan bool, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		TraceMessages: true,
+		OnData: func(message webrtc.DataChannelMessage) {
+			received <- true
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if _, err := peer2.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for data")
+	}
+	output := logs.String()
+	if !logged(output, `msg="received data message length=5 `, "peer=peer1") {
+		t.Fatalf("expected peer1 to trace the received message, got:\n%s", output)
+	}
+	if !logged(output, `msg="received signal"`, "peer=peer1", "signal_type=candidate candidate_type=host") {
+		t.Fatalf("expected peer1 to trace received candidates, got:\n%s", output)
+	}
+	if logged(output, `msg="sending data message`, "peer=peer2") || logged(output, `msg="received signal"`, "peer=peer2", "signal_type=candidate") {
+		t.Fatalf("expected peer2 not to trace messages, got:\n%s", output)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defaultLogs := &lockedBuffer{}
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	slog.SetDefault(slog.New(slog.NewTextHandler(defaultLogs, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	logs := &lockedBuffer{}
+	sdpLogs := &lockedBuffer{}
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		Logger: slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}, PeerOptions{
+		Logger: slog.New(slog.NewTextHandler(sdpLogs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		LogSDP: true,
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	// peers of earlier tests may still log while closing, but not negotiate
+	if strings.Contains(defaultLogs.String(), "created offer") || strings.Contains(defaultLogs.String(), "created answer") {
+		t.Fatalf("expected nothing on the default logger, got:\n%s", defaultLogs.String())
+	}
+	if output := logs.String(); !logged(output, `msg="created offer"`, "peer=peer1") || strings.Contains(output, "sdp=") {
+		t.Fatalf("expected peer1's logs without SDP, got:\n%s", output)
+	}
+	if output := sdpLogs.String(); !logged(output, `msg="remote description"`, "peer=peer2 signal_type=offer", `sdp="v=0`) || !logged(output, `msg="local description"`, "peer=peer2 signal_type=answer", `sdp="v=0`) {
+		t.Fatalf("expected peer2 to log its SDP, got:\n%s", output)
+	}
+}
+
+func TestOnLogEvent(t *testing.T) {
+	var mutex sync.Mutex
+	var events []LogEvent
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+		TraceMessages: true,
+		OnLogEvent: func(event LogEvent) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, event)
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	find := func(message string, attrs ...slog.Attr) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, event := range events {
+			if event.Message != message || event.Level != slog.LevelDebug {
+				continue
+			}
+			if peer, _ := event.Attr(LogKeyPeer); peer.String() != "peer1" {
+				continue
+			}
+			matches := true
+			for _, attr := range attrs {
+				if value, ok := event.Attr(attr.Key); !ok || !value.Equal(attr.Value) {
+					matches = false
+				}
+			}
+			if matches {
+				return true
+			}
+		}
+		return false
+	}
+	testutil.WaitFor(t, func() bool {
+		return find("connection state", slog.String(LogKeyState, "connected"))
+	})
+	expected := []struct {
+		message string
+		attrs   []slog.Attr
+	}{
+		{"created offer", []slog.Attr{slog.String(LogKeySignalType, SignalMessageOffer)}},
+		{"received signal", []slog.Attr{slog.String(LogKeySignalType, SignalMessageAnswer)}},
+		{"received signal", []slog.Attr{slog.String(LogKeySignalType, SignalMessageCandidate), slog.String(LogKeyCandidateType, "host")}},
+		{"gathered candidate", []slog.Attr{slog.String(LogKeyCandidateType, "host")}},
+		{"ice gathering state", []slog.Attr{slog.String(LogKeyState, "complete")}},
+	}
+	for _, event := range expected {
+		if !find(event.message, event.attrs...) {
+			t.Errorf("expected a %q event with %v", event.message, event.attrs)
+		}
+	}
+}
+
+func BenchmarkDebugLogDisabled(b *testing.B) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	})))
+	peer := NewPeer(PeerOptions{Id: "peer"})
+	defer peer.Close()
+
+	b.Run("eager", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			slog.Debug(fmt.Sprintf("%s: received signal message=%s", peer.id, SignalMessageCandidate))
+		}
+	})
+	b.Run("debugf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			peer.debugf("received signal message=%s", SignalMessageCandidate)
+		}
+	})
+	b.Run("tracef", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			peer.tracef("received signal message=%s", SignalMessageCandidate)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package simplepeer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// OnPeerSignal is called with the signal messages a managed peer emits and
+// the id of the remote peer they are for.
+type OnPeerSignal func(id string, message map[string]interface{}) error
+
+// OnPeerConnect and OnPeerClose are called with the remote id of a managed
+// peer when it connects and when it closes.
+type (
+	OnPeerConnect func(id string, peer *Peer)
+	OnPeerClose   func(id string, peer *Peer)
+)
+
+type PeerManagerOptions struct {
+	// OnSignal delivers the signal messages of every managed peer to the
+	// remote peer with the given id.
+	OnSignal OnPeerSignal
+	// PeerOptions returns the options of a peer Signal creates for a signal
+	// from an unknown id. Without it such signals return ErrUnknownPeer.
+	PeerOptions   func(id string) PeerOptions
+	OnPeerConnect OnPeerConnect
+	OnPeerClose   OnPeerClose
+}
+
+// PeerManager keeps a peer per remote id, routes signal messages to them
+// and forgets peers once they close. Managed peers must not be reopened: a
+// forgotten peer is not managed again by Reopen, and Signal for its id adds
+// a new peer.
+type PeerManager struct {
+	mutex         sync.RWMutex
+	peers         map[string]*Peer
+	closed        bool
+	peerOptions   func(id string) PeerOptions
+	onSignal      handlers[OnPeerSignal]
+	onPeerConnect handlers[OnPeerConnect]
+	onPeerClose   handlers[OnPeerClose]
+}
+
+func NewPeerManager(options ...PeerManagerOptions) *PeerManager {
+	manager := PeerManager{
+		peers: make(map[string]*Peer),
+	}
+	for _, option := range options {
+		if option.OnSignal != nil {
+			manager.onSignal.Append(option.OnSignal)
+		}
+		if option.PeerOptions != nil {
+			manager.peerOptions = option.PeerOptions
+		}
+		if option.OnPeerConnect != nil {
+			manager.onPeerConnect.Append(option.OnPeerConnect)
+		}
+		if option.OnPeerClose != nil {
+			manager.onPeerClose.Append(option.OnPeerClose)
+		}
+	}
+	return &manager
+}
+
+func (manager *PeerManager) OnSignal(fn OnPeerSignal) (off func()) {
+	return manager.onSignal.Append(fn)
+}
+
+// Deprecated: call the func returned by OnSignal instead.
+func (manager *PeerManager) OffSignal(fn OnPeerSignal) {
+	manager.onSignal.Delete(func(index int, onSignal OnPeerSignal) bool {
+		return sameFunc(onSignal, fn)
+	})
+}
+
+func (manager *PeerManager) OnPeerConnect(fn OnPeerConnect) (off func()) {
+	return manager.onPeerConnect.Append(fn)
+}
+
+// Deprecated: call the func returned by OnPeerConnect instead.
+func (manager *PeerManager) OffPeerConnect(fn OnPeerConnect) {
+	manager.onPeerConnect.Delete(func(index int, onPeerConnect OnPeerConnect) bool {
+		return sameFunc(onPeerConnect, fn)
+	})
+}
+
+func (manager *PeerManager) OnPeerClose(fn OnPeerClose) (off func()) {
+	return manager.onPeerClose.Append(fn)
+}
+
+// Deprecated: call the func returned by OnPeerClose instead.
+func (manager *PeerManager) OffPeerClose(fn OnPeerClose) {
+	manager.onPeerClose.Delete(func(index int, onPeerClose OnPeerClose) bool {
+		return sameFunc(onPeerClose, fn)
+	})
+}
+
+// Get returns the peer for the remote id.
+func (manager *PeerManager) Get(id string) (*Peer, bool) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	peer, ok := manager.peers[id]
+	return peer, ok
+}
+
+// Ids returns the remote ids of the managed peers, sorted.
+func (manager *PeerManager) Ids() []string {
+	manager.mutex.RLock()
+	ids := make([]string, 0, len(manager.peers))
+	for id := range manager.peers {
+		ids = append(ids, id)
+	}
+	manager.mutex.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// Create adds a peer for the remote id, returning ErrPeerExists if there
+// already is one. The id is checked with options.ValidateId, if set. Call
+// Init on the peer to make it the initiator.
+func (manager *PeerManager) Create(id string, options ...PeerOptions) (*Peer, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if _, ok := manager.peers[id]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrPeerExists, id)
+	}
+	return manager.create(id, options)
+}
+
+// GetOrCreate returns the peer for the remote id, adding one with options
+// if there is none.
+func (manager *PeerManager) GetOrCreate(id string, options ...PeerOptions) (*Peer, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if peer, ok := manager.peers[id]; ok {
+		return peer, nil
+	}
+	return manager.create(id, options)
+}
+
+func (manager *PeerManager) create(id string, options []PeerOptions) (*Peer, error) {
+	if manager.closed {
+		return nil, ErrPeerClosed
+	}
+	for _, option := range options {
+		if option.ValidateId == nil {
+			continue
+		}
+		if err := option.ValidateId(id); err != nil {
+			return nil, fmt.Errorf("%w: id %q: %w", ErrInvalidId, id, err)
+		}
+	}
+	peer := NewPeer(options...)
+	peer.OnSignal(func(message map[string]interface{}) error {
+		var errs []error
+		for _, fn := range manager.onSignal.Slice() {
+			if err := fn(id, message); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+	peer.OnConnect(func() {
+		for _, fn := range manager.onPeerConnect.Slice() {
+			fn(id, peer)
+		}
+	})
+	manager.peers[id] = peer
+	go manager.watch(id, peer)
+	return peer, nil
+}
+
+// watch forgets the peer once it closes.
+func (manager *PeerManager) watch(id string, peer *Peer) {
+	<-peer.Context().Done()
+	manager.mutex.Lock()
+	removed := manager.peers[id] == peer
+	if removed {
+		delete(manager.peers, id)
+	}
+	manager.mutex.Unlock()
+	if removed {
+		for _, fn := range manager.onPeerClose.Slice() {
+			fn(id, peer)
+		}
+	}
+}
+
+// Signal applies a signal message from the remote peer with the id, adding
+// a peer with PeerManagerOptions.PeerOptions if there is none.
+func (manager *PeerManager) Signal(id string, message map[string]interface{}) error {
+	peer, ok := manager.Get(id)
+	if !ok {
+		if manager.peerOptions == nil {
+			return fmt.Errorf("%w: %q", ErrUnknownPeer, id)
+		}
+		var err error
+		if peer, err = manager.GetOrCreate(id, manager.peerOptions(id)); err != nil {
+			return err
+		}
+	}
+	return peer.Signal(message)
+}
+
+// Broadcast writes data to every connected peer and joins their errors.
+func (manager *PeerManager) Broadcast(data []byte) error {
+	var errs []error
+	for _, peer := range manager.snapshot() {
+		if !peer.Connected() {
+			continue
+		}
+		if _, err := peer.Write(data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every peer. Peers can no longer be added afterwards.
+func (manager *PeerManager) Close() error {
+	manager.mutex.Lock()
+	manager.closed = true
+	manager.mutex.Unlock()
+	var errs []error
+	for _, peer := range manager.snapshot() {
+		if err := peer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (manager *PeerManager) snapshot() []*Peer {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	peers := make([]*Peer, 0, len(manager.peers))
+	for _, peer := range manager.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
@@ -0,0 +1,140 @@
+package simplepeer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+// signalingHub routes signal messages between the managers of a mesh by id.
+type signalingHub struct {
+	mutex    sync.Mutex
+	managers map[string]*PeerManager
+}
+
+func (hub *signalingHub) join(id string, options PeerManagerOptions) *PeerManager {
+	options.OnSignal = func(to string, message map[string]interface{}) error {
+		hub.mutex.Lock()
+		manager := hub.managers[to]
+		hub.mutex.Unlock()
+		return manager.Signal(id, message)
+	}
+	options.PeerOptions = func(remoteId string) PeerOptions {
+		return PeerOptions{Id: id, SendBye: true}
+	}
+	manager := NewPeerManager(options)
+	hub.mutex.Lock()
+	hub.managers[id] = manager
+	hub.mutex.Unlock()
+	return manager
+}
+
+func TestPeerManagerMesh(t *testing.T) {
+	hub := signalingHub{managers: make(map[string]*PeerManager)}
+	ids := []string{"a", "b", "c"}
+	connects := make(map[string]chan string)
+	received := make(map[string]chan string)
+	for _, id := range ids {
+		id := id
+		connects[id] = make(chan string, len(ids))
+		received[id] = make(chan string, len(ids))
+		hub.join(id, PeerManagerOptions{
+			OnPeerConnect: func(remoteId string, peer *Peer) {
+				connects[id] <- remoteId
+				peer.OnData(func(message webrtc.DataChannelMessage) {
+					received[id] <- string(message.Data)
+				})
+			},
+		})
+	}
+	defer func() {
+		for _, manager := range hub.managers {
+			manager.Close()
+		}
+	}()
+
+	// the smaller id initiates every connection of the mesh
+	for i, id := range ids {
+		for _, remoteId := range ids[i+1:] {
+			peer, err := hub.managers[id].Create(remoteId, PeerOptions{Id: id, SendBye: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := peer.Init(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, id := range ids {
+		for range ids[1:] {
+			select {
+			case <-connects[id]:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("%s: timed out waiting for the mesh to connect", id)
+			}
+		}
+		if count := len(hub.managers[id].Ids()); count != len(ids)-1 {
+			t.Fatalf("%s: expected %d peers, got %d", id, len(ids)-1, count)
+		}
+	}
+
+	if _, err := hub.managers["a"].Create("b"); !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("expected ErrPeerExists, got %v", err)
+	}
+	if err := hub.managers["a"].Broadcast([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		select {
+		case message := <-received[id]:
+			if message != "hello" {
+				t.Fatalf("%s: expected hello, got %s", id, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the broadcast", id)
+		}
+	}
+
+	closed := make(chan string, len(ids))
+	hub.managers["b"].OnPeerClose(func(remoteId string, peer *Peer) {
+		closed <- remoteId
+	})
+	if err := hub.managers["a"].Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case remoteId := <-closed:
+		if remoteId != "a" {
+			t.Fatalf("expected a to close, got %s", remoteId)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the closed peer to be removed")
+	}
+	if _, ok := hub.managers["b"].Get("a"); ok {
+		t.Fatal("expected the closed peer to be removed")
+	}
+	testutil.WaitFor(t, func() bool {
+		return len(hub.managers["a"].Ids()) == 0
+	})
+	if _, err := hub.managers["a"].Create("d"); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed from a closed manager, got %v", err)
+	}
+}
+
+func TestPeerManagerValidateId(t *testing.T) {
+	manager := NewPeerManager()
+	defer manager.Close()
+	if _, err := manager.Create("not-a-uuid", PeerOptions{ValidateId: UUIDValidator}); !errors.Is(err, ErrInvalidId) {
+		t.Fatalf("expected ErrInvalidId, got %v", err)
+	}
+	if err := manager.Signal("unknown", map[string]interface{}{"type": "renegotiate"}); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("expected ErrUnknownPeer, got %v", err)
+	}
+	if _, ok := manager.Get("not-a-uuid"); ok {
+		t.Fatalf("expected the invalid id to be refused, got %v", manager.Ids())
+	}
+}
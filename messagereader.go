@@ -0,0 +1,99 @@
+package simplepeer
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// MessageReader reads whole messages from the main channel, keeping whether
+// each was sent as text or binary. Without FrameMessages a message larger
+// than MaxMessageSize arrives as several messages.
+type MessageReader struct {
+	peer     *Peer
+	mutex    sync.Mutex
+	closed   bool
+	messages []webrtc.DataChannelMessage
+	changed  chan struct{}
+}
+
+// MessageReader returns a reader that queues every message received on the
+// main channel from now on until it is closed.
+func (peer *Peer) MessageReader() *MessageReader {
+	reader := &MessageReader{
+		peer:    peer,
+		changed: make(chan struct{}),
+	}
+	peer.messageReaders.Append(reader)
+	return reader
+}
+
+func (reader *MessageReader) push(message webrtc.DataChannelMessage) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.closed {
+		return
+	}
+	reader.messages = append(reader.messages, message)
+	close(reader.changed)
+	reader.changed = make(chan struct{})
+}
+
+// ReadMessage blocks until a message is available, the reader is closed or
+// the peer closes.
+func (reader *MessageReader) ReadMessage() (webrtc.DataChannelMessage, error) {
+	for {
+		reader.mutex.Lock()
+		if len(reader.messages) > 0 {
+			message := reader.messages[0]
+			reader.messages = reader.messages[1:]
+			reader.mutex.Unlock()
+			return message, nil
+		}
+		if reader.closed {
+			reader.mutex.Unlock()
+			return webrtc.DataChannelMessage{}, io.EOF
+		}
+		changed := reader.changed
+		reader.mutex.Unlock()
+		reader.peer.mutex.RLock()
+		peerContext := reader.peer.context
+		reader.peer.mutex.RUnlock()
+		select {
+		case <-peerContext.Done():
+			return webrtc.DataChannelMessage{}, peerClosedErr(peerContext)
+		case <-changed:
+		}
+	}
+}
+
+// Close stops queueing messages. Queued messages can still be read, after
+// which ReadMessage returns io.EOF.
+func (reader *MessageReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.closed {
+		return io.EOF
+	}
+	reader.closed = true
+	close(reader.changed)
+	reader.changed = make(chan struct{})
+	reader.peer.messageReaders.Delete(func(index int, messageReader *MessageReader) bool {
+		return messageReader == reader
+	})
+	return nil
+}
+
+// SendMessage sends message.Data as text or binary according to
+// message.IsString, chunked like Write.
+func (peer *Peer) SendMessage(message webrtc.DataChannelMessage) error {
+	_, err := peer.send(message.Data, message.IsString)
+	return err
+}
+
+func (peer *Peer) onReaderMessage(message webrtc.DataChannelMessage) {
+	for reader := range peer.messageReaders.Iter() {
+		reader.push(message)
+	}
+}
@@ -0,0 +1,50 @@
+package simplepeer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestMessageReader(t *testing.T) {
+	for _, frameMessages := range []bool{false, true} {
+		peer1, peer2 := connectTestPeers(t, PeerOptions{FrameMessages: frameMessages}, PeerOptions{FrameMessages: frameMessages})
+		reader := peer2.MessageReader()
+
+		sent := []webrtc.DataChannelMessage{
+			{IsString: true, Data: []byte(`{"type":"start"}`)},
+			{Data: []byte{0, 1, 2, 3}},
+			{IsString: true, Data: []byte(`{"type":"chunk"}`)},
+			{Data: bytes.Repeat([]byte{0xff}, 1024)},
+			{IsString: true, Data: []byte(`{"type":"end"}`)},
+		}
+		for _, message := range sent {
+			if err := peer1.SendMessage(message); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, expected := range sent {
+			message, err := reader.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.IsString != expected.IsString || !bytes.Equal(message.Data, expected.Data) {
+				t.Fatalf("framing %t: message %d: expected isString=%t %q, got isString=%t %q", frameMessages, i, expected.IsString, expected.Data, message.IsString, message.Data)
+			}
+		}
+
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.ReadMessage(); err != io.EOF {
+			t.Fatalf("expected io.EOF after Close, got %v", err)
+		}
+		if peer2.messageReaders.Len() != 0 {
+			t.Fatal("expected Close to detach the reader")
+		}
+		peer1.Close()
+		peer2.Close()
+	}
+}
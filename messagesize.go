@@ -0,0 +1,57 @@
+package simplepeer
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sctpMaxMessageSize is the largest message pion both sends and reads
+	// into its 64 KiB - 1 receive buffer.
+	sctpMaxMessageSize = math.MaxUint16
+	// defaultRemoteMaxMessageSize applies when the remote description has no
+	// a=max-message-size attribute, per RFC 8841.
+	defaultRemoteMaxMessageSize = 65536
+	maxMessageSizeAttribute     = "a=max-message-size:"
+)
+
+// remoteMaxMessageSize reads the a=max-message-size attribute of an SDP and
+// caps it at what pion can send. Zero means the remote peer has no limit.
+func remoteMaxMessageSize(sdp string) int {
+	size := defaultRemoteMaxMessageSize
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, maxMessageSizeAttribute) {
+			continue
+		}
+		parsed, err := strconv.ParseUint(strings.TrimPrefix(line, maxMessageSizeAttribute), 10, 64)
+		if err != nil {
+			continue
+		}
+		if parsed == 0 || parsed > sctpMaxMessageSize {
+			size = sctpMaxMessageSize
+		} else {
+			size = int(parsed)
+		}
+		break
+	}
+	if size > sctpMaxMessageSize {
+		size = sctpMaxMessageSize
+	}
+	return size
+}
+
+// MaxMessageSize returns how many bytes Write and WriteText put in a single
+// data channel message. It is PeerOptions.MaxChannelMessageSize if set,
+// otherwise the size negotiated with the remote peer, or 16 KiB before a
+// remote description has been applied.
+func (peer *Peer) MaxMessageSize() int {
+	if peer.maxChannelMessageSize > 0 {
+		return peer.maxChannelMessageSize
+	}
+	if size := peer.negotiatedMessageSize.Load(); size > 0 {
+		return int(size)
+	}
+	return maxChannelMessageSize
+}
@@ -0,0 +1,56 @@
+package simplepeer
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestRemoteMaxMessageSize(t *testing.T) {
+	for _, test := range []struct {
+		sdp  string
+		size int
+	}{
+		{"v=0\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n", sctpMaxMessageSize},
+		{"v=0\r\na=max-message-size:262144\r\n", sctpMaxMessageSize},
+		{"v=0\r\na=max-message-size:1024\r\n", 1024},
+		{"v=0\r\na=max-message-size:0\r\n", sctpMaxMessageSize},
+		{"v=0\r\na=max-message-size:lots\r\n", sctpMaxMessageSize},
+		{"v=0\r\na=max-message-size:65536\r\n", sctpMaxMessageSize},
+	} {
+		if size := remoteMaxMessageSize(test.sdp); size != test.size {
+			t.Errorf("expected %d for %q, got %d", test.size, test.sdp, size)
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	peer2Data := make(chan []byte, 8)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		MaxChannelMessageSize: 1000,
+		OnData: func(message webrtc.DataChannelMessage) {
+			peer2Data <- message.Data
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if size := peer1.MaxMessageSize(); size != sctpMaxMessageSize {
+		t.Fatalf("expected the negotiated size %d between two pion peers, got %d", sctpMaxMessageSize, size)
+	}
+	if size := peer2.MaxMessageSize(); size != 1000 {
+		t.Fatalf("expected the configured size 1000, got %d", size)
+	}
+	if _, err := peer1.Write(make([]byte, sctpMaxMessageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-peer2Data; len(data) != sctpMaxMessageSize {
+		t.Fatalf("expected one %d byte message, got %d", sctpMaxMessageSize, len(data))
+	}
+	if _, err := peer1.Write(make([]byte, sctpMaxMessageSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if first, second := len(<-peer2Data), len(<-peer2Data); first != sctpMaxMessageSize || second != 1 {
+		t.Fatalf("expected %d and 1 byte chunks, got %d and %d", sctpMaxMessageSize, first, second)
+	}
+}
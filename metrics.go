@@ -0,0 +1,162 @@
+package simplepeer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metricsRateWindow = 5 * time.Second
+	metricsRateBucket = 500 * time.Millisecond
+	metricsBuckets    = int(metricsRateWindow / metricsRateBucket)
+)
+
+// ChannelMetrics counts the data sent and received on one data channel.
+// Messages are data channel messages, so a chunked Write counts once per
+// chunk.
+type ChannelMetrics struct {
+	Label            string
+	BytesSent        uint64
+	BytesReceived    uint64
+	MessagesSent     uint64
+	MessagesReceived uint64
+}
+
+// Metrics is a snapshot of the data sent and received over the lifetime of
+// a peer, across reconnects.
+type Metrics struct {
+	BytesSent        uint64
+	BytesReceived    uint64
+	MessagesSent     uint64
+	MessagesReceived uint64
+	// SendRate and ReceiveRate are bytes per second over the last five
+	// seconds.
+	SendRate    float64
+	ReceiveRate float64
+	// Channels has the counters of every data channel, sorted by label.
+	Channels []ChannelMetrics
+	// Negotiations is how many negotiations the peer started, covering
+	// NegotiationIntents changes that waited NegotiationWait in total.
+	Negotiations       int
+	NegotiationIntents int
+	NegotiationWait    time.Duration
+}
+
+type channelCounters struct {
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+}
+
+// rateBucket holds the bytes of one metricsRateBucket long interval.
+type rateBucket struct {
+	start    time.Time
+	sent     uint64
+	received uint64
+}
+
+type metrics struct {
+	mutex    sync.RWMutex
+	channels map[string]*channelCounters
+	rate     sync.Mutex
+	buckets  [metricsBuckets]rateBucket
+}
+
+func (metrics *metrics) counters(label string) *channelCounters {
+	metrics.mutex.RLock()
+	counters, ok := metrics.channels[label]
+	metrics.mutex.RUnlock()
+	if ok {
+		return counters
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if metrics.channels == nil {
+		metrics.channels = make(map[string]*channelCounters)
+	}
+	if counters, ok = metrics.channels[label]; !ok {
+		counters = &channelCounters{}
+		metrics.channels[label] = counters
+	}
+	return counters
+}
+
+func (metrics *metrics) sent(label string, length int) {
+	counters := metrics.counters(label)
+	counters.bytesSent.Add(uint64(length))
+	counters.messagesSent.Add(1)
+	metrics.addRate(time.Now(), uint64(length), 0)
+}
+
+func (metrics *metrics) received(label string, length int) {
+	counters := metrics.counters(label)
+	counters.bytesReceived.Add(uint64(length))
+	counters.messagesReceived.Add(1)
+	metrics.addRate(time.Now(), 0, uint64(length))
+}
+
+func (metrics *metrics) addRate(now time.Time, sent, received uint64) {
+	start := now.Truncate(metricsRateBucket)
+	bucket := &metrics.buckets[int(start.UnixNano()/int64(metricsRateBucket))%metricsBuckets]
+	metrics.rate.Lock()
+	defer metrics.rate.Unlock()
+	if !bucket.start.Equal(start) {
+		*bucket = rateBucket{start: start}
+	}
+	bucket.sent += sent
+	bucket.received += received
+}
+
+func (metrics *metrics) rates(now time.Time) (sent, received float64) {
+	metrics.rate.Lock()
+	defer metrics.rate.Unlock()
+	var sentBytes, receivedBytes uint64
+	for _, bucket := range metrics.buckets {
+		if now.Sub(bucket.start) < metricsRateWindow {
+			sentBytes += bucket.sent
+			receivedBytes += bucket.received
+		}
+	}
+	seconds := metricsRateWindow.Seconds()
+	return float64(sentBytes) / seconds, float64(receivedBytes) / seconds
+}
+
+func (metrics *metrics) snapshot(now time.Time) Metrics {
+	var snapshot Metrics
+	metrics.mutex.RLock()
+	for label, counters := range metrics.channels {
+		channel := ChannelMetrics{
+			Label:            label,
+			BytesSent:        counters.bytesSent.Load(),
+			BytesReceived:    counters.bytesReceived.Load(),
+			MessagesSent:     counters.messagesSent.Load(),
+			MessagesReceived: counters.messagesReceived.Load(),
+		}
+		snapshot.BytesSent += channel.BytesSent
+		snapshot.BytesReceived += channel.BytesReceived
+		snapshot.MessagesSent += channel.MessagesSent
+		snapshot.MessagesReceived += channel.MessagesReceived
+		snapshot.Channels = append(snapshot.Channels, channel)
+	}
+	metrics.mutex.RUnlock()
+	sort.Slice(snapshot.Channels, func(i, j int) bool {
+		return snapshot.Channels[i].Label < snapshot.Channels[j].Label
+	})
+	snapshot.SendRate, snapshot.ReceiveRate = metrics.rates(now)
+	return snapshot
+}
+
+// Metrics returns the peer's data and negotiation counters. It can be
+// called at any time, including after Close.
+func (peer *Peer) Metrics() Metrics {
+	snapshot := peer.metrics.snapshot(time.Now())
+	peer.negotiations.mutex.Lock()
+	snapshot.Negotiations = peer.negotiations.total
+	snapshot.NegotiationIntents = peer.negotiations.totalIntents
+	snapshot.NegotiationWait = peer.negotiations.totalWait
+	peer.negotiations.mutex.Unlock()
+	return snapshot
+}
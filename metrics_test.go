@@ -0,0 +1,57 @@
+package simplepeer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestMetrics(t *testing.T) {
+	received := make(chan bool, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			received <- true
+		},
+	})
+	defer peer2.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := peer1.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+		<-received
+	}
+	peer1.Close()
+
+	sent := peer1.Metrics()
+	if sent.BytesSent != 3000 || sent.MessagesSent != 3 || sent.SendRate != 3000/metricsRateWindow.Seconds() {
+		t.Fatalf("unexpected sender metrics %+v", sent)
+	}
+	if len(sent.Channels) != 1 || sent.Channels[0].Label != peer1.channelName || sent.Channels[0].BytesSent != 3000 {
+		t.Fatalf("expected the main channel's counters, got %+v", sent.Channels)
+	}
+	if sent.Negotiations == 0 {
+		t.Fatal("expected the negotiation to be counted")
+	}
+	got := peer2.Metrics()
+	if got.BytesReceived != 3000 || got.MessagesReceived != 3 || got.ReceiveRate == 0 {
+		t.Fatalf("unexpected receiver metrics %+v", got)
+	}
+}
+
+func TestMetricsRates(t *testing.T) {
+	var metrics metrics
+	start := time.Now()
+	metrics.addRate(start, 500, 0)
+	metrics.addRate(start.Add(time.Second), 500, 100)
+	if sent, received := metrics.rates(start.Add(time.Second)); sent != 1000/metricsRateWindow.Seconds() || received != 100/metricsRateWindow.Seconds() {
+		t.Fatalf("expected both seconds in the window, got %f and %f", sent, received)
+	}
+	if sent, _ := metrics.rates(start.Add(metricsRateWindow + metricsRateBucket)); sent != 500/metricsRateWindow.Seconds() {
+		t.Fatalf("expected the first bucket to leave the window, got %f", sent)
+	}
+	if sent, received := metrics.rates(start.Add(time.Minute)); sent != 0 || received != 0 {
+		t.Fatalf("expected no rate after a minute, got %f and %f", sent, received)
+	}
+}
@@ -0,0 +1,290 @@
+package simplepeer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Stream multiplexing
+//
+// Mux carries any number of streams over the main channel. Every data
+// channel message is one mux frame: the stream id length as an unsigned
+// varint, the stream id, a flags byte, the payload length as an unsigned
+// varint and the payload. A frame with muxFlagOpen announces a stream, one
+// with muxFlagClose closes it on both sides. Frames are at most
+// MaxMessageSize bytes so each is sent as a single message. Both peers must
+// use Mux, and the main channel should carry nothing else once they do.
+const (
+	muxFlagOpen  byte = 1
+	muxFlagClose byte = 2
+)
+
+type OnStream func(id string, stream io.ReadWriteCloser)
+
+// Mux opens and accepts streams multiplexed over the main channel.
+type Mux struct {
+	peer     *Peer
+	mutex    sync.Mutex
+	streams  map[string]*muxStream
+	onStream handlers[OnStream]
+}
+
+// Mux returns the peer's stream multiplexer, creating it on first use.
+func (peer *Peer) Mux() *Mux {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if peer.mux == nil {
+		peer.mux = &Mux{
+			peer:    peer,
+			streams: make(map[string]*muxStream),
+		}
+	}
+	return peer.mux
+}
+
+// OpenStream opens a stream and announces it to the remote peer, which gets
+// it through OnStream. If both peers open the same id at once they share
+// one stream.
+func (mux *Mux) OpenStream(id string) (io.ReadWriteCloser, error) {
+	if muxHeaderSize(id) >= mux.peer.MaxMessageSize() {
+		return nil, fmt.Errorf("%w: stream id of %d bytes", ErrMessageTooLarge, len(id))
+	}
+	mux.mutex.Lock()
+	if _, ok := mux.streams[id]; ok {
+		mux.mutex.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrStreamExists, id)
+	}
+	stream := newMuxStream(mux, id)
+	mux.streams[id] = stream
+	mux.mutex.Unlock()
+	if err := mux.send(id, muxFlagOpen, nil); err != nil {
+		mux.remove(stream)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// OnStream adds a callback for streams opened by the remote peer.
+func (mux *Mux) OnStream(fn OnStream) (off func()) {
+	return mux.onStream.Append(fn)
+}
+
+// Deprecated: call the func returned by OnStream instead.
+func (mux *Mux) OffStream(fn OnStream) {
+	mux.onStream.Delete(func(index int, onStream OnStream) bool {
+		return sameFunc(onStream, fn)
+	})
+}
+
+func muxHeaderSize(id string) int {
+	return 2*binary.MaxVarintLen64 + len(id) + 1
+}
+
+func (mux *Mux) send(id string, flags byte, payload []byte) error {
+	frame := make([]byte, 0, muxHeaderSize(id)+len(payload))
+	frame = binary.AppendUvarint(frame, uint64(len(id)))
+	frame = append(frame, id...)
+	frame = append(frame, flags)
+	frame = binary.AppendUvarint(frame, uint64(len(payload)))
+	frame = append(frame, payload...)
+	_, err := mux.peer.Write(frame)
+	return err
+}
+
+func (mux *Mux) remove(stream *muxStream) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	if mux.streams[stream.id] == stream {
+		delete(mux.streams, stream.id)
+	}
+}
+
+func parseMuxFrame(data []byte) (id string, flags byte, payload []byte, err error) {
+	idLength, n := binary.Uvarint(data)
+	if n <= 0 || idLength > uint64(len(data)-n) {
+		return "", 0, nil, fmt.Errorf("%w: invalid mux stream id", ErrMalformedFrame)
+	}
+	data = data[n:]
+	id = string(data[:idLength])
+	data = data[idLength:]
+	if len(data) == 0 {
+		return "", 0, nil, fmt.Errorf("%w: missing mux flags", ErrMalformedFrame)
+	}
+	flags = data[0]
+	data = data[1:]
+	payloadLength, n := binary.Uvarint(data)
+	if n <= 0 || payloadLength != uint64(len(data)-n) {
+		return "", 0, nil, fmt.Errorf("%w: invalid mux payload length", ErrMalformedFrame)
+	}
+	return id, flags, data[n:], nil
+}
+
+func (mux *Mux) receive(data []byte) {
+	id, flags, payload, err := parseMuxFrame(data)
+	if err != nil {
+		mux.peer.error(err)
+		return
+	}
+	mux.mutex.Lock()
+	stream, ok := mux.streams[id]
+	if !ok {
+		// Frames for a stream closed here may still be in flight.
+		if flags&muxFlagOpen == 0 || flags&muxFlagClose != 0 {
+			mux.mutex.Unlock()
+			return
+		}
+		stream = newMuxStream(mux, id)
+		mux.streams[id] = stream
+	}
+	if flags&muxFlagClose != 0 {
+		delete(mux.streams, id)
+	}
+	mux.mutex.Unlock()
+	if !ok {
+		for _, fn := range mux.onStream.Slice() {
+			fn := fn
+			mux.peer.dispatch(func() { fn(id, stream) })
+		}
+	}
+	stream.push(payload)
+	if flags&muxFlagClose != 0 {
+		stream.closeRemote()
+	}
+}
+
+func (peer *Peer) onMuxMessage(data []byte) {
+	peer.mutex.RLock()
+	mux := peer.mux
+	peer.mutex.RUnlock()
+	if mux != nil {
+		mux.receive(data)
+	}
+}
+
+// muxStream buffers received payloads until they are read. There is no
+// flow control, a stream that is not read keeps everything sent to it.
+type muxStream struct {
+	mux          *Mux
+	id           string
+	writeMutex   sync.Mutex
+	mutex        sync.Mutex
+	buffer       []byte
+	closed       bool
+	remoteClosed bool
+	changed      chan struct{}
+}
+
+func newMuxStream(mux *Mux, id string) *muxStream {
+	return &muxStream{
+		mux:     mux,
+		id:      id,
+		changed: make(chan struct{}),
+	}
+}
+
+func (stream *muxStream) notifyLocked() {
+	close(stream.changed)
+	stream.changed = make(chan struct{})
+}
+
+func (stream *muxStream) push(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.closed {
+		return
+	}
+	stream.buffer = append(stream.buffer, payload...)
+	stream.notifyLocked()
+}
+
+func (stream *muxStream) closeRemote() {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	stream.remoteClosed = true
+	stream.notifyLocked()
+}
+
+// Read returns buffered data, then io.EOF once either side closed the
+// stream.
+func (stream *muxStream) Read(bytes []byte) (int, error) {
+	for {
+		stream.mutex.Lock()
+		if len(stream.buffer) > 0 && !stream.closed {
+			n := copy(bytes, stream.buffer)
+			stream.buffer = stream.buffer[n:]
+			stream.mutex.Unlock()
+			return n, nil
+		}
+		if stream.closed || stream.remoteClosed {
+			stream.mutex.Unlock()
+			return 0, io.EOF
+		}
+		changed := stream.changed
+		stream.mutex.Unlock()
+		peer := stream.mux.peer
+		peer.mutex.RLock()
+		peerContext := peer.context
+		peer.mutex.RUnlock()
+		select {
+		case <-peerContext.Done():
+			return 0, peerClosedErr(peerContext)
+		case <-changed:
+		}
+	}
+}
+
+// Write sends bytes as one or more frames. Frames of concurrent writes to
+// the same stream are not interleaved.
+func (stream *muxStream) Write(bytes []byte) (int, error) {
+	stream.writeMutex.Lock()
+	defer stream.writeMutex.Unlock()
+	limit := stream.mux.peer.MaxMessageSize() - muxHeaderSize(stream.id)
+	sent := 0
+	for sent < len(bytes) {
+		if stream.isClosed() {
+			return sent, io.ErrClosedPipe
+		}
+		count := len(bytes) - sent
+		if count > limit {
+			count = limit
+		}
+		if err := stream.mux.send(stream.id, 0, bytes[sent:sent+count]); err != nil {
+			return sent, err
+		}
+		sent += count
+	}
+	return sent, nil
+}
+
+func (stream *muxStream) isClosed() bool {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	return stream.closed || stream.remoteClosed
+}
+
+// Close closes the stream on both sides. Closing a stream the remote peer
+// already closed only releases it.
+func (stream *muxStream) Close() error {
+	stream.mutex.Lock()
+	if stream.closed {
+		stream.mutex.Unlock()
+		return io.ErrClosedPipe
+	}
+	stream.closed = true
+	remoteClosed := stream.remoteClosed
+	stream.buffer = nil
+	stream.notifyLocked()
+	stream.mutex.Unlock()
+	stream.mux.remove(stream)
+	if remoteClosed {
+		return nil
+	}
+	stream.writeMutex.Lock()
+	defer stream.writeMutex.Unlock()
+	return stream.mux.send(stream.id, muxFlagClose, nil)
+}
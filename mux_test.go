@@ -0,0 +1,149 @@
+package simplepeer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMuxStress(t *testing.T) {
+	const streamCount = 16
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	payloads := make(map[string][]byte, streamCount)
+	for i := 0; i < streamCount; i++ {
+		payload := make([]byte, 1+rand.Intn(512*1024))
+		rand.Read(payload)
+		payloads[fmt.Sprintf("stream-%d", i)] = payload
+	}
+
+	var received sync.Map
+	var receivers sync.WaitGroup
+	receivers.Add(streamCount)
+	peer2.Mux().OnStream(func(id string, stream io.ReadWriteCloser) {
+		defer receivers.Done()
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Error(err)
+		}
+		received.Store(id, data)
+		if err := stream.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	var writers sync.WaitGroup
+	for id, payload := range payloads {
+		id, payload := id, payload
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			stream, err := peer1.Mux().OpenStream(id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// Streams are written concurrently, each in random sized pieces.
+			for sent := 0; sent < len(payload); {
+				count := 1 + rand.Intn(64*1024)
+				if count > len(payload)-sent {
+					count = len(payload) - sent
+				}
+				if _, err := stream.Write(payload[sent : sent+count]); err != nil {
+					t.Error(err)
+					return
+				}
+				sent += count
+			}
+			if err := stream.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	writers.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		receivers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the streams to close")
+	}
+	for id, payload := range payloads {
+		data, _ := received.Load(id)
+		if !bytes.Equal(data.([]byte), payload) {
+			t.Fatalf("%s: expected %d bytes, got %d different ones", id, len(payload), len(data.([]byte)))
+		}
+	}
+}
+
+func TestMuxClose(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	accepted := make(chan io.ReadWriteCloser, 1)
+	peer2.Mux().OnStream(func(id string, stream io.ReadWriteCloser) {
+		accepted <- stream
+	})
+	stream, err := peer1.Mux().OpenStream("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.Mux().OpenStream("chat"); !errors.Is(err, ErrStreamExists) {
+		t.Fatalf("expected ErrStreamExists, got %v", err)
+	}
+	remote := <-accepted
+	if _, err := remote.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 16)
+	n, err := stream.Read(buffer)
+	if err != nil || string(buffer[:n]) != "hello" {
+		t.Fatalf("expected hello, got %q: %v", buffer[:n], err)
+	}
+
+	if err := remote.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Read(buffer); err != io.EOF {
+		t.Fatalf("expected io.EOF after the remote close, got %v", err)
+	}
+	if _, err := stream.Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe after the remote close, got %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.Mux().OpenStream("chat"); err != nil {
+		t.Fatalf("expected the id to be reusable after close, got %v", err)
+	}
+}
+
+func TestParseMuxFrame(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{5, 'a'},
+		{1, 'a'},
+		{1, 'a', 0, 3, 'x'},
+		{1, 'a', 0, 0, 'x'},
+	} {
+		if _, _, _, err := parseMuxFrame(data); !errors.Is(err, ErrMalformedFrame) {
+			t.Errorf("expected ErrMalformedFrame for %v, got %v", data, err)
+		}
+	}
+	id, flags, payload, err := parseMuxFrame([]byte{1, 'a', muxFlagOpen, 2, 'h', 'i'})
+	if err != nil || id != "a" || flags != muxFlagOpen || string(payload) != "hi" {
+		t.Fatalf("unexpected frame %q %d %q: %v", id, flags, payload, err)
+	}
+}
@@ -0,0 +1,317 @@
+package simplepeer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const maxNegotiationHistory = 64
+
+// NegotiationRecord describes one negotiation started by the peer or one
+// signaling state change of its connection.
+type NegotiationRecord struct {
+	// Time is when the negotiation started or the state changed.
+	Time time.Time
+	// SignalingState is the state the connection changed to, and
+	// webrtc.SignalingStateUnknown for records of negotiations.
+	SignalingState webrtc.SignalingState
+	// Intents is how many changes, such as added tracks or transceivers,
+	// were coalesced into the negotiation.
+	Intents int
+	// Wait is how long the oldest coalesced change waited for it.
+	Wait time.Duration
+}
+
+// negotiationState is the step of the offer/answer exchange in flight.
+type negotiationState int
+
+const (
+	negotiationIdle negotiationState = iota
+	// negotiationOffering waits for the answer to a local offer.
+	negotiationOffering
+	// negotiationAnswering applies a remote offer and answers it.
+	negotiationAnswering
+)
+
+// negotiationScheduler coalesces changes that need negotiation arriving
+// within the debounce window into a single negotiation.
+type negotiationScheduler struct {
+	mutex    sync.Mutex
+	debounce time.Duration
+	timer    *time.Timer
+	intents  int
+	oldest   time.Time
+	history  []NegotiationRecord
+	state    negotiationState
+	// total, totalIntents and totalWait count every negotiation for Metrics.
+	total        int
+	totalIntents int
+	totalWait    time.Duration
+}
+
+// intent records a change that needs negotiation. It returns true if the
+// negotiation should start now and otherwise calls fire once the debounce
+// window passes.
+func (scheduler *negotiationScheduler) intent(fire func()) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.addIntent()
+	return scheduler.schedule(fire)
+}
+
+// changed records a local change that pion's negotiationneeded event will
+// start the negotiation for.
+func (scheduler *negotiationScheduler) changed() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.addIntent()
+}
+
+// negotiationNeeded is intent for pion's negotiationneeded event, which
+// stands for the changes recorded with changed, or for one change that was
+// not, like a new data channel.
+func (scheduler *negotiationScheduler) negotiationNeeded(fire func()) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if scheduler.intents == 0 {
+		scheduler.addIntent()
+	}
+	return scheduler.schedule(fire)
+}
+
+func (scheduler *negotiationScheduler) addIntent() {
+	scheduler.intents++
+	if scheduler.intents == 1 {
+		scheduler.oldest = time.Now()
+	}
+}
+
+// schedule returns true if the negotiation should start now and otherwise
+// calls fire once the debounce window passes. The caller holds mutex.
+func (scheduler *negotiationScheduler) schedule(fire func()) bool {
+	if scheduler.debounce <= 0 {
+		return true
+	}
+	if scheduler.timer == nil {
+		scheduler.timer = time.AfterFunc(scheduler.debounce, func() {
+			scheduler.mutex.Lock()
+			scheduler.timer = nil
+			scheduler.mutex.Unlock()
+			fire()
+		})
+	}
+	return false
+}
+
+// start records a negotiation covering every intent since the last one.
+func (scheduler *negotiationScheduler) start() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	now := time.Now()
+	record := NegotiationRecord{Time: now, Intents: scheduler.intents}
+	if scheduler.intents > 0 {
+		record.Wait = now.Sub(scheduler.oldest)
+	}
+	scheduler.intents = 0
+	scheduler.total++
+	scheduler.totalIntents += record.Intents
+	scheduler.totalWait += record.Wait
+	scheduler.record(record)
+}
+
+// signalingStateChanged records a signaling state change of the connection.
+func (scheduler *negotiationScheduler) signalingStateChanged(state webrtc.SignalingState) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.record(NegotiationRecord{Time: time.Now(), SignalingState: state})
+}
+
+func (scheduler *negotiationScheduler) record(record NegotiationRecord) {
+	scheduler.history = append(scheduler.history, record)
+	if len(scheduler.history) > maxNegotiationHistory {
+		scheduler.history = scheduler.history[len(scheduler.history)-maxNegotiationHistory:]
+	}
+}
+
+// setState moves the exchange to state and returns the previous one.
+func (scheduler *negotiationScheduler) setState(state negotiationState) negotiationState {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	previous := scheduler.state
+	scheduler.state = state
+	return previous
+}
+
+func (scheduler *negotiationScheduler) currentState() negotiationState {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	return scheduler.state
+}
+
+// changesWaiting reports whether changes await a negotiation, and whether
+// the debounce window still collects them.
+func (scheduler *negotiationScheduler) changesWaiting() (waiting, debouncing bool) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	return scheduler.intents > 0, scheduler.timer != nil
+}
+
+func (scheduler *negotiationScheduler) stop() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if scheduler.timer != nil {
+		scheduler.timer.Stop()
+		scheduler.timer = nil
+	}
+	scheduler.intents = 0
+	scheduler.state = negotiationIdle
+}
+
+// SetNegotiationDebounce sets how long changes that need negotiation are
+// collected before a single negotiation covers them all. Zero negotiates
+// every change at once.
+func (peer *Peer) SetNegotiationDebounce(debounce time.Duration) {
+	peer.negotiations.mutex.Lock()
+	defer peer.negotiations.mutex.Unlock()
+	peer.negotiations.debounce = debounce
+}
+
+// NegotiationHistory returns the most recent negotiations and signaling
+// state changes, oldest first, across the connections the peer created.
+func (peer *Peer) NegotiationHistory() []NegotiationRecord {
+	peer.negotiations.mutex.Lock()
+	defer peer.negotiations.mutex.Unlock()
+	return append([]NegotiationRecord(nil), peer.negotiations.history...)
+}
+
+// Negotiating reports whether an offer/answer exchange is in flight or
+// changes await one. Changes made meanwhile, such as AddTrack, are
+// negotiated once the exchange settles.
+func (peer *Peer) Negotiating() bool {
+	waiting, _ := peer.negotiations.changesWaiting()
+	return waiting || peer.negotiations.currentState() != negotiationIdle || peer.pendingNegotiation.Load()
+}
+
+// OnNegotiationComplete adds a callback for when an exchange settles without
+// a renegotiation queued behind it.
+func (peer *Peer) OnNegotiationComplete(fn OnNegotiationComplete) (off func()) {
+	return peer.onNegotiationComplete.Append(fn)
+}
+
+// negotiationSettled ends the exchange in flight once the connection is
+// stable, starts the renegotiation queued behind it and otherwise fires
+// OnNegotiationComplete.
+func (peer *Peer) negotiationSettled() {
+	connection := peer.Connection()
+	if connection == nil || connection.SignalingState() != webrtc.SignalingStateStable {
+		return
+	}
+	previous := peer.negotiations.setState(negotiationIdle)
+	// pion reports changes made meanwhile only once stable, they are
+	// negotiated now unless the debounce window still collects them
+	if waiting, debouncing := peer.negotiations.changesWaiting(); waiting && !debouncing {
+		peer.pendingNegotiation.Store(true)
+	}
+	// a queued negotiation settles on its own, maybe already within
+	// negotiateIfPending when signaling is synchronous
+	if peer.negotiateIfPending() || previous == negotiationIdle || peer.Negotiating() {
+		return
+	}
+	peer.debugf("negotiation complete")
+	for _, fn := range peer.onNegotiationComplete.Slice() {
+		fn()
+	}
+}
+
+// OnSignalingStateChange adds a callback for the signaling state changes of
+// the connection and its replacements.
+func (peer *Peer) OnSignalingStateChange(fn OnSignalingStateChange) (off func()) {
+	return peer.onSignalingState.Append(fn)
+}
+
+// Deprecated: call the func returned by OnSignalingStateChange instead.
+func (peer *Peer) OffSignalingStateChange(fn OnSignalingStateChange) {
+	peer.onSignalingState.Delete(func(index int, onSignalingState OnSignalingStateChange) bool {
+		return sameFunc(onSignalingState, fn)
+	})
+}
+
+func (peer *Peer) onSignalingStateChange(state webrtc.SignalingState) {
+	peer.debugf("signaling state %s", state)
+	peer.negotiations.signalingStateChanged(state)
+	for _, fn := range peer.onSignalingState.Slice() {
+		fn(state)
+	}
+}
+
+func (peer *Peer) onNegotiationDebounced() {
+	if err := peer.negotiateWhenStable(); err != nil && !errors.Is(err, ErrOperationTimeout) {
+		peer.error(err)
+	}
+}
+
+const (
+	defaultMaxRenegotiationsPerMinute = 120
+	renegotiationStormReportInterval  = 10 * time.Second
+)
+
+// renegotiationLimiter caps how many renegotiation requests from the remote
+// peer are accepted per minute.
+type renegotiationLimiter struct {
+	mutex      sync.Mutex
+	max        int
+	accepted   []time.Time
+	rejected   int
+	lastReport time.Time
+}
+
+// allow records a renegotiation request. When the request is over the cap
+// and a report is due, it returns how many requests were rejected since the
+// last report.
+func (limiter *renegotiationLimiter) allow() (allowed bool, rejected int) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.max < 0 {
+		return true, 0
+	}
+	now := time.Now()
+	expired := 0
+	for expired < len(limiter.accepted) && now.Sub(limiter.accepted[expired]) >= time.Minute {
+		expired++
+	}
+	limiter.accepted = limiter.accepted[expired:]
+	if len(limiter.accepted) < limiter.max {
+		limiter.accepted = append(limiter.accepted, now)
+		return true, 0
+	}
+	limiter.rejected++
+	if !limiter.lastReport.IsZero() && now.Sub(limiter.lastReport) < renegotiationStormReportInterval {
+		return false, 0
+	}
+	rejected = limiter.rejected
+	limiter.rejected = 0
+	limiter.lastReport = now
+	return false, rejected
+}
+
+// renegotiationRequested checks a renegotiation request from the remote
+// peer against MaxRenegotiationsPerMinute.
+func (peer *Peer) renegotiationRequested() error {
+	allowed, rejected := peer.renegotiations.allow()
+	if allowed {
+		return nil
+	}
+	err := fmt.Errorf("%w: more than %d renegotiations per minute", ErrRenegotiationStorm, peer.renegotiations.max)
+	if rejected > 0 {
+		peer.debugf("rejected %d renegotiations", rejected)
+		peer.error(fmt.Errorf("%w: more than %d renegotiations per minute, rejected %d", ErrRenegotiationStorm, peer.renegotiations.max, rejected))
+		if peer.closeOnRenegotiationStorm {
+			go peer.shutdown(ErrRenegotiationStorm, true)
+		}
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package simplepeer
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestNegotiationNeededAddTrack(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		answerer  bool
+		connected bool
+	}{
+		{name: "initiator before connect"},
+		{name: "initiator after connect", connected: true},
+		{name: "answerer before connect", answerer: true},
+		{name: "answerer after connect", answerer: true, connected: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tracks := make(chan *webrtc.TrackRemote, 2)
+			onTrack := func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+				tracks <- track
+			}
+			var peer1, peer2 *Peer
+			if test.connected {
+				peer1, peer2 = connectTestPeers(t, PeerOptions{OnTrack: onTrack}, PeerOptions{OnTrack: onTrack})
+			} else {
+				peer1, peer2 = pairTestPeers(PeerOptions{OnTrack: onTrack}, PeerOptions{OnTrack: onTrack})
+				if err := peer1.Init(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer peer1.Close()
+			defer peer2.Close()
+			sender := peer1
+			if test.answerer {
+				sender = peer2
+				testutil.WaitFor(t, func() bool {
+					return peer2.Connection() != nil
+				})
+			}
+
+			track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "negotiation")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sender.AddTrack(track); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				ticker := time.NewTicker(20 * time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						track.WriteSample(media.Sample{Data: []byte{0x00}, Duration: 20 * time.Millisecond})
+					}
+				}
+			}()
+			select {
+			case remote := <-tracks:
+				if remote.StreamID() != "negotiation" {
+					t.Fatalf("expected the added track, got stream %q", remote.StreamID())
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected the track to be negotiated")
+			}
+			time.Sleep(time.Second)
+			if history := negotiationsOnly(peer1.NegotiationHistory()); len(history) > 3 {
+				t.Fatalf("expected no redundant negotiations, got %d", len(history))
+			}
+		})
+	}
+}
+
+// offerHold holds the offers of the initiator and the answers to them
+// while held is set, so a test can act while the initiator waits for an
+// answer.
+type offerHold struct {
+	held    atomic.Bool
+	offers  chan map[string]interface{}
+	answers chan map[string]interface{}
+}
+
+// connectHoldingOffers connects two peers like connectTestPeers, passing
+// the offers of the initiator and the answers to them through the returned
+// hold.
+func connectHoldingOffers(t *testing.T, peer1Options, peer2Options PeerOptions) (*Peer, *Peer, *offerHold) {
+	hold := &offerHold{
+		offers:  make(chan map[string]interface{}, 1),
+		answers: make(chan map[string]interface{}, 1),
+	}
+	var peer1, peer2 *Peer
+	peer1Options.Id = "peer1"
+	peer1Options.OnSignal = func(message map[string]interface{}) error {
+		if hold.held.Load() && message["type"] == SignalMessageOffer {
+			hold.offers <- message
+			return nil
+		}
+		return peer2.Signal(message)
+	}
+	peer2Options.Id = "peer2"
+	peer2Options.OnSignal = func(message map[string]interface{}) error {
+		if hold.held.Load() && message["type"] == SignalMessageAnswer {
+			hold.answers <- message
+			return nil
+		}
+		return peer1.Signal(message)
+	}
+	peer1 = NewPeer(peer1Options)
+	peer2 = NewPeer(peer2Options)
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, peer := range []*Peer{peer1, peer2} {
+		if err := peer.WaitForChannelOpen(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return peer1, peer2, hold
+}
+
+// heldOffer returns the next offer held by hold.
+func (hold *offerHold) heldOffer(t *testing.T) map[string]interface{} {
+	select {
+	case offer := <-hold.offers:
+		return offer
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the initiator to offer")
+		return nil
+	}
+}
+
+// heldAnswer returns the next answer held by hold.
+func (hold *offerHold) heldAnswer(t *testing.T) map[string]interface{} {
+	select {
+	case answer := <-hold.answers:
+		return answer
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the answerer to answer")
+		return nil
+	}
+}
+
+func TestOfferCollision(t *testing.T) {
+	tracks1 := make(chan *webrtc.TrackRemote, 2)
+	tracks2 := make(chan *webrtc.TrackRemote, 2)
+	peer1, peer2, hold := connectHoldingOffers(t, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			tracks1 <- track
+		},
+	}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			tracks2 <- track
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	// the initiator offers its track, its answer is held until the
+	// answerer requested its own track and offered it as well
+	hold.held.Store(true)
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "initiator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(video); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer2.Signal(hold.heldOffer(t)); err != nil {
+		t.Fatal(err)
+	}
+	answer := hold.heldAnswer(t)
+	transceivers := len(peer1.Connection().GetTransceivers())
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "answerer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer2.AddTrack(audio); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		return len(peer1.Connection().GetTransceivers()) > transceivers
+	})
+	colliding, err := peer2.Connection().CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(colliding.SDP, "m=audio") {
+		t.Fatal("expected the colliding offer to carry the answerer's track")
+	}
+	if err := peer1.Signal(BuildSignal(SignalMessage{Type: colliding.Type.String(), SDP: colliding.SDP})); err != nil {
+		t.Fatalf("expected the colliding offer to be dropped, got %v", err)
+	}
+	if state := peer1.Connection().SignalingState(); state != webrtc.SignalingStateHaveLocalOffer {
+		t.Fatalf("expected the initiator to keep its offer, got %s", state)
+	}
+	if pending := peer1.pendingSignals.Len(); pending != 0 {
+		t.Fatalf("expected the colliding offer to be dropped, not queued, got %d pending signals", pending)
+	}
+	if description := peer1.Connection().RemoteDescription(); description != nil && strings.Contains(description.SDP, "m=audio") {
+		t.Fatal("expected the colliding offer not to be applied")
+	}
+	// the answer completes the initiator's offer, after which it negotiates
+	// the answerer's track from the queued request
+	hold.held.Store(false)
+	if err := peer1.Signal(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				video.WriteSample(media.Sample{Data: []byte{0x00}, Duration: 20 * time.Millisecond})
+				audio.WriteSample(media.Sample{Data: []byte{0x00}, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+	for _, expected := range []struct {
+		tracks chan *webrtc.TrackRemote
+		stream string
+	}{{tracks2, "initiator"}, {tracks1, "answerer"}} {
+		select {
+		case remote := <-expected.tracks:
+			if remote.StreamID() != expected.stream {
+				t.Fatalf("expected stream %q, got %q", expected.stream, remote.StreamID())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected stream %q to be negotiated", expected.stream)
+		}
+	}
+	for _, peer := range []*Peer{peer1, peer2} {
+		description := peer.Connection().CurrentLocalDescription()
+		if description == nil || !strings.Contains(description.SDP, "m=video") || !strings.Contains(description.SDP, "m=audio") {
+			t.Fatalf("expected both media sections in the description of %s", peer.Id())
+		}
+		if state := peer.Connection().SignalingState(); state != webrtc.SignalingStateStable {
+			t.Fatalf("expected %s to be stable, got %s", peer.Id(), state)
+		}
+		if pending := peer.pendingSignals.Len(); pending != 0 {
+			t.Fatalf("expected the colliding offer to be dropped, %s has %d pending signals", peer.Id(), pending)
+		}
+	}
+}
+
+func TestNegotiating(t *testing.T) {
+	var completed1, completed2 atomic.Int32
+	peer1, peer2, hold := connectHoldingOffers(t, PeerOptions{
+		OnNegotiationComplete: func() {
+			completed1.Add(1)
+		},
+	}, PeerOptions{
+		OnNegotiationComplete: func() {
+			completed2.Add(1)
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	testutil.WaitFor(t, func() bool {
+		return !peer1.Negotiating() && !peer2.Negotiating() && completed1.Load() == 1 && completed2.Load() == 1
+	})
+
+	hold.held.Store(true)
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "negotiating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(video); err != nil {
+		t.Fatal(err)
+	}
+	offer := hold.heldOffer(t)
+	if !peer1.Negotiating() {
+		t.Fatal("expected the initiator to negotiate while its offer awaits an answer")
+	}
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "negotiating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(audio); err != nil {
+		t.Fatalf("expected a track added while negotiating to be queued, got %v", err)
+	}
+	if completed1.Load() != 1 {
+		t.Fatal("expected no completion while the offer awaits an answer")
+	}
+
+	// the queued renegotiation runs once the held offer is answered
+	hold.held.Store(false)
+	if err := peer2.Signal(offer); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		return completed1.Load() == 2 && !peer1.Negotiating() && !peer2.Negotiating()
+	})
+	description := peer2.Connection().CurrentRemoteDescription()
+	if description == nil || !strings.Contains(description.SDP, "m=video") || !strings.Contains(description.SDP, "m=audio") {
+		t.Fatal("expected the queued track to be negotiated")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if count := completed1.Load(); count != 2 {
+		t.Fatalf("expected one completion for the exchange and its queued renegotiation, got %d", count)
+	}
+}
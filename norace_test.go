@@ -0,0 +1,5 @@
+//go:build !race
+
+package simplepeer
+
+const raceEnabled = false
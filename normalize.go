@@ -0,0 +1,150 @@
+package simplepeer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const normalizedValue = "*"
+
+// NormalizeSdp rewrites the parts of an SDP that differ between otherwise
+// identical sessions, such as session ids, ICE credentials, fingerprints,
+// SSRCs, gathered candidates and the order of header extensions, so two
+// descriptions can be compared.
+func NormalizeSdp(sdp string) string {
+	lines := strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\n")
+	normalized := make([]string, 0, len(lines))
+	ssrcs := make(map[string]string)
+	normalizeSsrc := func(ssrc string) string {
+		if _, ok := ssrcs[ssrc]; !ok {
+			ssrcs[ssrc] = "ssrc" + strconv.Itoa(len(ssrcs)+1)
+		}
+		return ssrcs[ssrc]
+	}
+	for _, line := range lines {
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "a=candidate:"), line == "a=end-of-candidates":
+			continue
+		case strings.HasPrefix(line, "o="):
+			fields := strings.Fields(line)
+			if len(fields) == 6 {
+				fields[1], fields[2] = normalizedValue, normalizedValue
+				line = strings.Join(fields, " ")
+			}
+		case strings.HasPrefix(line, "c="):
+			fields := strings.Fields(line)
+			if len(fields) == 3 {
+				fields[2] = normalizedValue
+				line = strings.Join(fields, " ")
+			}
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			line = "a=ice-ufrag:" + normalizedValue
+		case strings.HasPrefix(line, "a=ice-pwd:"):
+			line = "a=ice-pwd:" + normalizedValue
+		case strings.HasPrefix(line, "a=fingerprint:"):
+			fields := strings.Fields(line)
+			line = fields[0] + " " + normalizedValue
+		case strings.HasPrefix(line, "a=ssrc-group:"):
+			fields := strings.Fields(line)
+			for i := 1; i < len(fields); i++ {
+				fields[i] = normalizeSsrc(fields[i])
+			}
+			line = strings.Join(fields, " ")
+		case strings.HasPrefix(line, "a=ssrc:"):
+			ssrc, attribute, _ := strings.Cut(strings.TrimPrefix(line, "a=ssrc:"), " ")
+			if name, _, ok := strings.Cut(attribute, ":"); ok && name == "cname" {
+				attribute = name + ":" + normalizedValue
+			}
+			line = "a=ssrc:" + normalizeSsrc(ssrc)
+			if attribute != "" {
+				line += " " + attribute
+			}
+		}
+		normalized = append(normalized, line)
+	}
+	sortRuns(normalized, "a=extmap:")
+	return strings.Join(normalized, "\r\n") + "\r\n"
+}
+
+// NormalizeCandidate keeps the component, protocol and type of a candidate
+// attribute and replaces the gathered foundation, priority, address and port.
+func NormalizeCandidate(candidate string) string {
+	fields := strings.Fields(strings.TrimPrefix(candidate, "a="))
+	if len(fields) < 8 || !strings.HasPrefix(fields[0], "candidate:") {
+		return candidate
+	}
+	return strings.Join([]string{
+		"candidate:" + normalizedValue,
+		fields[1],
+		strings.ToLower(fields[2]),
+		normalizedValue,
+		normalizedValue,
+		normalizedValue,
+		fields[6],
+		fields[7],
+	}, " ")
+}
+
+// NormalizeSignal returns a copy of a signal message with its SDP and
+// candidates normalized by NormalizeSdp and NormalizeCandidate, and the id
+// of its sender replaced.
+func NormalizeSignal(message map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(message))
+	for key, value := range message {
+		normalized[key] = value
+	}
+	if _, ok := message["from"].(string); ok {
+		normalized["from"] = normalizedValue
+	}
+	if sdp, ok := message["sdp"].(string); ok {
+		normalized["sdp"] = NormalizeSdp(sdp)
+	}
+	if candidate, ok := message["candidate"].(map[string]interface{}); ok {
+		normalized["candidate"] = normalizeCandidateInit(candidate)
+	}
+	if candidates, ok := message["candidates"].([]interface{}); ok {
+		normalizedCandidates := make([]interface{}, len(candidates))
+		for i, candidate := range candidates {
+			if candidate, ok := candidate.(map[string]interface{}); ok {
+				normalizedCandidates[i] = normalizeCandidateInit(candidate)
+			} else {
+				normalizedCandidates[i] = candidate
+			}
+		}
+		normalized["candidates"] = normalizedCandidates
+	}
+	return normalized
+}
+
+func normalizeCandidateInit(candidate map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(candidate))
+	for key, value := range candidate {
+		normalized[key] = value
+	}
+	if value, ok := candidate["candidate"].(string); ok {
+		normalized["candidate"] = NormalizeCandidate(value)
+	}
+	if _, ok := candidate["usernameFragment"].(string); ok {
+		normalized["usernameFragment"] = normalizedValue
+	}
+	return normalized
+}
+
+// sortRuns sorts each run of adjacent lines starting with prefix.
+func sortRuns(lines []string, prefix string) {
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && strings.HasPrefix(lines[end], prefix) {
+			end++
+		}
+		if end > start {
+			sort.Strings(lines[start:end])
+			start = end
+		} else {
+			start++
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package simplepeer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func createTestOffer(t *testing.T) string {
+	connection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connection.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := connection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer.SDP
+}
+
+func TestNormalizeSdp(t *testing.T) {
+	offer1 := createTestOffer(t)
+	offer2 := createTestOffer(t)
+	if offer1 == offer2 {
+		t.Fatal("expected independent offers to differ")
+	}
+	if NormalizeSdp(offer1) != NormalizeSdp(offer2) {
+		t.Fatalf("expected normalized offers to match:\n%s\n%s", NormalizeSdp(offer1), NormalizeSdp(offer2))
+	}
+	if NormalizeSdp(offer1) == NormalizeSdp(offer1+"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n") {
+		t.Fatal("expected an extra media section to survive normalization")
+	}
+}
+
+func TestNormalizeSignal(t *testing.T) {
+	message := map[string]interface{}{
+		"type": "candidate",
+		"candidate": map[string]interface{}{
+			"candidate":        "candidate:1966762133 1 UDP 2130706431 192.168.1.2 51234 typ host",
+			"sdpMid":           "0",
+			"sdpMLineIndex":    float64(0),
+			"usernameFragment": "abcd",
+		},
+	}
+	expected := map[string]interface{}{
+		"type": "candidate",
+		"candidate": map[string]interface{}{
+			"candidate":        "candidate:* 1 udp * * * typ host",
+			"sdpMid":           "0",
+			"sdpMLineIndex":    float64(0),
+			"usernameFragment": "*",
+		},
+	}
+	if normalized := NormalizeSignal(message); !reflect.DeepEqual(normalized, expected) {
+		t.Fatalf("expected %v, got %v", expected, normalized)
+	}
+	if message["candidate"].(map[string]interface{})["usernameFragment"] != "abcd" {
+		t.Fatal("expected NormalizeSignal not to modify its argument")
+	}
+}
+
+func FuzzNormalizeSdp(f *testing.F) {
+	f.Add("v=0\r\no=- 1 2 IN IP4 0.0.0.0\r\na=ssrc:1 cname:x\r\na=ssrc-group:FID 1 2\r\n")
+	f.Add("a=fingerprint:\r\na=ssrc:\r\no=\r\n")
+	f.Fuzz(func(t *testing.T, sdp string) {
+		normalized := NormalizeSdp(sdp)
+		if again := NormalizeSdp(normalized); again != normalized {
+			t.Fatalf("expected normalization to be idempotent:\n%q\n%q", normalized, again)
+		}
+	})
+}
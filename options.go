@@ -0,0 +1,171 @@
+package simplepeer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// GoogleSTUNServer is Google's public STUN server.
+const GoogleSTUNServer = "stun:stun.l.google.com:19302"
+
+// Option sets fields of the PeerOptions NewPeerWith creates the peer with.
+// Options are applied in order onto a single PeerOptions, so a later option
+// overrides an earlier one, including back to the zero value. Fields
+// without a With function are set with an Option of their own:
+//
+//	simplepeer.Option(func(options *simplepeer.PeerOptions) {
+//		options.SendBye = true
+//	})
+type Option func(options *PeerOptions)
+
+// NewPeerWith creates a peer from options, exactly like NewPeer with the
+// PeerOptions they set.
+func NewPeerWith(options ...Option) *Peer {
+	return NewPeer(ApplyOptions(options...))
+}
+
+// ApplyOptions returns the PeerOptions options set, e.g. to pass them to a
+// PeerManager.
+func ApplyOptions(options ...Option) PeerOptions {
+	var peerOptions PeerOptions
+	for _, option := range options {
+		option(&peerOptions)
+	}
+	return peerOptions
+}
+
+// WithOptions combines options into one, for presets shared across
+// peers.
+func WithOptions(options ...Option) Option {
+	return func(peerOptions *PeerOptions) {
+		for _, option := range options {
+			option(peerOptions)
+		}
+	}
+}
+
+// WithPeerOptions replaces everything set so far with base, so the struct
+// style can be the starting point of a list of options.
+func WithPeerOptions(base PeerOptions) Option {
+	return func(options *PeerOptions) {
+		*options = base
+	}
+}
+
+func WithID(id string) Option {
+	return func(options *PeerOptions) {
+		options.Id = id
+	}
+}
+
+func WithContext(ctx context.Context) Option {
+	return func(options *PeerOptions) {
+		options.Context = ctx
+	}
+}
+
+func WithChannelName(channelName string) Option {
+	return func(options *PeerOptions) {
+		options.ChannelName = channelName
+	}
+}
+
+func WithChannelConfig(channelConfig *webrtc.DataChannelInit) Option {
+	return func(options *PeerOptions) {
+		options.ChannelConfig = channelConfig
+	}
+}
+
+func WithConfig(config *webrtc.Configuration) Option {
+	return func(options *PeerOptions) {
+		options.Config = config
+	}
+}
+
+// WithICEServers adds servers to those of the configuration set so far.
+// The configuration is copied, so one passed to WithConfig is not changed.
+func WithICEServers(servers ...webrtc.ICEServer) Option {
+	return func(options *PeerOptions) {
+		var config webrtc.Configuration
+		if options.Config != nil {
+			config = *options.Config
+		}
+		config.ICEServers = append(append([]webrtc.ICEServer{}, config.ICEServers...), servers...)
+		options.Config = &config
+	}
+}
+
+// WithGoogleSTUN adds GoogleSTUNServer to the ICE servers.
+func WithGoogleSTUN() Option {
+	return WithICEServers(webrtc.ICEServer{URLs: []string{GoogleSTUNServer}})
+}
+
+func WithTracks(tracks ...webrtc.TrackLocal) Option {
+	return func(options *PeerOptions) {
+		options.Tracks = tracks
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(options *PeerOptions) {
+		options.Logger = logger
+	}
+}
+
+func WithMaxChannelMessageSize(size int) Option {
+	return func(options *PeerOptions) {
+		options.MaxChannelMessageSize = size
+	}
+}
+
+func WithFrameMessages(frameMessages bool) Option {
+	return func(options *PeerOptions) {
+		options.FrameMessages = frameMessages
+	}
+}
+
+func WithAutoReconnect(autoReconnect bool) Option {
+	return func(options *PeerOptions) {
+		options.AutoReconnect = autoReconnect
+	}
+}
+
+// WithOnSignal and the other handler options replace a handler of the same
+// kind set before. Further handlers are added with the peer's On methods.
+func WithOnSignal(fn OnSignal) Option {
+	return func(options *PeerOptions) {
+		options.OnSignal = fn
+	}
+}
+
+func WithOnConnect(fn OnConnect) Option {
+	return func(options *PeerOptions) {
+		options.OnConnect = fn
+	}
+}
+
+func WithOnData(fn OnData) Option {
+	return func(options *PeerOptions) {
+		options.OnData = fn
+	}
+}
+
+func WithOnError(fn OnError) Option {
+	return func(options *PeerOptions) {
+		options.OnError = fn
+	}
+}
+
+func WithOnClose(fn OnClose) Option {
+	return func(options *PeerOptions) {
+		options.OnClose = fn
+	}
+}
+
+func WithOnTrack(fn OnTrack) Option {
+	return func(options *PeerOptions) {
+		options.OnTrack = fn
+	}
+}
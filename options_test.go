@@ -0,0 +1,77 @@
+package simplepeer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestNewPeerWith(t *testing.T) {
+	config := &webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:127.0.0.1:3478"}}},
+	}
+	defaults := WithOptions(
+		WithConfig(config),
+		WithGoogleSTUN(),
+		WithMaxChannelMessageSize(1024),
+	)
+	peer := NewPeerWith(defaults, WithID("options"), WithChannelName("chat"), WithMaxChannelMessageSize(0))
+	defer peer.Close()
+
+	if peer.Id() != "options" || peer.channelName != "chat" {
+		t.Fatalf("expected the id and channel name, got %q and %q", peer.Id(), peer.channelName)
+	}
+	if peer.maxChannelMessageSize != 0 {
+		t.Fatalf("expected the later option to reset the message size, got %d", peer.maxChannelMessageSize)
+	}
+	if got := fmt.Sprint(peer.config.ICEServers); got != fmt.Sprint([]webrtc.ICEServer{
+		{URLs: []string{"stun:127.0.0.1:3478"}},
+		{URLs: []string{GoogleSTUNServer}},
+	}) {
+		t.Fatalf("expected the configured and the google server, got %s", got)
+	}
+	if len(config.ICEServers) != 1 {
+		t.Fatal("expected the configuration passed to WithConfig to be unchanged")
+	}
+
+	options := ApplyOptions(WithPeerOptions(PeerOptions{Id: "base", SendBye: true}), WithChannelName("chat"))
+	if options.Id != "base" || !options.SendBye || options.ChannelName != "chat" {
+		t.Fatalf("expected the struct options with the channel name, got %+v", options)
+	}
+}
+
+func TestTracksOption(t *testing.T) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := make(chan *webrtc.TrackRemote, 1)
+	peer1, peer2 := connectTestPeers(t, ApplyOptions(WithTracks(track)), ApplyOptions(WithOnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		tracks <- track
+	})))
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if senders := peer1.Connection().GetSenders(); len(senders) != 1 || senders[0].Track() != track {
+		t.Fatal("expected the track to be added to the connection")
+	}
+	go func() {
+		for i := 0; i < 100; i++ {
+			if track.WriteSample(media.Sample{Data: []byte{0x00}, Duration: 20 * time.Millisecond}) != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case remote := <-tracks:
+		if remote.StreamID() != "options" {
+			t.Fatalf("expected the track's stream, got %q", remote.StreamID())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the remote peer to receive the track")
+	}
+}
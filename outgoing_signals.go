@@ -0,0 +1,96 @@
+package simplepeer
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultSignalRetryBackoff = 100 * time.Millisecond
+
+type outgoingSignal struct {
+	message  SignalMessage
+	attempts int
+}
+
+// outgoingSignals holds signal messages that OnSignal failed to deliver so
+// they can be retried in order.
+type outgoingSignals struct {
+	mutex      sync.Mutex
+	retryCount int
+	backoff    time.Duration
+	sending    bool
+	timer      *time.Timer
+	messages   []*outgoingSignal
+}
+
+func (signals *outgoingSignals) push(message SignalMessage) {
+	signals.mutex.Lock()
+	defer signals.mutex.Unlock()
+	signals.messages = append(signals.messages, &outgoingSignal{message: message})
+}
+
+func (signals *outgoingSignals) clear() {
+	signals.mutex.Lock()
+	defer signals.mutex.Unlock()
+	if signals.timer != nil {
+		signals.timer.Stop()
+		signals.timer = nil
+	}
+	signals.messages = nil
+}
+
+// flush sends queued messages in order until one fails. Failures of manual
+// flushes are returned and do not count as retry attempts; automatic
+// failures are retried with exponential backoff and reported through
+// onExhausted once the retries are used up.
+func (signals *outgoingSignals) flush(send func(message SignalMessage) error, manual bool, onExhausted func(err error), retry func()) error {
+	signals.mutex.Lock()
+	if signals.sending || (!manual && signals.timer != nil) {
+		signals.mutex.Unlock()
+		return nil
+	}
+	signals.sending = true
+	signals.mutex.Unlock()
+	for {
+		signals.mutex.Lock()
+		if len(signals.messages) == 0 {
+			signals.sending = false
+			signals.mutex.Unlock()
+			return nil
+		}
+		head := signals.messages[0]
+		signals.mutex.Unlock()
+
+		err := send(head.message)
+
+		signals.mutex.Lock()
+		if err == nil {
+			signals.messages = signals.messages[1:]
+			signals.mutex.Unlock()
+			continue
+		}
+		if manual {
+			signals.sending = false
+			signals.mutex.Unlock()
+			return err
+		}
+		head.attempts++
+		if head.attempts > signals.retryCount {
+			signals.messages = signals.messages[1:]
+			signals.mutex.Unlock()
+			onExhausted(err)
+			continue
+		}
+		if signals.timer == nil {
+			signals.timer = time.AfterFunc(signals.backoff<<(head.attempts-1), func() {
+				signals.mutex.Lock()
+				signals.timer = nil
+				signals.mutex.Unlock()
+				retry()
+			})
+		}
+		signals.sending = false
+		signals.mutex.Unlock()
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package simplepeer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// PathUsageRecord describes the data sent over one selected ICE candidate
+// pair. A new record starts every time the selected pair changes.
+type PathUsageRecord struct {
+	Local  webrtc.ICECandidateType
+	Remote webrtc.ICECandidateType
+	// Relay is true if either side of the pair is a relay candidate.
+	Relay    bool
+	Start    time.Time
+	Duration time.Duration
+	// BytesSent and BytesReceived count data channel payload bytes.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+type pathUsage struct {
+	mutex   sync.Mutex
+	records []PathUsageRecord
+	active  bool
+}
+
+func (usage *pathUsage) reset() {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	usage.records = nil
+	usage.active = false
+}
+
+// selectPair ends the current record and starts one for the new pair.
+func (usage *pathUsage) selectPair(local, remote webrtc.ICECandidateType, at time.Time) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	usage.endLocked(at)
+	usage.records = append(usage.records, PathUsageRecord{
+		Local:  local,
+		Remote: remote,
+		Relay:  local == webrtc.ICECandidateTypeRelay || remote == webrtc.ICECandidateTypeRelay,
+		Start:  at,
+	})
+	usage.active = true
+}
+
+func (usage *pathUsage) end(at time.Time) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	usage.endLocked(at)
+}
+
+func (usage *pathUsage) endLocked(at time.Time) {
+	if usage.active {
+		current := &usage.records[len(usage.records)-1]
+		current.Duration = at.Sub(current.Start)
+		usage.active = false
+	}
+}
+
+// add attributes bytes to the pair that is currently selected.
+func (usage *pathUsage) add(sent, received int) {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	if !usage.active {
+		return
+	}
+	current := &usage.records[len(usage.records)-1]
+	current.BytesSent += uint64(sent)
+	current.BytesReceived += uint64(received)
+}
+
+func (usage *pathUsage) snapshot(now time.Time) []PathUsageRecord {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	records := append([]PathUsageRecord(nil), usage.records...)
+	if usage.active {
+		current := &records[len(records)-1]
+		current.Duration = now.Sub(current.Start)
+	}
+	return records
+}
+
+// PathUsage returns a record for every candidate pair the current or last
+// connection sent data over, oldest first.
+func (peer *Peer) PathUsage() []PathUsageRecord {
+	return peer.pathUsage.snapshot(time.Now())
+}
+
+func (peer *Peer) watchSelectedCandidatePair(connection *webrtc.PeerConnection) {
+	sctp := connection.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return
+	}
+	sctp.Transport().ICETransport().OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		if peer.Connection() != connection || pair == nil || pair.Local == nil || pair.Remote == nil {
+			return
+		}
+		peer.debugf("selected candidate pair local=%s remote=%s", pair.Local.Typ, pair.Remote.Typ)
+		peer.pathUsage.selectPair(pair.Local.Typ, pair.Remote.Typ, time.Now())
+	})
+}
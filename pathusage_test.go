@@ -0,0 +1,79 @@
+package simplepeer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestPathUsageAttribution(t *testing.T) {
+	var usage pathUsage
+	start := time.Now()
+	usage.add(10, 10)
+	usage.selectPair(webrtc.ICECandidateTypeHost, webrtc.ICECandidateTypeHost, start)
+	usage.add(100, 0)
+	usage.add(0, 50)
+	usage.selectPair(webrtc.ICECandidateTypeRelay, webrtc.ICECandidateTypeSrflx, start.Add(2*time.Second))
+	usage.add(30, 20)
+	usage.end(start.Add(5 * time.Second))
+	usage.add(1000, 1000)
+
+	records := usage.snapshot(start.Add(10 * time.Second))
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Relay || !records[1].Relay {
+		t.Fatalf("expected only the second pair to be relayed, got %+v", records)
+	}
+	if records[0].Duration != 2*time.Second || records[1].Duration != 3*time.Second {
+		t.Fatalf("expected durations of 2s and 3s, got %s and %s", records[0].Duration, records[1].Duration)
+	}
+	var sent, received uint64
+	for _, record := range records {
+		sent += record.BytesSent
+		received += record.BytesReceived
+	}
+	if sent != 130 || received != 70 {
+		t.Fatalf("expected 130 bytes sent and 70 received, got %d and %d", sent, received)
+	}
+	if records[1].BytesSent != 30 || records[1].BytesReceived != 20 {
+		t.Fatalf("expected 30 bytes sent and 20 received over the relay, got %d and %d", records[1].BytesSent, records[1].BytesReceived)
+	}
+}
+
+func TestPathUsage(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer2.Close()
+
+	written, err := peer1.Write(make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		var received uint64
+		for _, record := range peer2.PathUsage() {
+			received += record.BytesReceived
+		}
+		return received == uint64(written)
+	})
+	peer1.Close()
+	records := peer1.PathUsage()
+	if len(records) == 0 {
+		t.Fatal("expected a path usage record after closing")
+	}
+	var sent uint64
+	for _, record := range records {
+		if record.Relay {
+			t.Fatalf("expected a direct path, got %+v", record)
+		}
+		sent += record.BytesSent
+	}
+	if sent != uint64(written) {
+		t.Fatalf("expected %d bytes sent, got %d", written, sent)
+	}
+	if duration := records[len(records)-1].Duration; duration <= 0 {
+		t.Fatalf("expected the last record to have ended, got %s", duration)
+	}
+}
@@ -0,0 +1,113 @@
+package simplepeer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const defaultMaxPendingWriteBytes = 1 << 20
+
+// pendingWrites queues the writes of BufferWritesUntilOpen until the main
+// data channel opens.
+type pendingWrites struct {
+	mutex sync.Mutex
+	limit int
+	size  int
+	queue []pendingWrite
+	// flushing is set while queued writes are sent, so later writes queue
+	// behind them instead of overtaking them.
+	flushing bool
+}
+
+type pendingWrite struct {
+	data     []byte
+	isString bool
+}
+
+// push queues a copy of data unless the channel is open and no write is
+// queued before it. A write that does not fit the limit is rejected with
+// ErrSendBufferFull.
+func (writes *pendingWrites) push(channel *webrtc.DataChannel, data []byte, isString bool) (bool, error) {
+	writes.mutex.Lock()
+	defer writes.mutex.Unlock()
+	if channel != nil && channel.ReadyState() != webrtc.DataChannelStateConnecting && !writes.flushing && len(writes.queue) == 0 {
+		return false, nil
+	}
+	if writes.size+len(data) > writes.limit {
+		return true, fmt.Errorf("%w: %d bytes are waiting for the channel to open", ErrSendBufferFull, writes.size)
+	}
+	writes.queue = append(writes.queue, pendingWrite{data: append([]byte{}, data...), isString: isString})
+	writes.size += len(data)
+	return true, nil
+}
+
+// next returns the next write to send, or false once none is left, which
+// ends the flush.
+func (writes *pendingWrites) next() (pendingWrite, bool) {
+	writes.mutex.Lock()
+	defer writes.mutex.Unlock()
+	if len(writes.queue) == 0 {
+		writes.flushing = false
+		return pendingWrite{}, false
+	}
+	writes.flushing = true
+	write := writes.queue[0]
+	writes.queue[0] = pendingWrite{}
+	writes.queue = writes.queue[1:]
+	writes.size -= len(write.data)
+	return write, true
+}
+
+func (writes *pendingWrites) clear() {
+	writes.mutex.Lock()
+	defer writes.mutex.Unlock()
+	writes.queue = nil
+	writes.size = 0
+	writes.flushing = false
+}
+
+// flushPendingWrites sends the queued writes in order once the main channel
+// opened. A failed write is reported through OnError and drops the writes
+// after it.
+func (peer *Peer) flushPendingWrites() {
+	ctx := peer.Context()
+	for {
+		write, ok := peer.pendingWrites.next()
+		if !ok {
+			return
+		}
+		if _, err := peer.sendOpen(ctx, write.data, write.isString); err != nil {
+			peer.pendingWrites.clear()
+			peer.error(fmt.Errorf("sending a write buffered until the channel opened: %w", err))
+			return
+		}
+	}
+}
+
+// WaitForChannelOpen blocks until the main data channel is open, the
+// context is done or the peer closes.
+func (peer *Peer) WaitForChannelOpen(ctx context.Context) error {
+	for {
+		peer.mutex.RLock()
+		channelsChanged := peer.channelsChanged
+		peerContext := peer.context
+		channel := peer.channel
+		peer.mutex.RUnlock()
+		if peerContext.Err() != nil {
+			return peerClosedErr(peerContext)
+		}
+		if channel != nil && channel.ReadyState() == webrtc.DataChannelStateOpen {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-peerContext.Done():
+			return peerClosedErr(peerContext)
+		case <-channelsChanged:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestWriteBeforeChannelOpen(t *testing.T) {
+	answererWrites := make(chan error, 1)
+	var peer2 *Peer
+	peer1, peer2 := pairTestPeers(PeerOptions{}, PeerOptions{
+		OnSignalingStateChange: func(state webrtc.SignalingState) {
+			if state == webrtc.SignalingStateHaveRemoteOffer {
+				_, err := peer2.Write([]byte("early"))
+				answererWrites <- err
+			}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.Write([]byte("early")); !errors.Is(err, ErrChannelNotOpen) {
+		t.Fatalf("expected the initiator's early write to fail with ErrChannelNotOpen, got %v", err)
+	}
+	select {
+	case err := <-answererWrites:
+		if !errors.Is(err, ErrChannelNotOpen) {
+			t.Fatalf("expected the answerer's early write to fail with ErrChannelNotOpen, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the answerer to receive the offer")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, peer := range []*Peer{peer1, peer2} {
+		if err := peer.WaitForChannelOpen(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := peer.Write([]byte("open")); err != nil {
+			t.Fatalf("expected writes to succeed once the channel is open, got %v", err)
+		}
+	}
+	peer1.Close()
+	if err := peer1.WaitForChannelOpen(ctx); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed after Close, got %v", err)
+	}
+}
+
+func TestBufferWritesUntilOpen(t *testing.T) {
+	const count = 20
+	received1 := make(chan string, 2*count)
+	received2 := make(chan string, 2*count)
+	var peer1, peer2 *Peer
+	peer1, peer2 = pairTestPeers(PeerOptions{
+		BufferWritesUntilOpen: true,
+		OnConnect: func() {
+			peer1.WriteText("connected")
+		},
+		OnData: func(message webrtc.DataChannelMessage) {
+			received1 <- string(message.Data)
+		},
+		SynchronousData: true,
+	}, PeerOptions{
+		BufferWritesUntilOpen: true,
+		OnConnect: func() {
+			peer2.WriteText("connected")
+		},
+		OnData: func(message webrtc.DataChannelMessage) {
+			received2 <- string(message.Data)
+		},
+		SynchronousData: true,
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if _, err := peer2.WriteText("before init 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := peer1.WriteText(fmt.Sprint("initiator ", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			if _, err := peer2.WriteText(fmt.Sprint("before init ", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expect := func(received chan string, prefix string) {
+		for i := 0; i <= count; i++ {
+			expected := fmt.Sprint(prefix, i)
+			if i == count {
+				expected = "connected"
+			}
+			select {
+			case message := <-received:
+				if message != expected {
+					t.Fatalf("expected %q in order, got %q", expected, message)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timed out waiting for %q", expected)
+			}
+		}
+	}
+	expect(received2, "initiator ")
+	expect(received1, "before init ")
+}
+
+func TestBufferWritesUntilOpenLimit(t *testing.T) {
+	peer := NewPeer(PeerOptions{BufferWritesUntilOpen: true, MaxPendingWriteBytes: 10})
+	if _, err := peer.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Write([]byte("a")); !errors.Is(err, ErrSendBufferFull) {
+		t.Fatalf("expected ErrSendBufferFull over the limit, got %v", err)
+	}
+	peer.Close()
+	if _, err := peer.Write([]byte("a")); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package simplepeer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultPipeTimeout = 30 * time.Second
+
+// Pipe creates two peers signaling each other directly, initiates the first
+// and waits until both connected, e.g. to test code built on Peer without a
+// signaling server. OnSignal handlers in the options are called as well,
+// before the message is passed to the other peer. Both peers are closed if
+// they do not connect within 30 seconds.
+func Pipe(optionsA, optionsB PeerOptions) (*Peer, *Peer, error) {
+	peerA := NewPeer(optionsA)
+	peerB := NewPeer(optionsB)
+	peerA.OnSignal(func(message map[string]interface{}) error {
+		return peerB.Signal(message)
+	})
+	peerB.OnSignal(func(message map[string]interface{}) error {
+		return peerA.Signal(message)
+	})
+	connectedA := make(chan struct{})
+	connectedB := make(chan struct{})
+	peerA.OnConnect(closeOnce(connectedA))
+	peerB.OnConnect(closeOnce(connectedB))
+	fail := func(err error) (*Peer, *Peer, error) {
+		peerA.Close()
+		peerB.Close()
+		return nil, nil, err
+	}
+	if err := peerA.Init(); err != nil {
+		return fail(err)
+	}
+	timer := time.NewTimer(defaultPipeTimeout)
+	defer timer.Stop()
+	for connectedA != nil || connectedB != nil {
+		select {
+		case <-connectedA:
+			connectedA = nil
+		case <-connectedB:
+			connectedB = nil
+		case <-peerA.Context().Done():
+			return fail(fmt.Errorf("%w: first peer", ErrPeerClosed))
+		case <-peerB.Context().Done():
+			return fail(fmt.Errorf("%w: second peer", ErrPeerClosed))
+		case <-timer.C:
+			return fail(fmt.Errorf("%w: %w: pipe did not connect within %s", ErrConnectTimeout, ErrOperationTimeout, defaultPipeTimeout))
+		}
+	}
+	return peerA, peerB, nil
+}
+
+// closeOnce returns an OnConnect closing ch the first time it is called,
+// since reconnects call OnConnect again.
+func closeOnce(ch chan struct{}) OnConnect {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package simplepeer
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestPipe(t *testing.T) {
+	var signaled atomic.Int32
+	peerA, peerB, err := Pipe(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			signaled.Add(1)
+			return nil
+		},
+	}, PeerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerA.Close()
+	defer peerB.Close()
+	if signaled.Load() == 0 {
+		t.Fatal("expected the OnSignal of the options to be called")
+	}
+	if peerB.RemoteId() != peerA.Id() {
+		t.Fatalf("expected remote id %q, got %q", peerA.Id(), peerB.RemoteId())
+	}
+
+	received := make(chan []byte, 1)
+	peerB.OnData(func(message webrtc.DataChannelMessage) {
+		received <- message.Data
+	})
+	if _, err := peerA.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; string(data) != "hello" {
+		t.Fatalf("expected hello, got %q", data)
+	}
+}
+
+func TestPipeFails(t *testing.T) {
+	_, _, err := Pipe(PeerOptions{Id: "a", ValidateId: UUIDValidator}, PeerOptions{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
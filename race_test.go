@@ -0,0 +1,7 @@
+//go:build race
+
+package simplepeer
+
+// raceEnabled skips timing sensitive tests, which the race detector slows
+// down far beyond their budgets.
+const raceEnabled = true
@@ -0,0 +1,214 @@
+package simplepeer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const defaultReaderBufferSize = 1 << 20
+
+// ReaderOverflowPolicy controls what a Reader does with received data that
+// does not fit its buffer because it is read too slowly.
+type ReaderOverflowPolicy int
+
+const (
+	// ReaderOverflowBlock holds the channel's messages back until the
+	// consumer made room, which stops the remote peer's sends once the
+	// channel's own buffers are full. Every Reader and every OnData handler
+	// on the channel waits for the slowest Reader.
+	ReaderOverflowBlock ReaderOverflowPolicy = iota
+	// ReaderOverflowDrop drops messages that do not fit the buffer whole.
+	// Once the bytes buffered before the first dropped message are read,
+	// Read returns an error matching ErrReaderOverflow with how many bytes
+	// were dropped, and then goes on with the messages received after.
+	ReaderOverflowDrop
+)
+
+// Reader returns an io.ReadCloser of the bytes of every data message, in
+// the order they arrived, from now on until it is closed. It buffers up to
+// ReaderBufferSize bytes and handles more as ReaderOverflow says. Once the
+// peer closes, Read returns the buffered bytes and then io.EOF.
+func (peer *Peer) Reader() io.ReadCloser {
+	return peer.newReader()
+}
+
+func (peer *Peer) newReader() *peerReader {
+	reader := &peerReader{
+		peer:     peer,
+		size:     peer.readerBufferSize,
+		overflow: peer.readerOverflow,
+		changed:  make(chan struct{}),
+	}
+	peer.readers.Append(reader)
+	return reader
+}
+
+var _ io.ReadWriteCloser = (*Peer)(nil)
+
+// Read reads the bytes of the data messages, like a Reader the peer creates
+// on the first call and keeps for its lifetime, so with Write and Close the
+// peer is an io.ReadWriteCloser. Messages received before the first Read
+// are not buffered, a Read of an empty slice starts buffering without
+// blocking. It is meant for a single consumer: concurrent calls
+// each get a part of the bytes. Readers from Reader receive every message
+// independently of it. Once the peer closes, Read returns the buffered
+// bytes and then io.EOF.
+func (peer *Peer) Read(bytes []byte) (int, error) {
+	peer.readerOnce.Do(func() {
+		peer.reader = peer.newReader()
+	})
+	return peer.reader.Read(bytes)
+}
+
+// peerReader is a ring buffer between the channel's messages and Read.
+type peerReader struct {
+	peer     *Peer
+	size     int
+	overflow ReaderOverflowPolicy
+	mutex    sync.Mutex
+	closed   bool
+	// buffer is allocated on the first message, start is its first unread
+	// byte and length how many bytes are unread
+	buffer []byte
+	start  int
+	length int
+	// dropped counts the bytes dropped since Read last reported an overflow
+	dropped int
+	changed chan struct{}
+}
+
+// write copies data into the buffer. With ReaderOverflowBlock it waits for
+// room, the reader to close or the peer to close.
+func (reader *peerReader) write(data []byte) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.buffer == nil && !reader.closed {
+		reader.buffer = make([]byte, reader.size)
+	}
+	if reader.overflow == ReaderOverflowDrop {
+		// keep dropping until the gap is reported, so the bytes before and
+		// after it stay apart
+		if reader.closed || reader.dropped > 0 || len(data) > reader.size-reader.length {
+			if !reader.closed {
+				reader.dropped += len(data)
+				reader.notify()
+			}
+			return
+		}
+		reader.copyIn(data)
+		return
+	}
+	for len(data) > 0 {
+		if reader.closed {
+			return
+		}
+		if reader.length < reader.size {
+			data = data[reader.copyIn(data):]
+			continue
+		}
+		changed := reader.changed
+		reader.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-reader.peer.Context().Done():
+			reader.mutex.Lock()
+			return
+		}
+		reader.mutex.Lock()
+	}
+}
+
+// copyIn copies as much of data as fits and returns how much that was.
+func (reader *peerReader) copyIn(data []byte) int {
+	written := 0
+	for written < len(data) && reader.length < reader.size {
+		end := (reader.start + reader.length) % reader.size
+		limit := reader.size
+		if reader.start > end {
+			limit = reader.start
+		}
+		n := copy(reader.buffer[end:limit], data[written:])
+		written += n
+		reader.length += n
+	}
+	if written > 0 {
+		reader.notify()
+	}
+	return written
+}
+
+// notify wakes everyone waiting for the buffer to change. The caller holds
+// mutex.
+func (reader *peerReader) notify() {
+	close(reader.changed)
+	reader.changed = make(chan struct{})
+}
+
+// Read blocks until bytes are buffered, an overflow is to be reported, the
+// reader is closed or the peer closes.
+func (reader *peerReader) Read(bytes []byte) (int, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	for {
+		if reader.closed {
+			return 0, io.EOF
+		}
+		if len(bytes) == 0 {
+			return 0, nil
+		}
+		if reader.length > 0 {
+			n := 0
+			for n < len(bytes) && reader.length > 0 {
+				end := reader.start + reader.length
+				if end > reader.size {
+					end = reader.size
+				}
+				copied := copy(bytes[n:], reader.buffer[reader.start:end])
+				n += copied
+				reader.start = (reader.start + copied) % reader.size
+				reader.length -= copied
+			}
+			reader.notify()
+			return n, nil
+		}
+		if reader.dropped > 0 {
+			dropped := reader.dropped
+			reader.dropped = 0
+			return 0, fmt.Errorf("%w: %d bytes dropped", ErrReaderOverflow, dropped)
+		}
+		changed := reader.changed
+		peerContext := reader.peer.Context()
+		reader.mutex.Unlock()
+		select {
+		case <-changed:
+			reader.mutex.Lock()
+		case <-peerContext.Done():
+			reader.mutex.Lock()
+			if reader.length == 0 && reader.dropped == 0 {
+				return 0, io.EOF
+			}
+		}
+	}
+}
+
+// Close detaches the reader and wakes a blocked Read and write. Later reads
+// return io.EOF.
+func (reader *peerReader) Close() error {
+	reader.mutex.Lock()
+	if reader.closed {
+		reader.mutex.Unlock()
+		return io.EOF
+	}
+	reader.closed = true
+	reader.buffer = nil
+	reader.length = 0
+	reader.notify()
+	reader.mutex.Unlock()
+	// the channel's message handler iterates the readers while a blocked
+	// write waits, so it is woken before the reader is deleted
+	reader.peer.readers.Delete(func(index int, peerReader *peerReader) bool {
+		return peerReader == reader
+	})
+	return nil
+}
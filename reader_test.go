@@ -0,0 +1,176 @@
+package simplepeer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+)
+
+func TestReaderSlowConsumer(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{ReaderBufferSize: 4096})
+	defer peer1.Close()
+	defer peer2.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+	buffered := reader.(*peerReader)
+
+	goroutines := runtime.NumGoroutine()
+	var expected bytes.Buffer
+	for i := 0; i < 200; i++ {
+		expected.Write(bytes.Repeat([]byte{byte(i)}, 1000))
+	}
+	go func() {
+		for i := 0; i < 200; i++ {
+			peer1.Write(expected.Bytes()[i*1000 : (i+1)*1000])
+		}
+	}()
+	actual := make([]byte, 0, expected.Len())
+	chunk := make([]byte, 512)
+	maxGoroutines := 0
+	for len(actual) < expected.Len() {
+		time.Sleep(time.Millisecond)
+		buffered.mutex.Lock()
+		length := buffered.length
+		buffered.mutex.Unlock()
+		if length > 4096 {
+			t.Fatalf("expected at most 4096 buffered bytes, got %d", length)
+		}
+		if count := runtime.NumGoroutine(); count > maxGoroutines {
+			maxGoroutines = count
+		}
+		n, err := reader.Read(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, chunk[:n]...)
+	}
+	if !bytes.Equal(actual, expected.Bytes()) {
+		t.Fatal("expected the bytes in order")
+	}
+	if maxGoroutines > goroutines+10 {
+		t.Fatalf("expected no goroutine pileup, went from %d to %d", goroutines, maxGoroutines)
+	}
+}
+
+func TestReaderOverflowDrop(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		ReaderBufferSize: 1000,
+		ReaderOverflow:   ReaderOverflowDrop,
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+	buffered := reader.(*peerReader)
+
+	for i := 0; i < 5; i++ {
+		peer1.Write(bytes.Repeat([]byte{byte(i)}, 400))
+	}
+	testutil.WaitFor(t, func() bool {
+		buffered.mutex.Lock()
+		defer buffered.mutex.Unlock()
+		return buffered.dropped == 1200
+	})
+	actual := make([]byte, 800)
+	if _, err := io.ReadFull(reader, actual); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, append(bytes.Repeat([]byte{0}, 400), bytes.Repeat([]byte{1}, 400)...)) {
+		t.Fatal("expected the messages before the overflow")
+	}
+	if _, err := reader.Read(actual); !errors.Is(err, ErrReaderOverflow) {
+		t.Fatalf("expected an overflow error, got %v", err)
+	}
+
+	peer1.Write([]byte("after"))
+	n, err := reader.Read(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual[:n]) != "after" {
+		t.Fatalf("expected the message after the overflow, got %q", actual[:n])
+	}
+}
+
+func TestReaderEOFOnPeerClose(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+
+	peer1.Write([]byte("last"))
+	testutil.WaitFor(t, func() bool {
+		buffered := reader.(*peerReader)
+		buffered.mutex.Lock()
+		defer buffered.mutex.Unlock()
+		return buffered.length == 4
+	})
+	read := make(chan error, 1)
+	go func() {
+		data, err := io.ReadAll(reader)
+		if err == nil && string(data) != "last" {
+			err = errors.New("expected the buffered bytes before io.EOF, got " + string(data))
+		}
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	peer2.Close()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Read to return once the peer closed")
+	}
+}
+
+func TestPeerReadWriteCloser(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+	if _, err := peer2.Read(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type message struct {
+		Text  string
+		Count int
+	}
+	if err := gob.NewEncoder(peer1).Encode(message{Text: "hello", Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	var decoded message
+	if err := gob.NewDecoder(peer2).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Text != "hello" || decoded.Count != 3 {
+		t.Fatalf("expected the encoded message, got %+v", decoded)
+	}
+	if err := gob.NewDecoder(reader).Decode(&decoded); err != nil || decoded.Text != "hello" {
+		t.Fatalf("expected the separate reader to get the message too, got %+v, %v", decoded, err)
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := peer2.Read(make([]byte, 16))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	peer2.Close()
+	select {
+	case err := <-read:
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF once the peer closed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Read to return once the peer closed")
+	}
+}
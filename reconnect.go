@@ -0,0 +1,220 @@
+package simplepeer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultReconnectMaxRetries = 5
+	defaultReconnectBackoff    = time.Second
+)
+
+var errRemoteReconnected = fmt.Errorf("remote peer reconnected")
+
+// OnReconnecting is called when AutoReconnect replaces a lost connection.
+// attempt counts from 1 and cause is why the connection was lost.
+type OnReconnecting func(attempt int, cause error)
+
+// OnReconnected is called once a replaced connection is established.
+type OnReconnected func()
+
+// reconnector tracks the attempts of AutoReconnect to replace a lost
+// connection.
+type reconnector struct {
+	mutex      sync.Mutex
+	enabled    bool
+	maxRetries int
+	backoff    time.Duration
+	attempts   int
+	stopped    bool
+	timer      *time.Timer
+	// retired is the connection being replaced, whose state changes no
+	// longer matter.
+	retired *webrtc.PeerConnection
+	// tracks are added to the next connection: those of PeerOptions.Tracks
+	// to the first, those of a replaced connection to its replacement.
+	tracks []webrtc.TrackLocal
+}
+
+// next starts another attempt and returns its number and the delay before
+// it, or false if the connection should not be replaced.
+func (reconnects *reconnector) next(connection *webrtc.PeerConnection) (int, time.Duration, bool) {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	if !reconnects.enabled || reconnects.stopped || connection == nil || reconnects.attempts >= reconnects.maxRetries {
+		return 0, 0, false
+	}
+	reconnects.attempts++
+	reconnects.retired = connection
+	reconnects.tracks = nil
+	for _, sender := range connection.GetSenders() {
+		if track := sender.Track(); track != nil {
+			reconnects.tracks = append(reconnects.tracks, track)
+		}
+	}
+	return reconnects.attempts, reconnects.backoff << (reconnects.attempts - 1), true
+}
+
+func (reconnects *reconnector) schedule(delay time.Duration, fn func()) {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	if reconnects.stopped {
+		return
+	}
+	reconnects.timer = time.AfterFunc(delay, fn)
+}
+
+func (reconnects *reconnector) isRetired(connection *webrtc.PeerConnection) bool {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	return reconnects.retired == connection
+}
+
+// isReconnecting reports whether a lost connection is being replaced.
+func (reconnects *reconnector) isReconnecting() bool {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	return reconnects.attempts > 0 && !reconnects.stopped
+}
+
+func (reconnects *reconnector) isStopped() bool {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	return reconnects.stopped
+}
+
+func (reconnects *reconnector) takeTracks() []webrtc.TrackLocal {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	tracks := reconnects.tracks
+	reconnects.tracks = nil
+	return tracks
+}
+
+// connected ends a run of attempts and returns true if there was one.
+func (reconnects *reconnector) connected() bool {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	reconnecting := reconnects.attempts > 0
+	reconnects.attempts = 0
+	return reconnecting
+}
+
+func (reconnects *reconnector) resume() {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	reconnects.stopped = false
+}
+
+func (reconnects *reconnector) stop() {
+	reconnects.mutex.Lock()
+	defer reconnects.mutex.Unlock()
+	reconnects.stopped = true
+	reconnects.attempts = 0
+	reconnects.tracks = nil
+	if reconnects.timer != nil {
+		reconnects.timer.Stop()
+		reconnects.timer = nil
+	}
+}
+
+func (peer *Peer) OnReconnecting(fn OnReconnecting) (off func()) {
+	return peer.onReconnecting.Append(fn)
+}
+
+// Deprecated: call the func returned by OnReconnecting instead.
+func (peer *Peer) OffReconnecting(fn OnReconnecting) {
+	peer.onReconnecting.Delete(func(index int, onReconnecting OnReconnecting) bool {
+		return sameFunc(onReconnecting, fn)
+	})
+}
+
+func (peer *Peer) OnReconnected(fn OnReconnected) (off func()) {
+	return peer.onReconnected.Append(fn)
+}
+
+// Deprecated: call the func returned by OnReconnected instead.
+func (peer *Peer) OffReconnected(fn OnReconnected) {
+	peer.onReconnected.Delete(func(index int, onReconnected OnReconnected) bool {
+		return sameFunc(onReconnected, fn)
+	})
+}
+
+// onConnectionLost replaces the connection with AutoReconnect and destroys
+// the peer with cause otherwise. The initiator creates the replacement and
+// negotiates it, the other peer waits for the initiator's offer.
+func (peer *Peer) onConnectionLost(cause error) {
+	attempt, delay, ok := peer.reconnects.next(peer.Connection())
+	if !ok {
+		peer.connectionEnded(cause)
+		return
+	}
+	peer.debugf("connection lost, reconnecting attempt=%d", attempt)
+	for _, fn := range peer.onReconnecting.Slice() {
+		go fn(attempt, cause)
+	}
+	if err := peer.close(false, nil); err != nil {
+		peer.debugf("failed to close lost connection: %s", err)
+	}
+	if peer.initiator.Load() {
+		peer.reconnects.schedule(delay, peer.reconnect)
+	}
+}
+
+func (peer *Peer) reconnect() {
+	if peer.reconnects.isStopped() {
+		return
+	}
+	if err := peer.createPeer(); err != nil {
+		peer.error(err)
+		peer.onConnectionLost(err)
+	}
+}
+
+// onRemoteReconnected replaces the connection when an offer comes from a
+// new remote connection, which the initiator creates when it reconnects
+// before this peer noticed the old one was lost.
+func (peer *Peer) onRemoteReconnected(message SignalMessage) {
+	connection := peer.Connection()
+	if !peer.reconnects.enabled || connection == nil || message.Type != SignalMessageOffer {
+		return
+	}
+	current := connection.RemoteDescription()
+	if current == nil || sdpFingerprint(current.SDP) == sdpFingerprint(message.SDP) {
+		return
+	}
+	peer.onConnectionLost(errRemoteReconnected)
+}
+
+func sdpFingerprint(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "a=fingerprint:") {
+			return line
+		}
+	}
+	return ""
+}
+
+// reattachTracks adds the tracks of a replaced connection to its successor.
+func (peer *Peer) reattachTracks(connection *webrtc.PeerConnection) {
+	for _, track := range peer.reconnects.takeTracks() {
+		if _, err := connection.AddTrack(track); err != nil {
+			peer.error(err)
+		}
+	}
+}
+
+func (peer *Peer) onReconnectedConnection() {
+	if !peer.reconnects.connected() {
+		return
+	}
+	peer.debugf("reconnected")
+	for _, fn := range peer.onReconnected.Slice() {
+		go fn()
+	}
+}
@@ -0,0 +1,121 @@
+package simplepeer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAutoReconnect(t *testing.T) {
+	reconnecting := make(chan error, 4)
+	reconnected := make(chan bool, 4)
+	closed := make(chan bool, 4)
+	data := make(chan string, 4)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		AutoReconnect: true,
+		Backoff:       10 * time.Millisecond,
+		OnReconnecting: func(attempt int, cause error) {
+			reconnecting <- cause
+		},
+		OnReconnected: func() {
+			reconnected <- true
+		},
+		OnClose: func() {
+			closed <- true
+		},
+	}, PeerOptions{
+		AutoReconnect: true,
+		OnData: func(message webrtc.DataChannelMessage) {
+			data <- string(message.Data)
+		},
+		OnClose: func() {
+			closed <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		connection := peer1.Connection()
+		return connection != nil && connection.SignalingState() == webrtc.SignalingStateStable && len(connection.GetTransceivers()) == 1
+	})
+	old := peer1.Connection()
+
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	if cause := <-reconnecting; !errors.Is(cause, ErrConnectionFailed) {
+		t.Fatalf("expected the failure as cause, got %v", cause)
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the reconnect")
+	}
+	if peer1.Connection() == old {
+		t.Fatal("expected a new connection")
+	}
+	senders := peer1.Connection().GetSenders()
+	if len(senders) != 1 || senders[0].Track() != track {
+		t.Fatalf("expected the track to be re-added, got %d senders", len(senders))
+	}
+
+	testutil.WaitFor(t, func() bool {
+		_, err := peer1.Write([]byte("again"))
+		return err == nil
+	})
+	select {
+	case message := <-data:
+		if message != "again" {
+			t.Fatalf("expected again, got %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected callbacks registered before the reconnect to keep working")
+	}
+	select {
+	case <-closed:
+		t.Fatal("expected neither peer to close")
+	default:
+	}
+}
+
+func TestAutoReconnectMaxRetries(t *testing.T) {
+	closed := make(chan bool, 1)
+	// Signals go nowhere, so no replacement ever connects.
+	peer := NewPeer(PeerOptions{
+		AutoReconnect: true,
+		MaxRetries:    1,
+		Backoff:       time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			return nil
+		},
+		OnClose: func() {
+			closed <- true
+		},
+	})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	old := peer.Connection()
+
+	peer.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	testutil.WaitFor(t, func() bool {
+		connection := peer.Connection()
+		return connection != nil && connection != old
+	})
+	peer.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the peer to close once the retries are used up")
+	}
+}
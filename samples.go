@@ -0,0 +1,96 @@
+package simplepeer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+const defaultSampleBufferDepth = 64
+
+type OnSample func(track *webrtc.TrackRemote, sample media.Sample)
+
+// OnSample adds a callback for the media samples of remote tracks. Tracks
+// arriving while any OnSample callback is registered are read by the peer,
+// one goroutine per track, so OnTrack callbacks must not read them too.
+// Callbacks are called in order from that goroutine, which ends when the
+// track ends or the peer closes.
+func (peer *Peer) OnSample(fn OnSample) (off func()) {
+	return peer.onSample.Append(fn)
+}
+
+// Deprecated: call the func returned by OnSample instead.
+func (peer *Peer) OffSample(fn OnSample) {
+	peer.onSample.Delete(func(index int, onSample OnSample) bool {
+		return sameFunc(onSample, fn)
+	})
+}
+
+func (peer *Peer) readSamples(track *webrtc.TrackRemote) {
+	defer peer.sampleReaders.Done()
+	codec := track.Codec()
+	depacketizer := newDepacketizer(codec.MimeType)
+	if depacketizer == nil {
+		peer.error(fmt.Errorf("%w: %s", ErrNoDepacketizer, codec.MimeType))
+		return
+	}
+	builder := samplebuilder.New(uint16(peer.sampleBufferDepth), depacketizer, codec.ClockRate)
+	for {
+		// ends with an error once the receiver stops, which closing the
+		// connection does
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			peer.debugf("sample reader for track %s stopped: %s", track.ID(), err)
+			peer.endTracks(func(remoteTrack remoteTrack) bool {
+				return remoteTrack.track == track
+			})
+			return
+		}
+		builder.Push(packet)
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			for _, fn := range peer.onSample.Slice() {
+				fn(track, *sample)
+			}
+		}
+	}
+}
+
+func newDepacketizer(mimeType string) rtp.Depacketizer {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return &codecs.VP8Packet{}
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return &codecs.VP9Packet{}
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return &codecs.H264Packet{}
+	case strings.ToLower(webrtc.MimeTypeH265):
+		return &codecs.H265Packet{}
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return &codecs.OpusPacket{}
+	case strings.ToLower(webrtc.MimeTypePCMU), strings.ToLower(webrtc.MimeTypePCMA), strings.ToLower(webrtc.MimeTypeG722):
+		return wholePacketDepacketizer{}
+	default:
+		return nil
+	}
+}
+
+// wholePacketDepacketizer is for audio codecs that carry a sample per
+// packet as is, which pion has no depacketizer for.
+type wholePacketDepacketizer struct{}
+
+func (wholePacketDepacketizer) Unmarshal(packet []byte) ([]byte, error) {
+	return packet, nil
+}
+
+func (wholePacketDepacketizer) IsPartitionHead(payload []byte) bool {
+	return true
+}
+
+func (wholePacketDepacketizer) IsPartitionTail(marker bool, payload []byte) bool {
+	return true
+}
@@ -0,0 +1,56 @@
+package simplepeer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/testsrc"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestOnSample(t *testing.T) {
+	samples := make(chan media.Sample, 64)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnSample: func(track *webrtc.TrackRemote, sample media.Sample) {
+			select {
+			case samples <- sample:
+			default:
+			}
+		},
+		SampleBufferDepth: 16,
+	})
+	defer peer1.Close()
+
+	track, err := testsrc.NewVP8(testsrc.Options{ID: "video", StreamID: "samples", FrameRate: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	track.Start()
+	defer track.Stop()
+	select {
+	case sample := <-samples:
+		if _, ok := testsrc.FrameNumber(sample.Data); !ok {
+			t.Fatalf("expected a testsrc frame, got %x", sample.Data)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a sample")
+	}
+
+	if err := peer2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		peer2.sampleReaders.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the sample readers to stop on Close")
+	}
+}
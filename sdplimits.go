@@ -0,0 +1,57 @@
+package simplepeer
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultMaxRemoteMediaSections = 128
+	defaultMaxSdpBytes            = 1024 * 1024
+)
+
+// SdpLimitError reports a session description over MaxRemoteMediaSections
+// or MaxSDPBytes. It matches ErrSdpLimit.
+type SdpLimitError struct {
+	// Remote is true for a received description and false for one the peer
+	// created.
+	Remote bool
+	// Limit is "media sections" or "bytes".
+	Limit string
+	Size  int
+	Max   int
+}
+
+func (err *SdpLimitError) Error() string {
+	origin := "local"
+	if err.Remote {
+		origin = "remote"
+	}
+	return fmt.Sprintf("%s: %s sdp has %d %s, more than %d", ErrSdpLimit, origin, err.Size, err.Limit, err.Max)
+}
+
+func (err *SdpLimitError) Unwrap() error {
+	return ErrSdpLimit
+}
+
+func countMediaSections(sdp string) int {
+	count := strings.Count(sdp, "\nm=")
+	if strings.HasPrefix(sdp, "m=") {
+		count++
+	}
+	return count
+}
+
+// checkSdp enforces the byte limit on every description and the media
+// section limit on remote ones, before pion parses them.
+func (peer *Peer) checkSdp(sdp string, remote bool) error {
+	if peer.maxSdpBytes > 0 && len(sdp) > peer.maxSdpBytes {
+		return &SdpLimitError{Remote: remote, Limit: "bytes", Size: len(sdp), Max: peer.maxSdpBytes}
+	}
+	if remote && peer.maxRemoteMediaSections > 0 {
+		if count := countMediaSections(sdp); count > peer.maxRemoteMediaSections {
+			return &SdpLimitError{Remote: remote, Limit: "media sections", Size: count, Max: peer.maxRemoteMediaSections}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRejectRemoteMediaSections(t *testing.T) {
+	var operationsMutex sync.Mutex
+	var operations []string
+	peer := NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			return nil
+		},
+		OnError: func(err error) {},
+	})
+	peer.beforeOperation = func(operation string) {
+		operationsMutex.Lock()
+		defer operationsMutex.Unlock()
+		operations = append(operations, operation)
+	}
+
+	var offer strings.Builder
+	offer.WriteString("v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&offer, "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:%d\r\na=sendrecv\r\na=rtpmap:111 opus/48000/2\r\n", i)
+	}
+	err := peer.Signal(map[string]interface{}{"type": "offer", "sdp": offer.String()})
+	var limitErr *SdpLimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrSdpLimit) {
+		t.Fatalf("expected an SdpLimitError, got %v", err)
+	}
+	if !limitErr.Remote || limitErr.Limit != "media sections" || limitErr.Size != 300 {
+		t.Fatalf("expected 300 remote media sections, got %+v", limitErr)
+	}
+	select {
+	case <-peer.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the peer to close")
+	}
+	if cause := context.Cause(peer.Context()); !errors.Is(cause, ErrSdpLimit) {
+		t.Fatalf("expected the peer to close with ErrSdpLimit, got %v", cause)
+	}
+	operationsMutex.Lock()
+	defer operationsMutex.Unlock()
+	if len(operations) != 0 {
+		t.Fatalf("expected the offer to be rejected before any pion operation, got %v", operations)
+	}
+}
+
+func TestLocalSdpBytesLimit(t *testing.T) {
+	errs := make(chan error, 4)
+	signals := make(chan map[string]interface{}, 4)
+	peer := NewPeer(PeerOptions{
+		MaxSDPBytes: 100,
+		OnSignal: func(message map[string]interface{}) error {
+			signals <- message
+			return nil
+		},
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		var limitErr *SdpLimitError
+		if !errors.As(err, &limitErr) || limitErr.Remote || limitErr.Limit != "bytes" {
+			t.Fatalf("expected a local bytes SdpLimitError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sdp limit error")
+	}
+	select {
+	case message := <-signals:
+		t.Fatalf("expected the oversized offer not to be signaled, got %v", message["type"])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,489 @@
+package simplepeer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// SignalMessage is the typed form of a signal message. Only the fields
+// relevant to Type are set; bye messages, which Close sends with
+// PeerOptions.SendBye, have none.
+type SignalMessage struct {
+	Type string
+	// From is the id of the sending peer, which peers of this package send
+	// with every message. It is empty for peers that do not send it.
+	From string
+	// SDP is set for offer, answer, pranswer and rollback messages.
+	SDP string
+	// Candidate is set for candidate messages. An empty Candidate.Candidate
+	// marks the end of candidates.
+	Candidate *webrtc.ICECandidateInit
+	// Candidates is set for batched candidates messages.
+	Candidates []webrtc.ICECandidateInit
+	// TransceiverRequest is set for transceiverRequest messages.
+	TransceiverRequest *SignalMessageTransceiver
+	// Renegotiate is set for renegotiate messages.
+	Renegotiate bool
+	// TrackMetadata is set for trackMetadata messages.
+	TrackMetadata *SignalMessageMetadata
+}
+
+// SessionDescription returns the session description carried by offer,
+// answer, pranswer and rollback messages.
+func (message *SignalMessage) SessionDescription() webrtc.SessionDescription {
+	return webrtc.SessionDescription{
+		Type: webrtc.NewSDPType(message.Type),
+		SDP:  message.SDP,
+	}
+}
+
+// ParseSignal converts a signal message as received from the remote peer
+// into its typed form. It accepts every message simple-peer 9.x emits. The
+// value of "renegotiate" is ignored since the type already says it all, as
+// are the "streams" of a transceiver init, which have no pion equivalent.
+// Errors name the message type and the offending key, and match
+// ErrInvalidSignalMessageType or ErrInvalidSignalMessage with errors.Is.
+func ParseSignal(message map[string]interface{}) (SignalMessage, error) {
+	messageType, ok := message["type"].(string)
+	if !ok {
+		return SignalMessage{}, fmt.Errorf("%w: type expected string, got %s", ErrInvalidSignalMessageType, jsonTypeName(message["type"]))
+	}
+	signalMessage := SignalMessage{Type: messageType}
+	switch from := message["from"].(type) {
+	case nil:
+	case string:
+		signalMessage.From = from
+	default:
+		return signalMessage, invalidSignalField(messageType, "from", "string", from)
+	}
+	switch messageType {
+	case SignalMessageRenegotiate:
+		signalMessage.Renegotiate = true
+	case SignalMessageBye:
+	case SignalMessageTransceiverRequest:
+		transceiverRequestRaw, ok := message["transceiverRequest"].(map[string]interface{})
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "transceiverRequest", "object", message["transceiverRequest"])
+		}
+		transceiverRequest := SignalMessageTransceiver{}
+		kindRaw, ok := transceiverRequestRaw["kind"].(string)
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "transceiverRequest.kind", "string", transceiverRequestRaw["kind"])
+		}
+		transceiverRequest.Kind = webrtc.NewRTPCodecType(kindRaw)
+		if transceiverRequest.Kind == 0 {
+			return signalMessage, fmt.Errorf("%w: %s: transceiverRequest.kind expected audio or video, got %q", ErrInvalidSignalMessage, messageType, kindRaw)
+		}
+		// simple-peer sends init as a single RTCRtpTransceiverInit object, or
+		// leaves it out entirely.
+		switch initRaw := transceiverRequestRaw["init"].(type) {
+		case nil:
+		case map[string]interface{}:
+			transceiverInit, sendEncodings, err := parseTransceiverInit(messageType, "transceiverRequest.init", initRaw)
+			if err != nil {
+				return signalMessage, err
+			}
+			transceiverRequest.Init = append(transceiverRequest.Init, transceiverInit)
+			transceiverRequest.SendEncodings = sendEncodings
+		case []interface{}:
+			// init decoded by encoding/json holds its objects as interface{}
+			for i, initRaw := range initRaw {
+				initObject, ok := initRaw.(map[string]interface{})
+				if !ok {
+					return signalMessage, invalidSignalField(messageType, fmt.Sprintf("transceiverRequest.init[%d]", i), "object", initRaw)
+				}
+				transceiverInit, sendEncodings, err := parseTransceiverInit(messageType, fmt.Sprintf("transceiverRequest.init[%d]", i), initObject)
+				if err != nil {
+					return signalMessage, err
+				}
+				transceiverRequest.Init = append(transceiverRequest.Init, transceiverInit)
+				if i == 0 {
+					transceiverRequest.SendEncodings = sendEncodings
+				}
+			}
+		case []map[string]interface{}:
+			for i, initRaw := range initRaw {
+				transceiverInit, sendEncodings, err := parseTransceiverInit(messageType, fmt.Sprintf("transceiverRequest.init[%d]", i), initRaw)
+				if err != nil {
+					return signalMessage, err
+				}
+				transceiverRequest.Init = append(transceiverRequest.Init, transceiverInit)
+				if i == 0 {
+					transceiverRequest.SendEncodings = sendEncodings
+				}
+			}
+		default:
+			return signalMessage, invalidSignalField(messageType, "transceiverRequest.init", "object or array", initRaw)
+		}
+		signalMessage.TransceiverRequest = &transceiverRequest
+	case SignalMessageCandidate:
+		if message["candidate"] == nil {
+			signalMessage.Candidate = &webrtc.ICECandidateInit{}
+			break
+		}
+		candidateJSON, ok := message["candidate"].(map[string]interface{})
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "candidate", "object", message["candidate"])
+		}
+		candidate, err := parseCandidate(messageType, "candidate", candidateJSON)
+		if err != nil {
+			return signalMessage, err
+		}
+		signalMessage.Candidate = &candidate
+	case SignalMessageCandidates:
+		candidatesJSON, ok := message["candidates"].([]interface{})
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "candidates", "array", message["candidates"])
+		}
+		signalMessage.Candidates = make([]webrtc.ICECandidateInit, 0, len(candidatesJSON))
+		for i, candidateRaw := range candidatesJSON {
+			key := fmt.Sprintf("candidates[%d]", i)
+			candidateJSON, ok := candidateRaw.(map[string]interface{})
+			if !ok {
+				return signalMessage, invalidSignalField(messageType, key, "object", candidateRaw)
+			}
+			candidate, err := parseCandidate(messageType, key, candidateJSON)
+			if err != nil {
+				return signalMessage, err
+			}
+			signalMessage.Candidates = append(signalMessage.Candidates, candidate)
+		}
+	case SignalMessageTrackMetadata:
+		trackMetadataRaw, ok := message["trackMetadata"].(map[string]interface{})
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "trackMetadata", "object", message["trackMetadata"])
+		}
+		trackID, ok := trackMetadataRaw["trackId"].(string)
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "trackMetadata.trackId", "string", trackMetadataRaw["trackId"])
+		}
+		trackMetadata := SignalMessageMetadata{TrackID: trackID}
+		switch metadataRaw := trackMetadataRaw["metadata"].(type) {
+		case nil:
+		case map[string]interface{}:
+			trackMetadata.Metadata = make(map[string]string, len(metadataRaw))
+			for key, valueRaw := range metadataRaw {
+				value, ok := valueRaw.(string)
+				if !ok {
+					return signalMessage, invalidSignalField(messageType, "trackMetadata.metadata."+key, "string", valueRaw)
+				}
+				trackMetadata.Metadata[key] = value
+			}
+		default:
+			return signalMessage, invalidSignalField(messageType, "trackMetadata.metadata", "object", metadataRaw)
+		}
+		signalMessage.TrackMetadata = &trackMetadata
+	case SignalMessageAnswer, SignalMessageOffer, SignalMessagePRAnswer, SignalMessageRollback:
+		sdpRaw, ok := message["sdp"].(string)
+		if !ok {
+			return signalMessage, invalidSignalField(messageType, "sdp", "string", message["sdp"])
+		}
+		signalMessage.SDP = sdpRaw
+	default:
+		return signalMessage, fmt.Errorf("%w: unknown type %q", ErrInvalidSignalMessageType, messageType)
+	}
+	return signalMessage, nil
+}
+
+// BuildSignal converts a typed signal message into the form passed to
+// OnSignal. The result is accepted by ParseSignal both as is and after a
+// JSON round trip.
+func BuildSignal(signalMessage SignalMessage) map[string]interface{} {
+	message := map[string]interface{}{
+		"type": signalMessage.Type,
+	}
+	if signalMessage.From != "" {
+		message["from"] = signalMessage.From
+	}
+	switch signalMessage.Type {
+	case SignalMessageRenegotiate:
+		message["renegotiate"] = true
+	case SignalMessageBye:
+	case SignalMessageTransceiverRequest:
+		transceiverRequest := map[string]interface{}{}
+		if signalMessage.TransceiverRequest != nil {
+			// pion accepts at most one init, and simple-peer passes init
+			// straight to addTransceiver, so it is sent as a single object.
+			if len(signalMessage.TransceiverRequest.Init) > 0 {
+				transceiverInit := signalMessage.TransceiverRequest.Init[0]
+				initJSON := map[string]interface{}{
+					"direction": transceiverInit.Direction.String(),
+				}
+				if sendEncodingsJSON := buildSendEncodings(transceiverInit.SendEncodings, signalMessage.TransceiverRequest.SendEncodings); len(sendEncodingsJSON) > 0 {
+					initJSON["sendEncodings"] = sendEncodingsJSON
+				}
+				transceiverRequest["init"] = initJSON
+			}
+			transceiverRequest["kind"] = signalMessage.TransceiverRequest.Kind.String()
+		}
+		message["transceiverRequest"] = transceiverRequest
+	case SignalMessageCandidate:
+		candidate := webrtc.ICECandidateInit{}
+		if signalMessage.Candidate != nil {
+			candidate = *signalMessage.Candidate
+		}
+		message["candidate"] = buildCandidate(candidate)
+	case SignalMessageCandidates:
+		candidatesJSON := make([]interface{}, 0, len(signalMessage.Candidates))
+		for _, candidate := range signalMessage.Candidates {
+			candidatesJSON = append(candidatesJSON, buildCandidate(candidate))
+		}
+		message["candidates"] = candidatesJSON
+	case SignalMessageTrackMetadata:
+		trackMetadata := map[string]interface{}{}
+		if signalMessage.TrackMetadata != nil {
+			trackMetadata["trackId"] = signalMessage.TrackMetadata.TrackID
+			if signalMessage.TrackMetadata.Metadata != nil {
+				metadata := make(map[string]interface{}, len(signalMessage.TrackMetadata.Metadata))
+				for key, value := range signalMessage.TrackMetadata.Metadata {
+					metadata[key] = value
+				}
+				trackMetadata["metadata"] = metadata
+			}
+		}
+		message["trackMetadata"] = trackMetadata
+	default:
+		message["sdp"] = signalMessage.SDP
+	}
+	return message
+}
+
+// buildSendEncodings merges the pion send encodings of an init with the
+// full ones, whose parameters take precedence.
+func buildSendEncodings(sendEncodings []webrtc.RTPEncodingParameters, fullSendEncodings []SendEncoding) []map[string]interface{} {
+	count := len(sendEncodings)
+	if len(fullSendEncodings) > count {
+		count = len(fullSendEncodings)
+	}
+	sendEncodingsJSON := make([]map[string]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		// numbers are float64 as if decoded by encoding/json
+		sendEncodingJSON := map[string]interface{}{}
+		if i < len(sendEncodings) {
+			sendEncoding := sendEncodings[i]
+			sendEncodingJSON["rid"] = sendEncoding.RID
+			sendEncodingJSON["ssrc"] = float64(sendEncoding.SSRC)
+			sendEncodingJSON["payloadType"] = float64(sendEncoding.PayloadType)
+			sendEncodingJSON["rtx"] = map[string]interface{}{"ssrc": float64(sendEncoding.RTX.SSRC)}
+		}
+		if i < len(fullSendEncodings) {
+			fullSendEncoding := fullSendEncodings[i]
+			if fullSendEncoding.RID != "" {
+				sendEncodingJSON["rid"] = fullSendEncoding.RID
+			}
+			if fullSendEncoding.ScaleResolutionDownBy != 0 {
+				sendEncodingJSON["scaleResolutionDownBy"] = fullSendEncoding.ScaleResolutionDownBy
+			}
+			if fullSendEncoding.MaxBitrate != 0 {
+				sendEncodingJSON["maxBitrate"] = float64(fullSendEncoding.MaxBitrate)
+			}
+		}
+		sendEncodingsJSON = append(sendEncodingsJSON, sendEncodingJSON)
+	}
+	return sendEncodingsJSON
+}
+
+// parseTransceiverInit reads an RTCRtpTransceiverInit. Both fields are
+// optional, as in the browser API, with direction defaulting to sendrecv.
+// The send encodings are also returned in full, see SendEncoding.
+func parseTransceiverInit(messageType, key string, initRaw map[string]interface{}) (webrtc.RTPTransceiverInit, []SendEncoding, error) {
+	transceiverInit := webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendrecv,
+	}
+	if directionRaw, ok := initRaw["direction"].(string); ok {
+		transceiverInit.Direction = webrtc.NewRTPTransceiverDirection(directionRaw)
+		if transceiverInit.Direction == webrtc.RTPTransceiverDirectionUnknown {
+			return transceiverInit, nil, fmt.Errorf("%w: %s: %s.direction unknown direction %q", ErrInvalidSignalMessage, messageType, key, directionRaw)
+		}
+	} else if initRaw["direction"] != nil {
+		return transceiverInit, nil, invalidSignalField(messageType, key+".direction", "string", initRaw["direction"])
+	}
+	if initRaw["sendEncodings"] == nil {
+		return transceiverInit, nil, nil
+	}
+	var sendEncodingsRaw []map[string]interface{}
+	switch sendEncodings := initRaw["sendEncodings"].(type) {
+	case []map[string]interface{}:
+		sendEncodingsRaw = sendEncodings
+	case []interface{}:
+		for i, sendEncodingRaw := range sendEncodings {
+			sendEncoding, ok := sendEncodingRaw.(map[string]interface{})
+			if !ok {
+				return transceiverInit, nil, invalidSignalField(messageType, fmt.Sprintf("%s.sendEncodings[%d]", key, i), "object", sendEncodingRaw)
+			}
+			sendEncodingsRaw = append(sendEncodingsRaw, sendEncoding)
+		}
+	default:
+		return transceiverInit, nil, invalidSignalField(messageType, key+".sendEncodings", "array", initRaw["sendEncodings"])
+	}
+	sendEncodings := make([]SendEncoding, 0, len(sendEncodingsRaw))
+	for i, sendEncodingRaw := range sendEncodingsRaw {
+		sendEncoding, fullSendEncoding, err := parseSendEncoding(messageType, fmt.Sprintf("%s.sendEncodings[%d]", key, i), sendEncodingRaw)
+		if err != nil {
+			return transceiverInit, nil, err
+		}
+		transceiverInit.SendEncodings = append(transceiverInit.SendEncodings, sendEncoding)
+		sendEncodings = append(sendEncodings, fullSendEncoding)
+	}
+	return transceiverInit, sendEncodings, nil
+}
+
+// parseSendEncoding reads an RTCRtpEncodingParameters into both the pion
+// and the full form. Every key is optional.
+func parseSendEncoding(messageType, key string, sendEncodingRaw map[string]interface{}) (webrtc.RTPEncodingParameters, SendEncoding, error) {
+	var sendEncoding webrtc.RTPEncodingParameters
+	var fullSendEncoding SendEncoding
+	if ridRaw, ok := sendEncodingRaw["rid"].(string); ok {
+		sendEncoding.RID = ridRaw
+		fullSendEncoding.RID = ridRaw
+	} else if sendEncodingRaw["rid"] != nil {
+		return sendEncoding, fullSendEncoding, invalidSignalField(messageType, key+".rid", "string", sendEncodingRaw["rid"])
+	}
+	ssrc, err := parseInteger(messageType, key+".ssrc", sendEncodingRaw["ssrc"], math.MaxUint32)
+	if err != nil {
+		return sendEncoding, fullSendEncoding, err
+	}
+	sendEncoding.SSRC = webrtc.SSRC(ssrc)
+	payloadType, err := parseInteger(messageType, key+".payloadType", sendEncodingRaw["payloadType"], math.MaxUint8)
+	if err != nil {
+		return sendEncoding, fullSendEncoding, err
+	}
+	sendEncoding.PayloadType = webrtc.PayloadType(payloadType)
+	switch rtxRaw := sendEncodingRaw["rtx"].(type) {
+	case nil:
+	case map[string]interface{}:
+		rtxSSRC, err := parseInteger(messageType, key+".rtx.ssrc", rtxRaw["ssrc"], math.MaxUint32)
+		if err != nil {
+			return sendEncoding, fullSendEncoding, err
+		}
+		sendEncoding.RTX.SSRC = webrtc.SSRC(rtxSSRC)
+	default:
+		return sendEncoding, fullSendEncoding, invalidSignalField(messageType, key+".rtx", "object", rtxRaw)
+	}
+	if scaleRaw, ok := parseNumber(sendEncodingRaw["scaleResolutionDownBy"]); ok {
+		fullSendEncoding.ScaleResolutionDownBy = scaleRaw
+	} else if sendEncodingRaw["scaleResolutionDownBy"] != nil {
+		return sendEncoding, fullSendEncoding, invalidSignalField(messageType, key+".scaleResolutionDownBy", "number", sendEncodingRaw["scaleResolutionDownBy"])
+	}
+	maxBitrate, err := parseInteger(messageType, key+".maxBitrate", sendEncodingRaw["maxBitrate"], math.MaxUint64)
+	if err != nil {
+		return sendEncoding, fullSendEncoding, err
+	}
+	fullSendEncoding.MaxBitrate = maxBitrate
+	return sendEncoding, fullSendEncoding, nil
+}
+
+// parseNumber reads a number decoded by encoding/json, or of any Go
+// numeric type for messages built in process.
+func parseNumber(value interface{}) (float64, bool) {
+	if number, ok := value.(float64); ok {
+		return number, true
+	}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(reflected.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(reflected.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float(), true
+	}
+	return 0, false
+}
+
+// parseInteger reads an optional whole number from 0 to limit.
+func parseInteger(messageType, key string, value interface{}, limit uint64) (uint64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	number, ok := parseNumber(value)
+	if !ok {
+		return 0, invalidSignalField(messageType, key, "number", value)
+	}
+	if number < 0 || number > float64(limit) || number != math.Trunc(number) {
+		return 0, fmt.Errorf("%w: %s: %s out of range, got %v", ErrInvalidSignalMessage, messageType, key, value)
+	}
+	return uint64(number), nil
+}
+
+// parseCandidate reads the RTCIceCandidateInit nested under "candidate".
+// usernameFragment is optional since simple-peer does not send it.
+func parseCandidate(messageType, key string, candidateJSON map[string]interface{}) (webrtc.ICECandidateInit, error) {
+	var candidate webrtc.ICECandidateInit
+	if candidateRaw, ok := candidateJSON["candidate"].(string); ok {
+		candidate.Candidate = candidateRaw
+	} else if candidateJSON["candidate"] != nil {
+		return candidate, invalidSignalField(messageType, key+".candidate", "string", candidateJSON["candidate"])
+	}
+	if sdpMidRaw, ok := candidateJSON["sdpMid"].(string); ok {
+		candidate.SDPMid = &sdpMidRaw
+	} else if candidateJSON["sdpMid"] != nil {
+		return candidate, invalidSignalField(messageType, key+".sdpMid", "string", candidateJSON["sdpMid"])
+	}
+	switch sdpMLineIndexRaw := candidateJSON["sdpMLineIndex"].(type) {
+	case nil:
+	case float64:
+		if sdpMLineIndexRaw < 0 || sdpMLineIndexRaw > 65535 || sdpMLineIndexRaw != float64(uint16(sdpMLineIndexRaw)) {
+			return candidate, fmt.Errorf("%w: %s: %s.sdpMLineIndex out of range, got %v", ErrInvalidSignalMessage, messageType, key, sdpMLineIndexRaw)
+		}
+		sdpMLineIndex := uint16(sdpMLineIndexRaw)
+		candidate.SDPMLineIndex = &sdpMLineIndex
+	case uint16:
+		candidate.SDPMLineIndex = &sdpMLineIndexRaw
+	default:
+		return candidate, invalidSignalField(messageType, key+".sdpMLineIndex", "number", sdpMLineIndexRaw)
+	}
+	if usernameFragmentRaw, ok := candidateJSON["usernameFragment"].(string); ok {
+		candidate.UsernameFragment = &usernameFragmentRaw
+	} else if candidateJSON["usernameFragment"] != nil {
+		return candidate, invalidSignalField(messageType, key+".usernameFragment", "string", candidateJSON["usernameFragment"])
+	}
+	return candidate, nil
+}
+
+func invalidSignalField(messageType, key, expected string, value interface{}) error {
+	return fmt.Errorf("%w: %s: %s expected %s, got %s", ErrInvalidSignalMessage, messageType, key, expected, jsonTypeName(value))
+}
+
+// jsonTypeName names the JSON type of a decoded value, falling back to the
+// Go type for values that did not come from encoding/json.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func buildCandidate(candidate webrtc.ICECandidateInit) map[string]interface{} {
+	candidateJSON := map[string]interface{}{
+		"candidate":        candidate.Candidate,
+		"sdpMid":           nil,
+		"sdpMLineIndex":    nil,
+		"usernameFragment": nil,
+	}
+	if candidate.SDPMid != nil {
+		candidateJSON["sdpMid"] = *candidate.SDPMid
+	}
+	if candidate.SDPMLineIndex != nil {
+		candidateJSON["sdpMLineIndex"] = float64(*candidate.SDPMLineIndex)
+	}
+	if candidate.UsernameFragment != nil {
+		candidateJSON["usernameFragment"] = *candidate.UsernameFragment
+	}
+	return candidateJSON
+}
@@ -0,0 +1,177 @@
+package httppoll
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxPollWait = 30 * time.Second
+
+type HandlerOptions struct {
+	// MaxPollWait caps how long a poll is held, 30s by default.
+	MaxPollWait time.Duration
+	// MaxMessageBytes caps the size of posted messages, 64KiB by default.
+	MaxMessageBytes int64
+}
+
+// Handler is a reference server for Connect. It keeps an in-memory mailbox
+// per peer id:
+//
+//	POST /messages          stores a Message in the mailbox of its To
+//	GET  /messages?id=&after=&wait=
+//	                        drops the mailbox's messages up to after and
+//	                        returns the rest, waiting up to wait for one
+//
+// Mount it with http.StripPrefix under the base URL given to Connect.
+type Handler struct {
+	mutex           sync.Mutex
+	mailboxes       map[string]*mailbox
+	maxPollWait     time.Duration
+	maxMessageBytes int64
+}
+
+type mailbox struct {
+	seq      uint64
+	messages []Message
+	// received has the last stored sequence number per sender session
+	received map[string]uint64
+	// wake is closed and replaced when a message arrives
+	wake chan struct{}
+}
+
+func NewHandler(options ...HandlerOptions) *Handler {
+	handler := Handler{
+		mailboxes: make(map[string]*mailbox),
+	}
+	for _, option := range options {
+		if option.MaxPollWait > 0 {
+			handler.maxPollWait = option.MaxPollWait
+		}
+		if option.MaxMessageBytes > 0 {
+			handler.maxMessageBytes = option.MaxMessageBytes
+		}
+	}
+	if handler.maxPollWait == 0 {
+		handler.maxPollWait = defaultMaxPollWait
+	}
+	if handler.maxMessageBytes == 0 {
+		handler.maxMessageBytes = 64 * 1024
+	}
+	return &handler
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") != "/messages" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		handler.post(w, r)
+	case http.MethodGet:
+		handler.poll(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *Handler) post(w http.ResponseWriter, r *http.Request) {
+	var message Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, handler.maxMessageBytes)).Decode(&message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if message.From == "" || message.To == "" || message.Signal == nil {
+		http.Error(w, "from, to and signal are required", http.StatusBadRequest)
+		return
+	}
+	handler.mutex.Lock()
+	box := handler.mailbox(message.To)
+	sender := message.From + "/" + message.Session
+	if message.Seq == 0 || message.Seq > box.received[sender] {
+		box.received[sender] = message.Seq
+		box.seq++
+		message.Seq = box.seq
+		message.Session = ""
+		box.messages = append(box.messages, message)
+		close(box.wake)
+		box.wake = make(chan struct{})
+	}
+	handler.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *Handler) poll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	var after uint64
+	if value := query.Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+	}
+	if wait > handler.maxPollWait {
+		wait = handler.maxPollWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		handler.mutex.Lock()
+		box := handler.mailbox(id)
+		acknowledged := 0
+		for acknowledged < len(box.messages) && box.messages[acknowledged].Seq <= after {
+			acknowledged++
+		}
+		box.messages = box.messages[acknowledged:]
+		messages := append([]Message{}, box.messages...)
+		wake := box.wake
+		handler.mutex.Unlock()
+		if len(messages) != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(messages)
+			return
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[]\n"))
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// mailbox returns the mailbox of id, creating it. The handler's mutex must
+// be held.
+func (handler *Handler) mailbox(id string) *mailbox {
+	box, ok := handler.mailboxes[id]
+	if !ok {
+		box = &mailbox{
+			received: make(map[string]uint64),
+			wake:     make(chan struct{}),
+		}
+		handler.mailboxes[id] = box
+	}
+	return box
+}
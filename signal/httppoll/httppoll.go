@@ -0,0 +1,328 @@
+// Package httppoll relays the signal messages of peers over plain HTTP
+// requests, for networks that block websockets.
+//
+// Connect POSTs a peer's outgoing messages to a Handler and long-polls it
+// for incoming ones. Messages are delivered at least once and in order:
+// posts are retried until the handler stored them, which it does once per
+// sender sequence number, and polls acknowledge what the previous poll
+// returned, so a lost response is returned again and skipped by sequence.
+package httppoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPollWait      = 25 * time.Second
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
+var ErrNoRemoteId = fmt.Errorf("remote id unknown")
+
+type OnError func(err error)
+
+type Options struct {
+	// Client sends the requests, http.DefaultClient by default. Its Timeout
+	// must exceed PollWait.
+	Client *http.Client
+	// Header is sent with every request, e.g. for authentication.
+	Header http.Header
+	// Id is the mailbox polled for incoming messages, the peer's id by
+	// default.
+	Id string
+	// RemoteId is the mailbox outgoing messages are posted to, the peer's
+	// RemoteId by default.
+	RemoteId string
+	// PollWait is how long the handler holds a poll without messages.
+	// Defaults to 25s; lower it behind proxies that cut long requests.
+	PollWait time.Duration
+	// RetryDelay is the delay before retrying a failed request, doubled
+	// after every failure up to MaxRetryDelay. Defaults to 1s and 30s.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// OnError receives request and signal errors, which do not stop the
+	// connection.
+	OnError OnError
+}
+
+// Message is a signal message in a mailbox. Session and Seq number the
+// sender's posts so retries are stored once; in poll responses Seq is the
+// mailbox's own sequence number.
+type Message struct {
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Session string                 `json:"session,omitempty"`
+	Seq     uint64                 `json:"seq"`
+	Signal  map[string]interface{} `json:"signal"`
+}
+
+// Connect relays the signal messages of peer through the Handler at baseURL
+// until ctx is done or the peer closes. It returns ctx's error, or nil once
+// the peer closed. When it starts it flushes the peer's queued signals and
+// resignals its pending offer.
+func Connect(ctx context.Context, baseURL string, peer *simplepeer.Peer, options ...Options) error {
+	merged := mergeOptions(options)
+	if merged.Id == "" {
+		merged.Id = peer.Id()
+	}
+	client := &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		options: merged,
+		session: uuid.NewString(),
+		wake:    make(chan struct{}, 1),
+	}
+	onSignal := func(message map[string]interface{}) error {
+		to := merged.RemoteId
+		if to == "" {
+			to = peer.RemoteId()
+		}
+		if to == "" {
+			return ErrNoRemoteId
+		}
+		client.push(to, message)
+		return nil
+	}
+	offSignal := peer.SubscribeSignal(onSignal)
+	defer offSignal()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-peer.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go client.send(ctx)
+	if peer.Connection() != nil {
+		client.error(peer.FlushSignals())
+		client.error(peer.ResignalOffer())
+	}
+	client.poll(ctx, func(message Message) {
+		client.error(peer.Signal(message.Signal))
+	})
+	if peer.Context().Err() != nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+func mergeOptions(options []Options) Options {
+	merged := Options{
+		Client:        http.DefaultClient,
+		PollWait:      defaultPollWait,
+		RetryDelay:    defaultRetryDelay,
+		MaxRetryDelay: defaultMaxRetryDelay,
+	}
+	for _, option := range options {
+		if option.Client != nil {
+			merged.Client = option.Client
+		}
+		if option.Header != nil {
+			merged.Header = option.Header
+		}
+		if option.Id != "" {
+			merged.Id = option.Id
+		}
+		if option.RemoteId != "" {
+			merged.RemoteId = option.RemoteId
+		}
+		if option.PollWait > 0 {
+			merged.PollWait = option.PollWait
+		}
+		if option.RetryDelay > 0 {
+			merged.RetryDelay = option.RetryDelay
+		}
+		if option.MaxRetryDelay > 0 {
+			merged.MaxRetryDelay = option.MaxRetryDelay
+		}
+		if option.OnError != nil {
+			merged.OnError = option.OnError
+		}
+	}
+	return merged
+}
+
+// client posts queued messages in order and polls the mailbox of its id.
+type client struct {
+	baseURL string
+	options Options
+	session string
+	mutex   sync.Mutex
+	seq     uint64
+	queue   []Message
+	wake    chan struct{}
+}
+
+func (client *client) push(to string, signal map[string]interface{}) {
+	client.mutex.Lock()
+	client.seq++
+	client.queue = append(client.queue, Message{
+		From:    client.options.Id,
+		To:      to,
+		Session: client.session,
+		Seq:     client.seq,
+		Signal:  signal,
+	})
+	client.mutex.Unlock()
+	select {
+	case client.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send posts the queue front to back, retrying each message until the
+// handler accepted it.
+func (client *client) send(ctx context.Context) {
+	delay := client.options.RetryDelay
+	for {
+		client.mutex.Lock()
+		var message *Message
+		if len(client.queue) != 0 {
+			message = &client.queue[0]
+		}
+		client.mutex.Unlock()
+		if message == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.wake:
+			}
+			continue
+		}
+		if err := client.post(ctx, message); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			client.error(err)
+			if !client.sleep(ctx, &delay) {
+				return
+			}
+			continue
+		}
+		delay = client.options.RetryDelay
+		client.mutex.Lock()
+		client.queue = client.queue[1:]
+		client.mutex.Unlock()
+	}
+}
+
+func (client *client) post(ctx context.Context, message *Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, client.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// poll long-polls the mailbox until ctx is done, passing new messages to
+// onMessage in order.
+func (client *client) poll(ctx context.Context, onMessage func(message Message)) {
+	var after uint64
+	delay := client.options.RetryDelay
+	for {
+		messages, err := client.get(ctx, after)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			client.error(err)
+			if !client.sleep(ctx, &delay) {
+				return
+			}
+			continue
+		}
+		delay = client.options.RetryDelay
+		for _, message := range messages {
+			if message.Seq <= after {
+				continue
+			}
+			after = message.Seq
+			onMessage(message)
+		}
+	}
+}
+
+func (client *client) get(ctx context.Context, after uint64) ([]Message, error) {
+	query := url.Values{
+		"id":    {client.options.Id},
+		"after": {strconv.FormatUint(after, 10)},
+		"wait":  {client.options.PollWait.String()},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL+"/messages?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var messages []Message
+	if err := json.NewDecoder(response.Body).Decode(&messages); err != nil {
+		return nil, fmt.Errorf("%w: %w", simplepeer.ErrMalformedSignal, err)
+	}
+	return messages, nil
+}
+
+func (client *client) do(request *http.Request) (*http.Response, error) {
+	for key, values := range client.options.Header {
+		request.Header[key] = values
+	}
+	response, err := client.options.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		response.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", request.Method, request.URL.Path, response.Status, strings.TrimSpace(string(body)))
+	}
+	return response, nil
+}
+
+// sleep waits out the retry delay and doubles it, returning false if ctx is
+// done first.
+func (client *client) sleep(ctx context.Context, delay *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*delay):
+	}
+	*delay *= 2
+	if *delay > client.options.MaxRetryDelay {
+		*delay = client.options.MaxRetryDelay
+	}
+	return true
+}
+
+func (client *client) error(err error) {
+	if err != nil && client.options.OnError != nil {
+		client.options.OnError(err)
+	}
+}
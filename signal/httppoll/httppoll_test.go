@@ -0,0 +1,129 @@
+package httppoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testpeer"
+)
+
+func connectThrough(t *testing.T, handler http.Handler) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connected1, connected2 := make(chan struct{}), make(chan struct{})
+	peer1 := testpeer.New("peer1", connected1)
+	defer peer1.Close()
+	peer2 := testpeer.New("peer2", connected2)
+	defer peer2.Close()
+	options := Options{PollWait: time.Second, RetryDelay: 10 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		options := options
+		options.RemoteId = "peer2"
+		done <- Connect(ctx, server.URL, peer1, options)
+	}()
+	go Connect(ctx, server.URL, peer2, options)
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	testpeer.WaitConnected(t, connected1)
+	testpeer.WaitConnected(t, connected2)
+
+	peer1.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected nil once the peer closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Connect to return once the peer closed")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	connectThrough(t, NewHandler())
+}
+
+// lossyHandler handles every other request but answers it with an error,
+// as if the response was lost.
+type lossyHandler struct {
+	handler  http.Handler
+	requests atomic.Int32
+}
+
+func (lossy *lossyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if lossy.requests.Add(1)%2 == 0 {
+		lossy.handler.ServeHTTP(httptest.NewRecorder(), r)
+		http.Error(w, "lost", http.StatusBadGateway)
+		return
+	}
+	lossy.handler.ServeHTTP(w, r)
+}
+
+func TestConnectLostResponses(t *testing.T) {
+	connectThrough(t, &lossyHandler{handler: NewHandler()})
+}
+
+func TestHandlerDeliversOnceInOrder(t *testing.T) {
+	server := httptest.NewServer(NewHandler())
+	defer server.Close()
+	post := func(seq uint64, sdp string) {
+		body, _ := json.Marshal(Message{From: "a", To: "b", Session: "s", Seq: seq, Signal: map[string]interface{}{"type": "offer", "sdp": sdp}})
+		response, err := http.Post(server.URL+"/messages", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected 204, got %s", response.Status)
+		}
+	}
+	poll := func(after string) []Message {
+		response, err := http.Get(server.URL + "/messages?id=b&wait=0s&after=" + after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var messages []Message
+		if err := json.NewDecoder(response.Body).Decode(&messages); err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+	post(1, "first")
+	post(1, "first")
+	post(2, "second")
+	messages := poll("0")
+	if len(messages) != 2 || messages[0].Signal["sdp"] != "first" || messages[1].Signal["sdp"] != "second" {
+		t.Fatalf("expected first and second once, got %+v", messages)
+	}
+	// the response was lost, so the same poll returns them again
+	if again := poll("0"); len(again) != 2 {
+		t.Fatalf("expected both messages again, got %+v", again)
+	}
+	if rest := poll("1"); len(rest) != 1 || rest[0].Seq != messages[1].Seq {
+		t.Fatalf("expected only second after acknowledging first, got %+v", rest)
+	}
+	if rest := poll("2"); len(rest) != 0 {
+		t.Fatalf("expected no messages, got %+v", rest)
+	}
+
+	start := time.Now()
+	response, err := http.Get(server.URL + "/messages?id=b&wait=50ms&after=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected the poll to wait")
+	}
+}
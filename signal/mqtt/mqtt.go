@@ -0,0 +1,296 @@
+// Package mqtt relays the signal messages of peers over an existing MQTT
+// client.
+//
+// Every peer id has the topic <prefix>/<id>/in. Connect and ConnectManager
+// subscribe to the topic of the local id and publish to those of remote ids.
+// Envelopes carry the sender's session epoch and a sequence number, so QoS 1
+// redeliveries and stale messages, like a retained offer of an earlier
+// session, are dropped instead of applied twice.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultPrefix        = "simplepeer"
+	defaultQoS           = 1
+	defaultTimeout       = 10 * time.Second
+	reconnectCheckPeriod = time.Second
+)
+
+var (
+	ErrNoRemoteId = fmt.Errorf("remote id unknown")
+	ErrTimeout    = fmt.Errorf("mqtt timed out")
+)
+
+type OnError func(err error)
+
+type Options struct {
+	// Prefix is the first level of the topics, "simplepeer" by default.
+	Prefix string
+	// Id is the id whose topic is subscribed, the peer's id for Connect.
+	Id string
+	// RemoteId is the id whose topic Connect publishes to, the peer's
+	// RemoteId by default.
+	RemoteId string
+	// QoS of subscriptions and publishes, 1 by default. Use 2 only with
+	// brokers that support it.
+	QoS byte
+	// Timeout bounds waiting for subscribes and publishes, 10s by default.
+	Timeout time.Duration
+	// OnError receives publish, decode and signal errors, which do not stop
+	// the connection.
+	OnError OnError
+}
+
+// Envelope is the payload of a signal message. Epoch identifies the
+// sender's session by its start time in unix nanoseconds and Seq numbers
+// the session's messages from 1.
+type Envelope struct {
+	From   string                 `json:"from"`
+	Epoch  int64                  `json:"epoch"`
+	Seq    uint64                 `json:"seq"`
+	Signal map[string]interface{} `json:"signal"`
+}
+
+// Topic returns the topic the signal messages to id are published to.
+func Topic(prefix, id string) string {
+	return prefix + "/" + id + "/in"
+}
+
+// Connect relays the signal messages of peer over client until ctx is done
+// or the peer closes. It returns ctx's error, or nil once the peer closed.
+// The client must be connected and should reconnect on its own; after a
+// reconnect the subscription is renewed, the peer's queued signals are
+// flushed and its pending offer is resignaled.
+func Connect(ctx context.Context, client paho.Client, peer *simplepeer.Peer, options ...Options) error {
+	merged := mergeOptions(options)
+	if merged.Id == "" {
+		merged.Id = peer.Id()
+	}
+	relay := newRelay(client, merged)
+	onSignal := func(message map[string]interface{}) error {
+		remoteId := merged.RemoteId
+		if remoteId == "" {
+			remoteId = peer.RemoteId()
+		}
+		if remoteId == "" {
+			return ErrNoRemoteId
+		}
+		return relay.publish(remoteId, message)
+	}
+	offSignal := peer.SubscribeSignal(onSignal)
+	defer offSignal()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-peer.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := relay.run(ctx, func() {
+		resignal(peer, relay.error)
+	}, func(envelope Envelope) {
+		relay.error(peer.Signal(envelope.Signal))
+	})
+	if peer.Context().Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// ConnectManager relays the signal messages of every peer of manager over
+// client until ctx is done, publishing them to the topics of their remote
+// ids. Options.Id is the local id. Messages from unknown ids create peers as
+// PeerManager.Signal does.
+func ConnectManager(ctx context.Context, client paho.Client, manager *simplepeer.PeerManager, options ...Options) error {
+	merged := mergeOptions(options)
+	if merged.Id == "" {
+		return fmt.Errorf("mqtt: ConnectManager needs an id")
+	}
+	relay := newRelay(client, merged)
+	onSignal := func(id string, message map[string]interface{}) error {
+		return relay.publish(id, message)
+	}
+	offSignal := manager.OnSignal(onSignal)
+	defer offSignal()
+	return relay.run(ctx, func() {
+		for _, id := range manager.Ids() {
+			if peer, ok := manager.Get(id); ok {
+				resignal(peer, relay.error)
+			}
+		}
+	}, func(envelope Envelope) {
+		relay.error(manager.Signal(envelope.From, envelope.Signal))
+	})
+}
+
+// resignal resends what the broker may have lost while the client was
+// disconnected.
+func resignal(peer *simplepeer.Peer, onError func(err error)) {
+	if peer.Connection() == nil {
+		return
+	}
+	onError(peer.FlushSignals())
+	onError(peer.ResignalOffer())
+}
+
+func mergeOptions(options []Options) Options {
+	merged := Options{
+		Prefix:  defaultPrefix,
+		QoS:     defaultQoS,
+		Timeout: defaultTimeout,
+	}
+	for _, option := range options {
+		if option.Prefix != "" {
+			merged.Prefix = option.Prefix
+		}
+		if option.Id != "" {
+			merged.Id = option.Id
+		}
+		if option.RemoteId != "" {
+			merged.RemoteId = option.RemoteId
+		}
+		if option.QoS != 0 {
+			merged.QoS = option.QoS
+		}
+		if option.Timeout > 0 {
+			merged.Timeout = option.Timeout
+		}
+		if option.OnError != nil {
+			merged.OnError = option.OnError
+		}
+	}
+	return merged
+}
+
+// position is the last message applied from a sender.
+type position struct {
+	epoch int64
+	seq   uint64
+}
+
+// relay numbers outgoing envelopes and drops repeated incoming ones.
+type relay struct {
+	client  paho.Client
+	options Options
+	epoch   int64
+	// publishMutex guards seq
+	publishMutex sync.Mutex
+	seq          uint64
+	mutex        sync.Mutex
+	// received has the last applied position per sender
+	received map[string]position
+}
+
+func newRelay(client paho.Client, options Options) *relay {
+	return &relay{
+		client:   client,
+		options:  options,
+		epoch:    time.Now().UnixNano(),
+		received: make(map[string]position),
+	}
+}
+
+// run subscribes to the local topic and renews the subscription whenever
+// the client reconnected, until ctx is done.
+func (relay *relay) run(ctx context.Context, onReconnect func(), onEnvelope func(envelope Envelope)) error {
+	topic := Topic(relay.options.Prefix, relay.options.Id)
+	var handlerMutex sync.Mutex
+	handler := func(_ paho.Client, message paho.Message) {
+		// apply in order even if the client calls handlers concurrently
+		handlerMutex.Lock()
+		defer handlerMutex.Unlock()
+		if envelope, ok := relay.accept(message); ok && ctx.Err() == nil {
+			onEnvelope(envelope)
+		}
+	}
+	if err := relay.wait(relay.client.Subscribe(topic, relay.options.QoS, handler)); err != nil {
+		return err
+	}
+	defer relay.client.Unsubscribe(topic)
+	ticker := time.NewTicker(reconnectCheckPeriod)
+	defer ticker.Stop()
+	connected := true
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		open := relay.client.IsConnectionOpen()
+		if open && !connected {
+			if err := relay.wait(relay.client.Subscribe(topic, relay.options.QoS, handler)); err != nil {
+				relay.error(err)
+				continue
+			}
+			onReconnect()
+		}
+		connected = open
+	}
+}
+
+func (relay *relay) publish(to string, message map[string]interface{}) error {
+	// receivers drop messages numbered below one they applied, so messages
+	// are published in the order of their numbers
+	relay.publishMutex.Lock()
+	relay.seq++
+	payload, err := json.Marshal(Envelope{From: relay.options.Id, Epoch: relay.epoch, Seq: relay.seq, Signal: message})
+	if err != nil {
+		relay.publishMutex.Unlock()
+		return err
+	}
+	token := relay.client.Publish(Topic(relay.options.Prefix, to), relay.options.QoS, false, payload)
+	relay.publishMutex.Unlock()
+	return relay.wait(token)
+}
+
+// accept decodes a message and checks it comes after the last one applied
+// from its sender. Retained messages are always stale, since signal
+// messages are never published retained.
+func (relay *relay) accept(message paho.Message) (Envelope, bool) {
+	var envelope Envelope
+	if message.Retained() {
+		return envelope, false
+	}
+	if err := json.Unmarshal(message.Payload(), &envelope); err != nil {
+		relay.error(fmt.Errorf("%w: %w", simplepeer.ErrMalformedSignal, err))
+		return envelope, false
+	}
+	if envelope.From == "" || envelope.Signal == nil {
+		relay.error(fmt.Errorf("%w: envelope without from or signal", simplepeer.ErrMalformedSignal))
+		return envelope, false
+	}
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	last, ok := relay.received[envelope.From]
+	if ok && (envelope.Epoch < last.epoch || envelope.Epoch == last.epoch && envelope.Seq <= last.seq) {
+		return envelope, false
+	}
+	relay.received[envelope.From] = position{epoch: envelope.Epoch, seq: envelope.Seq}
+	return envelope, true
+}
+
+func (relay *relay) wait(token paho.Token) error {
+	if !token.WaitTimeout(relay.options.Timeout) {
+		return ErrTimeout
+	}
+	return token.Error()
+}
+
+func (relay *relay) error(err error) {
+	if err != nil && relay.options.OnError != nil {
+		relay.options.OnError(err)
+	}
+}
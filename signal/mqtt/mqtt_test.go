@@ -0,0 +1,231 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+	"github.com/aicacia/go-simplepeer/internal/testpeer"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// broker delivers publishes in order to the subscribers of their exact
+// topic, twice when duplicate is set, and replays retained messages on
+// subscribe.
+type broker struct {
+	mutex       sync.Mutex
+	duplicate   bool
+	subscribers map[string][]*fakeClient
+	retained    map[string][]byte
+}
+
+func newBroker() *broker {
+	return &broker{
+		subscribers: make(map[string][]*fakeClient),
+		retained:    make(map[string][]byte),
+	}
+}
+
+func (b *broker) publish(topic string, payload []byte, retained bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if retained {
+		b.retained[topic] = payload
+	}
+	for _, subscriber := range b.subscribers[topic] {
+		subscriber.deliver(&fakeMessage{topic: topic, payload: payload})
+		if b.duplicate {
+			subscriber.deliver(&fakeMessage{topic: topic, payload: payload, duplicate: true})
+		}
+	}
+}
+
+// fakeClient implements the parts of paho.Client the adapter uses.
+type fakeClient struct {
+	paho.Client
+	broker  *broker
+	open    atomic.Bool
+	mutex   sync.Mutex
+	handler paho.MessageHandler
+	queue   chan paho.Message
+}
+
+func newFakeClient(b *broker) *fakeClient {
+	client := &fakeClient{broker: b, queue: make(chan paho.Message, 256)}
+	client.open.Store(true)
+	go func() {
+		for message := range client.queue {
+			client.mutex.Lock()
+			handler := client.handler
+			client.mutex.Unlock()
+			if handler != nil {
+				handler(client, message)
+			}
+		}
+	}()
+	return client
+}
+
+func (client *fakeClient) deliver(message paho.Message) {
+	client.queue <- message
+}
+
+func (client *fakeClient) IsConnectionOpen() bool {
+	return client.open.Load()
+}
+
+func (client *fakeClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	client.mutex.Lock()
+	client.handler = callback
+	client.mutex.Unlock()
+	client.broker.mutex.Lock()
+	client.broker.subscribers[topic] = append(client.broker.subscribers[topic], client)
+	if payload, ok := client.broker.retained[topic]; ok {
+		client.deliver(&fakeMessage{topic: topic, payload: payload, retained: true})
+	}
+	client.broker.mutex.Unlock()
+	return doneToken{}
+}
+
+func (client *fakeClient) Unsubscribe(topics ...string) paho.Token {
+	client.mutex.Lock()
+	client.handler = nil
+	client.mutex.Unlock()
+	return doneToken{}
+}
+
+func (client *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	client.broker.publish(topic, payload.([]byte), retained)
+	return doneToken{}
+}
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (doneToken) Error() error                   { return nil }
+
+type fakeMessage struct {
+	topic     string
+	payload   []byte
+	duplicate bool
+	retained  bool
+}
+
+func (message *fakeMessage) Duplicate() bool   { return message.duplicate }
+func (message *fakeMessage) Qos() byte         { return 1 }
+func (message *fakeMessage) Retained() bool    { return message.retained }
+func (message *fakeMessage) Topic() string     { return message.topic }
+func (message *fakeMessage) MessageID() uint16 { return 0 }
+func (message *fakeMessage) Payload() []byte   { return message.payload }
+func (message *fakeMessage) Ack()              {}
+
+func TestConnectDropsDuplicatesAndRetained(t *testing.T) {
+	b := newBroker()
+	b.duplicate = true
+	// a retained offer of an earlier session
+	stale, _ := json.Marshal(Envelope{From: "peer1", Epoch: 1, Seq: 1, Signal: map[string]interface{}{"type": "offer", "sdp": "stale"}})
+	b.publish(Topic(defaultPrefix, "peer2"), stale, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var errs []error
+	var errsMutex sync.Mutex
+	onError := func(err error) {
+		errsMutex.Lock()
+		errs = append(errs, err)
+		errsMutex.Unlock()
+	}
+	connected1, connected2 := make(chan struct{}), make(chan struct{})
+	peer1 := testpeer.New("peer1", connected1)
+	defer peer1.Close()
+	peer2 := testpeer.New("peer2", connected2)
+	defer peer2.Close()
+	go Connect(ctx, newFakeClient(b), peer2, Options{OnError: onError})
+	go Connect(ctx, newFakeClient(b), peer1, Options{RemoteId: "peer2", OnError: onError})
+	time.Sleep(50 * time.Millisecond)
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	testpeer.WaitConnected(t, connected1)
+	testpeer.WaitConnected(t, connected2)
+	errsMutex.Lock()
+	defer errsMutex.Unlock()
+	if len(errs) != 0 {
+		t.Fatalf("expected duplicates and the stale offer to be dropped, got %v", errs)
+	}
+}
+
+func TestRelayAccept(t *testing.T) {
+	relay := newRelay(nil, mergeOptions(nil))
+	accept := func(epoch int64, seq uint64) bool {
+		payload, _ := json.Marshal(Envelope{From: "a", Epoch: epoch, Seq: seq, Signal: map[string]interface{}{}})
+		_, ok := relay.accept(&fakeMessage{payload: payload})
+		return ok
+	}
+	if !accept(2, 1) || !accept(2, 2) {
+		t.Fatal("expected new messages to be accepted")
+	}
+	if accept(2, 2) || accept(2, 1) {
+		t.Fatal("expected repeated messages to be dropped")
+	}
+	if accept(1, 9) {
+		t.Fatal("expected messages of an earlier session to be dropped")
+	}
+	if !accept(3, 1) {
+		t.Fatal("expected the first message of a later session to be accepted")
+	}
+}
+
+func TestConnectManagerResignalsAfterReconnect(t *testing.T) {
+	b := newBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connected := make(chan string, 2)
+	onConnect := func(id string, peer *simplepeer.Peer) {
+		connected <- id
+	}
+	manager1 := simplepeer.NewPeerManager(simplepeer.PeerManagerOptions{OnPeerConnect: onConnect})
+	defer manager1.Close()
+	manager2 := simplepeer.NewPeerManager(simplepeer.PeerManagerOptions{
+		OnPeerConnect: onConnect,
+		PeerOptions: func(id string) simplepeer.PeerOptions {
+			return simplepeer.PeerOptions{Id: "b"}
+		},
+	})
+	defer manager2.Close()
+	if err := ConnectManager(ctx, newFakeClient(b), manager1); err == nil {
+		t.Fatal("expected an error without an id")
+	}
+	client1 := newFakeClient(b)
+	go ConnectManager(ctx, client1, manager1, Options{Id: "a"})
+	time.Sleep(50 * time.Millisecond)
+	client1.open.Store(false)
+
+	peer, err := manager1.Create("b", simplepeer.PeerOptions{Id: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manager2 is not subscribed yet, so the offer is lost
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go ConnectManager(ctx, newFakeClient(b), manager2, Options{Id: "b"})
+	time.Sleep(2 * reconnectCheckPeriod)
+	client1.open.Store(true)
+	ids := map[string]bool{}
+	for len(ids) < 2 {
+		select {
+		case id := <-connected:
+			ids[id] = true
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out, connected %v", ids)
+		}
+	}
+}
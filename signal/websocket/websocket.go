@@ -0,0 +1,320 @@
+// Package websocket relays the signal messages of peers over a websocket.
+//
+// Connect serves a single peer and ConnectManager every peer of a
+// PeerManager. Both keep the socket alive with pings, redial it when it
+// drops and, once redialed, resend the signals that may have been lost.
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+	gorilla "github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval      = 30 * time.Second
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+var ErrNotConnected = fmt.Errorf("websocket not connected")
+
+type OnError func(err error)
+
+type Options struct {
+	// Header is sent with every dial, e.g. for authentication.
+	Header http.Header
+	// Dialer dials the socket, gorilla's DefaultDialer by default.
+	Dialer *gorilla.Dialer
+	// Room wraps every message in an Envelope for the room, so one socket
+	// can carry the signals of several peers. Without it Connect sends and
+	// receives bare signal messages.
+	Room string
+	// Id is the sender of envelopes, the peer's id for Connect.
+	Id string
+	// RemoteId is the recipient of the envelopes of Connect, the peer's
+	// RemoteId by default.
+	RemoteId string
+	// PingInterval is how often the socket is pinged. A socket that does
+	// not answer within twice the interval is redialed. Defaults to 30s.
+	PingInterval time.Duration
+	// ReconnectDelay is the delay before redialing, doubled after every
+	// failed dial up to MaxReconnectDelay. Defaults to 1s and 30s.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	// OnError receives dial, read and signal errors, which do not stop the
+	// connection.
+	OnError OnError
+}
+
+// Envelope addresses a signal message to a peer in a room. To is empty for
+// messages to every peer in the room.
+type Envelope struct {
+	Room   string                 `json:"room"`
+	From   string                 `json:"from,omitempty"`
+	To     string                 `json:"to,omitempty"`
+	Signal map[string]interface{} `json:"signal"`
+}
+
+// Connect relays the signal messages of peer over the websocket at url
+// until ctx is done or the peer closes. It returns ctx's error, or nil once
+// the peer closed. After every redial it flushes the peer's queued signals
+// and resignals its pending offer.
+func Connect(ctx context.Context, url string, peer *simplepeer.Peer, options ...Options) error {
+	merged := mergeOptions(options)
+	if merged.Id == "" {
+		merged.Id = peer.Id()
+	}
+	client := newClient(url, merged)
+	onSignal := func(message map[string]interface{}) error {
+		if merged.Room == "" {
+			return client.writeJSON(message)
+		}
+		remoteId := merged.RemoteId
+		if remoteId == "" {
+			remoteId = peer.RemoteId()
+		}
+		return client.writeJSON(Envelope{Room: merged.Room, From: merged.Id, To: remoteId, Signal: message})
+	}
+	offSignal := peer.SubscribeSignal(onSignal)
+	defer offSignal()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-peer.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := client.run(ctx, func() {
+		resignal(peer, client.error)
+	}, func(data []byte) {
+		if merged.Room == "" {
+			client.error(peer.SignalBytes(data))
+			return
+		}
+		envelope, ok := client.readEnvelope(data)
+		if ok {
+			client.error(peer.Signal(envelope.Signal))
+		}
+	})
+	if peer.Context().Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// ConnectManager relays the signal messages of every peer of manager over
+// the websocket at url until ctx is done, wrapping them in envelopes to
+// their remote ids. Options.Room must be set. Envelopes from unknown ids
+// create peers as PeerManager.Signal does.
+func ConnectManager(ctx context.Context, url string, manager *simplepeer.PeerManager, options ...Options) error {
+	merged := mergeOptions(options)
+	if merged.Room == "" {
+		return errors.New("websocket: ConnectManager needs a room")
+	}
+	client := newClient(url, merged)
+	onSignal := func(id string, message map[string]interface{}) error {
+		return client.writeJSON(Envelope{Room: merged.Room, From: merged.Id, To: id, Signal: message})
+	}
+	offSignal := manager.OnSignal(onSignal)
+	defer offSignal()
+	return client.run(ctx, func() {
+		for _, id := range manager.Ids() {
+			if peer, ok := manager.Get(id); ok {
+				resignal(peer, client.error)
+			}
+		}
+	}, func(data []byte) {
+		envelope, ok := client.readEnvelope(data)
+		if ok && envelope.From != "" {
+			client.error(manager.Signal(envelope.From, envelope.Signal))
+		}
+	})
+}
+
+// resignal resends what a dropped socket may have lost.
+func resignal(peer *simplepeer.Peer, onError func(err error)) {
+	if peer.Connection() == nil {
+		return
+	}
+	onError(peer.FlushSignals())
+	onError(peer.ResignalOffer())
+}
+
+func mergeOptions(options []Options) Options {
+	merged := Options{
+		Dialer:            gorilla.DefaultDialer,
+		PingInterval:      defaultPingInterval,
+		ReconnectDelay:    defaultReconnectDelay,
+		MaxReconnectDelay: defaultMaxReconnectDelay,
+	}
+	for _, option := range options {
+		if option.Header != nil {
+			merged.Header = option.Header
+		}
+		if option.Dialer != nil {
+			merged.Dialer = option.Dialer
+		}
+		if option.Room != "" {
+			merged.Room = option.Room
+		}
+		if option.Id != "" {
+			merged.Id = option.Id
+		}
+		if option.RemoteId != "" {
+			merged.RemoteId = option.RemoteId
+		}
+		if option.PingInterval > 0 {
+			merged.PingInterval = option.PingInterval
+		}
+		if option.ReconnectDelay > 0 {
+			merged.ReconnectDelay = option.ReconnectDelay
+		}
+		if option.MaxReconnectDelay > 0 {
+			merged.MaxReconnectDelay = option.MaxReconnectDelay
+		}
+		if option.OnError != nil {
+			merged.OnError = option.OnError
+		}
+	}
+	return merged
+}
+
+// client keeps a socket dialed and serializes writes to it.
+type client struct {
+	url     string
+	options Options
+	mutex   sync.Mutex
+	socket  *gorilla.Conn
+}
+
+func newClient(url string, options Options) *client {
+	return &client{url: url, options: options}
+}
+
+// run dials the socket and reads it, redialing whenever it drops, until
+// ctx is done. onOpen is called after every dial.
+func (client *client) run(ctx context.Context, onOpen func(), onMessage func(data []byte)) error {
+	delay := client.options.ReconnectDelay
+	for {
+		socket, _, err := client.options.Dialer.DialContext(ctx, client.url, client.options.Header)
+		if err == nil {
+			delay = client.options.ReconnectDelay
+			client.serve(ctx, socket, onOpen, onMessage)
+		} else if ctx.Err() == nil {
+			client.error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if err != nil {
+			delay *= 2
+			if delay > client.options.MaxReconnectDelay {
+				delay = client.options.MaxReconnectDelay
+			}
+		}
+	}
+}
+
+// serve reads the socket until it fails or ctx is done, pinging it in the
+// meantime.
+func (client *client) serve(ctx context.Context, socket *gorilla.Conn, onOpen func(), onMessage func(data []byte)) {
+	timeout := 2 * client.options.PingInterval
+	socket.SetReadDeadline(time.Now().Add(timeout))
+	socket.SetPongHandler(func(string) error {
+		return socket.SetReadDeadline(time.Now().Add(timeout))
+	})
+	client.mutex.Lock()
+	client.socket = socket
+	client.mutex.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		client.mutex.Lock()
+		client.socket = nil
+		client.mutex.Unlock()
+		close(done)
+		socket.Close()
+	}()
+	go func() {
+		ticker := time.NewTicker(client.options.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				client.mutex.Lock()
+				socket.WriteControl(gorilla.CloseMessage, gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				client.mutex.Unlock()
+				socket.Close()
+				return
+			case <-ticker.C:
+				client.mutex.Lock()
+				err := socket.WriteControl(gorilla.PingMessage, nil, time.Now().Add(client.options.PingInterval))
+				client.mutex.Unlock()
+				if err != nil {
+					socket.Close()
+					return
+				}
+			}
+		}
+	}()
+	onOpen()
+	for {
+		_, data, err := socket.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				client.error(err)
+			}
+			return
+		}
+		onMessage(data)
+	}
+}
+
+func (client *client) writeJSON(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.socket == nil {
+		return ErrNotConnected
+	}
+	return client.socket.WriteMessage(gorilla.TextMessage, data)
+}
+
+// readEnvelope decodes an envelope for this side of the room.
+func (client *client) readEnvelope(data []byte) (Envelope, bool) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		client.error(fmt.Errorf("%w: %w", simplepeer.ErrMalformedSignal, err))
+		return envelope, false
+	}
+	if envelope.Room != client.options.Room || envelope.Signal == nil {
+		return envelope, false
+	}
+	if envelope.To != "" && envelope.To != client.options.Id {
+		return envelope, false
+	}
+	return envelope, true
+}
+
+func (client *client) error(err error) {
+	if err != nil && client.options.OnError != nil {
+		client.options.OnError(err)
+	}
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	simplepeer "github.com/aicacia/go-simplepeer"
+	"github.com/aicacia/go-simplepeer/internal/testpeer"
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// hub relays every message to the other sockets connected to it.
+type hub struct {
+	mutex   sync.Mutex
+	sockets map[*gorilla.Conn]bool
+}
+
+func newHub(t *testing.T) (*hub, string) {
+	h := &hub{sockets: make(map[*gorilla.Conn]bool)}
+	upgrader := gorilla.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h.mutex.Lock()
+		h.sockets[socket] = true
+		h.mutex.Unlock()
+		defer func() {
+			h.mutex.Lock()
+			delete(h.sockets, socket)
+			h.mutex.Unlock()
+			socket.Close()
+		}()
+		for {
+			messageType, data, err := socket.ReadMessage()
+			if err != nil {
+				return
+			}
+			h.mutex.Lock()
+			for other := range h.sockets {
+				if other != socket {
+					other.WriteMessage(messageType, data)
+				}
+			}
+			h.mutex.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return h, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func (h *hub) count() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.sockets)
+}
+
+func (h *hub) dropAll() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for socket := range h.sockets {
+		socket.Close()
+	}
+}
+
+func TestConnect(t *testing.T) {
+	h, url := newHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connected1, connected2 := make(chan struct{}), make(chan struct{})
+	peer1 := testpeer.New("peer1", connected1)
+	peer2 := testpeer.New("peer2", connected2)
+	done1 := make(chan error, 1)
+	go func() {
+		done1 <- Connect(ctx, url, peer1, Options{Room: "room", RemoteId: "peer2"})
+	}()
+	done2 := make(chan error, 1)
+	go func() {
+		done2 <- Connect(ctx, url, peer2, Options{Room: "room", RemoteId: "peer1"})
+	}()
+	testutil.WaitFor(t, func() bool {
+		return h.count() == 2
+	})
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	testpeer.WaitConnected(t, connected1)
+	testpeer.WaitConnected(t, connected2)
+
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done1:
+		if err != nil {
+			t.Fatalf("expected nil once the peer closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Connect to return once the peer closed")
+	}
+	cancel()
+	if err := <-done2; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	peer2.Close()
+}
+
+func TestConnectResignalsAfterReconnect(t *testing.T) {
+	h, url := newHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connected1, connected2 := make(chan struct{}), make(chan struct{})
+	peer1 := testpeer.New("peer1", connected1)
+	defer peer1.Close()
+	peer2 := testpeer.New("peer2", connected2)
+	defer peer2.Close()
+	options := Options{ReconnectDelay: 10 * time.Millisecond}
+	go Connect(ctx, url, peer1, options)
+	testutil.WaitFor(t, func() bool {
+		return h.count() == 1
+	})
+	// the offer reaches nobody
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go Connect(ctx, url, peer2, options)
+	testutil.WaitFor(t, func() bool {
+		return h.count() == 2
+	})
+	h.dropAll()
+	testpeer.WaitConnected(t, connected1)
+	testpeer.WaitConnected(t, connected2)
+}
+
+func TestConnectManager(t *testing.T) {
+	h, url := newHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connected := make(chan string, 2)
+	onConnect := func(id string, peer *simplepeer.Peer) {
+		connected <- id
+	}
+	manager1 := simplepeer.NewPeerManager(simplepeer.PeerManagerOptions{OnPeerConnect: onConnect})
+	defer manager1.Close()
+	manager2 := simplepeer.NewPeerManager(simplepeer.PeerManagerOptions{
+		OnPeerConnect: onConnect,
+		PeerOptions: func(id string) simplepeer.PeerOptions {
+			return simplepeer.PeerOptions{Id: "b"}
+		},
+	})
+	defer manager2.Close()
+	if err := ConnectManager(ctx, url, manager1); err == nil {
+		t.Fatal("expected an error without a room")
+	}
+	go ConnectManager(ctx, url, manager1, Options{Room: "room", Id: "a"})
+	go ConnectManager(ctx, url, manager2, Options{Room: "room", Id: "b"})
+	testutil.WaitFor(t, func() bool {
+		return h.count() == 2
+	})
+
+	peer, err := manager1.Create("b", simplepeer.PeerOptions{Id: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for len(ids) < 2 {
+		select {
+		case id := <-connected:
+			ids[id] = true
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out, connected %v", ids)
+		}
+	}
+	if !ids["a"] || !ids["b"] {
+		t.Fatalf("expected a and b to connect, got %v", ids)
+	}
+}
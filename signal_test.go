@@ -0,0 +1,394 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSignalRoundTrip(t *testing.T) {
+	sdpMid := "0"
+	sdpMLineIndex := uint16(0)
+	usernameFragment := "ufrag"
+	candidate := webrtc.ICECandidateInit{
+		Candidate:        "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+		SDPMid:           &sdpMid,
+		SDPMLineIndex:    &sdpMLineIndex,
+		UsernameFragment: &usernameFragment,
+	}
+	messages := []SignalMessage{
+		{Type: SignalMessageOffer, SDP: "v=0\r\n"},
+		{Type: SignalMessageAnswer, SDP: "v=0\r\n"},
+		{Type: SignalMessagePRAnswer, SDP: "v=0\r\n"},
+		{Type: SignalMessageRollback, SDP: ""},
+		{Type: SignalMessageRenegotiate, Renegotiate: true},
+		{Type: SignalMessageBye},
+		{Type: SignalMessageBye, From: "peer1"},
+		{Type: SignalMessageCandidate, Candidate: &candidate},
+		{Type: SignalMessageCandidate, Candidate: &webrtc.ICECandidateInit{}},
+		{Type: SignalMessageCandidates, Candidates: []webrtc.ICECandidateInit{candidate, {}}},
+		{Type: SignalMessageTrackMetadata, TrackMetadata: &SignalMessageMetadata{TrackID: "camera", Metadata: map[string]string{"owner": "alice", "source": "camera"}}},
+		{Type: SignalMessageTrackMetadata, TrackMetadata: &SignalMessageMetadata{TrackID: "screen"}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction: webrtc.RTPTransceiverDirectionSendrecv,
+				SendEncodings: []webrtc.RTPEncodingParameters{{
+					RTPCodingParameters: webrtc.RTPCodingParameters{RID: "f"},
+				}},
+			}},
+			SendEncodings: []SendEncoding{{RID: "f"}},
+		}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction:     webrtc.RTPTransceiverDirectionSendonly,
+				SendEncodings: SimulcastEncodings("q", "h", "f"),
+			}},
+			SendEncodings: []SendEncoding{
+				{RID: "q", ScaleResolutionDownBy: 4, MaxBitrate: 150000},
+				{RID: "h", ScaleResolutionDownBy: 2, MaxBitrate: 500000},
+				{RID: "f", MaxBitrate: 2500000},
+			},
+		}},
+	}
+	for _, message := range messages {
+		parsed, err := ParseSignal(BuildSignal(message))
+		if err != nil {
+			t.Fatalf("%s: %s", message.Type, err)
+		}
+		if !reflect.DeepEqual(parsed, message) {
+			t.Fatalf("%s: expected %+v, got %+v", message.Type, message, parsed)
+		}
+	}
+	for _, message := range messages {
+		encoded, err := json.Marshal(BuildSignal(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSignal(decoded)
+		if err != nil {
+			t.Fatalf("%s: %s", message.Type, err)
+		}
+		if !reflect.DeepEqual(parsed, message) {
+			t.Fatalf("%s: expected %+v, got %+v", message.Type, message, parsed)
+		}
+	}
+}
+
+func TestParseTransceiverRequestInitArrayFromJSON(t *testing.T) {
+	message := map[string]interface{}{
+		"type": SignalMessageTransceiverRequest,
+		"transceiverRequest": map[string]interface{}{
+			"kind": "video",
+			"init": []map[string]interface{}{
+				{"direction": "sendonly", "sendEncodings": []map[string]interface{}{{"rid": "q", "scaleResolutionDownBy": 4}, {"rid": "f"}}},
+				{"direction": "recvonly"},
+			},
+		},
+	}
+	expected := SignalMessage{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+		Kind: webrtc.RTPCodecTypeVideo,
+		Init: []webrtc.RTPTransceiverInit{
+			{Direction: webrtc.RTPTransceiverDirectionSendonly, SendEncodings: SimulcastEncodings("q", "f")},
+			{Direction: webrtc.RTPTransceiverDirectionRecvonly},
+		},
+		SendEncodings: []SendEncoding{{RID: "q", ScaleResolutionDownBy: 4}, {RID: "f"}},
+	}}
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []map[string]interface{}{message, decoded} {
+		parsed, err := ParseSignal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed, expected) {
+			t.Fatalf("expected %+v, got %+v", expected, parsed)
+		}
+	}
+}
+
+func TestParseSignalInvalid(t *testing.T) {
+	if _, err := ParseSignal(map[string]interface{}{}); !errors.Is(err, ErrInvalidSignalMessageType) {
+		t.Fatalf("expected invalid signal message type, got %v", err)
+	}
+	if _, err := ParseSignal(map[string]interface{}{"type": "unknown"}); !errors.Is(err, ErrInvalidSignalMessageType) {
+		t.Fatalf("expected invalid signal message type, got %v", err)
+	}
+	if _, err := ParseSignal(map[string]interface{}{"type": SignalMessageOffer}); !errors.Is(err, ErrInvalidSignalMessage) {
+		t.Fatalf("expected invalid signal message, got %v", err)
+	}
+	if _, err := ParseSignal(map[string]interface{}{"type": SignalMessageCandidate, "candidate": "bad"}); !errors.Is(err, ErrInvalidSignalMessage) {
+		t.Fatalf("expected invalid signal message, got %v", err)
+	}
+}
+
+// simplePeerCorpus holds messages as emitted by simple-peer 9.x, after the
+// JSON encoding every signaling channel puts them through.
+var simplePeerCorpus = []string{
+	`{"type":"offer","sdp":"v=0\r\no=- 4215775240449105457 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"}`,
+	`{"type":"answer","sdp":"v=0\r\no=- 4215775240449105457 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"}`,
+	`{"type":"candidate","candidate":{"candidate":"candidate:842163049 1 udp 1677729535 1.2.3.4 56143 typ srflx raddr 0.0.0.0 rport 0 generation 0 ufrag sXP5 network-cost 999","sdpMLineIndex":0,"sdpMid":"0"}}`,
+	`{"type":"renegotiate","renegotiate":true}`,
+	`{"type":"transceiverRequest","transceiverRequest":{"kind":"video"}}`,
+	`{"type":"transceiverRequest","transceiverRequest":{"kind":"audio","init":{"direction":"recvonly"}}}`,
+	`{"type":"transceiverRequest","transceiverRequest":{"kind":"video","init":{"direction":"sendonly","streams":[],"sendEncodings":[{"rid":"f"},{"rid":"h"}]}}}`,
+	`{"type":"transceiverRequest","transceiverRequest":{"kind":"video","init":{"direction":"sendonly","sendEncodings":[{"rid":"q","scaleResolutionDownBy":4,"maxBitrate":150000},{"rid":"f","maxBitrate":2500000}]}}}`,
+}
+
+func TestParseSimplePeerCorpus(t *testing.T) {
+	expected := []SignalMessage{
+		{Type: SignalMessageOffer, SDP: "v=0\r\no=- 4215775240449105457 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"},
+		{Type: SignalMessageAnswer, SDP: "v=0\r\no=- 4215775240449105457 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"},
+		{Type: SignalMessageCandidate, Candidate: &webrtc.ICECandidateInit{
+			Candidate:     "candidate:842163049 1 udp 1677729535 1.2.3.4 56143 typ srflx raddr 0.0.0.0 rport 0 generation 0 ufrag sXP5 network-cost 999",
+			SDPMid:        func() *string { sdpMid := "0"; return &sdpMid }(),
+			SDPMLineIndex: func() *uint16 { sdpMLineIndex := uint16(0); return &sdpMLineIndex }(),
+		}},
+		{Type: SignalMessageRenegotiate, Renegotiate: true},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+		}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeAudio,
+			Init: []webrtc.RTPTransceiverInit{{Direction: webrtc.RTPTransceiverDirectionRecvonly}},
+		}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+				SendEncodings: []webrtc.RTPEncodingParameters{
+					{RTPCodingParameters: webrtc.RTPCodingParameters{RID: "f"}},
+					{RTPCodingParameters: webrtc.RTPCodingParameters{RID: "h"}},
+				},
+			}},
+			SendEncodings: []SendEncoding{{RID: "f"}, {RID: "h"}},
+		}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction:     webrtc.RTPTransceiverDirectionSendonly,
+				SendEncodings: SimulcastEncodings("q", "f"),
+			}},
+			SendEncodings: []SendEncoding{
+				{RID: "q", ScaleResolutionDownBy: 4, MaxBitrate: 150000},
+				{RID: "f", MaxBitrate: 2500000},
+			},
+		}},
+	}
+	for i, raw := range simplePeerCorpus {
+		var message map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &message); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSignal(message)
+		if err != nil {
+			t.Fatalf("%s: %s", raw, err)
+		}
+		if !reflect.DeepEqual(parsed, expected[i]) {
+			t.Fatalf("%s: expected %+v, got %+v", raw, expected[i], parsed)
+		}
+	}
+}
+
+// simplePeerAccepts mirrors the checks simple-peer 9.x applies in signal()
+// and the browser constructors it passes the message to.
+func simplePeerAccepts(message map[string]interface{}) error {
+	accepted := false
+	if message["renegotiate"] == true {
+		accepted = true
+	}
+	if transceiverRequest, ok := message["transceiverRequest"].(map[string]interface{}); ok {
+		if kind := transceiverRequest["kind"]; kind != "audio" && kind != "video" {
+			return fmt.Errorf("invalid transceiver kind %v", kind)
+		}
+		if init, ok := transceiverRequest["init"]; ok {
+			if _, ok := init.(map[string]interface{}); !ok {
+				return fmt.Errorf("transceiver init must be an object, got %T", init)
+			}
+		}
+		accepted = true
+	}
+	if candidateRaw, ok := message["candidate"]; ok && candidateRaw != nil {
+		candidate, ok := candidateRaw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("candidate must be an object, got %T", candidateRaw)
+		}
+		if _, ok := candidate["candidate"].(string); !ok {
+			return fmt.Errorf("candidate.candidate must be a string, got %T", candidate["candidate"])
+		}
+		if candidate["sdpMid"] == nil && candidate["sdpMLineIndex"] == nil {
+			return fmt.Errorf("candidate needs sdpMid or sdpMLineIndex")
+		}
+		accepted = true
+	}
+	if sdp, ok := message["sdp"]; ok && sdp != nil {
+		switch message["type"] {
+		case "offer", "answer", "pranswer", "rollback":
+		default:
+			return fmt.Errorf("invalid description type %v", message["type"])
+		}
+		accepted = true
+	}
+	if !accepted {
+		return fmt.Errorf("signal() called with invalid signal data")
+	}
+	return nil
+}
+
+func TestEmittedSignalsAcceptedBySimplePeer(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+
+	var mutex sync.Mutex
+	var rejected []string
+	seen := map[interface{}]bool{}
+	answers := 0
+	forward := func(to **Peer) OnSignal {
+		return func(message map[string]interface{}) error {
+			encoded, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				return err
+			}
+			mutex.Lock()
+			seen[decoded["type"]] = true
+			if decoded["type"] == SignalMessageAnswer {
+				answers++
+			}
+			if err := simplePeerAccepts(decoded); err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s: %s", encoded, err))
+			}
+			mutex.Unlock()
+			return (*to).SignalBytes(encoded)
+		}
+	}
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id:       "peer1",
+		OnSignal: forward(&peer2),
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id:       "peer2",
+		OnSignal: forward(&peer1),
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if _, err := peer2.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "interop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer2.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		done := seen[SignalMessageRenegotiate] && seen[SignalMessageTransceiverRequest] && answers > 1
+		mutex.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected renegotiation after the transceiver request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, messageType := range []string{SignalMessageOffer, SignalMessageAnswer, SignalMessageCandidate} {
+		if !seen[messageType] {
+			t.Fatalf("expected a %s signal", messageType)
+		}
+	}
+	if len(rejected) > 0 {
+		t.Fatalf("simple-peer would reject:\n%s", strings.Join(rejected, "\n"))
+	}
+}
+
+func TestParseSignalErrorsNameTheField(t *testing.T) {
+	tests := []struct {
+		message  map[string]interface{}
+		sentinel error
+		expected string
+	}{
+		{map[string]interface{}{"type": 1.0}, ErrInvalidSignalMessageType, "invalid signal message type: type expected string, got number"},
+		{map[string]interface{}{"type": "hello"}, ErrInvalidSignalMessageType, `invalid signal message type: unknown type "hello"`},
+		{map[string]interface{}{"type": SignalMessageOffer}, ErrInvalidSignalMessage, "invalid signal message: offer: sdp expected string, got null"},
+		{map[string]interface{}{"type": SignalMessageAnswer, "sdp": true}, ErrInvalidSignalMessage, "invalid signal message: answer: sdp expected string, got boolean"},
+		{map[string]interface{}{"type": SignalMessagePRAnswer, "sdp": []interface{}{}}, ErrInvalidSignalMessage, "invalid signal message: pranswer: sdp expected string, got array"},
+		{map[string]interface{}{"type": SignalMessageRollback, "sdp": map[string]interface{}{}}, ErrInvalidSignalMessage, "invalid signal message: rollback: sdp expected string, got object"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": "bad"}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate expected object, got string"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": 1.0}}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate.candidate expected string, got number"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": "", "sdpMid": 0.0}}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate.sdpMid expected string, got number"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": "", "sdpMLineIndex": "0"}}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate.sdpMLineIndex expected number, got string"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": "", "sdpMLineIndex": -1.0}}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate.sdpMLineIndex out of range, got -1"},
+		{map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": "", "usernameFragment": false}}, ErrInvalidSignalMessage, "invalid signal message: candidate: candidate.usernameFragment expected string, got boolean"},
+		{map[string]interface{}{"type": SignalMessageTrackMetadata}, ErrInvalidSignalMessage, "invalid signal message: trackMetadata: trackMetadata expected object, got null"},
+		{map[string]interface{}{"type": SignalMessageTrackMetadata, "trackMetadata": map[string]interface{}{"metadata": map[string]interface{}{}}}, ErrInvalidSignalMessage, "invalid signal message: trackMetadata: trackMetadata.trackId expected string, got null"},
+		{map[string]interface{}{"type": SignalMessageTrackMetadata, "trackMetadata": map[string]interface{}{"trackId": "camera", "metadata": []interface{}{}}}, ErrInvalidSignalMessage, "invalid signal message: trackMetadata: trackMetadata.metadata expected object, got array"},
+		{map[string]interface{}{"type": SignalMessageTrackMetadata, "trackMetadata": map[string]interface{}{"trackId": "camera", "metadata": map[string]interface{}{"width": 640.0}}}, ErrInvalidSignalMessage, "invalid signal message: trackMetadata: trackMetadata.metadata.width expected string, got number"},
+		{map[string]interface{}{"type": SignalMessageCandidates, "candidates": map[string]interface{}{}}, ErrInvalidSignalMessage, "invalid signal message: candidates: candidates expected array, got object"},
+		{map[string]interface{}{"type": SignalMessageCandidates, "candidates": []interface{}{map[string]interface{}{}, "bad"}}, ErrInvalidSignalMessage, "invalid signal message: candidates: candidates[1] expected object, got string"},
+		{map[string]interface{}{"type": SignalMessageCandidates, "candidates": []interface{}{map[string]interface{}{"sdpMid": true}}}, ErrInvalidSignalMessage, "invalid signal message: candidates: candidates[0].sdpMid expected string, got boolean"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest expected object, got null"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.kind expected string, got null"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "text"}}, ErrInvalidSignalMessage, `invalid signal message: transceiverRequest: transceiverRequest.kind expected audio or video, got "text"`},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": "sendonly"}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init expected object or array, got string"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": []interface{}{"sendonly"}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init[0] expected object, got string"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"direction": 1.0}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.direction expected string, got number"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"direction": "sideways"}}}, ErrInvalidSignalMessage, `invalid signal message: transceiverRequest: transceiverRequest.init.direction unknown direction "sideways"`},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"sendEncodings": "f"}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.sendEncodings expected array, got string"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"sendEncodings": []interface{}{"f"}}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.sendEncodings[0] expected object, got string"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"sendEncodings": []interface{}{map[string]interface{}{"rid": 1.0}}}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.sendEncodings[0].rid expected string, got number"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"sendEncodings": []interface{}{map[string]interface{}{"ssrc": -1.0}}}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.sendEncodings[0].ssrc out of range, got -1"},
+		{map[string]interface{}{"type": SignalMessageTransceiverRequest, "transceiverRequest": map[string]interface{}{"kind": "video", "init": map[string]interface{}{"sendEncodings": []interface{}{map[string]interface{}{"maxBitrate": "high"}}}}}, ErrInvalidSignalMessage, "invalid signal message: transceiverRequest: transceiverRequest.init.sendEncodings[0].maxBitrate expected number, got string"},
+	}
+	for _, test := range tests {
+		_, err := ParseSignal(test.message)
+		if !errors.Is(err, test.sentinel) {
+			t.Fatalf("%v: expected %v, got %v", test.message, test.sentinel, err)
+		}
+		if err.Error() != test.expected {
+			t.Fatalf("%v: expected %q, got %q", test.message, test.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// SignalCodec encodes the signal messages of SignalBytes and OnSignalBytes.
+type SignalCodec interface {
+	Marshal(message SignalMessage) ([]byte, error)
+	Unmarshal(data []byte) (SignalMessage, error)
+}
+
+var (
+	// JSONSignalCodec encodes signal messages as the JSON simple-peer
+	// sends and accepts. It is the default.
+	JSONSignalCodec SignalCodec = jsonSignalCodec{}
+	// CBORSignalCodec encodes signal messages as CBOR, for binary
+	// signaling channels between peers of this package. Messages are
+	// decoded straight into SignalMessage, so numbers keep their types.
+	CBORSignalCodec SignalCodec = cborSignalCodec{}
+)
+
+type jsonSignalCodec struct{}
+
+// jsonSignal is the JSON form of the most frequent messages, encoded
+// without building the map of BuildSignal first.
+type jsonSignal struct {
+	Type      string                   `json:"type"`
+	From      string                   `json:"from,omitempty"`
+	SDP       *string                  `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+}
+
+func (jsonSignalCodec) Marshal(message SignalMessage) ([]byte, error) {
+	switch message.Type {
+	case SignalMessageAnswer, SignalMessageOffer, SignalMessagePRAnswer, SignalMessageRollback:
+		return json.Marshal(jsonSignal{Type: message.Type, From: message.From, SDP: &message.SDP})
+	case SignalMessageCandidate:
+		candidate := message.Candidate
+		if candidate == nil {
+			candidate = &webrtc.ICECandidateInit{}
+		}
+		return json.Marshal(jsonSignal{Type: message.Type, From: message.From, Candidate: candidate})
+	}
+	return json.Marshal(BuildSignal(message))
+}
+
+func (jsonSignalCodec) Unmarshal(data []byte) (SignalMessage, error) {
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return SignalMessage{}, fmt.Errorf("%w: %w", ErrMalformedSignal, err)
+	}
+	return ParseSignal(message)
+}
+
+type cborSignalCodec struct{}
+
+func (cborSignalCodec) Marshal(message SignalMessage) ([]byte, error) {
+	return cbor.Marshal(message)
+}
+
+func (cborSignalCodec) Unmarshal(data []byte) (SignalMessage, error) {
+	var message SignalMessage
+	if err := cbor.Unmarshal(data, &message); err != nil {
+		return SignalMessage{}, fmt.Errorf("%w: %w", ErrMalformedSignal, err)
+	}
+	return message, validateSignal(&message)
+}
+
+// validateSignal checks that a decoded message has the fields its type
+// needs, as ParseSignal does for the map form. A missing candidate marks
+// the end of candidates.
+func validateSignal(message *SignalMessage) error {
+	switch message.Type {
+	case SignalMessageRenegotiate, SignalMessageBye, SignalMessageCandidates:
+	case SignalMessageTransceiverRequest:
+		if message.TransceiverRequest == nil {
+			return invalidSignalField(message.Type, "transceiverRequest", "object", nil)
+		}
+		if message.TransceiverRequest.Kind != webrtc.RTPCodecTypeAudio && message.TransceiverRequest.Kind != webrtc.RTPCodecTypeVideo {
+			return fmt.Errorf("%w: %s: transceiverRequest.kind expected audio or video, got %q", ErrInvalidSignalMessage, message.Type, message.TransceiverRequest.Kind)
+		}
+	case SignalMessageCandidate:
+		if message.Candidate == nil {
+			message.Candidate = &webrtc.ICECandidateInit{}
+		}
+	case SignalMessageTrackMetadata:
+		if message.TrackMetadata == nil {
+			return invalidSignalField(message.Type, "trackMetadata", "object", nil)
+		}
+	case SignalMessageAnswer, SignalMessageOffer, SignalMessagePRAnswer, SignalMessageRollback:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidSignalMessageType, message.Type)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSignalCodecRoundTrip(t *testing.T) {
+	sdpMid := "0"
+	sdpMLineIndex := uint16(3)
+	candidate := webrtc.ICECandidateInit{
+		Candidate:     "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+		SDPMid:        &sdpMid,
+		SDPMLineIndex: &sdpMLineIndex,
+	}
+	messages := []SignalMessage{
+		{Type: SignalMessageOffer, From: "peer1", SDP: "v=0\r\n"},
+		{Type: SignalMessageRenegotiate, Renegotiate: true},
+		{Type: SignalMessageCandidate, Candidate: &candidate},
+		{Type: SignalMessageCandidates, Candidates: []webrtc.ICECandidateInit{candidate}},
+		{Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction:     webrtc.RTPTransceiverDirectionSendonly,
+				SendEncodings: SimulcastEncodings("q", "f"),
+			}},
+			SendEncodings: []SendEncoding{{RID: "q", ScaleResolutionDownBy: 4, MaxBitrate: 150000}, {RID: "f"}},
+		}},
+		{Type: SignalMessageTrackMetadata, TrackMetadata: &SignalMessageMetadata{TrackID: "camera", Metadata: map[string]string{"owner": "alice"}}},
+	}
+	for name, codec := range map[string]SignalCodec{"json": JSONSignalCodec, "cbor": CBORSignalCodec} {
+		for _, message := range messages {
+			data, err := codec.Marshal(message)
+			if err != nil {
+				t.Fatalf("%s %s: %s", name, message.Type, err)
+			}
+			decoded, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s %s: %s", name, message.Type, err)
+			}
+			if !reflect.DeepEqual(decoded, message) {
+				t.Fatalf("%s %s: expected %+v, got %+v", name, message.Type, message, decoded)
+			}
+		}
+		if _, err := codec.Unmarshal([]byte{0xff, 0x00}); !errors.Is(err, ErrMalformedSignal) {
+			t.Fatalf("%s: expected ErrMalformedSignal, got %v", name, err)
+		}
+		data, err := codec.Marshal(SignalMessage{Type: "unknown"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := codec.Unmarshal(data); !errors.Is(err, ErrInvalidSignalMessageType) {
+			t.Fatalf("%s: expected an invalid type error, got %v", name, err)
+		}
+	}
+}
+
+func TestCBORSignaling(t *testing.T) {
+	connected := make(chan bool, 2)
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		SignalCodec: CBORSignalCodec,
+		OnSignalBytes: func(data []byte) error {
+			return peer2.SignalBytes(data)
+		},
+		OnConnect: func() {
+			connected <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		SignalCodec: CBORSignalCodec,
+		OnSignalBytes: func(data []byte) error {
+			return peer1.SignalBytes(data)
+		},
+		OnConnect: func() {
+			connected <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out connecting over CBOR signaling")
+		}
+	}
+}
+
+func TestSignalCodecKeepsOnSignal(t *testing.T) {
+	signaled := make(chan string, 1)
+	peer := NewPeer(PeerOptions{
+		SignalCodec: CBORSignalCodec,
+		OnSignal: func(message map[string]interface{}) error {
+			select {
+			case signaled <- message["type"].(string):
+			default:
+			}
+			return nil
+		},
+	})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case messageType := <-signaled:
+		if messageType != SignalMessageOffer {
+			t.Fatalf("expected an offer, got %s", messageType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected OnSignal to be called with a SignalCodec set")
+	}
+}
+
+func TestJSONSignalCodecMatchesBuildSignal(t *testing.T) {
+	messages := []SignalMessage{{Type: SignalMessageAnswer, From: "peer1"}, {Type: SignalMessageCandidate}}
+	for _, message := range benchmarkSignals() {
+		messages = append(messages, message)
+	}
+	for _, message := range messages {
+		data, err := JSONSignalCodec.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		expected, err := json.Marshal(BuildSignal(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedDecoded map[string]interface{}
+		if err := json.Unmarshal(expected, &expectedDecoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, expectedDecoded) {
+			t.Fatalf("%s: expected %s, got %s", message.Type, expected, data)
+		}
+	}
+}
+
+func benchmarkSignals() map[string]SignalMessage {
+	sdpMid := "0"
+	sdpMLineIndex := uint16(0)
+	return map[string]SignalMessage{
+		"Offer": {Type: SignalMessageOffer, SDP: strings.Repeat("a=candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host\r\n", 20)},
+		"Candidate": {Type: SignalMessageCandidate, Candidate: &webrtc.ICECandidateInit{
+			Candidate:     "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+			SDPMid:        &sdpMid,
+			SDPMLineIndex: &sdpMLineIndex,
+		}},
+		"TransceiverRequest": {Type: SignalMessageTransceiverRequest, TransceiverRequest: &SignalMessageTransceiver{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Init: []webrtc.RTPTransceiverInit{{
+				Direction:     webrtc.RTPTransceiverDirectionSendonly,
+				SendEncodings: SimulcastEncodings("q", "h", "f"),
+			}},
+			SendEncodings: []SendEncoding{{RID: "q", ScaleResolutionDownBy: 4}, {RID: "h", ScaleResolutionDownBy: 2}, {RID: "f"}},
+		}},
+	}
+}
+
+func BenchmarkSignalEncode(b *testing.B) {
+	for name, message := range benchmarkSignals() {
+		message := message
+		b.Run(name+"/OnSignal", func(b *testing.B) {
+			peer := NewPeer(PeerOptions{OnSignal: func(message map[string]interface{}) error {
+				return nil
+			}})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				peer.signal(message)
+			}
+		})
+		b.Run(name+"/OnSignalBytes", func(b *testing.B) {
+			peer := NewPeer(PeerOptions{OnSignalBytes: func(data []byte) error {
+				return nil
+			}})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				peer.signal(message)
+			}
+		})
+	}
+}
+
+func BenchmarkSignalDecode(b *testing.B) {
+	for name, message := range benchmarkSignals() {
+		data, err := JSONSignalCodec.Marshal(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name+"/Signal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseSignal(decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/SignalBytes", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := JSONSignalCodec.Unmarshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,114 +1,835 @@
 package simplepeer
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
-	atomicvalue "github.com/aicacia/go-atomic-value"
 	"github.com/aicacia/go-cslice"
 	"github.com/google/uuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v4"
 )
 
 var (
-	errInvalidSignalMessageType = fmt.Errorf("invalid signal message type")
-	errInvalidSignalMessage     = fmt.Errorf("invalid signal message")
-	errInvalidSignalState       = fmt.Errorf("invalid signal state")
-	errConnectionNotInitialized = fmt.Errorf("connection not initialized")
+	ErrInvalidSignalMessageType = fmt.Errorf("invalid signal message type")
+	ErrInvalidSignalMessage     = fmt.Errorf("invalid signal message")
+	ErrInvalidSignalState       = fmt.Errorf("invalid signal state")
+	ErrConnectionNotInitialized = fmt.Errorf("connection not initialized")
+	errFramingDisabled          = fmt.Errorf("framing disabled")
+	ErrMessageTooLarge          = fmt.Errorf("message too large")
+	ErrMalformedSignal          = fmt.Errorf("malformed signal")
+	ErrPeerClosed               = fmt.Errorf("peer closed")
+	ErrPeerDestroyed            = fmt.Errorf("peer destroyed")
+	ErrConnectionDisconnected   = fmt.Errorf("connection disconnected")
+	ErrConnectionFailed         = fmt.Errorf("connection failed")
+	ErrConnectionClosed         = fmt.Errorf("connection closed")
+	ErrInvalidId                = fmt.Errorf("invalid id")
+	ErrOperationTimeout         = fmt.Errorf("operation timed out")
+	ErrDoubleInitiator          = fmt.Errorf("both peers are initiators")
+	ErrMalformedFrame           = fmt.Errorf("malformed frame")
+	ErrRenegotiationStorm       = fmt.Errorf("renegotiation storm")
+	ErrSdpLimit                 = fmt.Errorf("sdp limit exceeded")
+	ErrMalformedJSON            = fmt.Errorf("malformed json")
+	ErrInvalidChannelConfig     = fmt.Errorf("invalid channel config")
+	ErrChannelClosed            = fmt.Errorf("data channel closed")
+	ErrChannelNotOpen           = fmt.Errorf("data channel not open")
+	ErrConnectTimeout           = fmt.Errorf("connect timed out")
+	ErrRemoteClosed             = fmt.Errorf("remote peer closed")
+	ErrPeerExists               = fmt.Errorf("peer already exists")
+	ErrUnknownPeer              = fmt.Errorf("unknown peer")
+	ErrNoSender                 = fmt.Errorf("no sender")
+	ErrCodecNotNegotiated       = fmt.Errorf("codec not negotiated")
+	ErrNoDepacketizer           = fmt.Errorf("no depacketizer for codec")
+	ErrNoTrack                  = fmt.Errorf("no track")
+	ErrTrackForwarded           = fmt.Errorf("track already forwarded")
+	ErrTrackEnded               = fmt.Errorf("track ended")
+	ErrDirectionUnsupported     = fmt.Errorf("transceiver direction change unsupported")
+	ErrStreamExists             = fmt.Errorf("stream already exists")
+	ErrFileRejected             = fmt.Errorf("file rejected")
+	ErrFileCancelled            = fmt.Errorf("file transfer cancelled")
+	ErrFileChecksum             = fmt.Errorf("file checksum mismatch")
+	ErrFileTransfer             = fmt.Errorf("file transfer failed")
+	ErrInvalidToken             = fmt.Errorf("invalid signal token")
+	ErrTokenOrder               = fmt.Errorf("signal token out of order")
+	ErrICEServersProvider       = fmt.Errorf("ice servers provider failed")
+	ErrReaderOverflow           = fmt.Errorf("reader buffer overflowed")
+	ErrSendBufferFull           = fmt.Errorf("send buffer full")
+	ErrMalformedTranscript      = fmt.Errorf("malformed transcript")
+	ErrSignalQueueFull          = fmt.Errorf("signal queue full")
+	ErrTrackMetadataDisabled    = fmt.Errorf("track metadata signaling disabled")
+)
+
+// Names the errors had before they were exported or renamed.
+var (
+	errInvalidSignalMessageType = ErrInvalidSignalMessageType
+	errInvalidSignalMessage     = ErrInvalidSignalMessage
+	errInvalidSignalState       = ErrInvalidSignalState
+	errConnectionNotInitialized = ErrConnectionNotInitialized
+	// Deprecated: use ErrChannelNotOpen.
+	ErrNotConnected = ErrChannelNotOpen
 )
 
 const (
 	SignalMessageRenegotiate        = "renegotiate"
 	SignalMessageTransceiverRequest = "transceiverRequest"
 	SignalMessageCandidate          = "candidate"
+	SignalMessageCandidates         = "candidates"
 	SignalMessageAnswer             = "answer"
 	SignalMessageOffer              = "offer"
 	SignalMessagePRAnswer           = "pranswer"
 	SignalMessageRollback           = "rollback"
+	SignalMessageBye                = "bye"
+	SignalMessageTrackMetadata      = "trackMetadata"
 )
 
+// maxChannelMessageSize is the message size used until the remote peer's
+// max-message-size is known.
 const maxChannelMessageSize = 16384
 
+const defaultMaxPendingSignals = 64
+
+// OversizePolicy controls what Write and WriteText do with payloads larger
+// than the maximum channel message size.
+type OversizePolicy int
+
+const (
+	// OversizePolicyChunk splits oversized payloads into several messages.
+	OversizePolicyChunk OversizePolicy = iota
+	// OversizePolicyError rejects oversized payloads with ErrMessageTooLarge
+	// without sending any part of them.
+	OversizePolicyError
+)
+
 type SignalMessageTransceiver struct {
 	Kind webrtc.RTPCodecType         `json:"kind"`
 	Init []webrtc.RTPTransceiverInit `json:"init"`
+	// SendEncodings are the send encodings of the first init including the
+	// parameters pion has no field for, so they survive being relayed.
+	SendEncodings []SendEncoding `json:"sendEncodings,omitempty"`
 }
 
 type OnSignal func(message map[string]interface{}) error
+type OnSignalBytes func(data []byte) error
 type OnConnect func()
 type OnData func(message webrtc.DataChannelMessage)
 type OnError func(err error)
 type OnClose func()
+type OnDrain func()
+type OnConnectionStateChange func(state webrtc.PeerConnectionState)
+type OnICEConnectionStateChange func(state webrtc.ICEConnectionState)
+type OnICEGatheringStateChange func(state webrtc.ICEGatheringState)
+type OnSignalingStateChange func(state webrtc.SignalingState)
+type OnNegotiationComplete func()
+type OnRemoteId func(id string)
 type OnTransceiver func(transceiver *webrtc.RTPTransceiver)
 type OnTrack func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+type SdpTransform func(sdp string) string
+type CandidateRewrite func(candidate webrtc.ICECandidateInit) []webrtc.ICECandidateInit
+
+// CallbackOptions adjusts how OnConnect, OnClose, OnTrack and
+// OnAllChannelsReady register a callback.
+type CallbackOptions struct {
+	// SkipReplay registers the callback for future events only, without
+	// delivering the state the peer has already reached.
+	SkipReplay bool
+}
+
+func skipReplay(options []CallbackOptions) bool {
+	for _, option := range options {
+		if option.SkipReplay {
+			return true
+		}
+	}
+	return false
+}
+
+type remoteTrack struct {
+	track    *webrtc.TrackRemote
+	receiver *webrtc.RTPReceiver
+	mid      string
+}
 
 type PeerOptions struct {
-	Id            string
+	Id string
+	// Context is the parent of the peer's lifecycle context. Cancelling it
+	// closes the peer.
+	Context       context.Context
 	ChannelName   string
 	ChannelConfig *webrtc.DataChannelInit
-	Tracks        []webrtc.TrackLocal
-	Config        *webrtc.Configuration
-	OfferConfig   *webrtc.OfferOptions
-	AnswerConfig  *webrtc.AnswerOptions
-	OnSignal      OnSignal
+	// ChannelReliability is applied on top of ChannelConfig. Conflicting
+	// settings make Init and Signal return ErrInvalidChannelConfig.
+	ChannelReliability ChannelReliability
+	// Tracks are added to the peer's first connection, like AddTrack once it
+	// is created.
+	Tracks []webrtc.TrackLocal
+	Config *webrtc.Configuration
+	// ICEServersProvider is called for the ICE servers of every new
+	// connection, from Init, Signal or a reconnect, which are added to
+	// those of Config. An error is reported through OnError and fails the
+	// connection attempt. pion keeps the servers of a connection for its
+	// lifetime, so fresh servers take effect with the next connection.
+	ICEServersProvider ICEServersProvider
+	// WebRTCAPI creates the peer's connections instead of pion's default
+	// API, e.g. to restrict codecs or register interceptors. Stats has no
+	// track counters for connections of a given API.
+	WebRTCAPI *webrtc.API
+	// MediaEngine, SettingEngine and InterceptorRegistry are assembled into
+	// the API when WebRTCAPI is not set. A missing MediaEngine has the
+	// default codecs and a missing InterceptorRegistry the default
+	// interceptors, as with pion's default API.
+	MediaEngine         *webrtc.MediaEngine
+	SettingEngine       *webrtc.SettingEngine
+	InterceptorRegistry *interceptor.Registry
+	// EnableDefaultInterceptors registers pion's default interceptors, NACK
+	// generator and responder, RTCP reports and TWCC, so the ones of
+	// InterceptorRegistry run alongside them. Without an InterceptorRegistry
+	// the defaults are always registered.
+	EnableDefaultInterceptors bool
+	OfferConfig               *webrtc.OfferOptions
+	AnswerConfig              *webrtc.AnswerOptions
+	// CandidateBatchInterval buffers locally gathered candidates and signals
+	// them as a single "candidates" message after the interval or once
+	// gathering completes. Zero signals each candidate as it is gathered.
+	// simple-peer does not understand "candidates" messages, so leave it
+	// unset when talking to it.
+	CandidateBatchInterval time.Duration
+	// CandidateRewrite replaces each locally gathered candidate in outgoing
+	// signals with the candidates it returns, e.g. to signal both the private
+	// and public address of a host behind NAT. Returning none drops the
+	// candidate. Local gathering is not affected.
+	CandidateRewrite CandidateRewrite
+	OversizePolicy   OversizePolicy
+	// MaxChannelMessageSize is the largest data channel message Write and
+	// WriteText send, larger payloads are chunked or rejected depending on
+	// OversizePolicy. Zero uses the max-message-size of the remote
+	// description, see MaxMessageSize.
+	MaxChannelMessageSize int
+	// FrameMessages sends each Write as a frame the remote peer reassembles
+	// for OnMessage and ReadMessage, see framing.go for the wire format. Both
+	// peers must enable it, so leave it unset when talking to simple-peer.
+	FrameMessages bool
+	// BufferedAmountHighThreshold is how many bytes may be queued on the data
+	// channel before Write and WriteText block. Defaults to 1 MiB.
+	BufferedAmountHighThreshold uint64
+	// BufferedAmountLowThreshold is how far the queue has to drain before
+	// blocked writes resume. Defaults to 256 KiB, and to half of
+	// MaxBufferedBytes if that is lower.
+	BufferedAmountLowThreshold uint64
+	// MaxBufferedBytes caps the bytes queued on the data channel plus those
+	// of writes in progress. A write that does not fit is handled as
+	// SendBufferPolicy says, without sending any of it. A write larger than
+	// the cap is accepted once nothing is queued, and BufferedAmountHighThreshold
+	// is lowered to the cap so it is still sent without exceeding it. Zero
+	// disables the cap.
+	MaxBufferedBytes uint64
+	SendBufferPolicy SendBufferPolicy
+	// OnDrain is called once a write that failed with ErrSendBufferFull
+	// would fit, see Peer.OnDrain.
+	OnDrain OnDrain
+	// SignalRetryCount is how many times a signal message is retried after
+	// OnSignal returns an error before OnError is fired. Zero disables
+	// retries and returns OnSignal errors directly.
+	SignalRetryCount int
+	// SignalRetryBackoff is the delay before the first retry, doubled for
+	// every further retry.
+	SignalRetryBackoff time.Duration
+	// SdpTransform rewrites local offers and answers after they are applied
+	// and before they are signaled, e.g. to add b=AS: lines or strip codecs.
+	SdpTransform SdpTransform
+	// RemoteSdpTransform rewrites remote descriptions before they are applied.
+	RemoteSdpTransform SdpTransform
+	// TraceMessages logs every data message and candidate at debug level.
+	TraceMessages bool
+	// LogSDP logs the SDP of every description sent and received at debug
+	// level. Descriptions are large, so they are left out otherwise.
+	LogSDP bool
+	// Logger receives the peer's logs with the peer id as the "peer"
+	// attribute. Defaults to slog.Default at the time of each record.
+	Logger *slog.Logger
+	// OnLogEvent receives every record the peer logs, see
+	// Peer.OnLogEvent.
+	OnLogEvent OnLogEvent
+	// OperationTimeout bounds CreateOffer, CreateAnswer, SetLocalDescription
+	// and SetRemoteDescription. An operation that runs longer is reported
+	// through OnError as ErrOperationTimeout. A timed out CreateOffer or
+	// CreateAnswer abandons the negotiation attempt, to be retried on the
+	// next negotiation. A timed out SetLocalDescription or
+	// SetRemoteDescription may still change the signaling state, so the
+	// connection is given up like a failed one: AutoReconnect replaces it,
+	// otherwise the peer closes. An answerer giving up its connection waits
+	// for the initiator's next offer, see ResignalOffer. The operations of a
+	// connection never overlap, one waits for a timed out predecessor to
+	// return within its own timeout. Zero disables it.
+	OperationTimeout time.Duration
+	// NegotiationDebounce collects changes that need negotiation for this
+	// long before negotiating them together. See SetNegotiationDebounce.
+	NegotiationDebounce time.Duration
+	// MaxRenegotiationsPerMinute caps the renegotiate and transceiverRequest
+	// signals accepted from the remote peer. Requests over the cap return
+	// ErrRenegotiationStorm and are reported through OnError at most every
+	// ten seconds. Defaults to 120, a negative value disables the cap.
+	MaxRenegotiationsPerMinute int
+	// CloseOnRenegotiationStorm closes the peer with ErrRenegotiationStorm
+	// once MaxRenegotiationsPerMinute is exceeded.
+	CloseOnRenegotiationStorm bool
+	// MaxRemoteMediaSections is how many m= sections a remote description may
+	// have. Larger ones are rejected with an SdpLimitError before they are
+	// applied and the peer closes. Defaults to 128, a negative value disables
+	// the limit.
+	MaxRemoteMediaSections int
+	// MaxSDPBytes limits the size of remote descriptions, which are rejected
+	// like MaxRemoteMediaSections, and of created offers and answers, which
+	// fail the negotiation instead of being signaled. Defaults to 1 MiB, a
+	// negative value disables the limit.
+	MaxSDPBytes int
+	// ValidateId checks Id and ChannelName before a connection is created.
+	// The default accepts anything; see UUIDValidator.
+	ValidateId ValidateId
+	// RequiredChannels lists data channel labels the initiator creates in
+	// addition to the main channel. OnAllChannelsReady fires once all of them
+	// are open. If the main channel's label is listed, both peers must use the
+	// same ChannelName.
+	RequiredChannels []string
+	// NegotiatedChannels are created by both peers when the connection is
+	// created and are usable without waiting for the remote peer to announce
+	// them. Both peers must list the same channels.
+	NegotiatedChannels []NegotiatedChannel
+	OnAllChannelsReady OnAllChannelsReady
+	OnFailure          OnFailure
+	OnSignal           OnSignal
+	// OnSignalBytes receives outgoing signal messages encoded with
+	// SignalCodec. It replaces OnSignal when both are set in the same
+	// PeerOptions.
+	OnSignalBytes OnSignalBytes
+	// SignalCodec encodes the signal messages of OnSignalBytes and decodes
+	// those passed to SignalBytes, JSONSignalCodec by default.
+	SignalCodec   SignalCodec
 	OnConnect     OnConnect
 	OnData        OnData
 	OnError       OnError
 	OnClose       OnClose
 	OnTransceiver OnTransceiver
 	OnTrack       OnTrack
+	// SynchronousData runs the data handlers, like OnData and OnJSON, in
+	// order on the data channel's goroutine, so they see messages in the
+	// order they arrived and a slow handler delays the messages after it.
+	// Otherwise every handler runs on a goroutine of its own with its own
+	// copy of the message's data. EnableV2Defaults turns it on.
+	SynchronousData bool
+	// OnTransceiverDirectionChange is called when a renegotiation changes
+	// the remote direction of a transceiver, see
+	// Peer.OnTransceiverDirectionChange.
+	OnTransceiverDirectionChange OnTransceiverDirectionChange
+	// OnTrackEnded is called when a remote track ends, see
+	// Peer.OnTrackEnded.
+	OnTrackEnded OnTrackEnded
+	// OnTrackMetadata is called when the remote peer sets the metadata of a
+	// track, see Peer.SetTrackMetadata.
+	OnTrackMetadata OnTrackMetadata
+	// SignalTrackMetadata lets SetTrackMetadata and AddTrackWithMetadata
+	// send trackMetadata signal messages. simple-peer fails on the unknown
+	// message type, so leave it unset when talking to simple-peer. Without
+	// it both return ErrTrackMetadataDisabled.
+	SignalTrackMetadata bool
+	// RequestKeyFrameOnTrack requests a keyframe for every remote track as
+	// it arrives, see RequestKeyFrame.
+	RequestKeyFrameOnTrack bool
+	// AutoReconnect replaces a disconnected or failed connection instead of
+	// closing the peer. The initiator creates a new connection, re-adds the
+	// tracks of the old one and negotiates it through OnSignal, the other
+	// peer waits for its offer. Both peers should enable it. The other peer
+	// also waits when the initiator closes the connection, since it cannot
+	// tell that apart from a replaced one, unless the initiator uses SendBye.
+	AutoReconnect bool
+	// MaxRetries is how many replacement connections AutoReconnect tries in
+	// a row before the peer closes. Defaults to 5.
+	MaxRetries int
+	// Backoff is how long the initiator waits before the first replacement,
+	// doubled for every further one. Defaults to one second.
+	Backoff        time.Duration
+	OnReconnecting OnReconnecting
+	OnReconnected  OnReconnected
+	// DisconnectedTimeout is how long a disconnected connection may take to
+	// recover before it is given up like a failed one. OnDisconnect and
+	// OnResume mark the gap. Defaults to five seconds, a negative value
+	// gives up at once.
+	DisconnectedTimeout time.Duration
+	OnDisconnect        OnDisconnect
+	OnResume            OnResume
+	// OnRemoteId is called once the id of the remote peer is known.
+	OnRemoteId OnRemoteId
+	// SendBye makes Close signal a bye message, on which the remote peer
+	// closes at once instead of when its connection times out. simple-peer
+	// rejects the unknown type, so only enable it if the remote peer is
+	// this package.
+	SendBye bool
+	// OnConnectionStateChange is called for every state of the current
+	// connection, see Peer.OnConnectionStateChange.
+	OnConnectionStateChange OnConnectionStateChange
+	// OnICEConnectionStateChange and OnICEGatheringStateChange are called
+	// for the ICE states of the current connection.
+	OnICEConnectionStateChange OnICEConnectionStateChange
+	OnICEGatheringStateChange  OnICEGatheringStateChange
+	// OnSignalingStateChange is called for the signaling states of the
+	// current connection, which NegotiationHistory also records.
+	OnSignalingStateChange OnSignalingStateChange
+	// OnNegotiationComplete is called when an offer/answer exchange settles
+	// without a renegotiation queued behind it, see Peer.Negotiating.
+	OnNegotiationComplete OnNegotiationComplete
+	// OnSample receives the media samples of remote tracks, see
+	// Peer.OnSample.
+	OnSample OnSample
+	// SampleBufferDepth is how many packets OnSample holds back to reorder
+	// them and wait for the rest of a sample. Defaults to 64.
+	SampleBufferDepth int
+	// ReaderBufferSize is how many received bytes every Reader buffers until
+	// they are read. Defaults to 1 MiB.
+	ReaderBufferSize int
+	// ReaderOverflow is what a Reader does with data that does not fit its
+	// buffer, see ReaderOverflowPolicy.
+	ReaderOverflow ReaderOverflowPolicy
+	// BufferWritesUntilOpen queues writes made before the main data channel
+	// is open, e.g. between Init and OnConnect, and sends them in order once
+	// it opens. Writes made while queued writes are sent queue behind them.
+	// Without it such writes return ErrChannelNotOpen.
+	BufferWritesUntilOpen bool
+	// MaxPendingWriteBytes bounds the bytes BufferWritesUntilOpen queues.
+	// Writes that do not fit return ErrSendBufferFull. Defaults to 1 MiB.
+	MaxPendingWriteBytes int
+	// MaxPendingSignals bounds the signal messages queued until the
+	// signaling state allows applying them. Further messages are dropped and
+	// reported through OnError as ErrSignalQueueFull. Defaults to 64.
+	MaxPendingSignals int
+}
+
+// ChannelInfo is a snapshot of the peer's data channel. It holds copies of
+// the channel's properties so it can be read without further synchronization.
+type ChannelInfo struct {
+	Label      string
+	Id         *uint16
+	ReadyState webrtc.DataChannelState
+	Protocol   string
+}
+
+// CandidatePairInfo is a copy of the currently selected ICE candidate pair.
+type CandidatePairInfo struct {
+	Local  webrtc.ICECandidate
+	Remote webrtc.ICECandidate
+}
+
+// ConnectionInfo is a snapshot of the peer's connection states. It holds
+// copies of the connection's properties so it can be read without further
+// synchronization.
+type ConnectionInfo struct {
+	ConnectionState    webrtc.PeerConnectionState
+	ICEConnectionState webrtc.ICEConnectionState
+	ICEGatheringState  webrtc.ICEGatheringState
+	SignalingState     webrtc.SignalingState
+	CandidatePair      *CandidatePairInfo
 }
 
 type Peer struct {
-	id                string
-	initiator         bool
-	channelName       string
-	channelConfig     *webrtc.DataChannelInit
-	channel           *webrtc.DataChannel
-	config            webrtc.Configuration
-	connection        *webrtc.PeerConnection
-	offerConfig       *webrtc.OfferOptions
-	answerConfig      *webrtc.AnswerOptions
-	pendingCandidates cslice.CSlice[webrtc.ICECandidateInit]
-	onSignal          atomicvalue.AtomicValue[OnSignal]
-	onConnect         cslice.CSlice[OnConnect]
-	onData            cslice.CSlice[OnData]
-	onError           cslice.CSlice[OnError]
-	onClose           cslice.CSlice[OnClose]
-	onTransceiver     cslice.CSlice[OnTransceiver]
-	onTrack           cslice.CSlice[OnTrack]
+	id                          string
+	initiator                   atomic.Bool
+	channelName                 string
+	channelConfig               *webrtc.DataChannelInit
+	channelReliability          ChannelReliability
+	channelConfigErr            error
+	mutex                       sync.RWMutex
+	parentContext               context.Context
+	context                     context.Context
+	cancel                      context.CancelCauseFunc
+	channel                     *webrtc.DataChannel
+	channels                    map[string]*webrtc.DataChannel
+	channelsChanged             chan struct{}
+	requiredChannels            []string
+	negotiatedChannels          []NegotiatedChannel
+	validateId                  ValidateId
+	operationTimeout            time.Duration
+	traceMessages               bool
+	logSdp                      bool
+	logger                      *slog.Logger
+	onLogEvent                  handlers[OnLogEvent]
+	sdpTransform                SdpTransform
+	candidateRewrite            CandidateRewrite
+	remoteSdpTransform          SdpTransform
+	allChannelsReady            bool
+	config                      webrtc.Configuration
+	iceServersProvider          ICEServersProvider
+	api                         *webrtc.API
+	apiErr                      error
+	connection                  *webrtc.PeerConnection
+	offerConfig                 *webrtc.OfferOptions
+	answerConfig                *webrtc.AnswerOptions
+	pendingRemoteCandidates     cslice.CSlice[webrtc.ICECandidateInit]
+	pendingLocalCandidates      cslice.CSlice[webrtc.ICECandidateInit]
+	pendingSignals              cslice.CSlice[SignalMessage]
+	maxPendingSignals           int
+	candidateBatch              candidateBatch
+	oversizePolicy              OversizePolicy
+	bufferedAmountHighThreshold uint64
+	bufferedAmountLowThreshold  uint64
+	bufferedAmountLow           chan struct{}
+	maxBufferedBytes            uint64
+	sendBufferPolicy            SendBufferPolicy
+	sendBuffer                  sendBuffer
+	onDrain                     handlers[OnDrain]
+	frameMessages               bool
+	signalTrackMetadata         bool
+	orderedDispatch             bool
+	copyOnReceive               bool
+	strictErrors                bool
+	sendBye                     bool
+	sendMutex                   sync.Mutex
+	frameReader                 frameReader
+	messageQueue                messageQueue
+	outgoingSignals             outgoingSignals
+	negotiations                negotiationScheduler
+	renegotiations              renegotiationLimiter
+	maxRemoteMediaSections      int
+	maxSdpBytes                 int
+	closeOnRenegotiationStorm   bool
+	pendingNegotiation          atomic.Bool
+	writeDeadline               time.Time
+	maxChannelMessageSize       int
+	negotiatedMessageSize       atomic.Int64
+	onSignal                    handlers[OnSignal]
+	onSignalBytes               handlers[OnSignalBytes]
+	onConnect                   handlers[OnConnect]
+	onData                      handlers[OnData]
+	onDataFrom                  handlers[labeledOnData]
+	onDataMessage               handlers[OnDataMessage]
+	onMessage                   handlers[OnMessage]
+	onJSON                      handlers[OnJSON]
+	messageReaders              cslice.CSlice[*MessageReader]
+	readers                     cslice.CSlice[*peerReader]
+	bufferWritesUntilOpen       bool
+	pendingWrites               pendingWrites
+	// reader backs Read, created on its first call
+	readerOnce                   sync.Once
+	reader                       *peerReader
+	mux                          *Mux
+	files                        fileTransfers
+	metrics                      metrics
+	reconnects                   reconnector
+	disconnects                  disconnectGrace
+	onDisconnect                 handlers[OnDisconnect]
+	onResume                     handlers[OnResume]
+	destroyed                    error
+	onReconnecting               handlers[OnReconnecting]
+	onReconnected                handlers[OnReconnected]
+	onConnectionState            handlers[OnConnectionStateChange]
+	onICEConnectionState         handlers[OnICEConnectionStateChange]
+	onICEGatheringState          handlers[OnICEGatheringStateChange]
+	onSignalingState             handlers[OnSignalingStateChange]
+	onNegotiationComplete        handlers[OnNegotiationComplete]
+	remoteId                     string
+	onRemoteId                   handlers[OnRemoteId]
+	onFileOffer                  handlers[OnFileOffer]
+	onError                      handlers[OnError]
+	onClose                      handlers[OnClose]
+	onChannelClose               handlers[OnClose]
+	onTransceiver                handlers[OnTransceiver]
+	onTrack                      handlers[OnTrack]
+	onTrackForMid                handlers[midOnTrack]
+	onSample                     handlers[OnSample]
+	onTransceiverDirectionChange handlers[OnTransceiverDirectionChange]
+	onTrackEnded                 handlers[OnTrackEnded]
+	onTrackMetadata              handlers[OnTrackMetadata]
+	signalCodec                  SignalCodec
+	requestKeyFrameOnTrack       bool
+	sampleBufferDepth            int
+	readerBufferSize             int
+	readerOverflow               ReaderOverflowPolicy
+	sampleReaders                sync.WaitGroup
+	onAllChannelsReady           handlers[OnAllChannelsReady]
+	onFailure                    handlers[OnFailure]
+	attempt                      connectionAttempt
+	pathUsage                    pathUsage
+	// callbackMutex orders replayable events against late registrations so
+	// each callback sees an event exactly once.
+	callbackMutex sync.Mutex
+	// createMutex serializes creating connections, so concurrent signals
+	// create one connection. signalingMutex serializes the changes of the
+	// local and remote descriptions. Neither is held while signaling.
+	createMutex    sync.Mutex
+	signalingMutex sync.Mutex
+	connected      bool
+	closed         bool
+	remoteTracks   []remoteTrack
+	// simulcastLayers are the layers of the simulcast senders of the
+	// connection, guarded by mutex.
+	simulcastLayers map[*webrtc.RTPSender][]*SimulcastLayer
+	// forwards are the remote tracks forwarded by ForwardTrack, guarded by
+	// mutex.
+	forwards map[*webrtc.TrackRemote]*trackForward
+	// streamStats reads the RTP stream statistics of the connection,
+	// guarded by mutex.
+	streamStats stats.Getter
+	// pausedTracks are the tracks of the transceivers paused by
+	// SetTransceiverDirection and remoteDirections the transceiver
+	// directions of the remote description by mid, both guarded by mutex.
+	pausedTracks     map[*webrtc.RTPTransceiver]pausedTrack
+	remoteDirections map[string]webrtc.RTPTransceiverDirection
+	// requestedTransceivers are the transceivers of tracks the non-initiator
+	// added that it asked the initiator to offer, guarded by mutex.
+	requestedTransceivers map[*webrtc.RTPTransceiver]bool
+	// remoteTrackMetadata is the metadata of the remote peer's tracks by
+	// track ID, guarded by mutex.
+	remoteTrackMetadata map[string]map[string]string
+	// operationSlot is held by the running operation of the connection,
+	// guarded by mutex.
+	operationSlot chan struct{}
+	// beforeOperation lets tests stall the pion calls guarded by
+	// operationTimeout.
+	beforeOperation func(operation string)
 }
 
 func NewPeer(options ...PeerOptions) *Peer {
 	peer := Peer{
+		parentContext:               context.Background(),
+		channelsChanged:             make(chan struct{}),
+		bufferedAmountLow:           make(chan struct{}),
+		bufferedAmountHighThreshold: defaultBufferedAmountHighThreshold,
+		bufferedAmountLowThreshold:  defaultBufferedAmountLowThreshold,
 		config: webrtc.Configuration{
 			ICEServers: []webrtc.ICEServer{},
 		},
 	}
+	if V2DefaultsEnabled() {
+		peer.orderedDispatch = true
+		peer.copyOnReceive = true
+		peer.strictErrors = true
+	}
+	var apiOptions apiOptions
 	for _, option := range options {
 		if option.Id != "" {
 			peer.id = option.Id
 		}
+		if option.Context != nil {
+			peer.parentContext = option.Context
+		}
 		if option.ChannelName != "" {
 			peer.channelName = option.ChannelName
 		}
 		if option.ChannelConfig != nil {
 			peer.channelConfig = option.ChannelConfig
 		}
+		if option.ChannelReliability != ChannelReliable {
+			peer.channelReliability = option.ChannelReliability
+		}
+		if len(option.Tracks) > 0 {
+			peer.reconnects.tracks = append([]webrtc.TrackLocal{}, option.Tracks...)
+		}
 		if option.Config != nil {
 			peer.config = *option.Config
 		}
+		if option.ICEServersProvider != nil {
+			peer.iceServersProvider = option.ICEServersProvider
+		}
+		if option.WebRTCAPI != nil {
+			apiOptions.api = option.WebRTCAPI
+		}
+		if option.MediaEngine != nil {
+			apiOptions.mediaEngine = option.MediaEngine
+		}
+		if option.SettingEngine != nil {
+			apiOptions.settingEngine = option.SettingEngine
+		}
+		if option.InterceptorRegistry != nil {
+			apiOptions.interceptorRegistry = option.InterceptorRegistry
+		}
+		if option.EnableDefaultInterceptors {
+			apiOptions.defaultInterceptors = true
+		}
 		if option.AnswerConfig != nil {
 			peer.answerConfig = option.AnswerConfig
 		}
 		if option.OfferConfig != nil {
 			peer.offerConfig = option.OfferConfig
 		}
-		if option.OnSignal != nil {
-			peer.onSignal.Store(option.OnSignal)
+		if option.CandidateBatchInterval > 0 {
+			peer.candidateBatch.interval = option.CandidateBatchInterval
+		}
+		if option.OversizePolicy != OversizePolicyChunk {
+			peer.oversizePolicy = option.OversizePolicy
+		}
+		if option.MaxChannelMessageSize > 0 {
+			peer.maxChannelMessageSize = option.MaxChannelMessageSize
+		}
+		if option.FrameMessages {
+			peer.frameMessages = true
+		}
+		if option.SignalTrackMetadata {
+			peer.signalTrackMetadata = true
+		}
+		if option.BufferedAmountHighThreshold > 0 {
+			peer.bufferedAmountHighThreshold = option.BufferedAmountHighThreshold
+		}
+		if option.BufferedAmountLowThreshold > 0 {
+			peer.bufferedAmountLowThreshold = option.BufferedAmountLowThreshold
+		}
+		if option.MaxBufferedBytes > 0 {
+			peer.maxBufferedBytes = option.MaxBufferedBytes
+		}
+		if option.SendBufferPolicy != SendBufferPolicyError {
+			peer.sendBufferPolicy = option.SendBufferPolicy
+		}
+		if option.OnDrain != nil {
+			peer.onDrain.Append(option.OnDrain)
+		}
+		if option.SignalRetryCount > 0 {
+			peer.outgoingSignals.retryCount = option.SignalRetryCount
+		}
+		if option.SignalRetryBackoff > 0 {
+			peer.outgoingSignals.backoff = option.SignalRetryBackoff
+		}
+		if option.CandidateRewrite != nil {
+			peer.candidateRewrite = option.CandidateRewrite
+		}
+		if option.SdpTransform != nil {
+			peer.sdpTransform = option.SdpTransform
+		}
+		if option.RemoteSdpTransform != nil {
+			peer.remoteSdpTransform = option.RemoteSdpTransform
+		}
+		if option.TraceMessages {
+			peer.traceMessages = true
+		}
+		if option.LogSDP {
+			peer.logSdp = true
+		}
+		if option.Logger != nil {
+			peer.logger = option.Logger
+		}
+		if option.OnLogEvent != nil {
+			peer.onLogEvent.Append(option.OnLogEvent)
+		}
+		if option.OperationTimeout > 0 {
+			peer.operationTimeout = option.OperationTimeout
+		}
+		if option.NegotiationDebounce > 0 {
+			peer.negotiations.debounce = option.NegotiationDebounce
+		}
+		if option.MaxRenegotiationsPerMinute != 0 {
+			peer.renegotiations.max = option.MaxRenegotiationsPerMinute
+		}
+		if option.CloseOnRenegotiationStorm {
+			peer.closeOnRenegotiationStorm = true
+		}
+		if option.MaxRemoteMediaSections != 0 {
+			peer.maxRemoteMediaSections = option.MaxRemoteMediaSections
+		}
+		if option.MaxSDPBytes != 0 {
+			peer.maxSdpBytes = option.MaxSDPBytes
+		}
+		if option.ValidateId != nil {
+			peer.validateId = option.ValidateId
+		}
+		if len(option.RequiredChannels) > 0 {
+			peer.requiredChannels = option.RequiredChannels
+		}
+		if len(option.NegotiatedChannels) > 0 {
+			peer.negotiatedChannels = option.NegotiatedChannels
+		}
+		if option.OnAllChannelsReady != nil {
+			peer.onAllChannelsReady.Append(option.OnAllChannelsReady)
+		}
+		if option.OnFailure != nil {
+			peer.onFailure.Append(option.OnFailure)
+		}
+		if option.AutoReconnect {
+			peer.reconnects.enabled = true
+		}
+		if option.MaxRetries > 0 {
+			peer.reconnects.maxRetries = option.MaxRetries
+		}
+		if option.Backoff > 0 {
+			peer.reconnects.backoff = option.Backoff
+		}
+		if option.OnReconnecting != nil {
+			peer.onReconnecting.Append(option.OnReconnecting)
+		}
+		if option.OnReconnected != nil {
+			peer.onReconnected.Append(option.OnReconnected)
+		}
+		if option.DisconnectedTimeout != 0 {
+			peer.disconnects.timeout = option.DisconnectedTimeout
+		}
+		if option.OnDisconnect != nil {
+			peer.onDisconnect.Append(option.OnDisconnect)
+		}
+		if option.OnResume != nil {
+			peer.onResume.Append(option.OnResume)
+		}
+		if option.OnRemoteId != nil {
+			peer.onRemoteId.Append(option.OnRemoteId)
+		}
+		if option.SendBye {
+			peer.sendBye = true
+		}
+		if option.OnConnectionStateChange != nil {
+			peer.onConnectionState.Append(option.OnConnectionStateChange)
+		}
+		if option.OnICEConnectionStateChange != nil {
+			peer.onICEConnectionState.Append(option.OnICEConnectionStateChange)
+		}
+		if option.OnICEGatheringStateChange != nil {
+			peer.onICEGatheringState.Append(option.OnICEGatheringStateChange)
+		}
+		if option.OnSignalingStateChange != nil {
+			peer.onSignalingState.Append(option.OnSignalingStateChange)
+		}
+		if option.OnNegotiationComplete != nil {
+			peer.onNegotiationComplete.Append(option.OnNegotiationComplete)
+		}
+		if option.OnSample != nil {
+			peer.onSample.Append(option.OnSample)
+		}
+		if option.OnTransceiverDirectionChange != nil {
+			peer.onTransceiverDirectionChange.Append(option.OnTransceiverDirectionChange)
+		}
+		if option.OnTrackEnded != nil {
+			peer.onTrackEnded.Append(option.OnTrackEnded)
+		}
+		if option.OnTrackMetadata != nil {
+			peer.onTrackMetadata.Append(option.OnTrackMetadata)
+		}
+		if option.SampleBufferDepth != 0 {
+			peer.sampleBufferDepth = option.SampleBufferDepth
+		}
+		if option.ReaderBufferSize != 0 {
+			peer.readerBufferSize = option.ReaderBufferSize
+		}
+		if option.ReaderOverflow != ReaderOverflowBlock {
+			peer.readerOverflow = option.ReaderOverflow
+		}
+		if option.BufferWritesUntilOpen {
+			peer.bufferWritesUntilOpen = true
+		}
+		if option.MaxPendingWriteBytes > 0 {
+			peer.pendingWrites.limit = option.MaxPendingWriteBytes
+		}
+		if option.MaxPendingSignals > 0 {
+			peer.maxPendingSignals = option.MaxPendingSignals
+		}
+		if option.OnSignalBytes != nil {
+			peer.OnSignalBytes(option.OnSignalBytes)
+		} else if option.OnSignal != nil {
+			peer.onSignal.Append(option.OnSignal)
+		}
+		if option.SignalCodec != nil {
+			peer.signalCodec = option.SignalCodec
 		}
 		if option.OnConnect != nil {
 			peer.onConnect.Append(option.OnConnect)
@@ -116,6 +837,9 @@ func NewPeer(options ...PeerOptions) *Peer {
 		if option.OnData != nil {
 			peer.onData.Append(option.OnData)
 		}
+		if option.SynchronousData {
+			peer.orderedDispatch = true
+		}
 		if option.OnError != nil {
 			peer.onError.Append(option.OnError)
 		}
@@ -128,453 +852,1670 @@ func NewPeer(options ...PeerOptions) *Peer {
 		if option.OnTrack != nil {
 			peer.onTrack.Append(option.OnTrack)
 		}
+		if option.RequestKeyFrameOnTrack {
+			peer.requestKeyFrameOnTrack = true
+		}
 	}
 	if peer.channelName == "" {
 		peer.channelName = uuid.New().String()
 	}
+	peer.api, peer.apiErr = apiOptions.build(func(_ string, getter stats.Getter) {
+		peer.mutex.Lock()
+		peer.streamStats = getter
+		peer.mutex.Unlock()
+	})
+	peer.channelConfig, peer.channelConfigErr = channelConfigFor(peer.channelConfig, peer.channelReliability)
+	if peer.channelConfigErr == nil {
+		peer.channelConfigErr = validateNegotiatedChannels(peer.negotiatedChannels)
+	}
+	if peer.maxRemoteMediaSections == 0 {
+		peer.maxRemoteMediaSections = defaultMaxRemoteMediaSections
+	}
+	if peer.maxSdpBytes == 0 {
+		peer.maxSdpBytes = defaultMaxSdpBytes
+	}
+	if peer.renegotiations.max == 0 {
+		peer.renegotiations.max = defaultMaxRenegotiationsPerMinute
+	}
+	if peer.disconnects.timeout == 0 {
+		peer.disconnects.timeout = defaultDisconnectedTimeout
+	}
+	if peer.sampleBufferDepth <= 0 {
+		peer.sampleBufferDepth = defaultSampleBufferDepth
+	}
+	if peer.readerBufferSize <= 0 {
+		peer.readerBufferSize = defaultReaderBufferSize
+	}
+	if peer.pendingWrites.limit == 0 {
+		peer.pendingWrites.limit = defaultMaxPendingWriteBytes
+	}
+	if peer.maxPendingSignals == 0 {
+		peer.maxPendingSignals = defaultMaxPendingSignals
+	}
+	if peer.signalCodec == nil {
+		peer.signalCodec = JSONSignalCodec
+	}
+	if peer.maxBufferedBytes > 0 && peer.bufferedAmountHighThreshold > peer.maxBufferedBytes {
+		peer.bufferedAmountHighThreshold = peer.maxBufferedBytes
+	}
+	if peer.maxBufferedBytes > 0 && peer.bufferedAmountLowThreshold >= peer.maxBufferedBytes {
+		peer.bufferedAmountLowThreshold = peer.maxBufferedBytes / 2
+	}
+	if peer.bufferedAmountLowThreshold > peer.bufferedAmountHighThreshold {
+		peer.bufferedAmountLowThreshold = peer.bufferedAmountHighThreshold
+	}
+	if peer.outgoingSignals.backoff == 0 {
+		peer.outgoingSignals.backoff = defaultSignalRetryBackoff
+	}
+	if peer.reconnects.maxRetries == 0 {
+		peer.reconnects.maxRetries = defaultReconnectMaxRetries
+	}
+	if peer.reconnects.backoff == 0 {
+		peer.reconnects.backoff = defaultReconnectBackoff
+	}
 	if peer.id == "" {
 		peer.id = uuid.New().String()
 	}
+	if peer.logger != nil {
+		peer.logger = peer.logger.With(slog.String(LogKeyPeer, peer.id))
+	}
+	peer.context, peer.cancel = context.WithCancelCause(peer.parentContext)
+	context.AfterFunc(peer.parentContext, func() {
+		peer.shutdown(context.Cause(peer.parentContext), false)
+	})
 	return &peer
 }
 
+// Context returns the peer's lifecycle context. It is done once the peer
+// closes and context.Cause reports why, e.g. ErrPeerClosed after Close.
+func (peer *Peer) Context() context.Context {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.context
+}
+
 func (peer *Peer) Id() string {
 	return peer.id
 }
 
+// ChannelName returns the label of the main data channel, a generated UUID
+// unless PeerOptions.ChannelName was set.
+func (peer *Peer) ChannelName() string {
+	return peer.channelName
+}
+
+// MaxChannelMessageSize returns PeerOptions.MaxChannelMessageSize, zero if
+// it was not set. MaxMessageSize returns the size in effect.
+func (peer *Peer) MaxChannelMessageSize() int {
+	return peer.maxChannelMessageSize
+}
+
+// RemoteId returns the id of the remote peer, sent with its signal
+// messages, or an empty string until it is known or if the remote peer
+// does not send it, like simple-peer.
+func (peer *Peer) RemoteId() string {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.remoteId
+}
+
+func (peer *Peer) OnRemoteId(fn OnRemoteId) (off func()) {
+	return peer.onRemoteId.Append(fn)
+}
+
+// Deprecated: call the func returned by OnRemoteId instead.
+func (peer *Peer) OffRemoteId(fn OnRemoteId) {
+	peer.onRemoteId.Delete(func(index int, onRemoteId OnRemoteId) bool {
+		return sameFunc(onRemoteId, fn)
+	})
+}
+
+func (peer *Peer) setRemoteId(id string) {
+	peer.mutex.Lock()
+	changed := peer.remoteId != id
+	peer.remoteId = id
+	peer.mutex.Unlock()
+	if !changed {
+		return
+	}
+	peer.debugf("remote id %s", id)
+	for _, fn := range peer.onRemoteId.Slice() {
+		go fn(id)
+	}
+}
+
+// Connection returns the underlying pion connection. The pointer is shared
+// with the peer and becomes stale (or nil) once the peer closes or recreates
+// its connection, so prefer ConnectionInfo unless direct access is required.
 func (peer *Peer) Connection() *webrtc.PeerConnection {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
 	return peer.connection
 }
 
+// Channel returns the underlying pion data channel. The pointer is shared
+// with the peer and becomes stale (or nil) once the peer closes, so prefer
+// ChannelInfo unless direct access is required.
 func (peer *Peer) Channel() *webrtc.DataChannel {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
 	return peer.channel
 }
 
+// ChannelInfo returns a snapshot of the data channel, or false if there is no
+// channel. It is safe to call concurrently with Close.
+func (peer *Peer) ChannelInfo() (ChannelInfo, bool) {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	if peer.channel == nil {
+		return ChannelInfo{}, false
+	}
+	info := ChannelInfo{
+		Label:      peer.channel.Label(),
+		ReadyState: peer.channel.ReadyState(),
+		Protocol:   peer.channel.Protocol(),
+	}
+	if id := peer.channel.ID(); id != nil {
+		idCopy := *id
+		info.Id = &idCopy
+	}
+	return info, true
+}
+
+// ConnectionInfo returns a snapshot of the connection states and selected
+// candidate pair, or false if there is no connection. It is safe to call
+// concurrently with Close.
+func (peer *Peer) ConnectionInfo() (ConnectionInfo, bool) {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	if peer.connection == nil {
+		return ConnectionInfo{}, false
+	}
+	info := ConnectionInfo{
+		ConnectionState:    peer.connection.ConnectionState(),
+		ICEConnectionState: peer.connection.ICEConnectionState(),
+		ICEGatheringState:  peer.connection.ICEGatheringState(),
+		SignalingState:     peer.connection.SignalingState(),
+	}
+	info.CandidatePair = selectedCandidatePair(peer.connection)
+	return info, true
+}
+
+func selectedCandidatePair(connection *webrtc.PeerConnection) *CandidatePairInfo {
+	if sctp := connection.SCTP(); sctp != nil {
+		if dtls := sctp.Transport(); dtls != nil {
+			if ice := dtls.ICETransport(); ice != nil {
+				if pair, err := ice.GetSelectedCandidatePair(); err == nil && pair != nil && pair.Local != nil && pair.Remote != nil {
+					return &CandidatePairInfo{
+						Local:  *pair.Local,
+						Remote: *pair.Remote,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LocalDescription returns the applied local description, or nil before one
+// is set or when there is no connection.
+func (peer *Peer) LocalDescription() *webrtc.SessionDescription {
+	connection := peer.Connection()
+	if connection == nil {
+		return nil
+	}
+	return connection.LocalDescription()
+}
+
+// RemoteDescription returns the applied remote description, or nil before
+// one is set or when there is no connection.
+func (peer *Peer) RemoteDescription() *webrtc.SessionDescription {
+	connection := peer.Connection()
+	if connection == nil {
+		return nil
+	}
+	return connection.RemoteDescription()
+}
+
+// SignalingState returns the connection's signaling state, or
+// webrtc.SignalingStateUnknown when there is no connection.
+func (peer *Peer) SignalingState() webrtc.SignalingState {
+	connection := peer.Connection()
+	if connection == nil {
+		return webrtc.SignalingStateUnknown
+	}
+	return connection.SignalingState()
+}
+
+// ConnectionState returns the connection's state, or
+// webrtc.PeerConnectionStateUnknown when there is no connection. It is safe
+// to call concurrently and before Init.
+func (peer *Peer) ConnectionState() webrtc.PeerConnectionState {
+	connection := peer.Connection()
+	if connection == nil {
+		return webrtc.PeerConnectionStateUnknown
+	}
+	return connection.ConnectionState()
+}
+
+// Connected reports whether the connection is established and the data
+// channel is open, so writes can go through.
+func (peer *Peer) Connected() bool {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.connection != nil && peer.connection.ConnectionState() == webrtc.PeerConnectionStateConnected &&
+		peer.channel != nil && peer.channel.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+// OnConnectionStateChange adds a callback for the state changes of the
+// connection, including the connections AutoReconnect replaces it with. It is
+// called after the peer handled the change, but before a Disconnected,
+// Failed or Closed connection is torn down.
+func (peer *Peer) OnConnectionStateChange(fn OnConnectionStateChange) (off func()) {
+	return peer.onConnectionState.Append(fn)
+}
+
+// Deprecated: call the func returned by OnConnectionStateChange instead.
+func (peer *Peer) OffConnectionStateChange(fn OnConnectionStateChange) {
+	peer.onConnectionState.Delete(func(index int, onConnectionState OnConnectionStateChange) bool {
+		return sameFunc(onConnectionState, fn)
+	})
+}
+
+// OnICEConnectionStateChange adds a callback for the ICE connection state
+// changes of the connection and its replacements. It can be added before
+// the connection exists.
+func (peer *Peer) OnICEConnectionStateChange(fn OnICEConnectionStateChange) (off func()) {
+	return peer.onICEConnectionState.Append(fn)
+}
+
+// Deprecated: call the func returned by OnICEConnectionStateChange instead.
+func (peer *Peer) OffICEConnectionStateChange(fn OnICEConnectionStateChange) {
+	peer.onICEConnectionState.Delete(func(index int, onICEConnectionState OnICEConnectionStateChange) bool {
+		return sameFunc(onICEConnectionState, fn)
+	})
+}
+
+// OnICEGatheringStateChange adds a callback for the ICE gathering state
+// changes of the connection and its replacements.
+func (peer *Peer) OnICEGatheringStateChange(fn OnICEGatheringStateChange) (off func()) {
+	return peer.onICEGatheringState.Append(fn)
+}
+
+// Deprecated: call the func returned by OnICEGatheringStateChange instead.
+func (peer *Peer) OffICEGatheringStateChange(fn OnICEGatheringStateChange) {
+	peer.onICEGatheringState.Delete(func(index int, onICEGatheringState OnICEGatheringStateChange) bool {
+		return sameFunc(onICEGatheringState, fn)
+	})
+}
+
+// isCurrentConnection reports whether events of connection still concern
+// the peer, which they do not once createPeer replaced it.
+func (peer *Peer) isCurrentConnection(connection *webrtc.PeerConnection) bool {
+	current := peer.Connection()
+	return (current == nil || current == connection) && !peer.reconnects.isRetired(connection)
+}
+
 func (peer *Peer) Initiator() bool {
-	return peer.initiator
+	return peer.initiator.Load()
 }
 
+// Write sends bytes on the data channel. It blocks while more than
+// BufferedAmountHighThreshold bytes are waiting to be sent. With
+// MaxBufferedBytes it may instead fail with ErrSendBufferFull. Before the
+// channel is open it fails with ErrChannelNotOpen, unless
+// BufferWritesUntilOpen queues the write; see WaitForChannelOpen.
 func (peer *Peer) Write(bytes []byte) (int, error) {
-	sent := 0
-	if peer.channel == nil {
-		return sent, errConnectionNotInitialized
+	return peer.send(bytes, false)
+}
+
+func (peer *Peer) WriteText(text string) (int, error) {
+	return peer.send([]byte(text), true)
+}
+
+// WriteContext sends bytes like Write, giving up with the context's error if
+// it is done while the write is blocked. The count is the number of bytes
+// handed to the data channel before that. With FrameMessages a frame that
+// has started is always finished so the remote peer can keep reading.
+func (peer *Peer) WriteContext(ctx context.Context, bytes []byte) (int, error) {
+	return peer.sendContext(ctx, bytes, false)
+}
+
+// SetWriteDeadline makes Write, WriteText and the other writes without a
+// context give up with os.ErrDeadlineExceeded once t has passed. The zero
+// time removes the deadline.
+func (peer *Peer) SetWriteDeadline(t time.Time) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.writeDeadline = t
+}
+
+func (peer *Peer) send(bytes []byte, isString bool) (int, error) {
+	peer.mutex.RLock()
+	deadline := peer.writeDeadline
+	peer.mutex.RUnlock()
+	return peer.sendBefore(deadline, bytes, isString)
+}
+
+// sendBefore sends bytes and reports os.ErrDeadlineExceeded if deadline,
+// unless zero, passes while the write is blocked.
+func (peer *Peer) sendBefore(deadline time.Time, bytes []byte, isString bool) (int, error) {
+	if deadline.IsZero() {
+		return peer.sendContext(context.Background(), bytes, isString)
 	}
-	if bytesLeft := len(bytes); bytesLeft > 0 {
-		for bytesLeft > 0 {
-			count := bytesLeft
-			if count > maxChannelMessageSize {
-				count = maxChannelMessageSize
-			}
-			if err := peer.channel.Send(bytes[sent:(sent + count)]); err != nil {
-				return sent, err
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	sent, err := peer.sendContext(ctx, bytes, isString)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = os.ErrDeadlineExceeded
+	}
+	return sent, err
+}
+
+func (peer *Peer) sendContext(ctx context.Context, bytes []byte, isString bool) (int, error) {
+	if err := peer.destroyedErr(); err != nil {
+		return 0, err
+	}
+	if peer.bufferWritesUntilOpen && peer.Context().Err() == nil {
+		if queued, err := peer.pendingWrites.push(peer.Channel(), bytes, isString); queued {
+			if err != nil {
+				return 0, err
 			}
-			bytesLeft -= count
-			sent += count
+			return len(bytes), nil
+		}
+	}
+	return peer.sendOpen(ctx, bytes, isString)
+}
+
+// sendOpen sends bytes on the main channel, which has to be open.
+func (peer *Peer) sendOpen(ctx context.Context, bytes []byte, isString bool) (int, error) {
+	sent := 0
+	channel := peer.Channel()
+	if channel == nil {
+		if peer.Connection() != nil {
+			// the answerer has no channel until the initiator's arrives
+			return sent, ErrChannelNotOpen
+		}
+		return sent, peer.notInitializedErr()
+	}
+	switch channel.ReadyState() {
+	case webrtc.DataChannelStateClosing, webrtc.DataChannelStateClosed:
+		return sent, ErrChannelClosed
+	case webrtc.DataChannelStateConnecting:
+		return sent, ErrChannelNotOpen
+	}
+	maxMessageSize := peer.MaxMessageSize()
+	if peer.oversizePolicy == OversizePolicyError && len(bytes) > maxMessageSize {
+		return sent, ErrMessageTooLarge
+	}
+	release, err := peer.reserveSendBuffer(ctx, channel, len(bytes))
+	if err != nil {
+		return sent, err
+	}
+	defer release()
+	peer.tracef("sending data message length=%d isString=%t", len(bytes), isString)
+	if peer.frameMessages {
+		return peer.sendFramed(ctx, channel, bytes, isString, maxMessageSize)
+	}
+	for sent < len(bytes) {
+		count := chunkLength(bytes[sent:], maxMessageSize, isString)
+		if err := peer.sendChunk(ctx, channel, bytes[sent:(sent+count)], isString); err != nil {
+			return sent, err
 		}
+		sent += count
 	}
 	return sent, nil
 }
 
-func (peer *Peer) Reader() io.ReadCloser {
-	pipeReader, pipeWriter := io.Pipe()
-	onData := func(message webrtc.DataChannelMessage) {
-		pipeWriter.Write(message.Data)
+// chunkLength returns how many bytes of the start of bytes fit in a message
+// of at most limit bytes. Text is cut on a rune boundary so no multi-byte
+// UTF-8 sequence is split between messages.
+func chunkLength(bytes []byte, limit int, isString bool) int {
+	if len(bytes) <= limit {
+		return len(bytes)
 	}
-	onClose := func() {
-		peer.OffData(onData)
-		if err := pipeWriter.Close(); err != nil {
-			peer.error(err)
+	if isString {
+		for count := limit; count > 0; count-- {
+			if utf8.RuneStart(bytes[count]) {
+				return count
+			}
 		}
 	}
-	peer.OnData(onData)
+	return limit
+}
 
-	return &peerReader{
-		peer:       peer,
-		pipeReader: pipeReader,
-		onClose:    onClose,
+func (peer *Peer) sendChunk(ctx context.Context, channel *webrtc.DataChannel, chunk []byte, isString bool) error {
+	if err := peer.waitForBufferedAmount(ctx, channel); err != nil {
+		return err
+	}
+	var err error
+	if isString {
+		err = channel.SendText(string(chunk))
+	} else {
+		err = channel.Send(chunk)
+	}
+	if err != nil {
+		if state := channel.ReadyState(); state == webrtc.DataChannelStateClosing || state == webrtc.DataChannelStateClosed {
+			return ErrChannelClosed
+		}
+		return err
 	}
+	peer.pathUsage.add(len(chunk), 0)
+	peer.metrics.sent(channel.Label(), len(chunk))
+	return nil
+}
+
+// Writer returns an io.WriteCloser that sends on the data channel like
+// Write. Closing it flushes the channel like Flush and detaches the writer
+// without closing the peer. It also has a SetWriteDeadline(time.Time) error
+// method for a deadline of its own.
+func (peer *Peer) Writer() io.WriteCloser {
+	return &peerWriter{peer: peer}
 }
+
 func (peer *Peer) Init() error {
-	peer.initiator = true
+	peer.initiator.Store(true)
 	return peer.createPeer()
 }
 
+// AddTransceiverFromKind adds a transceiver on the initiator and asks the
+// initiator for one otherwise, returning a nil transceiver. Send encodings
+// with rids make it a simulcast sender, see SimulcastLayers.
 func (peer *Peer) AddTransceiverFromKind(kind webrtc.RTPCodecType, init ...webrtc.RTPTransceiverInit) (*webrtc.RTPTransceiver, error) {
-	if peer.connection == nil {
-		return nil, errConnectionNotInitialized
+	connection := peer.Connection()
+	if connection == nil {
+		return nil, peer.notInitializedErr()
 	}
-	if peer.initiator {
-		transceiver, err := peer.connection.AddTransceiverFromKind(kind, init...)
+	if peer.initiator.Load() {
+		// recorded first, pion may fire OnNegotiationNeeded before it returns
+		peer.negotiations.changed()
+		transceiver, err := connection.AddTransceiverFromKind(kind, init...)
 		if err != nil {
 			return nil, err
 		}
+		if len(init) > 0 {
+			if err := peer.addSimulcastLayers(transceiver, init[0].SendEncodings); err != nil {
+				transceiver.Stop()
+				return nil, err
+			}
+		}
 		peer.transceiver(transceiver)
-		return transceiver, peer.needsNegotiation()
+		return transceiver, nil
 	} else {
-		initJSON := make([]map[string]interface{}, 0, len(init))
-		for _, transceiverInit := range init {
-			initJSON = append(initJSON, map[string]interface{}{
-				"direction":     transceiverInit.Direction.String(),
-				"sendEncodings": transceiverInit.SendEncodings,
-			})
-		}
-		err := peer.signal(map[string]interface{}{
-			"type": SignalMessageTransceiverRequest,
-			"transceiverRequest": map[string]interface{}{
-				"kind": kind.String(),
-				"init": initJSON,
+		err := peer.signal(SignalMessage{
+			Type: SignalMessageTransceiverRequest,
+			TransceiverRequest: &SignalMessageTransceiver{
+				Kind: kind,
+				Init: init,
 			},
 		})
 		return nil, err
 	}
 }
 
+// AddTrack adds a local track and negotiates it with the remote peer. It is
+// safe to call while a negotiation is in progress, including from an OnTrack
+// handler; the change is then negotiated once the current offer/answer
+// exchange completes.
 func (peer *Peer) AddTrack(track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
-	if peer.connection == nil {
-		return nil, errConnectionNotInitialized
+	connection := peer.Connection()
+	if connection == nil {
+		return nil, peer.notInitializedErr()
 	}
-	sender, err := peer.connection.AddTrack(track)
-	if err != nil {
-		return nil, err
-	}
-	return sender, peer.needsNegotiation()
+	peer.negotiations.changed()
+	return connection.AddTrack(track)
 }
 
+// OnSignal adds a handler for outgoing signal messages. Every handler is
+// called in registration order and their errors are joined, so a message
+// that is retried after any handler fails is delivered to all of them again.
 func (peer *Peer) OnSignal(fn OnSignal) {
-	peer.onSignal.Store(fn)
+	peer.SubscribeSignal(fn)
+}
+
+// SubscribeSignal is OnSignal returning a func that removes the handler.
+func (peer *Peer) SubscribeSignal(fn OnSignal) (off func()) {
+	return peer.onSignal.Append(fn)
+}
+
+// Deprecated: call the func returned by SubscribeSignal instead.
+func (peer *Peer) OffSignal(fn OnSignal) {
+	peer.onSignal.Delete(func(index int, onSignal OnSignal) bool {
+		return sameFunc(onSignal, fn)
+	})
+}
+
+// OnSignalBytes adds a handler for outgoing signal messages encoded with
+// PeerOptions.SignalCodec. Messages are encoded once for all handlers,
+// which are called after those of OnSignal.
+func (peer *Peer) OnSignalBytes(fn OnSignalBytes) (off func()) {
+	return peer.onSignalBytes.Append(fn)
+}
+
+// OnConnect adds a callback for when the data channel opens. Registered on
+// an already connected peer it fires at once unless SkipReplay is set.
+func (peer *Peer) OnConnect(fn OnConnect, options ...CallbackOptions) {
+	peer.SubscribeConnect(fn, options...)
 }
 
-func (peer *Peer) OnConnect(fn OnConnect) {
-	peer.onConnect.Append(fn)
+// SubscribeConnect is OnConnect returning a func that removes the callback.
+func (peer *Peer) SubscribeConnect(fn OnConnect, options ...CallbackOptions) (off func()) {
+	peer.callbackMutex.Lock()
+	off = peer.onConnect.Append(fn)
+	replay := peer.connected && !skipReplay(options)
+	peer.callbackMutex.Unlock()
+	if replay {
+		go fn()
+	}
+	return off
 }
 
+// Deprecated: call the func returned by SubscribeConnect instead.
 func (peer *Peer) OffConnect(fn OnConnect) {
 	peer.onConnect.Delete(func(index int, onConnect OnConnect) bool {
-		return &onConnect == &fn
+		return sameFunc(onConnect, fn)
 	})
 }
 
 func (peer *Peer) OnData(fn OnData) {
-	peer.onData.Append(fn)
+	peer.SubscribeData(fn)
+}
+
+// SubscribeData is OnData returning a func that removes the handler.
+func (peer *Peer) SubscribeData(fn OnData) (off func()) {
+	return peer.onData.Append(fn)
 }
 
+// Deprecated: call the func returned by SubscribeData instead.
 func (peer *Peer) OffData(fn OnData) {
 	peer.onData.Delete(func(index int, onData OnData) bool {
-		return &onData == &fn
+		return sameFunc(onData, fn)
 	})
 }
 
 func (peer *Peer) OnError(fn OnError) {
-	peer.onError.Append(fn)
+	peer.SubscribeError(fn)
 }
 
+// SubscribeError is OnError returning a func that removes the handler.
+func (peer *Peer) SubscribeError(fn OnError) (off func()) {
+	return peer.onError.Append(fn)
+}
+
+// Deprecated: call the func returned by SubscribeError instead.
 func (peer *Peer) OffError(fn OnError) {
 	peer.onError.Delete(func(index int, onError OnError) bool {
-		return &onError == &fn
+		return sameFunc(onError, fn)
 	})
 }
 
-func (peer *Peer) OnClose(fn OnClose) {
-	peer.onClose.Append(fn)
+// OnClose adds a callback for when the peer closes, by Close or on its own.
+// Registered after that happened it fires at once unless SkipReplay is set.
+func (peer *Peer) OnClose(fn OnClose, options ...CallbackOptions) {
+	peer.SubscribeClose(fn, options...)
+}
+
+// SubscribeClose is OnClose returning a func that removes the callback.
+func (peer *Peer) SubscribeClose(fn OnClose, options ...CallbackOptions) (off func()) {
+	peer.callbackMutex.Lock()
+	off = peer.onClose.Append(fn)
+	replay := peer.closed && !skipReplay(options)
+	peer.callbackMutex.Unlock()
+	if replay {
+		go fn()
+	}
+	return off
 }
 
+// Deprecated: call the func returned by SubscribeClose instead.
 func (peer *Peer) OffClose(fn OnClose) {
 	peer.onClose.Delete(func(index int, onClose OnClose) bool {
-		return &onClose == &fn
+		return sameFunc(onClose, fn)
 	})
 }
 
 func (peer *Peer) OnTransceiver(fn OnTransceiver) {
-	peer.onTransceiver.Append(fn)
+	peer.SubscribeTransceiver(fn)
+}
+
+// SubscribeTransceiver is OnTransceiver returning a func that removes the
+// handler.
+func (peer *Peer) SubscribeTransceiver(fn OnTransceiver) (off func()) {
+	return peer.onTransceiver.Append(fn)
 }
 
+// Deprecated: call the func returned by SubscribeTransceiver instead.
 func (peer *Peer) OffTransceiver(fn OnTransceiver) {
 	peer.onTransceiver.Delete(func(index int, onTransceiver OnTransceiver) bool {
-		return &onTransceiver == &fn
+		return sameFunc(onTransceiver, fn)
 	})
 }
 
-func (peer *Peer) OnTrack(fn OnTrack) {
-	peer.onTrack.Append(fn)
+// OnTrack adds a callback for remote tracks without an OnTrackForMid
+// callback. Tracks that already arrived on the current connection are
+// replayed to it unless SkipReplay is set.
+func (peer *Peer) OnTrack(fn OnTrack, options ...CallbackOptions) {
+	peer.SubscribeTrack(fn, options...)
 }
 
-func (peer *Peer) OffTrack(fn OnTrack) {
-	peer.onTrack.Delete(func(index int, onTrack OnTrack) bool {
-		return &onTrack == &fn
-	})
-}
+// SubscribeTrack is OnTrack returning a func that removes the callback.
+func (peer *Peer) SubscribeTrack(fn OnTrack, options ...CallbackOptions) (off func()) {
+	peer.callbackMutex.Lock()
+	off = peer.onTrack.Append(fn)
+	var replay []remoteTrack
+	if !skipReplay(options) {
+		for _, remoteTrack := range peer.remoteTracks {
+			if !peer.hasTrackForMid(remoteTrack.mid) {
+				replay = append(replay, remoteTrack)
+			}
+		}
+	}
+	peer.callbackMutex.Unlock()
+	for _, remoteTrack := range replay {
+		go fn(remoteTrack.track, remoteTrack.receiver)
+	}
+	return off
+}
 
-func (peer *Peer) signal(message map[string]interface{}) error {
-	return peer.onSignal.Load()(message)
+// Deprecated: call the func returned by SubscribeTrack instead.
+func (peer *Peer) OffTrack(fn OnTrack) {
+	peer.onTrack.Delete(func(index int, onTrack OnTrack) bool {
+		return sameFunc(onTrack, fn)
+	})
 }
 
-func (peer *Peer) Signal(message map[string]interface{}) error {
-	if peer.connection == nil {
-		err := peer.createPeer()
+func (peer *Peer) signal(message SignalMessage) error {
+	if peer.outgoingSignals.retryCount == 0 {
+		return peer.sendSignal(message)
+	}
+	peer.outgoingSignals.push(message)
+	return peer.flushSignals(false)
+}
+
+// sendSignal passes a message to the handlers, converting it to the map
+// and the encoded form only if there are handlers for them.
+func (peer *Peer) sendSignal(message SignalMessage) error {
+	message.From = peer.id
+	var errs []error
+	if onSignal := peer.onSignal.Slice(); len(onSignal) > 0 {
+		signalMessage := BuildSignal(message)
+		for _, fn := range onSignal {
+			if err := fn(signalMessage); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if onSignalBytes := peer.onSignalBytes.Slice(); len(onSignalBytes) > 0 {
+		data, err := peer.signalCodec.Marshal(message)
 		if err != nil {
-			return err
+			return errors.Join(append(errs, err)...)
 		}
+		for _, fn := range onSignalBytes {
+			if err := fn(data); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FlushSignals immediately sends signal messages that are waiting to be
+// retried, e.g. after the signaling transport reconnects. It stops at and
+// returns the first error, keeping the failed message queued.
+func (peer *Peer) FlushSignals() error {
+	return peer.flushSignals(true)
+}
+
+func (peer *Peer) flushSignals(manual bool) error {
+	return peer.outgoingSignals.flush(peer.sendSignal, manual, peer.error, func() {
+		peer.flushSignals(false)
+	})
+}
+
+// Signal applies a signal message from the remote peer, creating the
+// connection if there is none. It may be called from any goroutine;
+// concurrent signals, Init and negotiation are serialized per peer.
+func (peer *Peer) Signal(message map[string]interface{}) error {
+	signalMessage, err := ParseSignal(message)
+	if err != nil {
+		peer.debug("invalid signal", slog.Any("error", err))
+		if peer.logSdp {
+			peer.debugf("invalid signal message: %+v", message)
+		}
+		return err
+	}
+	return peer.handleSignal(signalMessage)
+}
+
+// SignalBytes decodes a signal message with PeerOptions.SignalCodec and
+// applies it like Signal. Undecodable input returns an error matching
+// ErrMalformedSignal.
+func (peer *Peer) SignalBytes(data []byte) error {
+	message, err := peer.signalCodec.Unmarshal(data)
+	if err != nil {
+		peer.debugf("invalid signal: %s", err)
+		return err
+	}
+	return peer.handleSignal(message)
+}
+
+func (peer *Peer) handleSignal(message SignalMessage) error {
+	if err := peer.destroyedErr(); err != nil {
+		return err
+	}
+	if message.From != "" {
+		peer.setRemoteId(message.From)
+	}
+	if message.Type == SignalMessageBye {
+		peer.debug("received signal", slog.String(LogKeySignalType, message.Type))
+		peer.reconnects.stop()
+		return peer.shutdown(ErrRemoteClosed, true)
+	}
+	peer.onRemoteReconnected(message)
+	if peer.initiator.Load() && peer.Connection() == nil && peer.reconnects.isReconnecting() && (message.Type == SignalMessageCandidate || message.Type == SignalMessageCandidates) {
+		// the reconnect creates the initiator's next connection, candidates
+		// arriving before it belong to the lost one
+		peer.debug("dropping candidates of a lost connection", slog.String(LogKeySignalType, message.Type))
+		return nil
+	}
+	connection, err := peer.connectionOrCreate()
+	if err != nil {
+		return err
 	}
-	messageType, ok := message["type"].(string)
-	if !ok {
-		return errInvalidSignalMessageType
+	switch message.Type {
+	case SignalMessageCandidate:
+		peer.trace("received signal", candidateAttrs(message.Candidate.Candidate, slog.String(LogKeySignalType, message.Type))...)
+	case SignalMessageCandidates:
+		peer.trace("received signal", slog.String(LogKeySignalType, message.Type), slog.Int("candidates", len(message.Candidates)))
+	default:
+		peer.debug("received signal", slog.String(LogKeySignalType, message.Type))
 	}
-	slog.Debug(fmt.Sprintf("%s: received signal message=%s", peer.id, messageType))
-	switch messageType {
+	switch message.Type {
+	case SignalMessageTrackMetadata:
+		peer.setRemoteTrackMetadata(message.TrackMetadata)
+		return nil
 	case SignalMessageRenegotiate:
+		if !peer.initiator.Load() {
+			if peer.strictErrors {
+				return ErrInvalidSignalState
+			}
+			return nil
+		}
+		if err := peer.renegotiationRequested(); err != nil {
+			return err
+		}
 		return peer.needsNegotiation()
 	case SignalMessageTransceiverRequest:
-		if !peer.initiator {
-			return errInvalidSignalState
-		}
-		transceiverRequestRaw, ok := message["transceiverRequest"].(map[string]interface{})
-		if !ok {
-			return errInvalidSignalMessage
+		if !peer.initiator.Load() {
+			return ErrInvalidSignalState
 		}
-		var kind webrtc.RTPCodecType
-		if kindRaw, ok := transceiverRequestRaw["kind"].(string); ok {
-			kind = webrtc.NewRTPCodecType(kindRaw)
-		} else {
-			return errInvalidSignalMessageType
-		}
-		var init []webrtc.RTPTransceiverInit
-		if initsRaw, ok := transceiverRequestRaw["init"].([]map[string]interface{}); ok {
-			for _, initRaw := range initsRaw {
-				var direction webrtc.RTPTransceiverDirection
-				if directionRaw, ok := initRaw["direction"].(string); ok {
-					direction = webrtc.NewRTPTransceiverDirection(directionRaw)
-				} else {
-					return errInvalidSignalMessage
-				}
-				sendEncodingsRaw, ok := initRaw["sendEncodings"].([]map[string]interface{})
-				if !ok {
-					return errInvalidSignalMessage
-				}
-				sendEncodings := make([]webrtc.RTPEncodingParameters, len(sendEncodingsRaw))
-				for i, sendEncodingRaw := range sendEncodingsRaw {
-					err := fromJSON[webrtc.RTPEncodingParameters](sendEncodingRaw, &sendEncodings[i])
-					if err != nil {
-						return err
-					}
-				}
-				init = append(init, webrtc.RTPTransceiverInit{
-					Direction:     direction,
-					SendEncodings: sendEncodings,
-				})
-			}
+		if err := peer.renegotiationRequested(); err != nil {
+			return err
 		}
-		_, err := peer.AddTransceiverFromKind(kind, init...)
+		_, err := peer.AddTransceiverFromKind(message.TransceiverRequest.Kind, message.TransceiverRequest.Init...)
 		return err
 	case SignalMessageCandidate:
-		candidateJSON, ok := message["candidate"].(map[string]interface{})
-		if !ok {
-			return errInvalidSignalMessage
+		if message.Candidate.Candidate == "" {
+			peer.tracef("received end of candidates")
 		}
-		var candidate webrtc.ICECandidateInit
-		if candidateRaw, ok := candidateJSON["candidate"].(string); ok {
-			candidate.Candidate = candidateRaw
-		} else {
-			return errInvalidSignalMessage
+		return peer.addCandidate(connection, *message.Candidate)
+	case SignalMessageCandidates:
+		var errs []error
+		for _, candidate := range message.Candidates {
+			if err := peer.addCandidate(connection, candidate); err != nil {
+				errs = append(errs, err)
+			}
 		}
-		if sdpMidRaw, ok := candidateJSON["sdpMid"].(string); ok {
-			candidate.SDPMid = &sdpMidRaw
+		return errors.Join(errs...)
+	default:
+		sdp := message.SessionDescription()
+		if err := peer.checkSdp(sdp.SDP, true); err != nil {
+			peer.debugf("rejecting remote sdp: %s", err)
+			peer.shutdown(err, true)
+			return err
 		}
-		if sdpMLineIndexRaw, ok := candidateJSON["sdpMLineIndex"].(float64); ok {
-			sdpMLineIndex := uint16(sdpMLineIndexRaw)
-			candidate.SDPMLineIndex = &sdpMLineIndex
+		peer.signalingMutex.Lock()
+		if sdp.Type == webrtc.SDPTypeOffer && peer.initiator.Load() && connection.RemoteDescription() == nil {
+			if connection.LocalDescription() == nil {
+				// our initial offer is still being created, createOffer
+				// processes the offer once it is
+				peer.queueSignal(message)
+				peer.signalingMutex.Unlock()
+				return nil
+			}
+			if connection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+				peer.signalingMutex.Unlock()
+				return peer.resolveDoubleInitiator(connection, message)
+			}
 		}
-		if usernameFragmentRaw, ok := candidateJSON["usernameFragment"].(string); ok {
-			candidate.UsernameFragment = &usernameFragmentRaw
+		if sdp.Type == webrtc.SDPTypeOffer && peer.initiator.Load() && connection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+			// offer collision: pion cannot roll back our offer, so it is
+			// kept and the remote offer dropped, a remote able to roll back
+			// offers again once stable. Queueing it instead would apply it
+			// after the remote rolled it back. Answerers never offer, they
+			// request changes, which are queued while we negotiate.
+			peer.signalingMutex.Unlock()
+			peer.logAttrs(slog.LevelWarn, "offer collision, dropping remote offer")
+			return nil
 		}
-		if peer.connection.RemoteDescription() == nil {
-			peer.pendingCandidates.Append(candidate)
+		if !canSetRemoteDescription(connection.SignalingState(), sdp.Type) {
+			peer.debugf("queueing signal message=%s in signaling state=%s", message.Type, connection.SignalingState())
+			peer.queueSignal(message)
+			peer.signalingMutex.Unlock()
 			return nil
-		} else {
-			return peer.connection.AddICECandidate(candidate)
-		}
-	case SignalMessageAnswer:
-		fallthrough
-	case SignalMessageOffer:
-		fallthrough
-	case SignalMessagePRAnswer:
-		fallthrough
-	case SignalMessageRollback:
-		sdpRaw, ok := message["sdp"].(string)
-		if !ok {
-			return errInvalidSignalMessage
 		}
-		sdp := webrtc.SessionDescription{
-			Type: webrtc.NewSDPType(messageType),
-			SDP:  sdpRaw,
+		if sdp.Type == webrtc.SDPTypeOffer {
+			peer.negotiations.setState(negotiationAnswering)
 		}
-		slog.Debug(fmt.Sprintf("%s: setting remote sdp", peer.id))
-		if err := peer.connection.SetRemoteDescription(sdp); err != nil {
+		errs, err := peer.setRemoteDescription(connection, sdp)
+		remoteDescription := connection.RemoteDescription()
+		peer.signalingMutex.Unlock()
+		if err != nil {
+			peer.negotiations.setState(negotiationIdle)
 			return err
 		}
-		var errs []error
-		for candidate := range peer.pendingCandidates.Iter() {
-			if err := peer.connection.AddICECandidate(candidate); err != nil {
-				errs = append(errs, err)
-			}
-		}
-		peer.pendingCandidates.Clear()
-		remoteDescription := peer.connection.RemoteDescription()
+		peer.signalPendingLocalCandidates()
 		if remoteDescription == nil {
 			errs = append(errs, webrtc.ErrNoRemoteDescription)
 		} else if remoteDescription.Type == webrtc.SDPTypeOffer {
 			err := peer.createAnswer()
 			if err != nil {
+				peer.negotiations.setState(negotiationIdle)
 				errs = append(errs, err)
 			}
+		} else {
+			peer.processPendingSignals()
+			peer.negotiationSettled()
 		}
 		return errors.Join(errs...)
-	default:
-		slog.Debug(fmt.Sprintf("%s: invalid signal type: %+v", peer.id, message))
-		return errInvalidSignalMessageType
 	}
 }
 
+// setRemoteDescription applies sdp and the candidates that arrived before
+// it, returning the errors of the candidates separately. The caller holds
+// signalingMutex.
+func (peer *Peer) setRemoteDescription(connection *webrtc.PeerConnection, sdp webrtc.SessionDescription) ([]error, error) {
+	peer.debugf("setting remote sdp")
+	if peer.remoteSdpTransform != nil && sdp.Type != webrtc.SDPTypeRollback {
+		sdp.SDP = peer.remoteSdpTransform(sdp.SDP)
+	}
+	peer.debugSdp("remote", sdp)
+	if err := peer.operation(connection, "SetRemoteDescription", func() error {
+		return connection.SetRemoteDescription(sdp)
+	}); err != nil {
+		return nil, err
+	}
+	if sdp.Type != webrtc.SDPTypeRollback {
+		peer.negotiatedMessageSize.Store(int64(remoteMaxMessageSize(sdp.SDP)))
+	}
+	peer.updateRemoteDirections(connection)
+	var errs []error
+	for candidate := range peer.pendingRemoteCandidates.Iter() {
+		if err := connection.AddICECandidate(candidate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	peer.pendingRemoteCandidates.Clear()
+	return errs, nil
+}
+
+func (peer *Peer) addCandidate(connection *webrtc.PeerConnection, candidate webrtc.ICECandidateInit) error {
+	peer.attempt.addRemoteCandidate(candidate)
+	peer.signalingMutex.Lock()
+	defer peer.signalingMutex.Unlock()
+	if connection.RemoteDescription() == nil {
+		peer.pendingRemoteCandidates.Append(candidate)
+		return nil
+	} else {
+		return connection.AddICECandidate(candidate)
+	}
+}
+
+// Close closes the peer and fires OnClose. Closing a closed peer does nothing
+// and returns nil.
+// A closed peer stays closed, see Reopen.
 func (peer *Peer) Close() error {
-	return peer.close(false)
+	peer.reconnects.stop()
+	if peer.Connection() == nil && peer.Context().Err() != nil {
+		return nil
+	}
+	if peer.sendBye && peer.Connection() != nil {
+		if err := peer.sendSignal(SignalMessage{Type: SignalMessageBye}); err != nil {
+			peer.debugf("failed to signal bye: %s", err)
+		}
+	}
+	return peer.shutdown(ErrPeerClosed, true)
 }
 
-func (peer *Peer) close(triggerCallbacks bool) error {
-	var channelErr, internalChannelErr, connectionErr error
-	if peer.channel != nil {
-		channelErr = peer.channel.Close()
-		peer.channel = nil
+// Reopen makes a closed peer usable again, Init and Signal then create a
+// new connection. Until then the peer stays closed and they return
+// ErrPeerClosed. It does nothing unless the peer closed, and fails after
+// Destroy or once the parent context is done. A PeerManager does not manage
+// a reopened peer again, create a new one for the id instead.
+func (peer *Peer) Reopen() error {
+	if err := peer.destroyedErr(); err != nil {
+		return err
+	}
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if peer.context.Err() == nil {
+		return nil
+	}
+	if peer.parentContext.Err() != nil {
+		return peerClosedErr(peer.parentContext)
+	}
+	peer.debugf("reopening")
+	peer.context, peer.cancel = context.WithCancelCause(peer.parentContext)
+	return nil
+}
+
+// Destroy closes the peer for good. It fires OnError with err, unless nil,
+// and OnClose once, later calls do nothing. Like every callback they run in
+// their own goroutines, so OnClose may run before OnError. Afterwards
+// writes, signals and Init return ErrPeerDestroyed wrapping err, and err is
+// the cause of Context.
+func (peer *Peer) Destroy(err error) error {
+	peer.reconnects.stop()
+	return peer.destroy(err)
+}
+
+func (peer *Peer) destroy(err error) error {
+	destroyed, cause := ErrPeerDestroyed, ErrPeerDestroyed
+	if err != nil {
+		destroyed, cause = fmt.Errorf("%w: %w", ErrPeerDestroyed, err), err
+	}
+	peer.mutex.Lock()
+	if peer.destroyed != nil {
+		peer.mutex.Unlock()
+		return nil
+	}
+	peer.destroyed = destroyed
+	peer.mutex.Unlock()
+	if err != nil {
+		peer.error(err)
 	}
-	if peer.connection != nil {
-		connectionErr = peer.connection.Close()
-		peer.connection = nil
+	return peer.shutdown(cause, true)
+}
+
+// connectionEnded destroys the peer with cause when its connection ends on
+// its own. After Close only the close callbacks are left to fire.
+func (peer *Peer) connectionEnded(cause error) {
+	if peer.Context().Err() != nil {
+		peer.shutdown(cause, true)
+	} else {
+		peer.destroy(cause)
 	}
+}
+
+// destroyedErr returns the error operations on a destroyed peer fail with.
+func (peer *Peer) destroyedErr() error {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.destroyed
+}
+
+// notInitializedErr explains why there is no connection: the peer closed,
+// or it was not initialized yet.
+func (peer *Peer) notInitializedErr() error {
+	if peerContext := peer.Context(); peerContext.Err() != nil {
+		return peerClosedErr(peerContext)
+	}
+	return ErrConnectionNotInitialized
+}
+
+// peerClosedErr returns an error matching ErrPeerClosed and the cause of
+// the peer's context, e.g. ErrRemoteClosed.
+func peerClosedErr(peerContext context.Context) error {
+	cause := context.Cause(peerContext)
+	if errors.Is(cause, ErrPeerClosed) {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", ErrPeerClosed, cause)
+}
+
+func (peer *Peer) shutdown(cause error, triggerCallbacks bool) error {
+	peer.mutex.RLock()
+	cancel := peer.cancel
+	peer.mutex.RUnlock()
+	cancel(cause)
+	peer.pendingWrites.clear()
+	var failure *NegotiationFailure
 	if triggerCallbacks {
-		for fn := range peer.onClose.Iter() {
-			go fn()
+		failure = peer.attempt.fail(peer.Connection(), cause)
+	}
+	return peer.close(triggerCallbacks, failure)
+}
+
+func (peer *Peer) close(triggerCallbacks bool, failure *NegotiationFailure) error {
+	var channelErr, internalChannelErr, connectionErr error
+	peer.mutex.Lock()
+	channel := peer.channel
+	peer.channel = nil
+	channels := peer.channels
+	peer.channels = nil
+	peer.simulcastLayers = nil
+	peer.streamStats = nil
+	peer.pausedTracks = nil
+	peer.remoteDirections = nil
+	peer.requestedTransceivers = nil
+	connection := peer.connection
+	peer.connection = nil
+	peer.mutex.Unlock()
+	if channel != nil {
+		channelErr = channel.Close()
+	}
+	for _, extraChannel := range channels {
+		if extraChannel != channel {
+			extraChannel.Close()
+		}
+	}
+	peer.onChannelStateChange()
+	if connection != nil {
+		connectionErr = connection.Close()
+	}
+	peer.pendingSignals.Clear()
+	peer.pendingRemoteCandidates.Clear()
+	peer.pendingLocalCandidates.Clear()
+	peer.candidateBatch.stop()
+	peer.outgoingSignals.clear()
+	peer.negotiations.stop()
+	peer.disconnects.stop()
+	peer.pathUsage.end(time.Now())
+	peer.callbackMutex.Lock()
+	peer.connected = false
+	endedTracks := peer.remoteTracks
+	peer.remoteTracks = nil
+	var onClose []OnClose
+	var onFailure []OnFailure
+	if triggerCallbacks && !peer.closed {
+		peer.closed = true
+		onClose = peer.onClose.Slice()
+		if failure != nil {
+			onFailure = peer.onFailure.Slice()
 		}
 	}
+	peer.callbackMutex.Unlock()
+	for _, remoteTrack := range endedTracks {
+		peer.trackEnded(remoteTrack.track)
+	}
+	for _, fn := range onFailure {
+		go fn(*failure)
+	}
+	for _, fn := range onClose {
+		go fn()
+	}
 	return errors.Join(channelErr, internalChannelErr, connectionErr)
 }
 
+// connectionOrCreate returns the connection, creating it if there is none.
+func (peer *Peer) connectionOrCreate() (*webrtc.PeerConnection, error) {
+	peer.createMutex.Lock()
+	defer peer.createMutex.Unlock()
+	if connection := peer.Connection(); connection != nil {
+		return connection, nil
+	}
+	if err := peer.newConnection(); err != nil {
+		return nil, err
+	}
+	if connection := peer.Connection(); connection != nil {
+		return connection, nil
+	}
+	return nil, peer.notInitializedErr()
+}
+
+// createPeer replaces the connection with a new one.
 func (peer *Peer) createPeer() error {
-	err := peer.close(false)
+	peer.createMutex.Lock()
+	defer peer.createMutex.Unlock()
+	return peer.newConnection()
+}
+
+func (peer *Peer) newConnection() error {
+	if err := peer.destroyedErr(); err != nil {
+		return err
+	}
+	// a closed peer stays closed, late signals must not bring it back
+	if peerContext := peer.Context(); peerContext.Err() != nil {
+		return peerClosedErr(peerContext)
+	}
+	if err := peer.validateIds(); err != nil {
+		return err
+	}
+	if peer.channelConfigErr != nil {
+		return peer.channelConfigErr
+	}
+	if peer.apiErr != nil {
+		return peer.apiErr
+	}
+	config, err := peer.configuration()
 	if err != nil {
 		return err
 	}
-	slog.Debug(fmt.Sprintf("%s: creating peer", peer.id))
-	peer.connection, err = webrtc.NewPeerConnection(peer.config)
+	err = peer.close(false, nil)
 	if err != nil {
 		return err
 	}
-	peer.connection.OnConnectionStateChange(peer.onConnectionStateChange)
-	peer.connection.OnICECandidate(peer.onICECandidate)
-	peer.connection.OnNegotiationNeeded(peer.onNegotiationNeeded)
-	peer.connection.OnTrack(peer.onTrackRemote)
-	if peer.initiator {
-		peer.channel, err = peer.connection.CreateDataChannel(peer.channelName, peer.channelConfig)
-		if err != nil {
-			return err
+	peer.attempt.reset()
+	peer.pathUsage.reset()
+	peer.negotiatedMessageSize.Store(0)
+	peer.debugf("creating peer")
+	var connection *webrtc.PeerConnection
+	if peer.api != nil {
+		connection, err = peer.api.NewPeerConnection(config)
+	} else {
+		connection, err = webrtc.NewPeerConnection(config)
+	}
+	if err != nil {
+		return err
+	}
+	peer.mutex.Lock()
+	if peer.context.Err() != nil {
+		peerContext := peer.context
+		peer.mutex.Unlock()
+		connection.Close()
+		return peerClosedErr(peerContext)
+	}
+	peer.connection = connection
+	peer.operationSlot = make(chan struct{}, 1)
+	peer.mutex.Unlock()
+	peer.callbackMutex.Lock()
+	peer.closed = false
+	peer.callbackMutex.Unlock()
+	peer.reconnects.resume()
+	connection.OnConnectionStateChange(func(pcs webrtc.PeerConnectionState) {
+		// a connection replaced by createPeer must not tear down its successor
+		if !peer.isCurrentConnection(connection) {
+			return
+		}
+		peer.onConnectionStateChange(pcs)
+	})
+	connection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if !peer.isCurrentConnection(connection) {
+			return
+		}
+		if state == webrtc.ICEConnectionStateConnected || state == webrtc.ICEConnectionStateCompleted {
+			peer.attempt.update(func(attempt *connectionAttempt) {
+				attempt.iceConnected = true
+			})
+		}
+		peer.debug("ice connection state", slog.String(LogKeyState, state.String()))
+		for _, fn := range peer.onICEConnectionState.Slice() {
+			fn(state)
+		}
+	})
+	connection.OnSignalingStateChange(func(state webrtc.SignalingState) {
+		if peer.isCurrentConnection(connection) {
+			peer.onSignalingStateChange(state)
+		}
+	})
+	connection.OnICEGatheringStateChange(func(state webrtc.ICEGatheringState) {
+		if !peer.isCurrentConnection(connection) {
+			return
+		}
+		peer.debug("ice gathering state", slog.String(LogKeyState, state.String()))
+		for _, fn := range peer.onICEGatheringState.Slice() {
+			fn(state)
+		}
+	})
+	connection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		peer.onICECandidate(connection, candidate)
+	})
+	peer.watchSelectedCandidatePair(connection)
+	connection.OnNegotiationNeeded(func() {
+		if peer.isCurrentConnection(connection) {
+			peer.onNegotiationNeeded()
+		}
+	})
+	connection.OnTrack(peer.onTrackRemote)
+	if err := peer.createNegotiatedChannels(); err != nil {
+		return err
+	}
+	if peer.initiator.Load() {
+		if peer.getChannel(peer.channelName) == nil {
+			if err := peer.createChannel(peer.channelName, peer.channelConfig, true); err != nil {
+				return err
+			}
+		}
+		for _, label := range peer.requiredChannels {
+			if peer.getChannel(label) == nil {
+				if err := peer.createChannel(label, nil, false); err != nil {
+					return err
+				}
+			}
 		}
-		peer.channel.OnError(peer.onDataChannelError)
-		peer.channel.OnOpen(peer.onDataChannelOpen)
-		peer.channel.OnMessage(peer.onDataChannelMessage)
 	} else {
-		peer.connection.OnDataChannel(peer.onDataChannel)
+		connection.OnDataChannel(peer.onDataChannel)
 	}
-	slog.Debug(fmt.Sprintf("%s: created peer", peer.id))
+	peer.reattachTracks(connection)
+	peer.debugf("created peer")
 	return nil
 }
 
 func (peer *Peer) needsNegotiation() error {
-	if peer.connection == nil {
-		return errConnectionNotInitialized
+	if peer.Connection() == nil {
+		return peer.notInitializedErr()
 	}
-	if peer.initiator {
-		slog.Debug(fmt.Sprintf("%s: needs negotiation", peer.id))
-		return peer.negotiate()
+	if !peer.negotiations.intent(peer.onNegotiationDebounced) {
+		peer.debugf("needs negotiation, debouncing")
+		return nil
 	}
-	return nil
+	return peer.negotiateWhenStable()
+}
+
+func (peer *Peer) negotiateWhenStable() error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	if connection.SignalingState() != webrtc.SignalingStateStable || peer.negotiations.currentState() != negotiationIdle {
+		peer.debugf("negotiation in progress, queueing negotiation")
+		peer.pendingNegotiation.Store(true)
+		peer.negotiateIfPending()
+		return nil
+	}
+	peer.debugf("needs negotiation")
+	return peer.negotiate()
+}
+
+// negotiateIfPending starts the queued negotiation once stable and reports
+// whether it did.
+func (peer *Peer) negotiateIfPending() bool {
+	if connection := peer.Connection(); connection == nil || connection.SignalingState() != webrtc.SignalingStateStable {
+		return false
+	}
+	if peer.negotiations.currentState() != negotiationIdle || !peer.pendingNegotiation.Swap(false) {
+		return false
+	}
+	if err := peer.negotiate(); err != nil && !errors.Is(err, ErrOperationTimeout) {
+		peer.error(err)
+	}
+	return true
 }
 
 func (peer *Peer) negotiate() error {
-	if peer.connection == nil {
-		return errConnectionNotInitialized
+	if peer.Connection() == nil {
+		return peer.notInitializedErr()
 	}
-	if peer.initiator {
+	peer.negotiations.start()
+	if peer.initiator.Load() {
 		return peer.createOffer()
-	} else {
-		return peer.signal(map[string]interface{}{
-			"type":        SignalMessageRenegotiate,
-			"renegotiate": true,
+	}
+	requested, err := peer.requestTransceivers()
+	if err != nil || requested {
+		return err
+	}
+	return peer.signal(SignalMessage{
+		Type:        SignalMessageRenegotiate,
+		Renegotiate: true,
+	})
+}
+
+// requestTransceivers asks the initiator for a recvonly transceiver for
+// every track added on this side that has no m= section yet, since only
+// the initiator's offers create them. pion gives the offered sections to
+// the waiting transceivers. It returns false if there was nothing to ask.
+func (peer *Peer) requestTransceivers() (bool, error) {
+	connection := peer.Connection()
+	if connection == nil {
+		return false, peer.notInitializedErr()
+	}
+	var requests []*webrtc.RTPTransceiver
+	peer.mutex.Lock()
+	for _, transceiver := range connection.GetTransceivers() {
+		sender := transceiver.Sender()
+		if transceiver.Mid() != "" || sender == nil || sender.Track() == nil || peer.requestedTransceivers[transceiver] {
+			continue
+		}
+		if peer.requestedTransceivers == nil {
+			peer.requestedTransceivers = make(map[*webrtc.RTPTransceiver]bool)
+		}
+		peer.requestedTransceivers[transceiver] = true
+		requests = append(requests, transceiver)
+	}
+	peer.mutex.Unlock()
+	for _, transceiver := range requests {
+		err := peer.signal(SignalMessage{
+			Type: SignalMessageTransceiverRequest,
+			TransceiverRequest: &SignalMessageTransceiver{
+				Kind: transceiver.Kind(),
+				Init: []webrtc.RTPTransceiverInit{{Direction: webrtc.RTPTransceiverDirectionRecvonly}},
+			},
 		})
+		if err != nil {
+			return true, err
+		}
 	}
+	return len(requests) > 0, nil
 }
 
 func (peer *Peer) createOffer() error {
-	if peer.connection == nil {
-		return errConnectionNotInitialized
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	peer.signalingMutex.Lock()
+	if state := connection.SignalingState(); state != webrtc.SignalingStateStable {
+		peer.signalingMutex.Unlock()
+		peer.debug("negotiation in progress, queueing negotiation", slog.String(LogKeyState, state.String()))
+		peer.pendingNegotiation.Store(true)
+		return nil
 	}
-	slog.Debug(fmt.Sprintf("%s: creating offer", peer.id))
-	offer, err := peer.connection.CreateOffer(peer.offerConfig)
+	peer.debug("creating offer", slog.String(LogKeySignalType, SignalMessageOffer))
+	peer.negotiations.setState(negotiationOffering)
+	offer, err := peer.setLocalDescription(connection, "CreateOffer", func() (webrtc.SessionDescription, error) {
+		return connection.CreateOffer(peer.offerConfig)
+	})
+	peer.signalingMutex.Unlock()
 	if err != nil {
+		peer.negotiations.setState(negotiationIdle)
 		return err
 	}
-	if err := peer.connection.SetLocalDescription(offer); err != nil {
-		return err
+	if peer.Connection() != connection {
+		peer.debugf("dropping offer of a replaced connection")
+		return nil
 	}
-	offerJSON, err := toJSON(offer)
-	if err != nil {
+	peer.debug("created offer", slog.String(LogKeySignalType, SignalMessageOffer))
+	if peer.sdpTransform != nil {
+		offer.SDP = peer.sdpTransform(offer.SDP)
+		if err := peer.checkSdp(offer.SDP, false); err != nil {
+			return err
+		}
+	}
+	peer.debugSdp("local", offer)
+	if err := peer.signal(SignalMessage{Type: offer.Type.String(), SDP: offer.SDP}); err != nil {
 		return err
 	}
-	slog.Debug(fmt.Sprintf("%s: created offer", peer.id))
-	return peer.signal(offerJSON)
+	peer.processPendingSignals()
+	return nil
+}
+
+// ResignalOffer signals the pending local offer again, with the candidates
+// gathered so far, e.g. after the signaling transport reconnected and may
+// have lost it. It does nothing unless an offer awaits its answer.
+func (peer *Peer) ResignalOffer() error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	peer.signalingMutex.Lock()
+	var offer *webrtc.SessionDescription
+	if connection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		offer = connection.LocalDescription()
+	}
+	peer.signalingMutex.Unlock()
+	if offer == nil {
+		return nil
+	}
+	sdp := offer.SDP
+	if peer.sdpTransform != nil {
+		sdp = peer.sdpTransform(sdp)
+	}
+	peer.debugf("resignaling offer")
+	return peer.signal(SignalMessage{Type: offer.Type.String(), SDP: sdp})
 }
 
 func (peer *Peer) createAnswer() error {
-	if peer.connection == nil {
-		return errConnectionNotInitialized
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
 	}
-	slog.Debug(fmt.Sprintf("%s: creating answer", peer.id))
-	answer, err := peer.connection.CreateAnswer(peer.answerConfig)
+	peer.debug("creating answer", slog.String(LogKeySignalType, SignalMessageAnswer))
+	peer.signalingMutex.Lock()
+	answer, err := peer.setLocalDescription(connection, "CreateAnswer", func() (webrtc.SessionDescription, error) {
+		return connection.CreateAnswer(peer.answerConfig)
+	})
+	peer.signalingMutex.Unlock()
 	if err != nil {
 		return err
 	}
-	if err := peer.connection.SetLocalDescription(answer); err != nil {
+	// the exchange settled once the answer is applied, even if signaling it
+	// fails
+	defer peer.negotiationSettled()
+	peer.debug("created answer", slog.String(LogKeySignalType, SignalMessageAnswer))
+	if peer.sdpTransform != nil {
+		answer.SDP = peer.sdpTransform(answer.SDP)
+		if err := peer.checkSdp(answer.SDP, false); err != nil {
+			return err
+		}
+	}
+	peer.debugSdp("local", answer)
+	if err := peer.signal(SignalMessage{Type: answer.Type.String(), SDP: answer.SDP}); err != nil {
 		return err
 	}
-	answerJSON, err := toJSON(answer)
-	if err != nil {
+	peer.processPendingSignals()
+	return nil
+}
+
+// setLocalDescription creates an offer or answer with create, named name,
+// and applies it. The caller holds signalingMutex.
+func (peer *Peer) setLocalDescription(connection *webrtc.PeerConnection, name string, create func() (webrtc.SessionDescription, error)) (webrtc.SessionDescription, error) {
+	var description webrtc.SessionDescription
+	if err := peer.operation(connection, name, func() (err error) {
+		description, err = create()
+		return err
+	}); err != nil {
+		// a timed out create still writes description
+		return webrtc.SessionDescription{}, err
+	}
+	if err := peer.checkSdp(description.SDP, false); err != nil {
+		return description, err
+	}
+	err := peer.operation(connection, "SetLocalDescription", func() error {
+		return connection.SetLocalDescription(description)
+	})
+	return description, err
+}
+
+// resolveDoubleInitiator handles an initial offer arriving while our own
+// initial offer is outstanding, which only happens when both peers called
+// Init. The remote id is not known yet, so the offer with the smaller SDP
+// session id wins; its sender stays initiator and the other peer starts over
+// as the answerer, dropping its own data channel.
+func (peer *Peer) resolveDoubleInitiator(connection *webrtc.PeerConnection, message SignalMessage) error {
+	peer.logAttrs(slog.LevelWarn, "both peers are initiators, resolving")
+	peer.error(ErrDoubleInitiator)
+	localDescription := connection.LocalDescription()
+	if localDescription != nil && compareSdpSessions(localDescription.SDP, message.SDP) < 0 {
+		peer.debugf("keeping local offer, dropping remote offer")
+		return nil
+	}
+	peer.debugf("dropping local offer, becoming the answerer")
+	// candidates for the remote offer are still valid for the new connection
+	remoteCandidates := peer.pendingRemoteCandidates.Slice()
+	peer.initiator.Store(false)
+	if err := peer.createPeer(); err != nil {
+		return err
+	}
+	for _, candidate := range remoteCandidates {
+		peer.pendingRemoteCandidates.Append(candidate)
+	}
+	return peer.handleSignal(message)
+}
+
+// compareSdpSessions orders two descriptions by the session id of their
+// o= line, falling back to the whole description when the ids are equal.
+func compareSdpSessions(a, b string) int {
+	aSessionId, bSessionId := sdpSessionId(a), sdpSessionId(b)
+	if len(aSessionId) != len(bSessionId) {
+		return len(aSessionId) - len(bSessionId)
+	}
+	if comparison := strings.Compare(aSessionId, bSessionId); comparison != 0 {
+		return comparison
+	}
+	return strings.Compare(a, b)
+}
+
+func sdpSessionId(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if strings.HasPrefix(line, "o=") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				return fields[1]
+			}
+		}
+	}
+	return ""
+}
+
+// operation runs a pion call on connection that may block, giving up once
+// the operation timeout expires. It waits for the previous operation of the
+// connection to return first, so a call that timed out never overlaps the
+// next one, and a negotiation left pending by the timeout is retried once
+// the call returns.
+func (peer *Peer) operation(connection *webrtc.PeerConnection, name string, fn func() error) error {
+	run := fn
+	if peer.beforeOperation != nil {
+		run = func() error {
+			peer.beforeOperation(name)
+			return fn()
+		}
+	}
+	if peer.operationTimeout <= 0 {
+		return run()
+	}
+	peer.mutex.RLock()
+	slot := peer.operationSlot
+	peer.mutex.RUnlock()
+	timer := time.NewTimer(peer.operationTimeout)
+	defer timer.Stop()
+	select {
+	case slot <- struct{}{}:
+	case <-timer.C:
+		return peer.operationTimedOut(connection, name, "waiting for the previous operation")
+	}
+	done := make(chan error)
+	abandoned := make(chan struct{})
+	go func() {
+		err := run()
+		<-slot
+		select {
+		case done <- err:
+		case <-abandoned:
+			// the negotiation given up on can be retried now that nothing
+			// overlaps it
+			peer.negotiateIfPending()
+		}
+	}()
+	select {
+	case err := <-done:
 		return err
+	case <-timer.C:
+		close(abandoned)
+		return peer.operationTimedOut(connection, name, "running")
+	}
+}
+
+// operationTimedOut reports the operation timing out. A description still
+// being applied may change the signaling state whenever it returns, leaving
+// an offer or answer that is never signaled, so its connection is given up.
+func (peer *Peer) operationTimedOut(connection *webrtc.PeerConnection, name, while string) error {
+	err := fmt.Errorf("%w: %s %s for %s", ErrOperationTimeout, name, while, peer.operationTimeout)
+	peer.debugf("%s", err)
+	switch name {
+	case "SetLocalDescription", "SetRemoteDescription":
+		// without AutoReconnect the peer is destroyed with err, which
+		// reports it
+		if peer.reconnects.enabled {
+			peer.error(err)
+		}
+		go func() {
+			if peer.Connection() == connection {
+				peer.onConnectionLost(err)
+			}
+		}()
+	default:
+		if peer.initiator.Load() {
+			peer.pendingNegotiation.Store(true)
+		}
+		peer.error(err)
+	}
+	return err
+}
+
+func canSetRemoteDescription(signalingState webrtc.SignalingState, sdpType webrtc.SDPType) bool {
+	switch sdpType {
+	case webrtc.SDPTypeOffer:
+		return signalingState == webrtc.SignalingStateStable
+	case webrtc.SDPTypeAnswer:
+		return signalingState == webrtc.SignalingStateHaveLocalOffer || signalingState == webrtc.SignalingStateHaveRemotePranswer
+	case webrtc.SDPTypePranswer:
+		return signalingState == webrtc.SignalingStateHaveLocalOffer
+	default:
+		return true
+	}
+}
+
+// queueSignal queues message until the signaling state allows applying it,
+// or drops it once MaxPendingSignals are queued. Callers hold
+// signalingMutex.
+func (peer *Peer) queueSignal(message SignalMessage) {
+	if peer.pendingSignals.Len() >= peer.maxPendingSignals {
+		peer.error(fmt.Errorf("%w: dropping %s message, %d are queued", ErrSignalQueueFull, message.Type, peer.maxPendingSignals))
+		return
+	}
+	peer.pendingSignals.Append(message)
+}
+
+func (peer *Peer) processPendingSignals() {
+	for count := peer.pendingSignals.Len(); count > 0; count-- {
+		message, ok := peer.pendingSignals.PopFront()
+		if !ok {
+			return
+		}
+		if err := peer.handleSignal(message); err != nil {
+			peer.error(err)
+		}
 	}
-	slog.Debug(fmt.Sprintf("%s: created answer", peer.id))
-	return peer.signal(answerJSON)
 }
 
 func (peer *Peer) connect() {
-	for fn := range peer.onConnect.Iter() {
+	peer.attempt.update(func(attempt *connectionAttempt) {
+		attempt.connected = true
+	})
+	peer.callbackMutex.Lock()
+	peer.connected = true
+	onConnect := peer.onConnect.Slice()
+	peer.callbackMutex.Unlock()
+	for _, fn := range onConnect {
 		go fn()
 	}
 }
 
 func (peer *Peer) error(err error) {
+	peer.attempt.update(func(attempt *connectionAttempt) {
+		attempt.lastError = err
+	})
 	handled := false
-	for fn := range peer.onError.Iter() {
+	for _, fn := range peer.onError.Slice() {
 		go fn(err)
 		handled = true
 	}
 	if !handled {
-		slog.Error(fmt.Sprintf("%s: unhandled: %s", peer.id, err))
+		peer.logAttrs(slog.LevelError, "unhandled", slog.Any("error", err))
 	}
 }
 
 func (peer *Peer) transceiver(transceiver *webrtc.RTPTransceiver) {
-	for fn := range peer.onTransceiver.Iter() {
+	for _, fn := range peer.onTransceiver.Slice() {
 		go fn(transceiver)
 	}
 }
 
 func (peer *Peer) track(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-	for fn := range peer.onTrack.Iter() {
+	mid := peer.midForReceiver(receiver)
+	peer.callbackMutex.Lock()
+	peer.remoteTracks = append(peer.remoteTracks, remoteTrack{track: track, receiver: receiver, mid: mid})
+	onTrack := peer.trackCallbacks(mid)
+	peer.callbackMutex.Unlock()
+	if peer.requestKeyFrameOnTrack {
+		if err := peer.RequestKeyFrame(track); err != nil {
+			peer.error(err)
+		}
+	}
+	if peer.onSample.Len() > 0 {
+		peer.sampleReaders.Add(1)
+		go peer.readSamples(track)
+	}
+	for _, fn := range onTrack {
 		go fn(track, receiver)
 	}
 }
@@ -584,63 +2525,216 @@ func (peer *Peer) onDataChannelError(err error) {
 }
 
 func (peer *Peer) onDataChannelOpen() {
+	if peer.bufferWritesUntilOpen {
+		go peer.flushPendingWrites()
+	}
 	peer.connect()
+}
+
+func (peer *Peer) onDataChannelMessage(channel *webrtc.DataChannel, message webrtc.DataChannelMessage) {
+	label := channel.Label()
+	peer.pathUsage.add(0, len(message.Data))
+	peer.metrics.received(label, len(message.Data))
+	peer.tracef("received data message length=%d isString=%t label=%s", len(message.Data), message.IsString, label)
+	// readers get the bytes in order whatever the dispatch
+	for reader := range peer.readers.Iter() {
+		reader.write(message.Data)
+	}
+	for _, fn := range peer.onData.Slice() {
+		fn := fn
+		message := peer.receivedMessage(message)
+		peer.dispatch(func() { fn(message) })
+	}
+	for _, onDataFrom := range peer.onDataFrom.Slice() {
+		if onDataFrom.label == label {
+			fn := onDataFrom.fn
+			message := peer.receivedMessage(message)
+			peer.dispatch(func() { fn(message) })
+		}
+	}
+	for _, fn := range peer.onDataMessage.Slice() {
+		fn := fn
+		message := DataMessage{DataChannelMessage: peer.receivedMessage(message), Label: label, Channel: channel}
+		peer.dispatch(func() { fn(message) })
+	}
+	if channel == peer.Channel() {
+		if peer.frameMessages {
+			peer.onFramedMessage(message)
+		} else {
+			peer.onReaderMessage(message)
+			peer.onMuxMessage(message.Data)
+			peer.onJSONMessage(message.Data)
+		}
+	}
+}
 
+// receivedMessage returns the message to hand to one data handler, with the
+// data of receivedData.
+func (peer *Peer) receivedMessage(message webrtc.DataChannelMessage) webrtc.DataChannelMessage {
+	message.Data = peer.receivedData(message.Data)
+	return message
 }
 
-func (peer *Peer) onDataChannelMessage(message webrtc.DataChannelMessage) {
-	for fn := range peer.onData.Iter() {
-		go fn(message)
+// receivedData returns the data to hand to one data handler, its own copy if
+// copyOnReceive is set or the handler runs on its own goroutine.
+func (peer *Peer) receivedData(data []byte) []byte {
+	if peer.copyOnReceive || !peer.orderedDispatch {
+		return append([]byte(nil), data...)
+	}
+	return data
+}
+
+// dispatch runs a data handler in order on the calling goroutine if
+// orderedDispatch is set and on its own goroutine otherwise.
+func (peer *Peer) dispatch(fn func()) {
+	if peer.orderedDispatch {
+		fn()
+	} else {
+		go fn()
 	}
 }
 
 func (peer *Peer) onConnectionStateChange(pcs webrtc.PeerConnectionState) {
+	peer.debug("connection state", slog.String(LogKeyState, pcs.String()))
 	switch pcs {
-	case webrtc.PeerConnectionStateUnknown:
-		slog.Debug(fmt.Sprintf("%s: connection state unknown", peer.id))
-	case webrtc.PeerConnectionStateNew:
-		slog.Debug(fmt.Sprintf("%s: connection new", peer.id))
-	case webrtc.PeerConnectionStateConnecting:
-		slog.Debug(fmt.Sprintf("%s: connecting", peer.id))
 	case webrtc.PeerConnectionStateConnected:
-		slog.Debug(fmt.Sprintf("%s: connection established", peer.id))
+		peer.attempt.update(func(attempt *connectionAttempt) {
+			attempt.dtlsConnected = true
+		})
+		peer.onReconnectedConnection()
+		peer.onResumed()
+	}
+	// application callbacks see lost connections before they are torn down
+	for _, fn := range peer.onConnectionState.Slice() {
+		fn(pcs)
+	}
+	switch pcs {
 	case webrtc.PeerConnectionStateDisconnected:
-		slog.Debug(fmt.Sprintf("%s: connection disconnected", peer.id))
-		peer.close(true)
+		peer.onDisconnected()
 	case webrtc.PeerConnectionStateFailed:
-		slog.Debug(fmt.Sprintf("%s: connection failed", peer.id))
-		peer.close(true)
+		peer.disconnects.stop()
+		peer.onConnectionLost(ErrConnectionFailed)
 	case webrtc.PeerConnectionStateClosed:
-		slog.Debug(fmt.Sprintf("%s: connection closed", peer.id))
-		peer.close(true)
+		peer.disconnects.stop()
+		// the initiator closing a connection it replaces looks like a close
+		if peer.initiator.Load() {
+			peer.connectionEnded(ErrConnectionClosed)
+		} else {
+			peer.onConnectionLost(ErrConnectionClosed)
+		}
 	}
 }
 
-func (peer *Peer) onICECandidate(pendingCandidate *webrtc.ICECandidate) {
-	if peer.connection == nil || pendingCandidate == nil {
+// endOfCandidates is the marker signaled once gathering completes. Browsers
+// reject a candidate without sdpMid and sdpMLineIndex, so it names the first
+// media section, which carries the bundled transport.
+func endOfCandidates() webrtc.ICECandidateInit {
+	sdpMLineIndex := uint16(0)
+	return webrtc.ICECandidateInit{SDPMLineIndex: &sdpMLineIndex}
+}
+
+// onICECandidate signals a candidate gathered by connection. A connection
+// replaced by createPeer may still be gathering, its candidates must not
+// reach the new session.
+func (peer *Peer) onICECandidate(connection *webrtc.PeerConnection, pendingCandidate *webrtc.ICECandidate) {
+	if peer.Connection() != connection || !peer.isCurrentConnection(connection) {
 		return
 	}
-	if peer.connection.RemoteDescription() == nil {
-		peer.pendingCandidates.Append(pendingCandidate.ToJSON())
-	} else {
-		iceCandidateInit := pendingCandidate.ToJSON()
-		iceCandidateInitJSON, err := toJSON(iceCandidateInit)
-		if err != nil {
-			peer.error(err)
+	if pendingCandidate != nil {
+		peer.attempt.update(func(attempt *connectionAttempt) {
+			if attempt.localCandidates != nil {
+				attempt.localCandidates[pendingCandidate.Typ]++
+			}
+		})
+	}
+	if pendingCandidate == nil {
+		if connection.RemoteDescription() == nil {
+			peer.pendingLocalCandidates.Append(endOfCandidates())
 			return
 		}
-		err = peer.signal(map[string]interface{}{
-			"type":      SignalMessageCandidate,
-			"candidate": iceCandidateInitJSON,
-		})
-		if err != nil {
-			peer.error(err)
+		peer.debug("signaling end of candidates", slog.String(LogKeySignalType, SignalMessageCandidate))
+		if peer.candidateBatch.interval > 0 {
+			peer.candidateBatch.add(endOfCandidates(), peer.flushCandidateBatch)
+			peer.flushCandidateBatch()
+		} else {
+			peer.signalCandidate(endOfCandidates())
 		}
+		return
+	}
+	candidates := []webrtc.ICECandidateInit{pendingCandidate.ToJSON()}
+	if peer.candidateRewrite != nil {
+		candidates = peer.candidateRewrite(candidates[0])
+	}
+	for _, candidate := range candidates {
+		peer.trace("gathered candidate", candidateAttrs(candidate.Candidate)...)
+		if connection.RemoteDescription() == nil {
+			peer.pendingLocalCandidates.Append(candidate)
+		} else if peer.candidateBatch.interval > 0 {
+			peer.candidateBatch.add(candidate, peer.flushCandidateBatch)
+		} else {
+			peer.signalCandidate(candidate)
+		}
+	}
+}
+
+func (peer *Peer) signalPendingLocalCandidates() {
+	count := peer.pendingLocalCandidates.Len()
+	if count == 0 {
+		return
+	}
+	peer.debugf("signaling %d pending local candidates", count)
+	for ; count > 0; count-- {
+		candidate, ok := peer.pendingLocalCandidates.PopFront()
+		if !ok {
+			break
+		}
+		if peer.candidateBatch.interval > 0 {
+			peer.candidateBatch.add(candidate, peer.flushCandidateBatch)
+		} else {
+			peer.signalCandidate(candidate)
+		}
+	}
+	peer.flushCandidateBatch()
+}
+
+func (peer *Peer) signalCandidate(iceCandidateInit webrtc.ICECandidateInit) {
+	err := peer.signal(SignalMessage{
+		Type:      SignalMessageCandidate,
+		Candidate: &iceCandidateInit,
+	})
+	if err != nil {
+		peer.error(err)
+	}
+}
+
+func (peer *Peer) flushCandidateBatch() {
+	candidates := peer.candidateBatch.take()
+	if len(candidates) == 0 {
+		return
+	}
+	peer.debugf("signaling %d batched candidates", len(candidates))
+	err := peer.signal(SignalMessage{
+		Type:       SignalMessageCandidates,
+		Candidates: candidates,
+	})
+	if err != nil {
+		peer.error(err)
 	}
 }
 
+// onNegotiationNeeded is where local changes, like added tracks,
+// transceivers and data channels, start a negotiation: the initiator offers
+// and the other peer asks it to with a renegotiate signal. pion only fires
+// it while the signaling state is stable and again once it returns to
+// stable, so changes made during a negotiation are covered by the next.
 func (peer *Peer) onNegotiationNeeded() {
-	peer.needsNegotiation()
+	if !peer.negotiations.negotiationNeeded(peer.onNegotiationDebounced) {
+		peer.debugf("needs negotiation, debouncing")
+		return
+	}
+	if err := peer.negotiateWhenStable(); err != nil && !errors.Is(err, ErrOperationTimeout) {
+		peer.error(err)
+	}
 }
 
 func (peer *Peer) onTrackRemote(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
@@ -649,55 +2743,74 @@ func (peer *Peer) onTrackRemote(track *webrtc.TrackRemote, receiver *webrtc.RTPR
 
 func (peer *Peer) onDataChannel(channel *webrtc.DataChannel) {
 	if channel != nil {
-		peer.channel = channel
-		peer.channel.OnError(peer.onDataChannelError)
-		peer.channel.OnOpen(peer.onDataChannelOpen)
-		peer.channel.OnMessage(peer.onDataChannelMessage)
+		peer.attachChannel(channel, channel.Label() == peer.channelName || !peer.isRequiredChannel(channel.Label()))
 	}
 }
 
-func toJSON(v interface{}) (map[string]interface{}, error) {
-	encoded, err := json.Marshal(v)
-	if err != nil {
-		return nil, err
-	}
-	var decoded map[string]interface{}
-	if err := json.Unmarshal(encoded, &decoded); err != nil {
-		return nil, err
-	}
-	return decoded, nil
+type candidateBatch struct {
+	mutex      sync.Mutex
+	interval   time.Duration
+	timer      *time.Timer
+	candidates []webrtc.ICECandidateInit
 }
 
-func fromJSON[T any](v map[string]interface{}, value *T) error {
-	encoded, err := json.Marshal(v)
-	if err != nil {
-		return err
+func (batch *candidateBatch) add(candidate webrtc.ICECandidateInit, flush func()) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	batch.candidates = append(batch.candidates, candidate)
+	if batch.timer == nil {
+		batch.timer = time.AfterFunc(batch.interval, flush)
 	}
-	if err := json.Unmarshal(encoded, value); err != nil {
-		return err
+}
+
+func (batch *candidateBatch) take() []webrtc.ICECandidateInit {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	if batch.timer != nil {
+		batch.timer.Stop()
+		batch.timer = nil
 	}
-	return nil
+	candidates := batch.candidates
+	batch.candidates = nil
+	return candidates
 }
 
-type peerReader struct {
-	closed     bool
-	peer       *Peer
-	pipeReader *io.PipeReader
-	onClose    func()
+func (batch *candidateBatch) stop() {
+	batch.take()
 }
 
-func (reader *peerReader) Read(bytes []byte) (int, error) {
-	if reader.closed {
-		return 0, io.EOF
+type peerWriter struct {
+	closed   atomic.Bool
+	peer     *Peer
+	mutex    sync.Mutex
+	deadline time.Time
+}
+
+func (writer *peerWriter) Write(bytes []byte) (int, error) {
+	if writer.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	writer.mutex.Lock()
+	deadline := writer.deadline
+	writer.mutex.Unlock()
+	if deadline.IsZero() {
+		return writer.peer.Write(bytes)
 	}
-	return reader.pipeReader.Read(bytes)
+	return writer.peer.sendBefore(deadline, bytes, false)
+}
+
+// SetWriteDeadline sets a deadline for this writer's writes that replaces
+// the peer's, see Peer.SetWriteDeadline.
+func (writer *peerWriter) SetWriteDeadline(t time.Time) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.deadline = t
+	return nil
 }
 
-func (reader *peerReader) Close() error {
-	if reader.closed {
-		return io.EOF
+func (writer *peerWriter) Close() error {
+	if writer.closed.Swap(true) {
+		return io.ErrClosedPipe
 	}
-	reader.onClose()
-	reader.closed = true
-	return reader.pipeReader.Close()
+	return writer.peer.Flush(context.Background())
 }
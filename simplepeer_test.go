@@ -1,14 +1,22 @@
 package simplepeer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/aicacia/go-simplepeer/internal/testutil"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
@@ -236,6 +244,7 @@ func TestStreams(t *testing.T) {
 				return
 			}
 			sent.Add(1)
+			time.Sleep(time.Millisecond)
 		}
 	}()
 
@@ -243,9 +252,15 @@ func TestStreams(t *testing.T) {
 	received := &atomic.Int64{}
 	rtpPacket := &rtp.Packet{}
 	peer2Track := <-peer2TrackChan
-	for done.Load() == false {
+	for {
+		if err := peer2Track.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
 		read, _, err := peer2Track.Read(trackBuffer)
 		if err != nil {
+			if done.Load() {
+				break
+			}
 			t.Fatal(err)
 		}
 		if err = rtpPacket.Unmarshal(trackBuffer[:read]); err != nil {
@@ -255,7 +270,7 @@ func TestStreams(t *testing.T) {
 	}
 
 	if received.Load() < sent.Load() {
-		t.Fatalf("Received %d packets, but sent %d", received, sent)
+		t.Fatalf("Received %d packets, but sent %d", received.Load(), sent.Load())
 	}
 
 	err = <-result
@@ -263,3 +278,2669 @@ func TestStreams(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSignalQueuesAnswerBeforeLocalOffer(t *testing.T) {
+	peer1Error := make(chan error, 1)
+	answers := make(chan map[string]interface{}, 1)
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageOffer {
+				return nil
+			}
+			return peer2.Signal(message)
+		},
+		OnError: func(err error) {
+			peer1Error <- err
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageAnswer {
+				answers <- message
+				return nil
+			}
+			return peer1.Signal(message)
+		},
+	})
+	if err := peer1.createPeer(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.connection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := peer1.connection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer2.Signal(BuildSignal(SignalMessage{Type: offer.Type.String(), SDP: offer.SDP})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := peer1.Signal(<-answers); err != nil {
+		t.Fatalf("expected answer to be queued, got %s", err)
+	}
+	if peer1.pendingSignals.Len() != 1 {
+		t.Fatalf("expected 1 pending signal, got %d", peer1.pendingSignals.Len())
+	}
+
+	peer1.initiator.Store(true)
+	if err := peer1.createOffer(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-peer1Error:
+		t.Fatal(err)
+	default:
+	}
+	if peer1.pendingSignals.Len() != 0 {
+		t.Fatalf("expected pending signals to be replayed, got %d", peer1.pendingSignals.Len())
+	}
+	if state := peer1.connection.SignalingState(); state != webrtc.SignalingStateStable {
+		t.Fatalf("expected signaling state stable, got %s", state)
+	}
+
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pairTestPeers creates two peers signaling each other without waiting for
+// them to connect. peer1 is the initiator once Init is called.
+func TestSignalQueueBounded(t *testing.T) {
+	errs := make(chan error, 1)
+	peer := NewPeer(PeerOptions{
+		MaxPendingSignals: 2,
+		OnSignal: func(message map[string]interface{}) error {
+			return nil
+		},
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	defer peer.Close()
+	if err := peer.createPeer(); err != nil {
+		t.Fatal(err)
+	}
+	// answers are queued while stable
+	answer := BuildSignal(SignalMessage{Type: SignalMessageAnswer, SDP: createTestOffer(t)})
+	for i := 0; i < 3; i++ {
+		if err := peer.Signal(answer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending := peer.pendingSignals.Len(); pending != 2 {
+		t.Fatalf("expected 2 pending signals, got %d", pending)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrSignalQueueFull) {
+			t.Fatalf("expected ErrSignalQueueFull, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the dropped signal to be reported")
+	}
+}
+
+func pairTestPeers(peer1Options, peer2Options PeerOptions) (*Peer, *Peer) {
+	var peer1, peer2 *Peer
+	peer1Options.Id = "peer1"
+	peer1Options.OnSignal = func(message map[string]interface{}) error {
+		return peer2.Signal(message)
+	}
+	peer2Options.Id = "peer2"
+	peer2Options.OnSignal = func(message map[string]interface{}) error {
+		return peer1.Signal(message)
+	}
+	peer1 = NewPeer(peer1Options)
+	peer2 = NewPeer(peer2Options)
+	return peer1, peer2
+}
+
+func connectTestPeers(t testing.TB, peer1Options, peer2Options PeerOptions) (*Peer, *Peer) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+
+	peer1Options.OnConnect = func() {
+		peer1Connect <- true
+	}
+	peer2Options.OnConnect = func() {
+		peer2Connect <- true
+	}
+	peer1, peer2 := pairTestPeers(peer1Options, peer2Options)
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+	return peer1, peer2
+}
+
+func TestSnapshotAccessors(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{ChannelName: "snapshot"}, PeerOptions{})
+	defer peer2.Close()
+
+	channelInfo, ok := peer1.ChannelInfo()
+	if !ok {
+		t.Fatal("expected channel info")
+	}
+	if channelInfo.Label != "snapshot" {
+		t.Fatalf("expected label 'snapshot', got '%s'", channelInfo.Label)
+	}
+	if channelInfo.ReadyState != webrtc.DataChannelStateOpen {
+		t.Fatalf("expected channel open, got %s", channelInfo.ReadyState)
+	}
+	connectionInfo, ok := peer1.ConnectionInfo()
+	if !ok {
+		t.Fatal("expected connection info")
+	}
+	if connectionInfo.SignalingState != webrtc.SignalingStateStable {
+		t.Fatalf("expected signaling state stable, got %s", connectionInfo.SignalingState)
+	}
+
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					peer1.ChannelInfo()
+					peer1.ConnectionInfo()
+				}
+			}
+		}()
+	}
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+
+	if _, ok := peer1.ChannelInfo(); ok {
+		t.Fatal("expected no channel info after close")
+	}
+	if _, ok := peer1.ConnectionInfo(); ok {
+		t.Fatal("expected no connection info after close")
+	}
+}
+
+func TestCandidateBatching(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	var candidateMessages, candidatesMessages atomic.Int64
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id:                     "peer1",
+		CandidateBatchInterval: 50 * time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id:                     "peer2",
+		CandidateBatchInterval: 50 * time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			switch message["type"] {
+			case SignalMessageCandidate:
+				candidateMessages.Add(1)
+			case SignalMessageCandidates:
+				candidatesMessages.Add(1)
+			}
+			encoded, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				return err
+			}
+			return peer1.Signal(decoded)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if candidateMessages.Load() != 0 {
+		t.Fatalf("expected no candidate messages, got %d", candidateMessages.Load())
+	}
+	if candidatesMessages.Load() == 0 {
+		t.Fatal("expected batched candidates messages")
+	}
+}
+
+func TestEndOfCandidates(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	peer2EndOfCandidates := make(chan bool, 1)
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageCandidate {
+				if candidate, ok := message["candidate"].(map[string]interface{}); ok && candidate["candidate"] == "" {
+					select {
+					case peer2EndOfCandidates <- true:
+					default:
+					}
+				}
+			}
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	select {
+	case <-peer2EndOfCandidates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected end of candidates signal")
+	}
+
+	if err := peer1.Signal(map[string]interface{}{"type": SignalMessageCandidate, "candidate": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.Signal(map[string]interface{}{"type": SignalMessageCandidate, "candidate": map[string]interface{}{"candidate": ""}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAddTrackFromOnTrack runs the cycle 100 times, the race it covers
+// only showed up in some runs.
+func TestAddTrackFromOnTrack(t *testing.T) {
+	for run := 0; run < 100; run++ {
+		t.Run(fmt.Sprint(run), func(t *testing.T) {
+			testAddTrackFromOnTrack(t)
+		})
+	}
+}
+
+func testAddTrackFromOnTrack(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	peer1Track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2Track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer1Received := make(chan bool, 1)
+	peer2Received := make(chan bool, 1)
+	peerErrors := make(chan error, 2)
+	readTrack := func(track *webrtc.TrackRemote, received chan bool) {
+		if _, _, err := track.ReadRTP(); err != nil {
+			peerErrors <- err
+			return
+		}
+		received <- true
+	}
+	peer1.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		readTrack(track, peer1Received)
+	})
+	peer2.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if _, err := peer2.AddTrack(peer2Track); err != nil {
+			peerErrors <- err
+			return
+		}
+		readTrack(track, peer2Received)
+	})
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for sequenceNumber := uint16(0); ; sequenceNumber++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, track := range []*webrtc.TrackLocalStaticRTP{peer1Track, peer2Track} {
+					track.WriteRTP(&rtp.Packet{
+						Header: rtp.Header{
+							Version:        2,
+							SequenceNumber: sequenceNumber,
+							Timestamp:      uint32(sequenceNumber) * 90,
+						},
+						Payload: []byte{0x10, 0x00, 0x00},
+					})
+				}
+			}
+		}
+	}()
+
+	if _, err := peer1.AddTrack(peer1Track); err != nil {
+		t.Fatal(err)
+	}
+	for _, received := range []chan bool{peer1Received, peer2Received} {
+		select {
+		case <-received:
+		case err := <-peerErrors:
+			t.Fatal(err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for media")
+		}
+	}
+}
+
+func TestPendingLocalCandidatesAreSignaled(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	var peer1Candidates atomic.Int64
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageCandidate {
+				peer1Candidates.Add(1)
+			}
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageAnswer {
+				go func() {
+					time.Sleep(200 * time.Millisecond)
+					if err := peer1.Signal(message); err != nil {
+						t.Error(err)
+					}
+				}()
+				return nil
+			}
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if peer1Candidates.Load() == 0 {
+		t.Fatal("expected candidates gathered before the answer to be signaled")
+	}
+	if peer1.pendingLocalCandidates.Len() != 0 || peer1.pendingRemoteCandidates.Len() != 0 {
+		t.Fatal("expected pending candidates to be drained")
+	}
+}
+
+func TestOversizePolicy(t *testing.T) {
+	for _, policy := range []OversizePolicy{OversizePolicyChunk, OversizePolicyError} {
+		peer2Data := make(chan []byte, 8)
+		peer1, peer2 := connectTestPeers(t, PeerOptions{OversizePolicy: policy}, PeerOptions{
+			OnData: func(message webrtc.DataChannelMessage) {
+				peer2Data <- message.Data
+			},
+		})
+		maxMessageSize := peer1.MaxMessageSize()
+
+		if sent, err := peer1.Write(make([]byte, maxMessageSize)); err != nil || sent != maxMessageSize {
+			t.Fatalf("policy %d: expected %d bytes sent, got %d: %v", policy, maxMessageSize, sent, err)
+		}
+		if data := <-peer2Data; len(data) != maxMessageSize {
+			t.Fatalf("policy %d: expected one %d byte message, got %d", policy, maxMessageSize, len(data))
+		}
+
+		sent, err := peer1.Write(make([]byte, maxMessageSize+1))
+		switch policy {
+		case OversizePolicyChunk:
+			if err != nil || sent != maxMessageSize+1 {
+				t.Fatalf("expected %d bytes sent, got %d: %v", maxMessageSize+1, sent, err)
+			}
+			if first, second := len(<-peer2Data), len(<-peer2Data); first+second != maxMessageSize+1 || (first != 1 && second != 1) {
+				t.Fatalf("expected %d and 1 byte chunks, got %d and %d", maxMessageSize, first, second)
+			}
+		case OversizePolicyError:
+			if !errors.Is(err, ErrMessageTooLarge) || sent != 0 {
+				t.Fatalf("expected message too large with nothing sent, got %d: %v", sent, err)
+			}
+			if _, err := peer1.WriteText(string(make([]byte, maxMessageSize+1))); !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("expected message too large, got %v", err)
+			}
+			select {
+			case data := <-peer2Data:
+				t.Fatalf("expected nothing to be received, got %d bytes", len(data))
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		peer1.Close()
+		peer2.Close()
+	}
+}
+
+func TestSignalBytes(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignalBytes: func(data []byte) error {
+			return peer2.SignalBytes(data)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignalBytes: func(data []byte) error {
+			return peer1.SignalBytes(data)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if err := peer1.SignalBytes([]byte("{")); !errors.Is(err, ErrMalformedSignal) {
+		t.Fatalf("expected malformed signal, got %v", err)
+	}
+	if err := peer1.SignalBytes([]byte(`{"type":"bogus"}`)); err == nil || errors.Is(err, ErrMalformedSignal) {
+		t.Fatalf("expected invalid signal message, got %v", err)
+	}
+}
+
+func TestPeerContext(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	if err := peer1.Context().Err(); err != nil {
+		t.Fatalf("expected context to be active, got %s", err)
+	}
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1.Context().Done()
+	if cause := context.Cause(peer1.Context()); !errors.Is(cause, ErrPeerClosed) {
+		t.Fatalf("expected peer closed cause, got %v", cause)
+	}
+	select {
+	case <-peer2.Context().Done():
+	case <-time.After(30 * time.Second):
+		t.Fatal("expected remote peer context to be done")
+	}
+	if cause := context.Cause(peer2.Context()); !errors.Is(cause, ErrConnectionClosed) && !errors.Is(cause, ErrConnectionDisconnected) && !errors.Is(cause, ErrConnectionFailed) {
+		t.Fatalf("expected connection cause, got %v", cause)
+	}
+
+	errParentCancelled := errors.New("parent cancelled")
+	parent, cancel := context.WithCancelCause(context.Background())
+	peer3, peer4 := connectTestPeers(t, PeerOptions{Context: parent}, PeerOptions{})
+	defer peer4.Close()
+	cancel(errParentCancelled)
+	<-peer3.Context().Done()
+	if cause := context.Cause(peer3.Context()); !errors.Is(cause, errParentCancelled) {
+		t.Fatalf("expected parent cause, got %v", cause)
+	}
+	for peer3.Connection() != nil {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSignalRetry(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	var failures atomic.Int64
+
+	var peer1, peer2 *Peer
+	var failedMutex sync.Mutex
+	failed := map[string]bool{}
+	peer1 = NewPeer(PeerOptions{
+		Id:                 "peer1",
+		SignalRetryCount:   3,
+		SignalRetryBackoff: 10 * time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			key := fmt.Sprintf("%v", message)
+			failedMutex.Lock()
+			alreadyFailed := failed[key]
+			failed[key] = true
+			failedMutex.Unlock()
+			if !alreadyFailed {
+				failures.Add(1)
+				return errors.New("transport down")
+			}
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+	if failures.Load() == 0 {
+		t.Fatal("expected failed signals to be retried")
+	}
+}
+
+func TestSignalRetryExhaustedAndFlush(t *testing.T) {
+	errTransportDown := errors.New("transport down")
+	peer1Error := make(chan error, 8)
+	peer1 := NewPeer(PeerOptions{
+		Id:                 "peer1",
+		SignalRetryCount:   1,
+		SignalRetryBackoff: 10 * time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			return errTransportDown
+		},
+		OnError: func(err error) {
+			peer1Error <- err
+		},
+	})
+	defer peer1.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-peer1Error:
+		if !errors.Is(err, errTransportDown) {
+			t.Fatalf("expected transport down, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected OnError after retries were exhausted")
+	}
+
+	peer2Connect := make(chan bool)
+	peer3Connect := make(chan bool)
+	var transportUp atomic.Bool
+	var peer2, peer3 *Peer
+	peer2 = NewPeer(PeerOptions{
+		Id:                 "peer2",
+		SignalRetryCount:   10,
+		SignalRetryBackoff: time.Hour,
+		OnSignal: func(message map[string]interface{}) error {
+			if !transportUp.Load() {
+				return errTransportDown
+			}
+			return peer3.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	peer3 = NewPeer(PeerOptions{
+		Id: "peer3",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer3Connect <- true
+		},
+	})
+	defer peer2.Close()
+	defer peer3.Close()
+	if err := peer2.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the offer to fail and wait for its retry
+	testutil.WaitFor(t, func() bool {
+		peer2.outgoingSignals.mutex.Lock()
+		defer peer2.outgoingSignals.mutex.Unlock()
+		return peer2.outgoingSignals.timer != nil
+	})
+	if err := peer2.FlushSignals(); !errors.Is(err, errTransportDown) {
+		t.Fatalf("expected transport down, got %v", err)
+	}
+	transportUp.Store(true)
+	if err := peer2.FlushSignals(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer2Connect
+	<-peer3Connect
+}
+
+func TestWaitForChannels(t *testing.T) {
+	var allReady atomic.Int32
+	ready := make(chan struct{}, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		RequiredChannels: []string{"a", "b"},
+	}, PeerOptions{
+		RequiredChannels: []string{"a", "b", "c"},
+		OnAllChannelsReady: func() {
+			allReady.Add(1)
+			ready <- struct{}{}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if err := peer1.WaitForChannels(context.Background(), "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := peer2.WaitForChannels(ctx, "a", "b", "c"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if allReady.Load() != 0 {
+		t.Fatal("expected OnAllChannelsReady not to fire before the last channel opens")
+	}
+
+	if err := peer1.createChannel("c", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := peer2.WaitForChannels(ctx, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if !peer2.channelsOpen([]string{"a", "b", "c"}) {
+		t.Fatal("expected all channels to be open")
+	}
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		t.Fatal("expected OnAllChannelsReady to fire")
+	}
+	if allReady.Load() != 1 {
+		t.Fatalf("expected OnAllChannelsReady to fire once, fired %d times", allReady.Load())
+	}
+}
+
+func TestMultipleSignalHandlers(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+
+	var forwarded, logged atomic.Int32
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			forwarded.Add(1)
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer1.OnSignal(func(message map[string]interface{}) error {
+		logged.Add(1)
+		return nil
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+	if forwarded.Load() == 0 || forwarded.Load() != logged.Load() {
+		t.Fatalf("expected both handlers to see every signal, forwarded %d, logged %d", forwarded.Load(), logged.Load())
+	}
+
+	errA := errors.New("a")
+	errB := errors.New("b")
+	var called atomic.Int32
+	peer3 := NewPeer()
+	peer3.OnSignal(func(message map[string]interface{}) error {
+		called.Add(1)
+		return errA
+	})
+	peer3.OnSignal(func(message map[string]interface{}) error {
+		called.Add(1)
+		return nil
+	})
+	peer3.OnSignal(func(message map[string]interface{}) error {
+		called.Add(1)
+		return errB
+	})
+	err := peer3.signal(SignalMessage{Type: SignalMessageRenegotiate, Renegotiate: true})
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("expected both errors to be joined, got %v", err)
+	}
+	if called.Load() != 3 {
+		t.Fatalf("expected all handlers to be called, got %d", called.Load())
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	peer1Error := make(chan error, 1)
+
+	var stalled atomic.Bool
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id:               "peer1",
+		OperationTimeout: 100 * time.Millisecond,
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+		OnError: func(err error) {
+			select {
+			case peer1Error <- err:
+			default:
+			}
+		},
+	})
+	peer1.beforeOperation = func(operation string) {
+		if operation == "CreateOffer" && !stalled.Swap(true) {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-peer1Error:
+		if !errors.Is(err, ErrOperationTimeout) {
+			t.Fatalf("expected operation timeout, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "CreateOffer") {
+			t.Fatalf("expected the error to name CreateOffer, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected operation timeout")
+	}
+	if state := peer1.Connection().SignalingState(); state != webrtc.SignalingStateStable {
+		t.Fatalf("expected signaling state stable after the timeout, got %s", state)
+	}
+
+	// the stalled CreateOffer still runs, the next one must not overlap it
+	err := peer1.Signal(BuildSignal(SignalMessage{Type: SignalMessageRenegotiate, Renegotiate: true}))
+	if !errors.Is(err, ErrOperationTimeout) || !strings.Contains(err.Error(), "waiting for the previous operation") {
+		t.Fatalf("expected the next CreateOffer to wait for the stalled one, got %v", err)
+	}
+	for _, connect := range []chan bool{peer1Connect, peer2Connect} {
+		select {
+		case <-connect:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the retried negotiation to connect")
+		}
+	}
+}
+
+func TestOperationTimeoutSetLocalDescription(t *testing.T) {
+	testOperationTimeoutReconnects(t, "SetLocalDescription")
+}
+
+func TestOperationTimeoutSetRemoteDescription(t *testing.T) {
+	testOperationTimeoutReconnects(t, "SetRemoteDescription")
+}
+
+// testOperationTimeoutReconnects stalls the first call of operation on the
+// initiator. The call may still change the signaling state, so the
+// connection is replaced and negotiated anew.
+func testOperationTimeoutReconnects(t *testing.T, operation string) {
+	peer1Connect := make(chan bool, 1)
+	peer2Connect := make(chan bool, 1)
+	peer1Error := make(chan error, 1)
+
+	var stalled atomic.Bool
+	peer1, peer2 := pairTestPeers(PeerOptions{
+		OperationTimeout: 100 * time.Millisecond,
+		AutoReconnect:    true,
+		Backoff:          10 * time.Millisecond,
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+		OnError: func(err error) {
+			select {
+			case peer1Error <- err:
+			default:
+			}
+		},
+	}, PeerOptions{
+		AutoReconnect: true,
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer1.beforeOperation = func(name string) {
+		if name == operation && !stalled.Swap(true) {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	if err := peer1.Init(); err != nil && !errors.Is(err, ErrOperationTimeout) {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-peer1Error:
+		if !errors.Is(err, ErrOperationTimeout) || !strings.Contains(err.Error(), operation) {
+			t.Fatalf("expected %s to time out, got %v", operation, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected operation timeout")
+	}
+	for _, connect := range []chan bool{peer1Connect, peer2Connect} {
+		select {
+		case <-connect:
+		case <-time.After(10 * time.Second):
+			t.Fatal("expected the replacement connection to connect")
+		}
+	}
+	if !stalled.Load() {
+		t.Fatalf("expected %s to be stalled", operation)
+	}
+}
+
+func TestLateRegistrationReplay(t *testing.T) {
+	peer2Track := make(chan *webrtc.TrackRemote, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		RequiredChannels: []string{"extra"},
+	}, PeerOptions{
+		RequiredChannels: []string{"extra"},
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			peer2Track <- track
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	var connected, skipped, allReady, tracks, closed atomic.Int32
+	peer1.OnConnect(func() {
+		connected.Add(1)
+	})
+	peer1.OnConnect(func() {
+		skipped.Add(1)
+	}, CallbackOptions{SkipReplay: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := peer2.WaitForChannels(ctx, "extra"); err != nil {
+		t.Fatal(err)
+	}
+	peer2.OnAllChannelsReady(func() {
+		allReady.Add(1)
+	})
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for sequenceNumber := uint16(0); ; sequenceNumber++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				track.WriteRTP(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						SequenceNumber: sequenceNumber,
+						Timestamp:      uint32(sequenceNumber) * 90,
+					},
+					Payload: []byte{0x10, 0x00, 0x00},
+				})
+			}
+		}
+	}()
+	var remoteTrack *webrtc.TrackRemote
+	select {
+	case remoteTrack = <-peer2Track:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the remote track")
+	}
+	peer2.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if track == remoteTrack {
+			tracks.Add(1)
+		}
+	})
+
+	peer2.Close()
+	peer2.OnClose(func() {
+		closed.Add(1)
+	})
+	peer2.OnClose(func() {
+		skipped.Add(1)
+	}, CallbackOptions{SkipReplay: true})
+
+	time.Sleep(200 * time.Millisecond)
+	for name, count := range map[string]*atomic.Int32{
+		"OnConnect":          &connected,
+		"OnAllChannelsReady": &allReady,
+		"OnTrack":            &tracks,
+		"OnClose":            &closed,
+	} {
+		if count.Load() != 1 {
+			t.Fatalf("expected %s to be replayed once, got %d", name, count.Load())
+		}
+	}
+	if skipped.Load() != 0 {
+		t.Fatalf("expected SkipReplay callbacks not to fire, got %d", skipped.Load())
+	}
+}
+
+func TestCloseUninitialized(t *testing.T) {
+	closed := make(chan bool, 2)
+	peer := NewPeer(PeerOptions{
+		OnClose: func() {
+			closed <- true
+		},
+	})
+	if err := peer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	peer.OnClose(func() {
+		closed <- true
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected OnClose to fire and be replayed")
+		}
+	}
+}
+
+func TestSdpTransform(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+
+	var remoteTransforms atomic.Int32
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		SdpTransform: func(sdp string) string {
+			return strings.Replace(sdp, "c=IN IP4 0.0.0.0\r\n", "c=IN IP4 0.0.0.0\r\nb=AS:500\r\n", 1)
+		},
+		OnSignalBytes: func(data []byte) error {
+			return peer2.SignalBytes(data)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		RemoteSdpTransform: func(sdp string) string {
+			remoteTransforms.Add(1)
+			if !strings.Contains(sdp, "b=AS:500\r\n") {
+				t.Error("expected the transformed sdp to survive the JSON round trip")
+			}
+			return sdp
+		},
+		OnSignalBytes: func(data []byte) error {
+			return peer1.SignalBytes(data)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if remoteTransforms.Load() == 0 {
+		t.Fatal("expected RemoteSdpTransform to be called")
+	}
+	if !strings.Contains(peer2.Connection().RemoteDescription().SDP, "b=AS:500") {
+		t.Fatal("expected the remote description to carry the transformed sdp")
+	}
+	if strings.Contains(peer1.Connection().LocalDescription().SDP, "b=AS:500") {
+		t.Fatal("expected the local description to be applied untransformed")
+	}
+}
+
+func TestDescriptionGetters(t *testing.T) {
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	negotiating := make(chan error, 1)
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageOffer {
+				var err error
+				if description := peer1.LocalDescription(); description == nil || description.Type != webrtc.SDPTypeOffer {
+					err = fmt.Errorf("expected local offer during negotiation, got %v", description)
+				} else if peer1.RemoteDescription() != nil {
+					err = fmt.Errorf("expected no remote description during negotiation")
+				} else if state := peer1.SignalingState(); state != webrtc.SignalingStateHaveLocalOffer {
+					err = fmt.Errorf("expected have-local-offer during negotiation, got %s", state)
+				}
+				select {
+				case negotiating <- err:
+				default:
+				}
+			}
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	defer peer2.Close()
+
+	if peer1.LocalDescription() != nil || peer1.RemoteDescription() != nil {
+		t.Fatal("expected no descriptions before Init")
+	}
+	if state := peer1.SignalingState(); state != webrtc.SignalingStateUnknown {
+		t.Fatalf("expected unknown signaling state before Init, got %s", state)
+	}
+	if state := peer1.ConnectionState(); state != webrtc.PeerConnectionStateUnknown {
+		t.Fatalf("expected unknown connection state before Init, got %s", state)
+	}
+
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-negotiating; err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+
+	if description := peer1.RemoteDescription(); description == nil || description.Type != webrtc.SDPTypeAnswer {
+		t.Fatalf("expected remote answer, got %v", description)
+	}
+	if state := peer1.SignalingState(); state != webrtc.SignalingStateStable {
+		t.Fatalf("expected stable signaling state, got %s", state)
+	}
+	if state := peer1.ConnectionState(); state != webrtc.PeerConnectionStateConnected {
+		t.Fatalf("expected connected, got %s", state)
+	}
+
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if peer1.LocalDescription() != nil || peer1.RemoteDescription() != nil {
+		t.Fatal("expected no descriptions after Close")
+	}
+	if state := peer1.SignalingState(); state != webrtc.SignalingStateUnknown {
+		t.Fatalf("expected unknown signaling state after Close, got %s", state)
+	}
+	if state := peer1.ConnectionState(); state != webrtc.PeerConnectionStateUnknown {
+		t.Fatalf("expected unknown connection state after Close, got %s", state)
+	}
+}
+
+func TestStaleConnectionCandidates(t *testing.T) {
+	peer := NewPeer(PeerOptions{})
+	defer peer.Close()
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	stale := peer.Connection()
+	if err := peer.createPeer(); err != nil {
+		t.Fatal(err)
+	}
+	hasCandidate := func(address string) bool {
+		for _, candidate := range peer.pendingLocalCandidates.Slice() {
+			if strings.Contains(candidate.Candidate, address) {
+				return true
+			}
+		}
+		return false
+	}
+	candidate := func(address string) *webrtc.ICECandidate {
+		return &webrtc.ICECandidate{Foundation: "1", Priority: 1, Address: address, Protocol: webrtc.ICEProtocolUDP, Port: 9, Typ: webrtc.ICECandidateTypeHost, Component: 1}
+	}
+	peer.onICECandidate(stale, candidate("203.0.113.1"))
+	if hasCandidate("203.0.113.1") {
+		t.Fatal("expected the candidate of the replaced connection to be dropped")
+	}
+	peer.onICECandidate(peer.Connection(), candidate("203.0.113.2"))
+	if !hasCandidate("203.0.113.2") {
+		t.Fatal("expected the candidate of the current connection to be kept")
+	}
+}
+
+func TestDoubleInitiator(t *testing.T) {
+	peer1Connect := make(chan bool, 1)
+	peer2Connect := make(chan bool, 1)
+	peer1Data := make(chan string, 1)
+	peer2Data := make(chan string, 1)
+	peerErrors := make(chan error, 2)
+	peer1Signals := make(chan map[string]interface{}, 100)
+	peer2Signals := make(chan map[string]interface{}, 100)
+
+	peer1 := NewPeer(PeerOptions{
+		Id:          "peer1",
+		ChannelName: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			peer1Signals <- message
+			return nil
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+		OnData: func(message webrtc.DataChannelMessage) {
+			peer1Data <- string(message.Data)
+		},
+		OnError: func(err error) {
+			peerErrors <- err
+		},
+	})
+	peer2 := NewPeer(PeerOptions{
+		Id:          "peer2",
+		ChannelName: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			peer2Signals <- message
+			return nil
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+		OnData: func(message webrtc.DataChannelMessage) {
+			peer2Data <- string(message.Data)
+		},
+		OnError: func(err error) {
+			peerErrors <- err
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer2.Init(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	defer close(done)
+	forward := func(signals chan map[string]interface{}, to *Peer) {
+		for {
+			select {
+			case <-done:
+				return
+			case message := <-signals:
+				to.Signal(message)
+			}
+		}
+	}
+	go forward(peer1Signals, peer2)
+	go forward(peer2Signals, peer1)
+
+	for _, connect := range []chan bool{peer1Connect, peer2Connect} {
+		select {
+		case <-connect:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the double initiators to connect")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-peerErrors; !errors.Is(err, ErrDoubleInitiator) {
+			t.Fatalf("expected double initiator error, got %v", err)
+		}
+	}
+	if peer1.Initiator() == peer2.Initiator() {
+		t.Fatal("expected exactly one initiator to survive")
+	}
+	for _, peer := range []*Peer{peer1, peer2} {
+		peer.mutex.RLock()
+		channelCount := len(peer.channels)
+		peer.mutex.RUnlock()
+		if channelCount != 1 {
+			t.Fatalf("%s: expected a single channel, got %d", peer.Id(), channelCount)
+		}
+	}
+	peer1Channel, _ := peer1.ChannelInfo()
+	peer2Channel, _ := peer2.ChannelInfo()
+	if peer1Channel.Label != peer2Channel.Label {
+		t.Fatalf("expected both peers to use the same channel, got %s and %s", peer1Channel.Label, peer2Channel.Label)
+	}
+
+	if _, err := peer1.WriteText("from peer1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer2.WriteText("from peer2"); err != nil {
+		t.Fatal(err)
+	}
+	for expected, data := range map[string]chan string{"from peer1": peer2Data, "from peer2": peer1Data} {
+		select {
+		case received := <-data:
+			if received != expected {
+				t.Fatalf("expected %q, got %q", expected, received)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
+
+func TestOnDataFrom(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		RequiredChannels: []string{"chat", "files"},
+	}, PeerOptions{
+		RequiredChannels: []string{"chat", "files"},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	chat := make(chan string, 2)
+	files := make(chan string, 2)
+	all := make(chan string, 4)
+	messages := make(chan DataMessage, 4)
+	peer2.OnDataFrom("chat", func(message webrtc.DataChannelMessage) {
+		chat <- string(message.Data)
+	})
+	peer2.OnDataFrom("files", func(message webrtc.DataChannelMessage) {
+		files <- string(message.Data)
+	})
+	peer2.OnData(func(message webrtc.DataChannelMessage) {
+		all <- string(message.Data)
+	})
+	peer2.OnDataMessage(func(message DataMessage) {
+		messages <- message
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := peer1.WaitForChannels(ctx, "chat", "files"); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.getChannel("chat").SendText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.getChannel("files").SendText("file"); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(received chan string) string {
+		select {
+		case data := <-received:
+			return data
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for data")
+		}
+		return ""
+	}
+	if data := receive(chat); data != "hello" {
+		t.Fatalf("expected hello on chat, got %q", data)
+	}
+	if data := receive(files); data != "file" {
+		t.Fatalf("expected file on files, got %q", data)
+	}
+	allData := map[string]bool{receive(all): true, receive(all): true}
+	if !allData["hello"] || !allData["file"] {
+		t.Fatalf("expected OnData to see both messages, got %v", allData)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-messages:
+			expected := map[string]string{"hello": "chat", "file": "files"}[string(message.Data)]
+			if message.Label != expected || message.Channel == nil || message.Channel.Label() != expected {
+				t.Fatalf("expected %q to arrive on %s, got %s", message.Data, expected, message.Label)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for data messages")
+		}
+	}
+	select {
+	case data := <-chat:
+		t.Fatalf("expected only chat messages on chat, got %q", data)
+	default:
+	}
+}
+
+func TestOnTrackForMid(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	type midTrack struct {
+		mid   string
+		track *webrtc.TrackRemote
+	}
+	received := make(chan midTrack, 4)
+	generic := make(chan *webrtc.TrackRemote, 4)
+	for _, mid := range []string{"1", "2"} {
+		mid := mid
+		peer2.OnTrackForMid(mid, func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			received <- midTrack{mid: mid, track: track}
+		})
+	}
+	peer2.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		generic <- track
+	})
+
+	var tracks []*webrtc.TrackLocalStaticRTP
+	for _, id := range []string{"camera", "screen"} {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, id, "peer1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := peer1.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, track)
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for sequenceNumber := uint16(0); ; sequenceNumber++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, track := range tracks {
+					track.WriteRTP(&rtp.Packet{
+						Header: rtp.Header{
+							Version:        2,
+							SequenceNumber: sequenceNumber,
+							Timestamp:      uint32(sequenceNumber) * 90,
+						},
+						Payload: []byte{0x10, 0x00, 0x00},
+					})
+				}
+			}
+		}
+	}()
+
+	localMid := func(id string) string {
+		for _, transceiver := range peer1.Connection().GetTransceivers() {
+			if sender := transceiver.Sender(); sender != nil && sender.Track() != nil && sender.Track().ID() == id {
+				return transceiver.Mid()
+			}
+		}
+		return ""
+	}
+	seen := make(map[string]bool)
+	for len(seen) < 2 {
+		select {
+		case received := <-received:
+			if seen[received.mid] {
+				t.Fatalf("expected one track on mid %s", received.mid)
+			}
+			seen[received.mid] = true
+			if mid := localMid(received.track.ID()); mid != received.mid {
+				t.Fatalf("expected track %s on mid %s, got mid %s", received.track.ID(), mid, received.mid)
+			}
+			if mid := peer2.MidForTrack(received.track); mid != received.mid {
+				t.Fatalf("expected MidForTrack to return %s, got %s", received.mid, mid)
+			}
+		case track := <-generic:
+			t.Fatalf("expected track %s to skip OnTrack", track.ID())
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for tracks")
+		}
+	}
+}
+
+func TestWriteBackpressure(t *testing.T) {
+	const total = 32 * 1024 * 1024
+	const highThreshold = 512 * 1024
+	var received atomic.Int64
+	receivedAll := make(chan bool)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		BufferedAmountHighThreshold: highThreshold,
+		BufferedAmountLowThreshold:  128 * 1024,
+	}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			if received.Add(int64(len(message.Data))) == total {
+				close(receivedAll)
+			}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	chunk := make([]byte, 1024*1024)
+	var maxBufferedAmount uint64
+	for sent := 0; sent < total; sent += len(chunk) {
+		if _, err := peer1.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if bufferedAmount := peer1.Channel().BufferedAmount(); bufferedAmount > maxBufferedAmount {
+			maxBufferedAmount = bufferedAmount
+		}
+	}
+	if maxBufferedAmount > highThreshold+maxChannelMessageSize {
+		t.Fatalf("expected at most %d bytes buffered, got %d", highThreshold+maxChannelMessageSize, maxBufferedAmount)
+	}
+	select {
+	case <-receivedAll:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("timed out after receiving %d of %d bytes", received.Load(), total)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	const total = 200 * 1024
+	var received atomic.Int64
+	receivedAll := make(chan bool)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			if received.Add(int64(len(message.Data))) == total {
+				close(receivedAll)
+			}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	writer := peer1.Writer()
+	written, err := io.Copy(writer, bytes.NewReader(make([]byte, total)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != total {
+		t.Fatalf("expected to write %d bytes, got %d", total, written)
+	}
+	select {
+	case <-receivedAll:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out after receiving %d of %d bytes", received.Load(), total)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("closed")); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe after Close, got %v", err)
+	}
+	if peer1.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		t.Fatalf("expected closing the writer to keep the peer connected, got %s", peer1.ConnectionState())
+	}
+}
+
+func TestNegotiationDebounce(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	waitForNegotiations := func(count int) []NegotiationRecord {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			history := negotiationsOnly(peer1.NegotiationHistory())
+			if len(history) >= count {
+				return history
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %d negotiations, got %d", count, len(history))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	initial := len(waitForNegotiations(1))
+
+	const debounce = 200 * time.Millisecond
+	peer1.SetNegotiationDebounce(debounce)
+	for i := 0; i < 3; i++ {
+		if _, err := peer1.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := waitForNegotiations(initial + 1)
+	time.Sleep(2 * debounce)
+	if history := negotiationsOnly(peer1.NegotiationHistory()); len(history) != initial+1 {
+		t.Fatalf("expected the changes to coalesce into one negotiation, got %v", history[initial:])
+	}
+	record := history[initial]
+	if record.Intents < 3 {
+		t.Fatalf("expected at least 3 coalesced intents, got %d", record.Intents)
+	}
+	if record.Wait < debounce {
+		t.Fatalf("expected the oldest intent to wait at least %s, got %s", debounce, record.Wait)
+	}
+
+	peer1.SetNegotiationDebounce(0)
+	if _, err := peer1.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	history = waitForNegotiations(initial + 2)
+	if record := history[initial+1]; record.Wait >= debounce {
+		t.Fatalf("expected an immediate negotiation, waited %s", record.Wait)
+	}
+}
+
+// negotiationsOnly drops the signaling state changes from a history.
+func negotiationsOnly(history []NegotiationRecord) []NegotiationRecord {
+	var negotiations []NegotiationRecord
+	for _, record := range history {
+		if record.SignalingState == webrtc.SignalingStateUnknown {
+			negotiations = append(negotiations, record)
+		}
+	}
+	return negotiations
+}
+
+func TestSignalingStateHistory(t *testing.T) {
+	states := make(chan webrtc.SignalingState, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnSignalingStateChange: func(state webrtc.SignalingState) {
+			states <- state
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	for _, expected := range []webrtc.SignalingState{webrtc.SignalingStateHaveLocalOffer, webrtc.SignalingStateStable} {
+		select {
+		case state := <-states:
+			if state != expected {
+				t.Fatalf("expected %s, got %s", expected, state)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+	var transitions []webrtc.SignalingState
+	for _, record := range peer1.NegotiationHistory() {
+		if record.SignalingState != webrtc.SignalingStateUnknown {
+			transitions = append(transitions, record.SignalingState)
+		}
+	}
+	if len(transitions) < 2 || transitions[0] != webrtc.SignalingStateHaveLocalOffer || transitions[1] != webrtc.SignalingStateStable {
+		t.Fatalf("expected have-local-offer then stable in the history, got %v", transitions)
+	}
+}
+
+func TestFrameMessages(t *testing.T) {
+	onMessage := make(chan []byte, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		FrameMessages: true,
+	}, PeerOptions{
+		FrameMessages: true,
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer2.OnMessage(func(message []byte) {
+		onMessage <- message
+	})
+
+	messages := make(chan []byte, 16)
+	go func() {
+		for {
+			message, err := peer2.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- message
+		}
+	}()
+	testutil.WaitFor(t, func() bool {
+		peer2.messageQueue.mutex.Lock()
+		defer peer2.messageQueue.mutex.Unlock()
+		return peer2.messageQueue.active
+	})
+
+	sizes := []int{
+		0,
+		1,
+		maxChannelMessageSize - frameHeaderSize - 1,
+		maxChannelMessageSize - frameHeaderSize,
+		maxChannelMessageSize - frameHeaderSize + 1,
+		maxChannelMessageSize,
+		maxChannelMessageSize + 1,
+		3*maxChannelMessageSize + 7,
+	}
+	for i, size := range sizes {
+		payload := make([]byte, size)
+		for j := range payload {
+			payload[j] = byte(i + j)
+		}
+		written, err := peer1.Write(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != size {
+			t.Fatalf("expected to write %d bytes, got %d", size, written)
+		}
+		select {
+		case message := <-messages:
+			if !bytes.Equal(message, payload) {
+				t.Fatalf("expected a %d byte payload to arrive whole, got %d bytes", size, len(message))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %d byte payload", size)
+		}
+		select {
+		case message := <-onMessage:
+			if len(message) != size {
+				t.Fatalf("expected OnMessage to receive %d bytes, got %d", size, len(message))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for OnMessage with %d bytes", size)
+		}
+	}
+}
+
+func TestFrameReaderMalformed(t *testing.T) {
+	var reader frameReader
+	if _, _, err := reader.read([]byte{0, 0}); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("expected ErrMalformedFrame for a short header, got %v", err)
+	}
+	if _, _, err := reader.read([]byte{0, 0, 0, 1, 'a', 'b'}); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("expected ErrMalformedFrame for an overlong frame, got %v", err)
+	}
+	if message, ok, err := reader.read([]byte{0, 0, 0, 2, 'a'}); err != nil || ok {
+		t.Fatalf("expected an incomplete frame, got %q %t %v", message, ok, err)
+	}
+	if message, ok, err := reader.read([]byte{'b'}); err != nil || !ok || string(message) != "ab" {
+		t.Fatalf("expected the frame ab, got %q %t %v", message, ok, err)
+	}
+}
+
+func TestRenegotiationStorm(t *testing.T) {
+	var stormErrors atomic.Int32
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		MaxRenegotiationsPerMinute: 5,
+		OnError: func(err error) {
+			if errors.Is(err, ErrRenegotiationStorm) {
+				stormErrors.Add(1)
+			}
+		},
+	}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	rejected := 0
+	for i := 0; i < 50; i++ {
+		if err := peer1.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true}); errors.Is(err, ErrRenegotiationStorm) {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejected != 45 {
+		t.Fatalf("expected 45 rejected renegotiations, got %d", rejected)
+	}
+	testutil.WaitFor(t, func() bool {
+		return stormErrors.Load() > 0
+	})
+	time.Sleep(100 * time.Millisecond)
+	if count := stormErrors.Load(); count != 1 {
+		t.Fatalf("expected one rate limited error, got %d", count)
+	}
+	testutil.WaitFor(t, func() bool {
+		return peer1.SignalingState() == webrtc.SignalingStateStable
+	})
+	if peer1.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		t.Fatalf("expected the connection to survive the storm, got %s", peer1.ConnectionState())
+	}
+	if _, err := peer1.Write([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseOnRenegotiationStorm(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		MaxRenegotiationsPerMinute: 1,
+		CloseOnRenegotiationStorm:  true,
+		OnError:                    func(err error) {},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	for i := 0; i < 2; i++ {
+		peer1.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true})
+	}
+	select {
+	case <-peer1.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the peer to close")
+	}
+	if cause := context.Cause(peer1.Context()); !errors.Is(cause, ErrRenegotiationStorm) {
+		t.Fatalf("expected ErrRenegotiationStorm, got %v", cause)
+	}
+}
+
+func TestWriteTextKeepsRunesWhole(t *testing.T) {
+	messages := make(chan webrtc.DataChannelMessage, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{MaxChannelMessageSize: maxChannelMessageSize}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			messages <- message
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	for _, text := range []string{
+		"a" + strings.Repeat("😀", maxChannelMessageSize/4),
+		"ab" + strings.Repeat("漢", maxChannelMessageSize/3),
+	} {
+		written, err := peer1.WriteText(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != len(text) {
+			t.Fatalf("expected to write %d bytes, got %d", len(text), written)
+		}
+		received := 0
+		for received < len(text) {
+			select {
+			case message := <-messages:
+				if !message.IsString || !utf8.Valid(message.Data) {
+					t.Fatalf("expected valid UTF-8 text, got %d bytes ending in %x", len(message.Data), message.Data[len(message.Data)-3:])
+				}
+				if len(message.Data) > maxChannelMessageSize {
+					t.Fatalf("expected at most %d bytes per message, got %d", maxChannelMessageSize, len(message.Data))
+				}
+				received += len(message.Data)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out after receiving %d of %d bytes", received, len(text))
+			}
+		}
+	}
+}
+
+func TestChunkLength(t *testing.T) {
+	if count := chunkLength([]byte("ab€"), 3, true); count != 2 {
+		t.Fatalf("expected to back off to the rune boundary at 2, got %d", count)
+	}
+	if count := chunkLength([]byte("ab€"), 3, false); count != 3 {
+		t.Fatalf("expected binary data to be cut at the limit, got %d", count)
+	}
+	if count := chunkLength([]byte{0x80, 0x80, 0x80, 0x80}, 2, true); count != 2 {
+		t.Fatalf("expected text without a rune boundary to be cut at the limit, got %d", count)
+	}
+	if count := chunkLength([]byte("€"), 8, true); count != 3 {
+		t.Fatalf("expected short text to fit whole, got %d", count)
+	}
+}
+
+func TestCandidateRewrite(t *testing.T) {
+	const publicAddress = "203.0.113.7"
+	peer1Connect := make(chan bool, 1)
+	var signaledMutex sync.Mutex
+	var signaled []string
+
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		CandidateRewrite: func(candidate webrtc.ICECandidateInit) []webrtc.ICECandidateInit {
+			fields := strings.Fields(candidate.Candidate)
+			if len(fields) < 8 || fields[7] != "host" {
+				return []webrtc.ICECandidateInit{candidate}
+			}
+			public := candidate
+			fields[4] = publicAddress
+			public.Candidate = strings.Join(fields, " ")
+			return []webrtc.ICECandidateInit{candidate, public}
+		},
+		OnSignal: func(message map[string]interface{}) error {
+			if message["type"] == SignalMessageCandidate {
+				if candidate, ok := message["candidate"].(map[string]interface{}); ok {
+					signaledMutex.Lock()
+					signaled = append(signaled, candidate["candidate"].(string))
+					signaledMutex.Unlock()
+				}
+			}
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			return peer1.Signal(message)
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-peer1Connect:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting to connect")
+	}
+
+	testutil.WaitFor(t, func() bool {
+		signaledMutex.Lock()
+		defer signaledMutex.Unlock()
+		return len(signaled) > 0 && signaled[len(signaled)-1] == ""
+	})
+	signaledMutex.Lock()
+	defer signaledMutex.Unlock()
+	hosts, publics := 0, 0
+	for _, candidate := range signaled {
+		fields := strings.Fields(candidate)
+		if len(fields) < 8 || fields[7] != "host" {
+			continue
+		}
+		if fields[4] == publicAddress {
+			publics++
+		} else {
+			hosts++
+		}
+	}
+	if hosts == 0 || hosts != publics {
+		t.Fatalf("expected each host candidate to be signaled with its public variant, got %d host and %d public in %v", hosts, publics, signaled)
+	}
+}
+
+func TestNegotiatedChannels(t *testing.T) {
+	for name, channels := range map[string][]NegotiatedChannel{
+		"main": {
+			{Label: "main", ID: 0},
+			{Label: "input", ID: 1, Init: UnreliableChannelConfig(0)},
+		},
+		"extra": {
+			{Label: "input", ID: 5},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := PeerOptions{ChannelName: "main", NegotiatedChannels: channels}
+			peer1, peer2 := connectTestPeers(t, options, options)
+			defer peer1.Close()
+			defer peer2.Close()
+
+			if peer2.Channel().Label() != "main" || peer2.Channel().Negotiated() != (name == "main") {
+				t.Fatalf("expected main channel negotiated=%t, got %s negotiated=%t", name == "main", peer2.Channel().Label(), peer2.Channel().Negotiated())
+			}
+			received := make(chan string, 1)
+			peer2.OnDataFrom("input", func(message webrtc.DataChannelMessage) {
+				received <- string(message.Data)
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := peer1.WaitForChannels(ctx, "input"); err != nil {
+				t.Fatal(err)
+			}
+			if err := peer2.WaitForChannels(ctx, "input"); err != nil {
+				t.Fatal(err)
+			}
+			if !peer2.getChannel("input").Negotiated() {
+				t.Fatal("expected the input channel to be negotiated")
+			}
+			if err := peer1.getChannel("input").SendText("jump"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case data := <-received:
+				if data != "jump" {
+					t.Fatalf("expected jump, got %q", data)
+				}
+			case <-ctx.Done():
+				t.Fatal("timed out waiting for data on the negotiated channel")
+			}
+		})
+	}
+}
+
+func TestInvalidNegotiatedChannels(t *testing.T) {
+	peer := NewPeer(PeerOptions{NegotiatedChannels: []NegotiatedChannel{{Label: "a", ID: 1}, {Label: "b", ID: 1}}})
+	if err := peer.Init(); !errors.Is(err, ErrInvalidChannelConfig) {
+		t.Fatalf("expected ErrInvalidChannelConfig for duplicate ids, got %v", err)
+	}
+}
+
+func TestOnChannelClose(t *testing.T) {
+	var packets atomic.Int32
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			for {
+				if _, _, err := track.ReadRTP(); err != nil {
+					return
+				}
+				packets.Add(1)
+			}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	channelClosed := make(chan bool, 1)
+	peerClosed := make(chan bool, 1)
+	peer2.OnChannelClose(func() {
+		channelClosed <- true
+	})
+	peer2.OnClose(func() {
+		peerClosed <- true
+	})
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for sequenceNumber := uint16(0); ; sequenceNumber++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				track.WriteRTP(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						SequenceNumber: sequenceNumber,
+						Timestamp:      uint32(sequenceNumber) * 90,
+					},
+					Payload: []byte{0x10, 0x00, 0x00},
+				})
+			}
+		}
+	}()
+	testutil.WaitFor(t, func() bool {
+		return packets.Load() > 0
+	})
+
+	if err := peer1.Channel().Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-channelClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnChannelClose")
+	}
+	if _, err := peer2.Write([]byte("hello")); !errors.Is(err, ErrChannelClosed) {
+		t.Fatalf("expected ErrChannelClosed, got %v", err)
+	}
+	received := packets.Load()
+	testutil.WaitFor(t, func() bool {
+		return packets.Load() > received+10
+	})
+	select {
+	case <-peerClosed:
+		t.Fatal("expected the peer to stay open")
+	default:
+	}
+	if peer2.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		t.Fatalf("expected the connection to stay up, got %s", peer2.ConnectionState())
+	}
+}
+
+func TestCloseGracefully(t *testing.T) {
+	const total = 8 * 1024 * 1024
+	var received atomic.Int64
+	receivedAll := make(chan bool)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			if received.Add(int64(len(message.Data))) == total {
+				close(receivedAll)
+			}
+		},
+	})
+	defer peer2.Close()
+
+	if _, err := peer1.Write(make([]byte, total)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := peer1.CloseGracefully(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-receivedAll:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out after receiving %d of %d bytes", received.Load(), total)
+	}
+}
+
+func TestFlushContext(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if err := peer1.Flush(context.Background()); err != nil {
+		t.Fatalf("expected an empty channel to flush, got %v", err)
+	}
+	if _, err := peer1.Write(make([]byte, 4*1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := peer1.Flush(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFlushChannelClosed(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if _, err := peer1.Write(make([]byte, 4*1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.Channel().Close(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := peer1.Flush(ctx); !errors.Is(err, ErrChannelClosed) {
+		t.Fatalf("expected ErrChannelClosed, got %v", err)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	const total = 16 * 1024 * 1024
+	var received atomic.Int64
+	peer1, peer2 := connectTestPeers(t, PeerOptions{BufferedAmountHighThreshold: 128 * 1024}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			received.Add(int64(len(message.Data)))
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sent, err := peer1.WriteContext(ctx, []byte("cancelled")); err != context.Canceled || sent != 0 {
+		t.Fatalf("expected nothing sent with context.Canceled, got %d: %v", sent, err)
+	}
+
+	payload := make([]byte, total)
+	peer1.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	sent, err := peer1.Write(payload)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got %d: %v", sent, err)
+	}
+	if sent == 0 || sent == total || sent%peer1.MaxMessageSize() != 0 {
+		t.Fatalf("expected whole chunks to be sent before the deadline, got %d", sent)
+	}
+	testutil.WaitFor(t, func() bool { return received.Load() == int64(sent) })
+
+	peer1.SetWriteDeadline(time.Time{})
+	if _, err := peer1.Write([]byte("after")); err != nil {
+		t.Fatalf("expected the peer to stay writable, got %v", err)
+	}
+	testutil.WaitFor(t, func() bool { return received.Load() == int64(sent)+5 })
+
+	writer := peer1.Writer()
+	if err := writer.(interface{ SetWriteDeadline(time.Time) error }).SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("late")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the writer's deadline to apply, got %v", err)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	events := make(chan string, 4)
+	reason := errors.New("kicked")
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnError: func(err error) {
+			if errors.Is(err, reason) {
+				events <- "error"
+			}
+		},
+		OnClose: func() {
+			events <- "close"
+		},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	if err := peer1.Destroy(reason); err != nil {
+		t.Fatal(err)
+	}
+	peer1.Destroy(reason)
+	if first, second := <-events, <-events; first == second {
+		t.Fatalf("expected OnError and OnClose, got %s twice", first)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected OnClose once, got %s", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if cause := context.Cause(peer1.Context()); cause != reason {
+		t.Fatalf("expected the reason as context cause, got %v", cause)
+	}
+	if _, err := peer1.Write([]byte("late")); !errors.Is(err, ErrPeerDestroyed) || !errors.Is(err, reason) {
+		t.Fatalf("expected ErrPeerDestroyed wrapping the reason, got %v", err)
+	}
+	if err := peer1.Signal(map[string]interface{}{"type": "renegotiate", "renegotiate": true}); !errors.Is(err, ErrPeerDestroyed) {
+		t.Fatalf("expected ErrPeerDestroyed from Signal, got %v", err)
+	}
+	if err := peer1.Init(); !errors.Is(err, ErrPeerDestroyed) {
+		t.Fatalf("expected ErrPeerDestroyed from Init, got %v", err)
+	}
+}
+
+func TestClosedPeerStaysClosed(t *testing.T) {
+	candidates := make(chan map[string]interface{}, 16)
+	peer1, peer2 := pairTestPeers(PeerOptions{}, PeerOptions{})
+	defer peer2.Close()
+	peer2.OnSignal(func(message map[string]interface{}) error {
+		if message["type"] == SignalMessageCandidate || message["type"] == SignalMessageCandidates {
+			select {
+			case candidates <- message:
+			default:
+			}
+		}
+		return nil
+	})
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := peer1.WaitForChannelOpen(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var candidate map[string]interface{}
+	select {
+	case candidate = <-candidates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a candidate from the remote peer")
+	}
+
+	peer1.Close()
+	if err := peer1.Signal(candidate); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed from a late candidate, got %v", err)
+	}
+	if peer1.Connection() != nil || peer1.Context().Err() == nil {
+		t.Fatal("expected the late candidate not to reopen the peer")
+	}
+	if _, err := peer1.Write([]byte("late")); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed from Write, got %v", err)
+	}
+	if err := peer1.Init(); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed from Init, got %v", err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed from AddTrack, got %v", err)
+	}
+
+	if err := peer1.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	defer peer1.Close()
+	if err := peer1.Signal(candidate); err != nil {
+		t.Fatalf("expected a reopened peer to accept signals, got %v", err)
+	}
+	if peer1.Connection() == nil || peer1.Context().Err() != nil {
+		t.Fatal("expected the reopened peer to create a connection")
+	}
+	peer1.Destroy(nil)
+	if err := peer1.Reopen(); !errors.Is(err, ErrPeerDestroyed) {
+		t.Fatalf("expected a destroyed peer not to reopen, got %v", err)
+	}
+}
+
+func TestConnectionFailureDestroys(t *testing.T) {
+	errs := make(chan error, 4)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnError: func(err error) {
+			errs <- err
+		},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	if err := <-errs; !errors.Is(err, ErrConnectionFailed) {
+		t.Fatalf("expected OnError with ErrConnectionFailed, got %v", err)
+	}
+	if _, err := peer1.Write([]byte("late")); !errors.Is(err, ErrPeerDestroyed) || !errors.Is(err, ErrConnectionFailed) {
+		t.Fatalf("expected ErrPeerDestroyed wrapping ErrConnectionFailed, got %v", err)
+	}
+}
+
+func TestConnectedAndConnectionState(t *testing.T) {
+	peer := NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			return nil
+		},
+	})
+	if peer.Connected() || peer.ConnectionState() != webrtc.PeerConnectionStateUnknown {
+		t.Fatalf("expected an unconnected peer, got %s", peer.ConnectionState())
+	}
+	if err := peer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Write([]byte("early")); !errors.Is(err, ErrChannelNotOpen) {
+		t.Fatalf("expected ErrChannelNotOpen before the channel opens, got %v", err)
+	}
+	peer.Close()
+	if peer.Connected() || peer.ConnectionState() != webrtc.PeerConnectionStateUnknown {
+		t.Fatalf("expected no connection after Close, got %s", peer.ConnectionState())
+	}
+
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer2.Close()
+	testutil.WaitFor(t, peer1.Connected)
+	if state := peer1.ConnectionState(); state != webrtc.PeerConnectionStateConnected {
+		t.Fatalf("expected connected, got %s", state)
+	}
+	peer1.Close()
+	if peer1.Connected() {
+		t.Fatal("expected Connected to be false after Close")
+	}
+}
+
+func TestOnConnectionStateChange(t *testing.T) {
+	states := make(chan webrtc.PeerConnectionState, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnConnectionStateChange: func(state webrtc.PeerConnectionState) {
+			states <- state
+		},
+	}, PeerOptions{})
+	defer peer2.Close()
+	late := make(chan webrtc.PeerConnectionState, 16)
+	peer1.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		late <- state
+	})
+
+	waitForState := func(states chan webrtc.PeerConnectionState, expected webrtc.PeerConnectionState) {
+		t.Helper()
+		for {
+			select {
+			case state := <-states:
+				if state == expected {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %s", expected)
+			}
+		}
+	}
+	waitForState(states, webrtc.PeerConnectionStateConnected)
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	waitForState(states, webrtc.PeerConnectionStateFailed)
+	waitForState(late, webrtc.PeerConnectionStateFailed)
+}
+
+func TestICEStateCallbacks(t *testing.T) {
+	iceStates := make(chan webrtc.ICEConnectionState, 16)
+	gatheringStates := make(chan webrtc.ICEGatheringState, 16)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnICEConnectionStateChange: func(state webrtc.ICEConnectionState) {
+			iceStates <- state
+		},
+		OnICEGatheringStateChange: func(state webrtc.ICEGatheringState) {
+			gatheringStates <- state
+		},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	waitForICEState := func(states chan webrtc.ICEConnectionState, expected webrtc.ICEConnectionState) {
+		t.Helper()
+		for {
+			select {
+			case state := <-states:
+				if state == expected {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %s", expected)
+			}
+		}
+	}
+	waitForICEState(iceStates, webrtc.ICEConnectionStateConnected)
+	for state := range gatheringStates {
+		if state == webrtc.ICEGatheringStateComplete {
+			break
+		}
+	}
+
+	late := make(chan webrtc.ICEConnectionState, 16)
+	peer1.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		late <- state
+	})
+	peer1.Close()
+	waitForICEState(late, webrtc.ICEConnectionStateClosed)
+}
+
+func TestSendBye(t *testing.T) {
+	closed := make(chan struct{})
+	peer1, peer2 := connectTestPeers(t, PeerOptions{SendBye: true}, PeerOptions{
+		OnClose: func() {
+			close(closed)
+		},
+	})
+
+	start := time.Now()
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the remote peer to close")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the bye to close the remote peer at once, took %s", elapsed)
+	}
+	if cause := context.Cause(peer2.Context()); cause != ErrRemoteClosed {
+		t.Fatalf("expected ErrRemoteClosed as the cause, got %v", cause)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	var closes atomic.Int32
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnClose: func() {
+			closes.Add(1)
+		},
+		OnError: func(err error) {},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(3)
+		go func() {
+			defer wait.Done()
+			peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+		}()
+		go func() {
+			defer wait.Done()
+			peer1.Close()
+		}()
+		go func() {
+			defer wait.Done()
+			peer1.Write([]byte("racing"))
+			peer1.Connected()
+			peer1.ChannelInfo()
+		}()
+	}
+	wait.Wait()
+	time.Sleep(100 * time.Millisecond)
+	if count := closes.Load(); count != 1 {
+		t.Fatalf("expected OnClose once, got %d", count)
+	}
+}
+
+func TestConcurrentSignals(t *testing.T) {
+	peer2Signals := make(chan map[string]interface{}, 64)
+	peer1Connect := make(chan struct{}, 1)
+	peer2Connect := make(chan struct{}, 1)
+	var peer2 *Peer
+	peer1 := NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- struct{}{}
+		},
+	})
+	defer peer1.Close()
+	peer2 = NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			peer2Signals <- message
+			return nil
+		},
+		OnConnect: func() {
+			peer2Connect <- struct{}{}
+		},
+	})
+	defer peer2.Close()
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// collect the answer and every candidate, then deliver them all at once
+	var messages []map[string]interface{}
+	for gathered := false; !gathered; {
+		select {
+		case message := <-peer2Signals:
+			messages = append(messages, message)
+			if candidate, ok := message["candidate"].(map[string]interface{}); ok && candidate["candidate"] == "" {
+				gathered = true
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the candidates")
+		}
+	}
+	var wait sync.WaitGroup
+	for _, message := range messages {
+		message := message
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if err := peer1.Signal(message); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wait.Wait()
+	go func() {
+		for message := range peer2Signals {
+			peer1.Signal(message)
+		}
+	}()
+
+	for _, connected := range []chan struct{}{peer1Connect, peer2Connect} {
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the peers to connect")
+		}
+	}
+}
+
+func TestOnCloseOnce(t *testing.T) {
+	var closes atomic.Int32
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnClose: func() {
+			closes.Add(1)
+		},
+		OnError: func(err error) {},
+	}, PeerOptions{})
+	defer peer2.Close()
+
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateFailed)
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateClosed)
+	peer1.onConnectionStateChange(webrtc.PeerConnectionStateDisconnected)
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.Close(); err != nil {
+		t.Fatalf("expected closing again to return nil, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if count := closes.Load(); count != 1 {
+		t.Fatalf("expected OnClose once, got %d", count)
+	}
+}
+
+func TestRemoteId(t *testing.T) {
+	remoteIds := make(chan string, 4)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnRemoteId: func(id string) {
+			remoteIds <- id
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if id := peer1.RemoteId(); id != "peer2" {
+		t.Fatalf("expected peer2, got %q", id)
+	}
+	if id := peer2.RemoteId(); id != "peer1" {
+		t.Fatalf("expected peer1, got %q", id)
+	}
+	select {
+	case id := <-remoteIds:
+		if id != "peer1" {
+			t.Fatalf("expected OnRemoteId with peer1, got %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnRemoteId")
+	}
+	select {
+	case id := <-remoteIds:
+		t.Fatalf("expected OnRemoteId once, got %q again", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSynchronousData(t *testing.T) {
+	const count = 500
+	received := make(chan string, count)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		SynchronousData: true,
+		OnData: func(message webrtc.DataChannelMessage) {
+			received <- string(message.Data)
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	for i := 0; i < count; i++ {
+		if _, err := peer1.WriteText(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case data := <-received:
+			if data != fmt.Sprint(i) {
+				t.Fatalf("expected message %d in order, got %s", i, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+}
+
+func TestAsyncDataIsCopied(t *testing.T) {
+	first := make(chan []byte, 1)
+	second := make(chan []byte, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			first <- message.Data
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer2.OnData(func(message webrtc.DataChannelMessage) {
+		second <- message.Data
+	})
+	if _, err := peer1.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	data, copied := <-first, <-second
+	if &data[0] == &copied[0] {
+		t.Fatal("expected handlers on their own goroutines to receive their own copy")
+	}
+}
+
+func TestReaderKeepsOrder(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+
+	var expected bytes.Buffer
+	go func() {
+		for i := 0; i < 200; i++ {
+			peer1.Write([]byte(fmt.Sprintf("%04d", i)))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&expected, "%04d", i)
+	}
+	actual := make([]byte, expected.Len())
+	if _, err := io.ReadFull(reader, actual); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected.Bytes()) {
+		t.Fatalf("expected the bytes in order, got %s", actual)
+	}
+}
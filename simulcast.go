@@ -0,0 +1,107 @@
+package simplepeer
+
+import (
+	"fmt"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// SendEncoding is an RTCRtpEncodingParameters as browsers send it in a
+// transceiverRequest. pion has no equivalent for ScaleResolutionDownBy and
+// MaxBitrate, they are kept so relayed requests arrive unchanged.
+type SendEncoding struct {
+	RID                   string  `json:"rid,omitempty"`
+	ScaleResolutionDownBy float64 `json:"scaleResolutionDownBy,omitempty"`
+	MaxBitrate            uint64  `json:"maxBitrate,omitempty"`
+}
+
+// SimulcastEncodings returns send encodings for a simulcast layer per rid,
+// for the init of AddTransceiverFromKind.
+func SimulcastEncodings(rids ...string) []webrtc.RTPEncodingParameters {
+	encodings := make([]webrtc.RTPEncodingParameters, 0, len(rids))
+	for _, rid := range rids {
+		encodings = append(encodings, webrtc.RTPEncodingParameters{
+			RTPCodingParameters: webrtc.RTPCodingParameters{RID: rid},
+		})
+	}
+	return encodings
+}
+
+// SimulcastLayer is the track of one simulcast layer of a sender.
+type SimulcastLayer struct {
+	*webrtc.TrackLocalStaticRTP
+	transceiver *webrtc.RTPTransceiver
+}
+
+// WriteRTP writes packet to the layer, tagged with the mid and rid header
+// extensions the remote peer tells the layers apart by.
+func (layer *SimulcastLayer) WriteRTP(packet *rtp.Packet) error {
+	mid := layer.transceiver.Mid()
+	if mid == "" {
+		// not negotiated yet, so the track is not bound and drops packets
+		return layer.TrackLocalStaticRTP.WriteRTP(packet)
+	}
+	for _, extension := range layer.transceiver.Sender().GetParameters().HeaderExtensions {
+		var err error
+		switch extension.URI {
+		case sdp.SDESMidURI:
+			err = packet.Header.SetExtension(uint8(extension.ID), []byte(mid))
+		case sdp.SDESRTPStreamIDURI:
+			err = packet.Header.SetExtension(uint8(extension.ID), []byte(layer.RID()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return layer.TrackLocalStaticRTP.WriteRTP(packet)
+}
+
+// SimulcastLayers returns the layers of a simulcast sender added by
+// AddTransceiverFromKind, in the order of their send encodings, or nil if
+// sender does not send simulcast.
+func (peer *Peer) SimulcastLayers(sender *webrtc.RTPSender) []*SimulcastLayer {
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return peer.simulcastLayers[sender]
+}
+
+// addSimulcastLayers replaces the track pion creates for the sender of a
+// transceiver with a track per rid, if there are several encodings.
+func (peer *Peer) addSimulcastLayers(transceiver *webrtc.RTPTransceiver, encodings []webrtc.RTPEncodingParameters) error {
+	sender := transceiver.Sender()
+	if len(encodings) < 2 || sender == nil || sender.Track() == nil {
+		return nil
+	}
+	track := sender.Track()
+	codecTrack, ok := track.(interface {
+		Codec() webrtc.RTPCodecCapability
+	})
+	if !ok {
+		return fmt.Errorf("simulcast sender track %s has no codec", track.ID())
+	}
+	layers := make([]*SimulcastLayer, 0, len(encodings))
+	for i, encoding := range encodings {
+		layerTrack, err := webrtc.NewTrackLocalStaticRTP(codecTrack.Codec(), track.ID(), track.StreamID(), webrtc.WithRTPStreamID(encoding.RID))
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			err = sender.ReplaceTrack(layerTrack)
+		} else {
+			err = sender.AddEncoding(layerTrack)
+		}
+		if err != nil {
+			return fmt.Errorf("simulcast layer %q: %w", encoding.RID, err)
+		}
+		layers = append(layers, &SimulcastLayer{TrackLocalStaticRTP: layerTrack, transceiver: transceiver})
+	}
+	peer.mutex.Lock()
+	if peer.simulcastLayers == nil {
+		peer.simulcastLayers = make(map[*webrtc.RTPSender][]*SimulcastLayer)
+	}
+	peer.simulcastLayers[sender] = layers
+	peer.mutex.Unlock()
+	return nil
+}
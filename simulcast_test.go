@@ -0,0 +1,89 @@
+package simplepeer
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSimulcastTransceiverRequest(t *testing.T) {
+	transceivers := make(chan *webrtc.RTPTransceiver, 1)
+	var mutex sync.Mutex
+	rids := map[string]int{}
+	peer1, peer2 := connectTestPeers(t, PeerOptions{
+		OnTransceiver: func(transceiver *webrtc.RTPTransceiver) {
+			transceivers <- transceiver
+		},
+	}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			mutex.Lock()
+			rids[track.RID()]++
+			mutex.Unlock()
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	if _, err := peer2.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction:     webrtc.RTPTransceiverDirectionSendonly,
+		SendEncodings: SimulcastEncodings("q", "h", "f"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var transceiver *webrtc.RTPTransceiver
+	select {
+	case transceiver = <-transceivers:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the requested transceiver")
+	}
+	layers := peer1.SimulcastLayers(transceiver.Sender())
+	if len(layers) != 3 {
+		t.Fatalf("expected 3 simulcast layers, got %d", len(layers))
+	}
+	for i, rid := range []string{"q", "h", "f"} {
+		if layers[i].RID() != rid {
+			t.Fatalf("expected layer %d to have rid %q, got %q", i, rid, layers[i].RID())
+		}
+	}
+
+	received := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var received []string
+		for rid, count := range rids {
+			if count != 1 {
+				t.Fatalf("expected one track for rid %q, got %d", rid, count)
+			}
+			received = append(received, rid)
+		}
+		sort.Strings(received)
+		return received
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for sequenceNumber := uint16(0); len(received()) < 3; sequenceNumber++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the simulcast tracks, got %v", received())
+		}
+		time.Sleep(20 * time.Millisecond)
+		for _, layer := range layers {
+			packet := &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					SequenceNumber: sequenceNumber,
+					PayloadType:    96,
+				},
+				Payload: []byte{0x00},
+			}
+			if err := layer.WriteRTP(packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := received(); got[0] != "f" || got[1] != "h" || got[2] != "q" {
+		t.Fatalf("expected tracks for rids f, h and q, got %v", got)
+	}
+}
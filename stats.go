@@ -0,0 +1,221 @@
+package simplepeer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+)
+
+// OnStats is called with the snapshots of OnStats polling.
+type OnStats func(stats PeerStats)
+
+// PeerStats is a snapshot of the statistics of the peer's connection.
+type PeerStats struct {
+	Timestamp          time.Time
+	ConnectionState    webrtc.PeerConnectionState
+	ICEConnectionState webrtc.ICEConnectionState
+	DTLSState          webrtc.DTLSTransportState
+	// CandidatePair is the selected candidate pair, nil until ICE selected
+	// one.
+	CandidatePair *CandidatePairStats
+	// Inbound has the counters of remote tracks and Outbound those of local
+	// tracks, one per SSRC. Inbound counters only cover packets read from
+	// the track.
+	Inbound  []TrackStats
+	Outbound []TrackStats
+	// Channels has the counters of every data channel, sorted by label.
+	Channels []ChannelStats
+}
+
+// CandidatePairStats is the selected ICE candidate pair with its round trip
+// time and available outgoing bitrate in bits per second, both zero until
+// measured.
+type CandidatePairStats struct {
+	CandidatePairInfo
+	RoundTripTime            time.Duration
+	AvailableOutgoingBitrate float64
+}
+
+// TrackStats counts the RTP packets of one stream of a track. PacketsLost
+// is only set for inbound streams.
+type TrackStats struct {
+	TrackID     string
+	Kind        webrtc.RTPCodecType
+	Mid         string
+	RID         string
+	SSRC        webrtc.SSRC
+	Packets     uint64
+	Bytes       uint64
+	PacketsLost int64
+	NACKCount   uint32
+	PLICount    uint32
+	FIRCount    uint32
+}
+
+// ChannelStats counts the messages and bytes of one data channel.
+type ChannelStats struct {
+	Label            string
+	State            webrtc.DataChannelState
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+}
+
+// Stats returns a snapshot of the statistics of the current connection.
+func (peer *Peer) Stats() (PeerStats, error) {
+	peer.mutex.RLock()
+	connection := peer.connection
+	streamStats := peer.streamStats
+	peer.mutex.RUnlock()
+	if connection == nil {
+		return PeerStats{}, peer.notInitializedErr()
+	}
+	snapshot := PeerStats{
+		Timestamp:          time.Now(),
+		ConnectionState:    connection.ConnectionState(),
+		ICEConnectionState: connection.ICEConnectionState(),
+	}
+	if sctp := connection.SCTP(); sctp != nil {
+		if dtls := sctp.Transport(); dtls != nil {
+			snapshot.DTLSState = dtls.State()
+		}
+	}
+	report := connection.GetStats()
+	if pair := selectedCandidatePair(connection); pair != nil {
+		snapshot.CandidatePair = candidatePairStats(report, pair)
+	}
+	for _, entry := range report {
+		if channelStats, ok := entry.(webrtc.DataChannelStats); ok {
+			snapshot.Channels = append(snapshot.Channels, ChannelStats{
+				Label:            channelStats.Label,
+				State:            channelStats.State,
+				MessagesSent:     uint64(channelStats.MessagesSent),
+				MessagesReceived: uint64(channelStats.MessagesReceived),
+				BytesSent:        channelStats.BytesSent,
+				BytesReceived:    channelStats.BytesReceived,
+			})
+		}
+	}
+	sort.Slice(snapshot.Channels, func(i, j int) bool {
+		return snapshot.Channels[i].Label < snapshot.Channels[j].Label
+	})
+	snapshot.Inbound, snapshot.Outbound = trackStats(connection, streamStats)
+	return snapshot, nil
+}
+
+// OnStats calls fn with a snapshot every interval until the returned stop
+// is called or the peer closes. Intervals without a connection are skipped.
+func (peer *Peer) OnStats(interval time.Duration, fn OnStats) (stop func()) {
+	done := make(chan struct{})
+	ctx := peer.Context()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if snapshot, err := peer.Stats(); err == nil {
+					fn(snapshot)
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// candidatePairStats finds the report's stats of the selected pair by the
+// addresses of its candidates.
+func candidatePairStats(report webrtc.StatsReport, pair *CandidatePairInfo) *CandidatePairStats {
+	result := &CandidatePairStats{CandidatePairInfo: *pair}
+	for _, entry := range report {
+		pairStats, ok := entry.(webrtc.ICECandidatePairStats)
+		if !ok {
+			continue
+		}
+		if !candidateMatches(report, pairStats.LocalCandidateID, pair.Local) || !candidateMatches(report, pairStats.RemoteCandidateID, pair.Remote) {
+			continue
+		}
+		result.RoundTripTime = time.Duration(pairStats.CurrentRoundTripTime * float64(time.Second))
+		result.AvailableOutgoingBitrate = pairStats.AvailableOutgoingBitrate
+		break
+	}
+	return result
+}
+
+func candidateMatches(report webrtc.StatsReport, id string, candidate webrtc.ICECandidate) bool {
+	entry, ok := report[id]
+	if !ok {
+		return false
+	}
+	candidateStats, ok := entry.(webrtc.ICECandidateStats)
+	return ok && candidateStats.IP == candidate.Address && candidateStats.Port == int32(candidate.Port)
+}
+
+// trackStats lists the streams of the connection's transceivers with the
+// counters recorded by the stats interceptor, zero without one.
+func trackStats(connection *webrtc.PeerConnection, streamStats stats.Getter) (inbound, outbound []TrackStats) {
+	get := func(ssrc webrtc.SSRC) stats.Stats {
+		if streamStats != nil {
+			if recorded := streamStats.Get(uint32(ssrc)); recorded != nil {
+				return *recorded
+			}
+		}
+		return stats.Stats{}
+	}
+	for _, transceiver := range connection.GetTransceivers() {
+		mid := transceiver.Mid()
+		if sender := transceiver.Sender(); sender != nil && sender.Track() != nil {
+			track := sender.Track()
+			for _, encoding := range sender.GetParameters().Encodings {
+				recorded := get(encoding.SSRC)
+				outbound = append(outbound, TrackStats{
+					TrackID:   track.ID(),
+					Kind:      track.Kind(),
+					Mid:       mid,
+					RID:       encoding.RID,
+					SSRC:      encoding.SSRC,
+					Packets:   recorded.OutboundRTPStreamStats.PacketsSent,
+					Bytes:     recorded.OutboundRTPStreamStats.BytesSent,
+					NACKCount: recorded.OutboundRTPStreamStats.NACKCount,
+					PLICount:  recorded.OutboundRTPStreamStats.PLICount,
+					FIRCount:  recorded.OutboundRTPStreamStats.FIRCount,
+				})
+			}
+		}
+		if receiver := transceiver.Receiver(); receiver != nil {
+			for _, track := range receiver.Tracks() {
+				if track.SSRC() == 0 {
+					continue
+				}
+				recorded := get(track.SSRC())
+				inbound = append(inbound, TrackStats{
+					TrackID:     track.ID(),
+					Kind:        track.Kind(),
+					Mid:         mid,
+					RID:         track.RID(),
+					SSRC:        track.SSRC(),
+					Packets:     recorded.InboundRTPStreamStats.PacketsReceived,
+					Bytes:       recorded.InboundRTPStreamStats.BytesReceived,
+					PacketsLost: recorded.InboundRTPStreamStats.PacketsLost,
+					NACKCount:   recorded.InboundRTPStreamStats.NACKCount,
+					PLICount:    recorded.InboundRTPStreamStats.PLICount,
+					FIRCount:    recorded.InboundRTPStreamStats.FIRCount,
+				})
+			}
+		}
+	}
+	return inbound, outbound
+}
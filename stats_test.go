@@ -0,0 +1,84 @@
+package simplepeer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/aicacia/go-simplepeer/testsrc"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestStats(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnSample: func(track *webrtc.TrackRemote, sample media.Sample) {},
+	})
+	defer peer2.Close()
+
+	track, err := testsrc.NewVP8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer1.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	track.Start()
+	defer track.Stop()
+	for i := 0; i < 3; i++ {
+		if _, err := peer1.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var snapshot PeerStats
+	testutil.WaitFor(t, func() bool {
+		snapshot, err = peer2.Stats()
+		return err == nil && len(snapshot.Inbound) == 1 && snapshot.Inbound[0].Packets > 0
+	})
+	if snapshot.Inbound[0].Kind != webrtc.RTPCodecTypeVideo || snapshot.Inbound[0].Bytes == 0 {
+		t.Fatalf("expected inbound video bytes, got %+v", snapshot.Inbound[0])
+	}
+	if snapshot.DTLSState != webrtc.DTLSTransportStateConnected {
+		t.Fatalf("expected connected DTLS, got %s", snapshot.DTLSState)
+	}
+	if snapshot.CandidatePair == nil || snapshot.CandidatePair.Local.Address == "" {
+		t.Fatalf("expected the selected candidate pair, got %+v", snapshot.CandidatePair)
+	}
+	var received uint64
+	for _, channel := range snapshot.Channels {
+		received += channel.MessagesReceived
+	}
+	if received < 3 {
+		t.Fatalf("expected at least 3 received messages, got %+v", snapshot.Channels)
+	}
+
+	testutil.WaitFor(t, func() bool {
+		snapshot, err = peer1.Stats()
+		return err == nil && len(snapshot.Outbound) == 1 && snapshot.Outbound[0].Packets > 0
+	})
+	if snapshot.Outbound[0].TrackID != "video" {
+		t.Fatalf("expected outbound track video, got %q", snapshot.Outbound[0].TrackID)
+	}
+
+	var polled atomic.Int32
+	peer1.OnStats(20*time.Millisecond, func(PeerStats) {
+		polled.Add(1)
+	})
+	testutil.WaitFor(t, func() bool {
+		return polled.Load() >= 2
+	})
+	if err := peer1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	count := polled.Load()
+	time.Sleep(100 * time.Millisecond)
+	if polled.Load() != count {
+		t.Fatal("expected polling to stop on Close")
+	}
+	if _, err := peer1.Stats(); err == nil {
+		t.Fatal("expected an error without a connection")
+	}
+}
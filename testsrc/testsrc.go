@@ -0,0 +1,210 @@
+// Package testsrc provides synthetic media tracks for tests and demos.
+//
+// The tracks write well formed VP8 keyframes and Opus packets at a fixed
+// frame rate, each ending with its frame number, so receivers can check
+// which frames arrived and in what order. The frames are not encoded
+// pictures or sound.
+package testsrc
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+const (
+	defaultStreamID       = "testsrc"
+	defaultVideoFrameRate = 30
+	defaultAudioFrameRate = 50
+	defaultWidth          = 320
+	defaultHeight         = 240
+)
+
+// frameMarker precedes the frame number at the end of every frame.
+var frameMarker = []byte("tsrc")
+
+type Options struct {
+	// ID and StreamID of the track, "video" or "audio" and "testsrc" by
+	// default.
+	ID       string
+	StreamID string
+	// FrameRate is the frames per second, 30 for video and 50 for audio by
+	// default. Audio frames last at most 20ms, so lower audio rates leave
+	// gaps.
+	FrameRate int
+	// Width and Height are written into the VP8 keyframe headers, 320x240
+	// by default.
+	Width  uint16
+	Height uint16
+}
+
+// Track is a local track writing a synthetic frame every frame interval
+// between Start and Stop.
+type Track struct {
+	*webrtc.TrackLocalStaticSample
+	interval time.Duration
+	frame    func(number uint32) []byte
+	mutex    sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+	frames   atomic.Uint32
+}
+
+// NewVP8 returns a video track of VP8 keyframes.
+func NewVP8(options ...Options) (*Track, error) {
+	merged := mergeOptions("video", defaultVideoFrameRate, options)
+	return newTrack(webrtc.MimeTypeVP8, merged, func(number uint32) []byte {
+		return vp8Frame(merged.Width, merged.Height, number)
+	})
+}
+
+// NewOpus returns an audio track of mono Opus packets.
+func NewOpus(options ...Options) (*Track, error) {
+	merged := mergeOptions("audio", defaultAudioFrameRate, options)
+	toc := opusTOC(time.Second / time.Duration(merged.FrameRate))
+	return newTrack(webrtc.MimeTypeOpus, merged, func(number uint32) []byte {
+		return appendFrameNumber([]byte{toc}, number)
+	})
+}
+
+func mergeOptions(id string, frameRate int, options []Options) Options {
+	merged := Options{
+		ID:        id,
+		StreamID:  defaultStreamID,
+		FrameRate: frameRate,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+	}
+	for _, option := range options {
+		if option.ID != "" {
+			merged.ID = option.ID
+		}
+		if option.StreamID != "" {
+			merged.StreamID = option.StreamID
+		}
+		if option.FrameRate > 0 {
+			merged.FrameRate = option.FrameRate
+		}
+		if option.Width > 0 {
+			merged.Width = option.Width
+		}
+		if option.Height > 0 {
+			merged.Height = option.Height
+		}
+	}
+	return merged
+}
+
+func newTrack(mimeType string, options Options, frame func(number uint32) []byte) (*Track, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeType}, options.ID, options.StreamID)
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		TrackLocalStaticSample: track,
+		interval:               time.Second / time.Duration(options.FrameRate),
+		frame:                  frame,
+	}, nil
+}
+
+// Start starts writing frames. Frames written before the track is bound to
+// a connection are dropped.
+func (track *Track) Start() {
+	track.mutex.Lock()
+	defer track.mutex.Unlock()
+	if track.stop != nil {
+		return
+	}
+	track.stop = make(chan struct{})
+	track.done = make(chan struct{})
+	go track.run(track.stop, track.done)
+}
+
+// Stop stops writing frames and waits for the current write to return.
+func (track *Track) Stop() {
+	track.mutex.Lock()
+	defer track.mutex.Unlock()
+	if track.stop == nil {
+		return
+	}
+	close(track.stop)
+	<-track.done
+	track.stop = nil
+	track.done = nil
+}
+
+// Frames returns how many frames were written.
+func (track *Track) Frames() uint32 {
+	return track.frames.Load()
+}
+
+func (track *Track) run(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(track.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			number := track.frames.Load()
+			if err := track.WriteSample(media.Sample{Data: track.frame(number), Duration: track.interval}); err != nil {
+				return
+			}
+			track.frames.Add(1)
+		}
+	}
+}
+
+// FrameNumber returns the frame number of a frame or sample written by a
+// Track, or false if data is not one.
+func FrameNumber(data []byte) (uint32, bool) {
+	end := len(data) - 4
+	start := end - len(frameMarker)
+	if start < 0 || string(data[start:end]) != string(frameMarker) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[end:]), true
+}
+
+func appendFrameNumber(frame []byte, number uint32) []byte {
+	frame = append(frame, frameMarker...)
+	return binary.BigEndian.AppendUint32(frame, number)
+}
+
+// vp8Frame returns a keyframe with a valid uncompressed data chunk, the
+// frame tag and dimensions, followed by the frame number.
+func vp8Frame(width, height uint16, number uint32) []byte {
+	const headerSize = 10
+	frame := make([]byte, headerSize, headerSize+len(frameMarker)+4)
+	frame = appendFrameNumber(frame, number)
+	// key frame bit unset, version 0, shown, then the first partition size
+	tag := uint32(1<<4) | uint32(len(frame)-headerSize)<<5
+	frame[0] = byte(tag)
+	frame[1] = byte(tag >> 8)
+	frame[2] = byte(tag >> 16)
+	copy(frame[3:6], []byte{0x9d, 0x01, 0x2a})
+	binary.LittleEndian.PutUint16(frame[6:8], width&0x3fff)
+	binary.LittleEndian.PutUint16(frame[8:10], height&0x3fff)
+	return frame
+}
+
+// opusTOC returns the TOC byte of one fullband CELT mono frame of the
+// duration, which Opus allows to be 2.5, 5, 10 or 20ms. Other durations
+// are labeled 20ms.
+func opusTOC(duration time.Duration) byte {
+	switch {
+	case duration <= 2500*time.Microsecond:
+		return 28 << 3
+	case duration <= 5*time.Millisecond:
+		return 29 << 3
+	case duration <= 10*time.Millisecond:
+		return 30 << 3
+	default:
+		return 31 << 3
+	}
+}
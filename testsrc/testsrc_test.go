@@ -0,0 +1,79 @@
+package testsrc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp/codecs"
+)
+
+func TestVP8Frame(t *testing.T) {
+	frame := vp8Frame(640, 480, 7)
+	if !(&codecs.VP8Packet{}).IsPartitionHead(frame) || frame[0]&0x01 != 0 {
+		t.Fatal("expected a keyframe")
+	}
+	if frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		t.Fatalf("expected the keyframe start code, got %x", frame[3:6])
+	}
+	if width, height := int(frame[6])|int(frame[7])<<8, int(frame[8])|int(frame[9])<<8; width != 640 || height != 480 {
+		t.Fatalf("expected 640x480, got %dx%d", width, height)
+	}
+	if size := int(frame[0])>>5 | int(frame[1])<<3 | int(frame[2])<<11; size != len(frame)-10 {
+		t.Fatalf("expected a first partition of %d bytes, got %d", len(frame)-10, size)
+	}
+	if number, ok := FrameNumber(frame); !ok || number != 7 {
+		t.Fatalf("expected frame number 7, got %d, %v", number, ok)
+	}
+}
+
+func TestOpusTOC(t *testing.T) {
+	for _, test := range []struct {
+		frameRate int
+		toc       byte
+	}{
+		{50, 0xf8},
+		{100, 0xf0},
+		{400, 0xe0},
+		{25, 0xf8},
+	} {
+		track, err := NewOpus(Options{FrameRate: test.frameRate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame := track.frame(1); frame[0] != test.toc {
+			t.Errorf("expected TOC %x at %d frames per second, got %x", test.toc, test.frameRate, frame[0])
+		}
+	}
+}
+
+func TestFrameNumber(t *testing.T) {
+	if _, ok := FrameNumber([]byte("short")); ok {
+		t.Fatal("expected no frame number in a short buffer")
+	}
+	if _, ok := FrameNumber([]byte("no frame marker here")); ok {
+		t.Fatal("expected no frame number without the marker")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	track, err := NewVP8(Options{FrameRate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.ID() != "video" || track.StreamID() != "testsrc" {
+		t.Fatalf("expected the default ids, got %q %q", track.ID(), track.StreamID())
+	}
+	track.Start()
+	track.Start()
+	time.Sleep(100 * time.Millisecond)
+	track.Stop()
+	frames := track.Frames()
+	if frames == 0 {
+		t.Fatal("expected frames to be written")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if track.Frames() != frames {
+		t.Fatal("expected no frames after Stop")
+	}
+	track.Stop()
+}
@@ -0,0 +1,147 @@
+package simplepeer
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const tokenPollInterval = 10 * time.Millisecond
+
+// ExportOffer initiates the peer if needed and returns its offer, with every
+// ICE candidate, as a single line token for ImportSignal on the answerer,
+// e.g. to connect two terminals by copy and paste. It waits for ICE
+// gathering to complete or ctx to be done.
+func (peer *Peer) ExportOffer(ctx context.Context) (string, error) {
+	if peer.Connection() == nil {
+		if err := peer.Init(); err != nil {
+			return "", err
+		}
+	}
+	if !peer.initiator.Load() {
+		return "", fmt.Errorf("%w: the answerer exports its answer with ExportAnswer", ErrTokenOrder)
+	}
+	return peer.exportDescription(ctx, webrtc.SDPTypeOffer)
+}
+
+// ExportAnswer returns the answer to the offer token applied with
+// ImportSignal as a token for ImportSignal on the initiator. It waits for
+// ICE gathering to complete or ctx to be done.
+func (peer *Peer) ExportAnswer(ctx context.Context) (string, error) {
+	connection := peer.Connection()
+	if peer.initiator.Load() {
+		return "", fmt.Errorf("%w: the initiator exports its offer with ExportOffer", ErrTokenOrder)
+	}
+	if connection == nil || connection.RemoteDescription() == nil {
+		return "", fmt.Errorf("%w: import the offer token before exporting an answer", ErrTokenOrder)
+	}
+	return peer.exportDescription(ctx, webrtc.SDPTypeAnswer)
+}
+
+// ImportSignal applies an offer or answer token from ExportOffer or
+// ExportAnswer. Tokens in the wrong order, like an offer pasted into the
+// initiator, return an error matching ErrTokenOrder and are not applied.
+func (peer *Peer) ImportSignal(token string) error {
+	description, err := decodeToken(token, peer.maxSdpBytes)
+	if err != nil {
+		return err
+	}
+	switch description.Type {
+	case webrtc.SDPTypeOffer:
+		if peer.initiator.Load() {
+			return fmt.Errorf("%w: an offer token was imported into the initiator, import it into the answerer", ErrTokenOrder)
+		}
+	case webrtc.SDPTypeAnswer:
+		connection := peer.Connection()
+		if connection == nil || connection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+			return fmt.Errorf("%w: an answer token needs the offer of this peer, export it with ExportOffer first", ErrTokenOrder)
+		}
+	}
+	return peer.handleSignal(SignalMessage{Type: description.Type.String(), SDP: description.SDP})
+}
+
+// exportDescription waits for a local description of sdpType with every
+// candidate gathered and encodes it.
+func (peer *Peer) exportDescription(ctx context.Context, sdpType webrtc.SDPType) (string, error) {
+	ticker := time.NewTicker(tokenPollInterval)
+	defer ticker.Stop()
+	for {
+		connection := peer.Connection()
+		if connection == nil {
+			return "", peer.notInitializedErr()
+		}
+		if description := connection.LocalDescription(); description != nil && description.Type == sdpType {
+			select {
+			case <-webrtc.GatheringCompletePromise(connection):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			sdp := connection.LocalDescription().SDP
+			if peer.sdpTransform != nil {
+				sdp = peer.sdpTransform(sdp)
+			}
+			return encodeToken(sdpType, sdp)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-peer.Context().Done():
+			return "", ErrPeerClosed
+		}
+	}
+}
+
+// encodeToken deflates the description's type letter and sdp and encodes
+// them as unpadded url safe base64.
+func encodeToken(sdpType webrtc.SDPType, sdp string) (string, error) {
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	writer.Write([]byte(sdpType.String()[:1]))
+	writer.Write([]byte(sdp))
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+func decodeToken(token string, maxSdpBytes int) (webrtc.SessionDescription, error) {
+	compressed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	var reader io.Reader = flate.NewReader(bytes.NewReader(compressed))
+	if maxSdpBytes > 0 {
+		// the type letter and one byte over the limit are enough to reject it
+		reader = io.LimitReader(reader, int64(maxSdpBytes)+2)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if maxSdpBytes > 0 && len(data) > maxSdpBytes+1 {
+		return webrtc.SessionDescription{}, &SdpLimitError{Remote: true, Limit: "bytes", Size: len(data) - 1, Max: maxSdpBytes}
+	}
+	if len(data) == 0 {
+		return webrtc.SessionDescription{}, fmt.Errorf("%w: empty", ErrInvalidToken)
+	}
+	description := webrtc.SessionDescription{SDP: string(data[1:])}
+	switch data[0] {
+	case 'o':
+		description.Type = webrtc.SDPTypeOffer
+	case 'a':
+		description.Type = webrtc.SDPTypeAnswer
+	default:
+		return webrtc.SessionDescription{}, fmt.Errorf("%w: unknown type %q", ErrInvalidToken, data[0])
+	}
+	return description, nil
+}
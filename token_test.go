@@ -0,0 +1,66 @@
+package simplepeer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignalTokens(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	connected := make(chan struct{}, 2)
+	onConnect := func() {
+		connected <- struct{}{}
+	}
+	peer1 := NewPeer(PeerOptions{OnConnect: onConnect})
+	defer peer1.Close()
+	peer2 := NewPeer(PeerOptions{OnConnect: onConnect})
+	defer peer2.Close()
+
+	if _, err := peer2.ExportAnswer(ctx); !errors.Is(err, ErrTokenOrder) {
+		t.Fatalf("expected ErrTokenOrder exporting an answer first, got %v", err)
+	}
+	offer, err := peer1.ExportOffer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(offer, "\r\n") {
+		t.Fatal("expected a single line token")
+	}
+	if len(offer) >= len(peer1.Connection().LocalDescription().SDP) {
+		t.Fatalf("expected the token to be shorter than the sdp, got %d bytes", len(offer))
+	}
+	if err := peer1.ImportSignal(offer); !errors.Is(err, ErrTokenOrder) {
+		t.Fatalf("expected ErrTokenOrder importing an offer into the initiator, got %v", err)
+	}
+	if err := NewPeer().ImportSignal("not a token"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	if err := peer2.ImportSignal(offer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer2.ExportOffer(ctx); !errors.Is(err, ErrTokenOrder) {
+		t.Fatalf("expected ErrTokenOrder exporting an offer from the answerer, got %v", err)
+	}
+	answer, err := peer2.ExportAnswer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewPeer().ImportSignal(answer); !errors.Is(err, ErrTokenOrder) {
+		t.Fatalf("expected ErrTokenOrder importing an answer without an offer, got %v", err)
+	}
+	if err := peer1.ImportSignal(answer); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			t.Fatal("timed out connecting")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package simplepeer
+
+import (
+	"github.com/pion/webrtc/v4"
+)
+
+// SignalMessageMetadata is the application metadata of a local track,
+// keyed by the track ID the remote peer sees on the TrackRemote.
+type SignalMessageMetadata struct {
+	TrackID  string            `json:"trackId"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// OnTrackMetadata is called with the metadata the remote peer set for a
+// track, on every update.
+type OnTrackMetadata func(trackID string, metadata map[string]string)
+
+func (peer *Peer) OnTrackMetadata(fn OnTrackMetadata) (off func()) {
+	return peer.onTrackMetadata.Append(fn)
+}
+
+// Deprecated: call the func returned by OnTrackMetadata instead.
+func (peer *Peer) OffTrackMetadata(fn OnTrackMetadata) {
+	peer.onTrackMetadata.Delete(func(index int, onTrackMetadata OnTrackMetadata) bool {
+		return sameFunc(onTrackMetadata, fn)
+	})
+}
+
+// AddTrackWithMetadata signals the metadata of the track and adds it. The
+// metadata is signaled first, so it is known by the time the remote peer's
+// OnTrack fires. It needs PeerOptions.SignalTrackMetadata, without it the
+// track is not added.
+func (peer *Peer) AddTrackWithMetadata(track webrtc.TrackLocal, metadata map[string]string) (*webrtc.RTPSender, error) {
+	if err := peer.SetTrackMetadata(track.ID(), metadata); err != nil {
+		return nil, err
+	}
+	return peer.AddTrack(track)
+}
+
+// SetTrackMetadata signals the metadata of the local track with the ID,
+// replacing what was set before. It is sent as a trackMetadata message,
+// which only peers of this package understand, so it returns
+// ErrTrackMetadataDisabled unless PeerOptions.SignalTrackMetadata is set.
+func (peer *Peer) SetTrackMetadata(trackID string, metadata map[string]string) error {
+	if !peer.signalTrackMetadata {
+		return ErrTrackMetadataDisabled
+	}
+	return peer.signal(SignalMessage{
+		Type: SignalMessageTrackMetadata,
+		TrackMetadata: &SignalMessageMetadata{
+			TrackID:  trackID,
+			Metadata: metadata,
+		},
+	})
+}
+
+// TrackMetadata returns a copy of the metadata the remote peer set for the
+// track, or nil if it set none. It is kept across reconnects.
+func (peer *Peer) TrackMetadata(track *webrtc.TrackRemote) map[string]string {
+	if track == nil {
+		return nil
+	}
+	peer.mutex.RLock()
+	defer peer.mutex.RUnlock()
+	return copyMetadata(peer.remoteTrackMetadata[track.ID()])
+}
+
+func (peer *Peer) setRemoteTrackMetadata(trackMetadata *SignalMessageMetadata) {
+	peer.mutex.Lock()
+	if peer.remoteTrackMetadata == nil {
+		peer.remoteTrackMetadata = make(map[string]map[string]string)
+	}
+	peer.remoteTrackMetadata[trackMetadata.TrackID] = copyMetadata(trackMetadata.Metadata)
+	peer.mutex.Unlock()
+	for _, fn := range peer.onTrackMetadata.Slice() {
+		go fn(trackMetadata.TrackID, copyMetadata(trackMetadata.Metadata))
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,89 @@
+package simplepeer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/testsrc"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestTrackMetadata(t *testing.T) {
+	metadata := make(chan map[string]string, 1)
+	updates := make(chan map[string]string, 2)
+	var peer2 *Peer
+	peer1, peer2 := connectTestPeers(t, PeerOptions{SignalTrackMetadata: true}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			metadata <- peer2.TrackMetadata(track)
+		},
+		OnTrackMetadata: func(trackID string, metadata map[string]string) {
+			if trackID == "screen" {
+				updates <- metadata
+			}
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	track, err := testsrc.NewVP8(testsrc.Options{ID: "screen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen := map[string]string{"owner": "alice", "source": "screen"}
+	if _, err := peer1.AddTrackWithMetadata(track, screen); err != nil {
+		t.Fatal(err)
+	}
+	track.Start()
+	defer track.Stop()
+	select {
+	case received := <-metadata:
+		if !reflect.DeepEqual(received, screen) {
+			t.Fatalf("expected metadata %v in OnTrack, got %v", screen, received)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the track")
+	}
+	<-updates
+
+	presenting := map[string]string{"owner": "alice", "source": "screen", "presenting": "true"}
+	if err := peer1.SetTrackMetadata("screen", presenting); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case received := <-updates:
+		if !reflect.DeepEqual(received, presenting) {
+			t.Fatalf("expected updated metadata %v, got %v", presenting, received)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the metadata update")
+	}
+}
+
+func TestTrackMetadataDisabled(t *testing.T) {
+	signals := make(chan map[string]interface{}, 1)
+	peer := NewPeer(PeerOptions{
+		OnSignal: func(message map[string]interface{}) error {
+			signals <- message
+			return nil
+		},
+	})
+	defer peer.Close()
+
+	track, err := testsrc.NewVP8(testsrc.Options{ID: "screen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.AddTrackWithMetadata(track, map[string]string{"source": "screen"}); !errors.Is(err, ErrTrackMetadataDisabled) {
+		t.Fatalf("expected ErrTrackMetadataDisabled, got %v", err)
+	}
+	if err := peer.SetTrackMetadata("screen", map[string]string{"source": "screen"}); !errors.Is(err, ErrTrackMetadataDisabled) {
+		t.Fatalf("expected ErrTrackMetadataDisabled, got %v", err)
+	}
+	select {
+	case message := <-signals:
+		t.Fatalf("expected nothing to be signaled, got %v", message)
+	default:
+	}
+}
@@ -0,0 +1,233 @@
+package simplepeer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// OnTrackEnded is called once for a remote track when the remote peer stops
+// sending it, by renegotiating its m-section to recvonly or inactive or by
+// rejecting it, when reading it fails and when the connection closes.
+type OnTrackEnded func(track *webrtc.TrackRemote)
+
+type midOnTrack struct {
+	mid string
+	fn  OnTrack
+}
+
+// OnTrackForMid adds a callback for remote tracks arriving on the transceiver
+// with the given mid. Tracks with a mid specific callback are not passed to
+// OnTrack. Registrations are kept across renegotiations and reconnects.
+func (peer *Peer) OnTrackForMid(mid string, fn OnTrack, options ...CallbackOptions) (off func()) {
+	peer.callbackMutex.Lock()
+	off = peer.onTrackForMid.Append(midOnTrack{mid: mid, fn: fn})
+	var replay []remoteTrack
+	if !skipReplay(options) {
+		for _, remoteTrack := range peer.remoteTracks {
+			if remoteTrack.mid == mid {
+				replay = append(replay, remoteTrack)
+			}
+		}
+	}
+	peer.callbackMutex.Unlock()
+	for _, remoteTrack := range replay {
+		go fn(remoteTrack.track, remoteTrack.receiver)
+	}
+	return off
+}
+
+func (peer *Peer) OnTrackEnded(fn OnTrackEnded) (off func()) {
+	return peer.onTrackEnded.Append(fn)
+}
+
+// Deprecated: call the func returned by OnTrackEnded instead.
+func (peer *Peer) OffTrackEnded(fn OnTrackEnded) {
+	peer.onTrackEnded.Delete(func(index int, onTrackEnded OnTrackEnded) bool {
+		return sameFunc(onTrackEnded, fn)
+	})
+}
+
+// endTracks forgets the remote tracks matching ended and calls OnTrackEnded
+// for them. Tracks that already ended are not reported again.
+func (peer *Peer) endTracks(ended func(remoteTrack remoteTrack) bool) {
+	var endedTracks []*webrtc.TrackRemote
+	peer.callbackMutex.Lock()
+	remoteTracks := make([]remoteTrack, 0, len(peer.remoteTracks))
+	for _, remoteTrack := range peer.remoteTracks {
+		if ended(remoteTrack) {
+			endedTracks = append(endedTracks, remoteTrack.track)
+		} else {
+			remoteTracks = append(remoteTracks, remoteTrack)
+		}
+	}
+	peer.remoteTracks = remoteTracks
+	peer.callbackMutex.Unlock()
+	for _, track := range endedTracks {
+		peer.trackEnded(track)
+	}
+}
+
+func (peer *Peer) trackEnded(track *webrtc.TrackRemote) {
+	peer.debugf("remote track %s ended", track.ID())
+	for _, fn := range peer.onTrackEnded.Slice() {
+		go fn(track)
+	}
+}
+
+// Deprecated: call the func returned by OnTrackForMid instead.
+func (peer *Peer) OffTrackForMid(mid string, fn OnTrack) {
+	peer.onTrackForMid.Delete(func(index int, onTrackForMid midOnTrack) bool {
+		return onTrackForMid.mid == mid && sameFunc(onTrackForMid.fn, fn)
+	})
+}
+
+// MidForTrack returns the mid of the transceiver receiving the remote track
+// or an empty string if the track is not on the current connection.
+func (peer *Peer) MidForTrack(track *webrtc.TrackRemote) string {
+	connection := peer.Connection()
+	if connection == nil || track == nil {
+		return ""
+	}
+	for _, transceiver := range connection.GetTransceivers() {
+		receiver := transceiver.Receiver()
+		if receiver == nil {
+			continue
+		}
+		for _, receiverTrack := range receiver.Tracks() {
+			if receiverTrack == track {
+				return transceiver.Mid()
+			}
+		}
+	}
+	return ""
+}
+
+// RequestKeyFrame asks the remote sender of track for a keyframe with a
+// picture loss indication. It returns ErrTrackEnded if the track is not
+// received on the current connection anymore.
+func (peer *Peer) RequestKeyFrame(track *webrtc.TrackRemote) error {
+	if track == nil {
+		return ErrNoTrack
+	}
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	if peer.MidForTrack(track) == "" {
+		return fmt.Errorf("%w: %s", ErrTrackEnded, track.ID())
+	}
+	return connection.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
+	})
+}
+
+func (peer *Peer) midForReceiver(receiver *webrtc.RTPReceiver) string {
+	connection := peer.Connection()
+	if connection == nil || receiver == nil {
+		return ""
+	}
+	for _, transceiver := range connection.GetTransceivers() {
+		if transceiver.Receiver() == receiver {
+			return transceiver.Mid()
+		}
+	}
+	return ""
+}
+
+// trackCallbacks returns the callbacks for a remote track on mid, falling back
+// to the OnTrack callbacks when no mid specific callback is registered.
+func (peer *Peer) trackCallbacks(mid string) []OnTrack {
+	var callbacks []OnTrack
+	if mid != "" {
+		for _, onTrackForMid := range peer.onTrackForMid.Slice() {
+			if onTrackForMid.mid == mid {
+				callbacks = append(callbacks, onTrackForMid.fn)
+			}
+		}
+	}
+	if len(callbacks) > 0 {
+		return callbacks
+	}
+	return peer.onTrack.Slice()
+}
+
+func (peer *Peer) hasTrackForMid(mid string) bool {
+	if mid == "" {
+		return false
+	}
+	for _, onTrackForMid := range peer.onTrackForMid.Slice() {
+		if onTrackForMid.mid == mid {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceTrack sends track on sender in place of its current track, without
+// renegotiating. The track's codec must be one the sender negotiated,
+// otherwise ErrCodecNotNegotiated is returned and the current track keeps
+// sending. A nil track stops sending.
+func (peer *Peer) ReplaceTrack(sender *webrtc.RTPSender, track webrtc.TrackLocal) error {
+	if sender == nil {
+		return ErrNoSender
+	}
+	if track != nil {
+		if err := checkNegotiatedCodec(sender, track); err != nil {
+			return err
+		}
+	}
+	return sender.ReplaceTrack(track)
+}
+
+// ReplaceTrackByKind replaces the track of the first sender of kind, see
+// ReplaceTrack. It returns ErrNoSender if there is no such sender.
+func (peer *Peer) ReplaceTrackByKind(kind webrtc.RTPCodecType, track webrtc.TrackLocal) error {
+	connection := peer.Connection()
+	if connection == nil {
+		return peer.notInitializedErr()
+	}
+	var sender *webrtc.RTPSender
+	for _, transceiver := range connection.GetTransceivers() {
+		if transceiver.Kind() != kind || transceiver.Sender() == nil {
+			continue
+		}
+		// prefer a sender with a track, as a sendonly sender without one
+		// was never sending
+		if transceiver.Sender().Track() != nil {
+			sender = transceiver.Sender()
+			break
+		}
+		if sender == nil {
+			sender = transceiver.Sender()
+		}
+	}
+	if sender == nil {
+		return fmt.Errorf("%w: %s", ErrNoSender, kind)
+	}
+	return peer.ReplaceTrack(sender, track)
+}
+
+// checkNegotiatedCodec checks the codec of track, if it has one, against
+// the codecs sender negotiated, if any were yet.
+func checkNegotiatedCodec(sender *webrtc.RTPSender, track webrtc.TrackLocal) error {
+	codecTrack, ok := track.(interface {
+		Codec() webrtc.RTPCodecCapability
+	})
+	if !ok {
+		return nil
+	}
+	codecs := sender.GetParameters().Codecs
+	if len(codecs) == 0 {
+		return nil
+	}
+	mimeType := codecTrack.Codec().MimeType
+	for _, codec := range codecs {
+		if strings.EqualFold(codec.MimeType, mimeType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrCodecNotNegotiated, mimeType)
+}
@@ -0,0 +1,252 @@
+package simplepeer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/go-simplepeer/internal/testutil"
+	"github.com/aicacia/go-simplepeer/testsrc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestReplaceTrack(t *testing.T) {
+	remoteTracks := make(chan *webrtc.TrackRemote, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			remoteTracks <- track
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	camera, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := peer1.AddTrack(camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, func() bool {
+		return peer1.Connection().SignalingState() == webrtc.SignalingStateStable &&
+			peer2.Connection().SignalingState() == webrtc.SignalingStateStable &&
+			len(peer2.Connection().GetTransceivers()) == 1
+	})
+	negotiations := settledNegotiations(t, peer1)
+
+	screen, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "screen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.ReplaceTrackByKind(webrtc.RTPCodecTypeVideo, screen); err != nil {
+		t.Fatal(err)
+	}
+	if sender.Track() != screen {
+		t.Fatal("expected the sender to send the new track")
+	}
+	marker := []byte{0xde, 0xad, 0xbe, 0xef}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				screen.WriteSample(media.Sample{Data: marker, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+	var remote *webrtc.TrackRemote
+	select {
+	case remote = <-remoteTracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the remote track")
+	}
+	packet, _, err := remote.ReadRTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(packet.Payload, marker) {
+		t.Fatalf("expected the new track's samples, got %x", packet.Payload)
+	}
+
+	unsupported, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "video/unsupported"}, "video", "unsupported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer1.ReplaceTrack(sender, unsupported); !errors.Is(err, ErrCodecNotNegotiated) {
+		t.Fatalf("expected ErrCodecNotNegotiated, got %v", err)
+	}
+	if sender.Track() != screen {
+		t.Fatal("expected a failed replacement to keep the current track")
+	}
+	if err := peer1.ReplaceTrackByKind(webrtc.RTPCodecTypeAudio, screen); !errors.Is(err, ErrNoSender) {
+		t.Fatalf("expected ErrNoSender, got %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if count := len(negotiationsOnly(peer1.NegotiationHistory())); count != negotiations {
+		t.Fatalf("expected no renegotiation, got %d more", count-negotiations)
+	}
+}
+
+// settledNegotiations waits until the peer has started no negotiation for a
+// while and returns how many it started.
+func settledNegotiations(t *testing.T, peer *Peer) int {
+	count := len(negotiationsOnly(peer.NegotiationHistory()))
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		next := len(negotiationsOnly(peer.NegotiationHistory()))
+		if next == count && peer.Connection().SignalingState() == webrtc.SignalingStateStable {
+			return count
+		}
+		count = next
+	}
+	t.Fatal("timed out waiting for negotiations to settle")
+	return count
+}
+
+func TestRequestKeyFrame(t *testing.T) {
+	remoteTracks := make(chan *webrtc.TrackRemote, 1)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		RequestKeyFrameOnTrack: true,
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			remoteTracks <- track
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "keyframes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := peer1.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictureLoss := make(chan struct{}, 4)
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				if _, ok := packet.(*rtcp.PictureLossIndication); ok {
+					pictureLoss <- struct{}{}
+				}
+			}
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				track.WriteSample(media.Sample{Data: []byte{0x00}, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+	waitForPictureLoss := func() {
+		select {
+		case <-pictureLoss:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a picture loss indication")
+		}
+	}
+
+	var remote *webrtc.TrackRemote
+	select {
+	case remote = <-remoteTracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the remote track")
+	}
+	waitForPictureLoss()
+	if err := peer2.RequestKeyFrame(remote); err != nil {
+		t.Fatal(err)
+	}
+	waitForPictureLoss()
+
+	if err := peer2.RequestKeyFrame(nil); !errors.Is(err, ErrNoTrack) {
+		t.Fatalf("expected ErrNoTrack, got %v", err)
+	}
+	if err := peer1.RequestKeyFrame(remote); !errors.Is(err, ErrTrackEnded) {
+		t.Fatalf("expected ErrTrackEnded for a track of another connection, got %v", err)
+	}
+	if err := NewPeer().RequestKeyFrame(remote); !errors.Is(err, ErrConnectionNotInitialized) {
+		t.Fatalf("expected ErrConnectionNotInitialized, got %v", err)
+	}
+}
+
+func TestOnTrackEnded(t *testing.T) {
+	remoteTracks := make(chan *webrtc.TrackRemote, 2)
+	endedTracks := make(chan *webrtc.TrackRemote, 2)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnTrack: func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			remoteTracks <- track
+		},
+		OnTrackEnded: func(track *webrtc.TrackRemote) {
+			endedTracks <- track
+		},
+	})
+	defer peer1.Close()
+
+	track, err := testsrc.NewVP8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := peer1.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track.Start()
+	defer track.Stop()
+	waitForEnded := func(expected *webrtc.TrackRemote) {
+		select {
+		case ended := <-endedTracks:
+			if ended != expected {
+				t.Fatalf("expected track %p to end, got %p", expected, ended)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the track to end")
+		}
+	}
+	waitForTrack := func() *webrtc.TrackRemote {
+		select {
+		case remoteTrack := <-remoteTracks:
+			return remoteTrack
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the remote track")
+		}
+		return nil
+	}
+
+	remoteTrack := waitForTrack()
+	if err := peer1.PauseTrack(sender); err != nil {
+		t.Fatal(err)
+	}
+	waitForEnded(remoteTrack)
+
+	if _, err := peer1.ResumeTrack(sender); err != nil {
+		t.Fatal(err)
+	}
+	remoteTrack = waitForTrack()
+	if err := peer2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForEnded(remoteTrack)
+	select {
+	case ended := <-endedTracks:
+		t.Fatalf("expected every track to end once, %p ended again", ended)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,263 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	TranscriptInbound  = "in"
+	TranscriptOutbound = "out"
+)
+
+const defaultReplayTimeout = 5 * time.Second
+
+// TranscriptEntry is a signal message of a recorded session. At is the time
+// since the recording started.
+type TranscriptEntry struct {
+	Direction string                 `json:"direction"`
+	At        time.Duration          `json:"at"`
+	Message   map[string]interface{} `json:"message"`
+}
+
+// TranscriptRecorder records the signal messages of one peer as a
+// transcript ReplayTranscript can replay. Wrap the peer's OnSignal with
+// Outbound and pass the messages given to its Signal to Inbound.
+type TranscriptRecorder struct {
+	mutex   sync.Mutex
+	start   time.Time
+	entries []TranscriptEntry
+}
+
+func NewTranscriptRecorder() *TranscriptRecorder {
+	return &TranscriptRecorder{start: time.Now()}
+}
+
+// Inbound records a message the peer received.
+func (recorder *TranscriptRecorder) Inbound(message map[string]interface{}) {
+	recorder.record(TranscriptInbound, message)
+}
+
+// Outbound records a message the peer emitted.
+func (recorder *TranscriptRecorder) Outbound(message map[string]interface{}) {
+	recorder.record(TranscriptOutbound, message)
+}
+
+// Transcript returns the messages recorded so far as JSON.
+func (recorder *TranscriptRecorder) Transcript() ([]byte, error) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return json.Marshal(recorder.entries)
+}
+
+func (recorder *TranscriptRecorder) record(direction string, message map[string]interface{}) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.entries = append(recorder.entries, TranscriptEntry{
+		Direction: direction,
+		At:        time.Since(recorder.start),
+		Message:   message,
+	})
+}
+
+type ReplayOptions struct {
+	// Speed feeds the inbound messages this many times faster than they were
+	// recorded. Zero keeps the recorded timing.
+	Speed float64
+	// Timeout bounds how long the replay waits for each message the peer
+	// is expected to emit, 5 seconds by default.
+	Timeout time.Duration
+}
+
+// ReplayDivergence is an outbound message of the replay that differs from
+// the recording, both normalized by NormalizeSignal. Recorded is nil for
+// messages only the replay emitted, Replayed for messages it never emitted.
+type ReplayDivergence struct {
+	Index    int
+	Recorded map[string]interface{}
+	Replayed map[string]interface{}
+}
+
+type ReplayResult struct {
+	// Outbound is every message the peer emitted, as emitted.
+	Outbound    []map[string]interface{}
+	Divergences []ReplayDivergence
+	// Errors are those returned by Signal and reported through OnError.
+	Errors []error
+}
+
+// ReplayTranscript replays a transcript recorded by TranscriptRecorder
+// against a new peer created with opts, whose OnSignal and OnError are
+// replaced. The peer is the initiator if the transcript starts with an
+// outbound message. Before each inbound message the replay waits for the
+// peer to emit the messages recorded before it, so the peer sees the
+// recorded order however fast it runs. Candidate messages are not compared,
+// as which candidates are gathered depends on the host.
+func ReplayTranscript(transcript []byte, opts PeerOptions, options ...ReplayOptions) (ReplayResult, error) {
+	var entries []TranscriptEntry
+	if err := json.Unmarshal(transcript, &entries); err != nil {
+		return ReplayResult{}, fmt.Errorf("%w: %w", ErrMalformedTranscript, err)
+	}
+	var recorded []map[string]interface{}
+	for index, entry := range entries {
+		if entry.Direction != TranscriptInbound && entry.Direction != TranscriptOutbound {
+			return ReplayResult{}, fmt.Errorf("%w: entry %d: unknown direction %q", ErrMalformedTranscript, index, entry.Direction)
+		}
+		if entry.Message == nil {
+			return ReplayResult{}, fmt.Errorf("%w: entry %d: no message", ErrMalformedTranscript, index)
+		}
+		if entry.Direction == TranscriptOutbound && isReplayCompared(entry.Message) {
+			normalized, err := normalizeReplayed(entry.Message)
+			if err != nil {
+				return ReplayResult{}, fmt.Errorf("%w: entry %d: %w", ErrMalformedTranscript, index, err)
+			}
+			recorded = append(recorded, normalized)
+		}
+	}
+	speed, timeout := 0.0, defaultReplayTimeout
+	for _, option := range options {
+		if option.Speed != 0 {
+			speed = option.Speed
+		}
+		if option.Timeout != 0 {
+			timeout = option.Timeout
+		}
+	}
+
+	replay := &transcriptReplay{changed: make(chan struct{}, 1)}
+	opts.OnSignal = replay.outbound
+	opts.OnSignalBytes = nil
+	opts.OnError = replay.error
+	peer := NewPeer(opts)
+	defer peer.Close()
+	if len(entries) > 0 && entries[0].Direction == TranscriptOutbound {
+		if err := peer.Init(); err != nil {
+			return ReplayResult{}, err
+		}
+	}
+	start := time.Now()
+	expected := 0
+	for _, entry := range entries {
+		if entry.Direction == TranscriptOutbound {
+			if isReplayCompared(entry.Message) {
+				expected++
+			}
+			continue
+		}
+		replay.wait(expected, timeout)
+		if speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(entry.At) / speed))))
+		} else {
+			time.Sleep(time.Until(start.Add(entry.At)))
+		}
+		if err := peer.Signal(entry.Message); err != nil {
+			replay.error(err)
+		}
+	}
+	replay.wait(expected, timeout)
+	return replay.result(recorded), nil
+}
+
+type transcriptReplay struct {
+	mutex    sync.Mutex
+	messages []map[string]interface{}
+	compared []map[string]interface{}
+	errors   []error
+	changed  chan struct{}
+}
+
+func (replay *transcriptReplay) outbound(message map[string]interface{}) error {
+	replay.mutex.Lock()
+	replay.messages = append(replay.messages, message)
+	if isReplayCompared(message) {
+		if normalized, err := normalizeReplayed(message); err != nil {
+			replay.errors = append(replay.errors, err)
+		} else {
+			replay.compared = append(replay.compared, normalized)
+		}
+	}
+	replay.mutex.Unlock()
+	select {
+	case replay.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (replay *transcriptReplay) error(err error) {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	replay.errors = append(replay.errors, err)
+}
+
+// wait waits until the peer emitted count compared messages or timeout
+// passes without it emitting one.
+func (replay *transcriptReplay) wait(count int, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		replay.mutex.Lock()
+		emitted := len(replay.compared)
+		replay.mutex.Unlock()
+		if emitted >= count {
+			return
+		}
+		select {
+		case <-replay.changed:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (replay *transcriptReplay) result(recorded []map[string]interface{}) ReplayResult {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+	result := ReplayResult{
+		Outbound: append([]map[string]interface{}(nil), replay.messages...),
+		Errors:   append([]error(nil), replay.errors...),
+	}
+	count := len(recorded)
+	if len(replay.compared) > count {
+		count = len(replay.compared)
+	}
+	for index := 0; index < count; index++ {
+		divergence := ReplayDivergence{Index: index}
+		if index < len(recorded) {
+			divergence.Recorded = recorded[index]
+		}
+		if index < len(replay.compared) {
+			divergence.Replayed = replay.compared[index]
+		}
+		if !reflect.DeepEqual(divergence.Recorded, divergence.Replayed) {
+			result.Divergences = append(result.Divergences, divergence)
+		}
+	}
+	return result
+}
+
+func isReplayCompared(message map[string]interface{}) bool {
+	messageType, _ := message["type"].(string)
+	return messageType != SignalMessageCandidate && messageType != SignalMessageCandidates
+}
+
+// normalizeReplayed normalizes message as it reads once encoded as JSON, so
+// recorded and emitted messages compare alike.
+func normalizeReplayed(message map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return NormalizeSignal(decoded), nil
+}
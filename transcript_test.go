@@ -0,0 +1,115 @@
+package simplepeer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordTestSession connects two peers and returns the transcripts
+// recorded for each of them.
+func recordTestSession(t *testing.T) ([]byte, []byte) {
+	recorder1 := NewTranscriptRecorder()
+	recorder2 := NewTranscriptRecorder()
+	peer1Connect := make(chan bool)
+	peer2Connect := make(chan bool)
+	var peer1, peer2 *Peer
+	peer1 = NewPeer(PeerOptions{
+		Id: "peer1",
+		OnSignal: func(message map[string]interface{}) error {
+			recorder1.Outbound(message)
+			recorder2.Inbound(message)
+			return peer2.Signal(message)
+		},
+		OnConnect: func() {
+			peer1Connect <- true
+		},
+	})
+	peer2 = NewPeer(PeerOptions{
+		Id: "peer2",
+		OnSignal: func(message map[string]interface{}) error {
+			recorder2.Outbound(message)
+			recorder1.Inbound(message)
+			return peer1.Signal(message)
+		},
+		OnConnect: func() {
+			peer2Connect <- true
+		},
+	})
+	if err := peer1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-peer1Connect
+	<-peer2Connect
+	peer1.Close()
+	peer2.Close()
+	transcript1, err := recorder1.Transcript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript2, err := recorder2.Transcript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transcript1, transcript2
+}
+
+func TestReplayTranscript(t *testing.T) {
+	transcript1, transcript2 := recordTestSession(t)
+	for name, transcript := range map[string][]byte{"initiator": transcript1, "answerer": transcript2} {
+		result, err := ReplayTranscript(transcript, PeerOptions{}, ReplayOptions{Speed: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Divergences) != 0 {
+			t.Fatalf("expected the %s replay to match, got %+v", name, result.Divergences)
+		}
+		if len(result.Errors) != 0 {
+			t.Fatalf("expected the %s replay to succeed, got %v", name, result.Errors)
+		}
+		if len(result.Outbound) == 0 {
+			t.Fatalf("expected the %s replay to emit messages", name)
+		}
+	}
+}
+
+func TestReplayCorruptedTranscript(t *testing.T) {
+	_, transcript := recordTestSession(t)
+	var entries []TranscriptEntry
+	if err := json.Unmarshal(transcript, &entries); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := false
+	for _, entry := range entries {
+		if entry.Direction == TranscriptOutbound && entry.Message["type"] == SignalMessageAnswer {
+			entry.Message["sdp"] = strings.Replace(entry.Message["sdp"].(string), "a=setup:active", "a=setup:passive", 1)
+			corrupted = true
+		}
+	}
+	if !corrupted {
+		t.Fatal("expected the transcript to record an answer")
+	}
+	transcript, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ReplayTranscript(transcript, PeerOptions{}, ReplayOptions{Speed: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Divergences) != 1 {
+		t.Fatalf("expected the corrupted answer to diverge, got %+v", result.Divergences)
+	}
+	divergence := result.Divergences[0]
+	if divergence.Recorded["type"] != SignalMessageAnswer || divergence.Replayed["type"] != SignalMessageAnswer {
+		t.Fatalf("expected the answers to diverge, got %+v", divergence)
+	}
+
+	if _, err := ReplayTranscript([]byte(`[{"direction":"sideways","message":{}}]`), PeerOptions{}); !errors.Is(err, ErrMalformedTranscript) {
+		t.Fatalf("expected ErrMalformedTranscript, got %v", err)
+	}
+	if _, err := ReplayTranscript([]byte(`{`), PeerOptions{}); !errors.Is(err, ErrMalformedTranscript) {
+		t.Fatalf("expected ErrMalformedTranscript, got %v", err)
+	}
+}
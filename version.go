@@ -0,0 +1,78 @@
+package simplepeer
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Version is the version of the package API. Behavior only changes between
+// minor versions behind options or EnableV2Defaults.
+const Version = "1.0.0"
+
+// V1 is the Peer API that stays source compatible for the lifetime of the
+// module. Code written against it keeps compiling across upgrades.
+type V1 interface {
+	Id() string
+	Connection() *webrtc.PeerConnection
+	Channel() *webrtc.DataChannel
+	Initiator() bool
+	Write(bytes []byte) (int, error)
+	WriteText(text string) (int, error)
+	Reader() io.ReadCloser
+	Init() error
+	AddTransceiverFromKind(kind webrtc.RTPCodecType, init ...webrtc.RTPTransceiverInit) (*webrtc.RTPTransceiver, error)
+	AddTrack(track webrtc.TrackLocal) (*webrtc.RTPSender, error)
+	OnSignal(fn OnSignal)
+	OnConnect(fn OnConnect, options ...CallbackOptions)
+	OffConnect(fn OnConnect)
+	OnData(fn OnData)
+	OffData(fn OnData)
+	OnError(fn OnError)
+	OffError(fn OnError)
+	OnClose(fn OnClose, options ...CallbackOptions)
+	OffClose(fn OnClose)
+	OnTransceiver(fn OnTransceiver)
+	OffTransceiver(fn OnTransceiver)
+	OnTrack(fn OnTrack, options ...CallbackOptions)
+	OffTrack(fn OnTrack)
+	Signal(message map[string]interface{}) error
+	Close() error
+}
+
+var _ V1 = (*Peer)(nil)
+
+// Unsubscriber adds the handlers of the V1 On methods and returns a func
+// removing exactly the handler it added. The On methods of V1 keep
+// returning nothing, the other On methods of Peer return such a func
+// themselves.
+type Unsubscriber interface {
+	SubscribeSignal(fn OnSignal) (off func())
+	SubscribeConnect(fn OnConnect, options ...CallbackOptions) (off func())
+	SubscribeData(fn OnData) (off func())
+	SubscribeError(fn OnError) (off func())
+	SubscribeClose(fn OnClose, options ...CallbackOptions) (off func())
+	SubscribeTransceiver(fn OnTransceiver) (off func())
+	SubscribeTrack(fn OnTrack, options ...CallbackOptions) (off func())
+}
+
+var _ Unsubscriber = (*Peer)(nil)
+
+var v2Defaults atomic.Bool
+
+// EnableV2Defaults switches peers created afterwards to the recommended
+// defaults that would break existing users if they were on by default:
+//   - data handlers run in order on the channel's goroutine instead of one
+//     goroutine per message,
+//   - handlers receive their own copy of each message's data,
+//   - signals that are invalid for the peer's role, such as a renegotiate
+//     sent to the non-initiator, return an error instead of being ignored.
+func EnableV2Defaults() {
+	v2Defaults.Store(true)
+}
+
+// V2DefaultsEnabled reports whether EnableV2Defaults was called.
+func V2DefaultsEnabled() bool {
+	return v2Defaults.Load()
+}
@@ -0,0 +1,73 @@
+package simplepeer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func withV2Defaults(t *testing.T) {
+	EnableV2Defaults()
+	t.Cleanup(func() {
+		v2Defaults.Store(false)
+	})
+}
+
+func TestLegacyDefaults(t *testing.T) {
+	if V2DefaultsEnabled() {
+		t.Fatal("expected legacy defaults unless EnableV2Defaults is called")
+	}
+	peer := NewPeer(PeerOptions{OnSignal: func(message map[string]interface{}) error { return nil }})
+	defer peer.Close()
+	if peer.orderedDispatch || peer.copyOnReceive || peer.strictErrors {
+		t.Fatal("expected legacy defaults")
+	}
+	if err := peer.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true}); err != nil {
+		t.Fatalf("expected a renegotiate signal to the non-initiator to be ignored, got %v", err)
+	}
+}
+
+func TestV2Defaults(t *testing.T) {
+	withV2Defaults(t)
+	peer := NewPeer(PeerOptions{OnSignal: func(message map[string]interface{}) error { return nil }})
+	defer peer.Close()
+	if err := peer.Signal(map[string]interface{}{"type": SignalMessageRenegotiate, "renegotiate": true}); !errors.Is(err, ErrInvalidSignalState) {
+		t.Fatalf("expected a renegotiate signal to the non-initiator to fail, got %v", err)
+	}
+
+	const count = 500
+	received := make(chan []byte, count)
+	copies := make(chan []byte, count)
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{
+		OnData: func(message webrtc.DataChannelMessage) {
+			received <- message.Data
+		},
+	})
+	defer peer1.Close()
+	defer peer2.Close()
+	peer2.OnData(func(message webrtc.DataChannelMessage) {
+		copies <- message.Data
+	})
+	for i := 0; i < count; i++ {
+		if _, err := peer1.WriteText(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case data := <-received:
+			if string(data) != fmt.Sprint(i) {
+				t.Fatalf("expected message %d in order, got %s", i, data)
+			}
+			copied := <-copies
+			if &copied[0] == &data[0] {
+				t.Fatal("expected each handler to receive its own copy of the data")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+}
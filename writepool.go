@@ -0,0 +1,72 @@
+package simplepeer
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// chunkPool holds buffers for a data channel message of up to
+// sctpMaxMessageSize bytes, the largest pion sends.
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, sctpMaxMessageSize)
+		return &buffer
+	},
+}
+
+// getChunkBuffer returns a buffer of size bytes, pooled unless it is larger
+// than the pooled buffers.
+func getChunkBuffer(size int) *[]byte {
+	if size > sctpMaxMessageSize {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	buffer := chunkPool.Get().(*[]byte)
+	*buffer = (*buffer)[:size]
+	return buffer
+}
+
+func putChunkBuffer(buffer *[]byte) {
+	if cap(*buffer) == sctpMaxMessageSize {
+		chunkPool.Put(buffer)
+	}
+}
+
+// frameStart returns a pooled buffer holding the header of a frame of size
+// bytes followed by its first bytes.
+func frameStart(size int, first []byte) *[]byte {
+	buffer := getChunkBuffer(frameHeaderSize + len(first))
+	binary.BigEndian.PutUint32(*buffer, uint32(size))
+	copy((*buffer)[frameHeaderSize:], first)
+	return buffer
+}
+
+// ReadFrom sends what it reads from reader until EOF, reading at most a
+// message at a time into a pooled buffer, so io.Copy needs no buffer of its
+// own. With FrameMessages every read is sent as a frame.
+func (writer *peerWriter) ReadFrom(reader io.Reader) (int64, error) {
+	size := writer.peer.MaxMessageSize()
+	if writer.peer.frameMessages {
+		size -= frameHeaderSize
+	}
+	buffer := getChunkBuffer(size)
+	defer putChunkBuffer(buffer)
+	var total int64
+	for {
+		count, err := reader.Read(*buffer)
+		if count > 0 {
+			written, writeErr := writer.Write((*buffer)[:count])
+			total += int64(written)
+			if writeErr != nil {
+				return total, writeErr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
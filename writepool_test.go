@@ -0,0 +1,103 @@
+package simplepeer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// BenchmarkWriteThroughput writes 1 MiB per operation to a connected peer
+// that discards what it receives.
+func BenchmarkWriteThroughput(b *testing.B) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	run := func(b *testing.B, options PeerOptions, write func(peer *Peer) error) {
+		peer1, peer2 := connectTestPeers(b, options, PeerOptions{FrameMessages: options.FrameMessages})
+		defer peer1.Close()
+		defer peer2.Close()
+		b.SetBytes(int64(len(payload)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := write(peer1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("Write", func(b *testing.B) {
+		run(b, PeerOptions{}, func(peer *Peer) error {
+			_, err := peer.Write(payload)
+			return err
+		})
+	})
+	b.Run("WriteFramed", func(b *testing.B) {
+		run(b, PeerOptions{FrameMessages: true}, func(peer *Peer) error {
+			_, err := peer.Write(payload)
+			return err
+		})
+	})
+	// the struct hides bytes.Reader's WriteTo, like a file's, so io.Copy
+	// goes through the writer's ReadFrom
+	b.Run("Copy", func(b *testing.B) {
+		run(b, PeerOptions{}, func(peer *Peer) error {
+			_, err := io.Copy(peer.Writer(), struct{ io.Reader }{bytes.NewReader(payload)})
+			return err
+		})
+	})
+	b.Run("CopyFramed", func(b *testing.B) {
+		run(b, PeerOptions{FrameMessages: true}, func(peer *Peer) error {
+			_, err := io.Copy(peer.Writer(), struct{ io.Reader }{bytes.NewReader(payload)})
+			return err
+		})
+	})
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	peer1, peer2 := connectTestPeers(t, PeerOptions{}, PeerOptions{})
+	defer peer1.Close()
+	defer peer2.Close()
+	reader := peer2.Reader()
+	defer reader.Close()
+
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+	writer := peer1.Writer()
+	if _, ok := writer.(io.ReaderFrom); !ok {
+		t.Fatal("expected the writer to implement io.ReaderFrom")
+	}
+	done := make(chan error, 1)
+	go func() {
+		written, err := io.Copy(writer, struct{ io.Reader }{bytes.NewReader(payload)})
+		if err == nil && written != int64(len(payload)) {
+			err = io.ErrShortWrite
+		}
+		done <- err
+	}()
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(reader, received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("expected the copied bytes")
+	}
+}
+
+func TestChunkBuffers(t *testing.T) {
+	buffer := getChunkBuffer(100)
+	if len(*buffer) != 100 || cap(*buffer) != sctpMaxMessageSize {
+		t.Fatalf("expected a pooled buffer of 100 bytes, got %d of %d", len(*buffer), cap(*buffer))
+	}
+	putChunkBuffer(buffer)
+	large := getChunkBuffer(sctpMaxMessageSize + 1)
+	if len(*large) != sctpMaxMessageSize+1 {
+		t.Fatalf("expected a buffer larger than the pooled ones, got %d", len(*large))
+	}
+	putChunkBuffer(large)
+
+	frame := frameStart(1000, []byte("abc"))
+	if !bytes.Equal(*frame, []byte{0, 0, 3, 232, 'a', 'b', 'c'}) {
+		t.Fatalf("expected the frame header and first bytes, got %v", *frame)
+	}
+	putChunkBuffer(frame)
+}